@@ -0,0 +1,80 @@
+package args
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+)
+
+// JMXBean maps an attribute of a broker MBean to the metric it's reported as on the broker sample
+type JMXBean struct {
+	// MBean is the full object name of the MBean, without wildcards
+	MBean      string
+	Attribute  string
+	Metric     string
+	SourceType metric.SourceType
+}
+
+// jmxBeanSourceTypes are the metric types of the broker_jmx_beans
+var jmxBeanSourceTypes = map[string]metric.SourceType{
+	"gauge": metric.GAUGE,
+	"rate":  metric.RATE,
+	"delta": metric.DELTA,
+}
+
+// unmarshalBrokerJMXBeans parses the broker_jmx_beans JSON array of {"bean", "attribute", "metric", "type"}
+// objects. attribute defaults to Value and type to gauge.
+func unmarshalBrokerJMXBeans(arg string) ([]*JMXBean, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil
+	}
+
+	var rawBeans []struct {
+		Bean      string `json:"bean"`
+		Attribute string `json:"attribute"`
+		Metric    string `json:"metric"`
+		Type      string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(arg), &rawBeans); err != nil {
+		return nil, fmt.Errorf("broker_jmx_beans is not a JSON array of beans: %s", err)
+	}
+	if len(rawBeans) == 0 {
+		return nil, nil
+	}
+
+	beans := make([]*JMXBean, 0, len(rawBeans))
+	metricNames := make(map[string]bool)
+	for _, rawBean := range rawBeans {
+		if rawBean.Metric == "" {
+			return nil, errors.New("broker_jmx_beans has a bean without a metric name")
+		}
+		if metricNames[rawBean.Metric] {
+			return nil, fmt.Errorf("broker_jmx_beans reports metric %s more than once", rawBean.Metric)
+		}
+		metricNames[rawBean.Metric] = true
+
+		if !strings.Contains(rawBean.Bean, ":") || strings.ContainsAny(rawBean.Bean, "*?") {
+			return nil, fmt.Errorf("broker_jmx_beans bean '%s' of metric %s is not an MBean name without wildcards", rawBean.Bean, rawBean.Metric)
+		}
+
+		attribute := rawBean.Attribute
+		if attribute == "" {
+			attribute = "Value"
+		}
+
+		sourceType := metric.GAUGE
+		if rawBean.Type != "" {
+			var ok bool
+			if sourceType, ok = jmxBeanSourceTypes[strings.ToLower(rawBean.Type)]; !ok {
+				return nil, fmt.Errorf("broker_jmx_beans type '%s' of metric %s must be gauge, rate or delta", rawBean.Type, rawBean.Metric)
+			}
+		}
+
+		beans = append(beans, &JMXBean{MBean: rawBean.Bean, Attribute: attribute, Metric: rawBean.Metric, SourceType: sourceType})
+	}
+
+	return beans, nil
+}
@@ -0,0 +1,103 @@
+package args
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ClusterArgumentLists returns an argument list for each cluster in the clusters argument. Each cluster
+// starts from a copy of a, so the arguments passed outside of clusters are shared defaults, and the keys
+// of its entry override them. Keys are argument names, such as cluster_name or zookeeper_hosts.
+// If clusters is not set, a is the only cluster.
+func (a ArgumentList) ClusterArgumentLists() ([]ArgumentList, error) {
+	if strings.TrimSpace(a.Clusters) == "" {
+		return []ArgumentList{a}, nil
+	}
+
+	var clusters []map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(a.Clusters))
+	decoder.UseNumber()
+	if err := decoder.Decode(&clusters); err != nil {
+		return nil, fmt.Errorf("failed to parse clusters as a JSON array of objects: %s", err)
+	}
+	if len(clusters) == 0 {
+		return []ArgumentList{a}, nil
+	}
+
+	argLists := make([]ArgumentList, 0, len(clusters))
+	for i, cluster := range clusters {
+		clusterArgs := a
+		clusterArgs.Clusters = ""
+		for name, raw := range cluster {
+			if err := setArgument(&clusterArgs, name, raw); err != nil {
+				return nil, fmt.Errorf("invalid clusters entry %d: %s", i, err)
+			}
+		}
+
+		if clusterArgs.ClusterName == "" {
+			return nil, fmt.Errorf("invalid clusters entry %d: cluster_name is required", i)
+		}
+		argLists = append(argLists, clusterArgs)
+	}
+
+	return argLists, nil
+}
+
+// processArguments apply to the whole run rather than to a single cluster
+var processArguments = map[string]bool{
+	"clusters":                true,
+	"config_file":             true,
+	"max_concurrent_clusters": true,
+	"fail_on_error":           true,
+	"prometheus_addr":         true,
+	"prometheus_interval":     true,
+	"statsd_addr":             true,
+	"metric_allow_regex":      true,
+	"metric_deny_regex":       true,
+	"metric_prefix":           true,
+}
+
+// setArgument sets the field of the argument called name to the decoded JSON value raw
+func setArgument(a *ArgumentList, name string, raw interface{}) error {
+	val := reflect.ValueOf(a).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		if field.Anonymous || argumentName(field.Name) != name {
+			continue
+		}
+
+		if processArguments[name] {
+			return fmt.Errorf("argument %s can't be set per cluster", name)
+		}
+
+		value, err := configValue(raw)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			val.Field(i).SetString(value)
+		case reflect.Int:
+			intValue, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: not an integer", name)
+			}
+			val.Field(i).SetInt(int64(intValue))
+		case reflect.Bool:
+			boolValue, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: not a boolean", name)
+			}
+			val.Field(i).SetBool(boolValue)
+		default:
+			return fmt.Errorf("argument %s has an unsupported type", name)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown argument %s", name)
+}
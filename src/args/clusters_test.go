@@ -0,0 +1,79 @@
+package args
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClusterArgumentLists_SingleCluster(t *testing.T) {
+	a := ArgumentList{ClusterName: "testcluster"}
+
+	argLists, err := a.ClusterArgumentLists()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(argLists) != 1 || argLists[0] != a {
+		t.Errorf("Expected the arguments to be the only cluster, got %+v", argLists)
+	}
+}
+
+func TestClusterArgumentLists(t *testing.T) {
+	a := ArgumentList{
+		ClusterName:        "default",
+		DefaultJMXUser:     "shared",
+		Timeout:            10000,
+		ConsumerGroupRegex: ".*",
+		Clusters: `[
+			{"cluster_name": "cluster1", "zookeeper_hosts": [{"host": "zk1"}]},
+			{"cluster_name": "cluster2", "zookeeper_hosts": "[{\"host\": \"zk2\"}]", "timeout": 500, "consumer_offset": true}
+		]`,
+	}
+
+	argLists, err := a.ClusterArgumentLists()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(argLists) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", len(argLists))
+	}
+
+	first, second := argLists[0], argLists[1]
+	if first.ClusterName != "cluster1" || first.ZookeeperHosts != `[{"host":"zk1"}]` || first.Timeout != 10000 || first.ConsumerOffset {
+		t.Errorf("Unexpected arguments for the first cluster: %+v", first)
+	}
+	if second.ClusterName != "cluster2" || second.ZookeeperHosts != `[{"host": "zk2"}]` || second.Timeout != 500 || !second.ConsumerOffset {
+		t.Errorf("Unexpected arguments for the second cluster: %+v", second)
+	}
+
+	// Shared defaults cascade to every cluster
+	for _, argList := range argLists {
+		if argList.DefaultJMXUser != "shared" || argList.ConsumerGroupRegex != ".*" || argList.Clusters != "" {
+			t.Errorf("Expected shared defaults for cluster %s, got %+v", argList.ClusterName, argList)
+		}
+	}
+}
+
+func TestClusterArgumentLists_Errors(t *testing.T) {
+	testCases := []struct {
+		clusters string
+		message  string
+	}{
+		{`{"cluster_name": "cluster1"}`, "failed to parse clusters"},
+		{`[{"zookeeper_hosts": []}]`, "cluster_name is required"},
+		{`[{"cluster_name": "cluster1", "unknown": 1}]`, "unknown argument unknown"},
+		{`[{"cluster_name": "cluster1", "timeout": "soon"}]`, "invalid value for timeout"},
+		{`[{"cluster_name": "cluster1", "config_file": "other.json"}]`, "can't be set per cluster"},
+		{`[{"cluster_name": "cluster1", "prometheus_addr": ":9308"}]`, "can't be set per cluster"},
+		{`[{"cluster_name": "cluster1", "metric_deny_regex": "^broker\\."}]`, "can't be set per cluster"},
+		{`[{"cluster_name": "cluster1", "max_concurrent_clusters": 2}]`, "can't be set per cluster"},
+	}
+
+	for _, tc := range testCases {
+		_, err := ArgumentList{Clusters: tc.clusters}.ClusterArgumentLists()
+		if err == nil {
+			t.Errorf("Expected error for clusters %s", tc.clusters)
+		} else if !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("Expected error containing '%s', got '%s'", tc.message, err.Error())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package args
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LoadConfigFile sets the arguments defined in the YAML or JSON config file at path. Each key of the file is the
+// name of an argument, such as zookeeper_hosts. Arguments passed on the command line or through environment
+// variables take precedence over the file. Must be called after the integration is created so the argument
+// flags are defined and parsed.
+func LoadConfigFile(path string) error {
+	return applyConfigFile(flag.CommandLine, path)
+}
+
+func applyConfigFile(flags *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config_file: %s", err)
+	}
+
+	// YAML is a superset of JSON, so JSON config files are parsed the same way
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse config_file '%s' as a YAML or JSON object: %s", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, raw := range config {
+		f := flags.Lookup(name)
+		if f == nil {
+			log.Warn("Ignoring unknown argument '%s' in config_file", name)
+			continue
+		}
+
+		if explicit[name] || os.Getenv(strings.ToUpper(name)) != "" {
+			log.Debug("Argument '%s' is set on the command line or environment, ignoring config_file value", name)
+			continue
+		}
+
+		value, err := configValue(raw)
+		if err != nil {
+			return fmt.Errorf("invalid value for '%s' in config_file: %s", name, err)
+		}
+
+		if err := f.Value.Set(value); err != nil {
+			return fmt.Errorf("invalid value for '%s' in config_file: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// configValue converts a config file value to the string form of the argument. Strings are used as is,
+// while numbers and booleans are used as written. Lists and maps are passed through as JSON, which is
+// what arguments such as zookeeper_hosts and producers expect.
+func configValue(raw interface{}) (string, error) {
+	switch value := raw.(type) {
+	case nil:
+		return "", fmt.Errorf("null is not a valid value")
+	case string:
+		return value, nil
+	case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+		jsonValue, err := toJSONValue(value)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(jsonValue)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// toJSONValue converts the maps parsed from YAML, which can have keys of any type, to maps with string keys
+// that can be marshaled to JSON
+func toJSONValue(raw interface{}) (interface{}, error) {
+	switch value := raw.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			convertedItem, err := toJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[key] = convertedItem
+		}
+		return converted, nil
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key %v is not a string", key)
+			}
+			convertedItem, err := toJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[keyString] = convertedItem
+		}
+		return converted, nil
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			convertedItem, err := toJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = convertedItem
+		}
+		return converted, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,120 @@
+package args
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "nri-kafka-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	var argList ArgumentList
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.StringVar(&argList.ClusterName, "cluster_name", "", "")
+	flags.StringVar(&argList.ZookeeperHosts, "zookeeper_hosts", "[]", "")
+	flags.IntVar(&argList.Timeout, "timeout", 10000, "")
+	flags.BoolVar(&argList.ConsumerOffset, "consumer_offset", false, "")
+	if err := flags.Parse([]string{"-cluster_name", "fromFlag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfigFile(t, `{
+		"cluster_name": "fromFile",
+		"zookeeper_hosts": [{"host": "zk1", "port": 2181}],
+		"timeout": 500,
+		"consumer_offset": true,
+		"unknown_key": "value"
+	}`)
+	defer os.Remove(path)
+
+	if err := applyConfigFile(flags, path); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if argList.ClusterName != "fromFlag" {
+		t.Errorf("Expected command line to win, got cluster_name '%s'", argList.ClusterName)
+	}
+	if argList.ZookeeperHosts != `[{"host":"zk1","port":2181}]` {
+		t.Errorf("Unexpected zookeeper_hosts '%s'", argList.ZookeeperHosts)
+	}
+	if argList.Timeout != 500 {
+		t.Errorf("Expected timeout 500, got %d", argList.Timeout)
+	}
+	if !argList.ConsumerOffset {
+		t.Error("Expected consumer_offset to be set")
+	}
+}
+
+func TestApplyConfigFile_YAML(t *testing.T) {
+	var argList ArgumentList
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.StringVar(&argList.ClusterName, "cluster_name", "", "")
+	flags.StringVar(&argList.ZookeeperHosts, "zookeeper_hosts", "[]", "")
+	flags.IntVar(&argList.Timeout, "timeout", 10000, "")
+	flags.BoolVar(&argList.ConsumerOffset, "consumer_offset", false, "")
+
+	path := writeConfigFile(t, `
+cluster_name: testcluster
+zookeeper_hosts:
+  - host: zk1
+    port: 2181
+timeout: 500
+consumer_offset: true
+`)
+	defer os.Remove(path)
+
+	if err := applyConfigFile(flags, path); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if argList.ClusterName != "testcluster" {
+		t.Errorf("Expected cluster_name testcluster, got '%s'", argList.ClusterName)
+	}
+	if argList.ZookeeperHosts != `[{"host":"zk1","port":2181}]` {
+		t.Errorf("Unexpected zookeeper_hosts '%s'", argList.ZookeeperHosts)
+	}
+	if argList.Timeout != 500 {
+		t.Errorf("Expected timeout 500, got %d", argList.Timeout)
+	}
+	if !argList.ConsumerOffset {
+		t.Error("Expected consumer_offset to be set")
+	}
+}
+
+func TestApplyConfigFile_Errors(t *testing.T) {
+	var timeout int
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.IntVar(&timeout, "timeout", 10000, "")
+
+	if err := applyConfigFile(flags, "/nonexistent/config.json"); err == nil {
+		t.Error("Expected error for missing file")
+	}
+
+	testCases := []string{
+		`["not", "an", "object"]`,
+		`{"timeout": "soon"}`,
+		`{"timeout": null}`,
+		"timeout: [unterminated",
+	}
+	for _, tc := range testCases {
+		path := writeConfigFile(t, tc)
+		if err := applyConfigFile(flags, path); err == nil {
+			t.Errorf("Expected error for config file %s", tc)
+		}
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,46 @@
+package args
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// unmarshalCustomAttributes parses the custom_attributes JSON object of attribute names and string values, sorted
+// by name. Attributes the integration reports itself, such as clusterName, are skipped with a warning.
+func unmarshalCustomAttributes(arg string) ([]metric.Attribute, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil
+	}
+
+	var rawAttributes map[string]string
+	if err := json.Unmarshal([]byte(arg), &rawAttributes); err != nil {
+		return nil, fmt.Errorf("custom_attributes is not a JSON object of string attributes: %s", err)
+	}
+
+	names := make([]string, 0, len(rawAttributes))
+	for name := range rawAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var attributes []metric.Attribute
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("custom_attributes has an attribute without a name")
+		}
+		if reservedAttributes[name] || name == "clusterNamespace" {
+			log.Warn("Ignoring custom attribute %s, the integration reports it", name)
+			continue
+		}
+
+		attributes = append(attributes, metric.Attribute{Key: name, Value: rawAttributes[name]})
+	}
+
+	return attributes, nil
+}
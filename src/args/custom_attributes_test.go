@@ -0,0 +1,33 @@
+package args
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+)
+
+func Test_unmarshalCustomAttributes(t *testing.T) {
+	for _, arg := range []string{"", "{}"} {
+		attributes, err := unmarshalCustomAttributes(arg)
+		if err != nil || attributes != nil {
+			t.Errorf("Expected no attributes and error for '%s', got %v and %v", arg, attributes, err)
+		}
+	}
+
+	// Reserved attributes are skipped
+	attributes, err := unmarshalCustomAttributes(`{"team": "payments", "environment": "prod", "clusterName": "other"}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	expected := []metric.Attribute{{Key: "environment", Value: "prod"}, {Key: "team", Value: "payments"}}
+	if !reflect.DeepEqual(attributes, expected) {
+		t.Errorf("Expected %v got %v", expected, attributes)
+	}
+
+	for _, arg := range []string{`["prod"]`, `{"environment": 1}`, `{" ": "prod"}`} {
+		if _, err := unmarshalCustomAttributes(arg); err == nil {
+			t.Errorf("Expected error for '%s'", arg)
+		}
+	}
+}
@@ -4,34 +4,113 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/Shopify/sarama"
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/args"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
 )
 
-// GlobalArgs represents the global arguments that were passed in
-var GlobalArgs *KafkaArguments
+// Default sample names for the *_sample_name arguments
+const (
+	DefaultBrokerSampleName = "KafkaBrokerSample"
+	DefaultTopicSampleName  = "KafkaTopicSample"
+	DefaultOffsetSampleName = "KafkaOffsetSample"
+)
+
+// DefaultTopicWorkerPoolSize is the number of topic workers used when topic_worker_pool_size isn't positive
+const DefaultTopicWorkerPoolSize = 5
+
+// DefaultDescribeBatchSize is the number of consumer groups described per request when describe_batch_size isn't positive
+const DefaultDescribeBatchSize = 100
+
+// Offset collection strategies for the offset_collection_strategy argument
+const (
+	OffsetStrategyAdmin = "admin"
+	OffsetStrategyTopic = "topic"
+)
+
+// High water mark offsets of the consumed partitions, for the hwm_offset_type argument
+const (
+	HWMOffsetLogEnd     = "log_end"
+	HWMOffsetLastStable = "last_stable"
+)
+
+// Lag compared to lag_threshold by check_lag, for the lag_threshold_mode argument
+const (
+	LagThresholdTotal = "total"
+	LagThresholdMax   = "max"
+)
 
 // KafkaArguments is an special version of the config arguments that has advanced parsing
 // to allow arguments to be consumed easier.
 type KafkaArguments struct {
 	sdkArgs.DefaultArgumentList
-	ClusterName            string
-	ZookeeperHosts         []*ZookeeperHost
-	ZookeeperAuthScheme    string
-	ZookeeperAuthSecret    string
-	ZookeeperPath          string
-	DefaultJMXUser         string
-	DefaultJMXPassword     string
-	CollectBrokerTopicData bool
-	Producers              []*JMXHost
-	Consumers              []*JMXHost
-	TopicMode              string
-	TopicList              []string
-	TopicRegex             string
-	Timeout                int
-	CollectTopicSize       bool
+	ClusterName               string
+	ClusterNamespace          string
+	CustomAttributes          []metric.Attribute
+	ZookeeperHosts            []*ZookeeperHost
+	ZookeeperObserverHosts    []*ZookeeperHost
+	ZookeeperAuthScheme       string
+	ZookeeperAuthSecret       string
+	ZookeeperPath             string
+	ZookeeperConnectRetries   int
+	ZookeeperConnectBackoffMs int
+	BootstrapBrokers          []string
+	BootstrapBrokersFile      string
+	RequireZookeeper          bool
+	MetadataRefreshIntervalMs int
+	TLSServerName             string
+	ProxyURL                  *url.URL
+	KafkaVersion              *sarama.KafkaVersion
+	DefaultJMXPort            int
+	DefaultJMXUser            string
+	DefaultJMXPassword        string
+	BrokerJMXPorts            map[string]int
+	CollectBrokerTopicData    bool
+	JMXBrokerIDs              []int
+	BrokerJMXBeans            []*JMXBean
+	BrokerCircuitFailures     int
+	BrokerCircuitCooldownMs   int
+	BrokerCollectionStaggerMs int
+	Producers                 []*JMXHost
+	Consumers                 []*JMXHost
+	TopicMode                 string
+	TopicList                 []string
+	TopicRegex                *regexp.Regexp
+	TopicRelabelRules         []*TopicRelabelRule
+	TopicOverrides            []*TopicOverride
+	IncludeInternalTopics     bool
+	Timeout                   int
+	TestConnection            bool
+	CheckLag                  bool
+	FailOnError               bool
+	CollectClientMetrics      bool
+	LagThreshold              int64
+	LagThresholdMode          string
+	CollectTopicSize          bool
+	CollectTopicThroughput    bool
+	TopicWorkerPoolSize       int
+	CollectInventory          bool
+	CollectTransactions       bool
+	CollectOffsetsTopic       bool
+	CollectZookeeperMetrics   bool
+	CollectLogDirSizes        bool
+	CollectQuotas             bool
+	MaxQuotaPrincipals        int
+
+	// Sample names
+	BrokerSampleName string
+	TopicSampleName  string
+	OffsetSampleName string
 
 	// SSL options
 	KeyStore           string
@@ -40,9 +119,27 @@ type KafkaArguments struct {
 	TrustStorePassword string
 
 	// Consumer offset arguments
-	ConsumerOffset     bool
-	ConsumerGroups     ConsumerGroups
-	ConsumerGroupRegex *regexp.Regexp
+	ConsumerOffset           bool
+	CollectHighWaterMarks    bool
+	HWMOffsetType            string
+	ConsumerGroups           ConsumerGroups
+	ConsumerGroupRegex       *regexp.Regexp
+	ConsumerGroupPartitions  map[string][]int32
+	LagKeys                  map[string][]string
+	CoordinatorBrokerIDs     []int
+	RefreshMetadataOnCollect bool
+	ConsumerOffsetStaggerMs  int
+	BestEffortDescribe       bool
+	DescribeBatchSize        int
+	OffsetStatePath          string
+	OffsetCollectionStrategy string
+	StallDetectionCycles     int
+	MaxPartitionsPerGroup    int
+	SkipGroupsOverPartitions int
+	CollectGroupMembers      bool
+	MaxGroupMembers          int
+	MinLagToReport           int
+	PartitionLagThreshold    int
 }
 
 // ZookeeperHost is a storage struct for ZooKeeper connection information
@@ -64,19 +161,36 @@ type JMXHost struct {
 // into more easily used structs
 func ParseArgs(a ArgumentList) (*KafkaArguments, error) {
 
+	// Expand environment variables so secrets don't have to be passed on the command line
+	if err := expandEnvVars(&a); err != nil {
+		return nil, err
+	}
+
+	// Read the secrets given as file:// or env: references, so they don't have to be in the config
+	if err := resolveSecretArgs(&a); err != nil {
+		return nil, err
+	}
+
 	// Parse ZooKeeper hosts
-	var zookeeperHosts []*ZookeeperHost
-	err := json.Unmarshal([]byte(a.ZookeeperHosts), &zookeeperHosts)
+	zookeeperHosts, err := unmarshalZookeeperHosts(a.ZookeeperHosts)
 	if err != nil {
 		log.Error("Failed to parse zookeepers from json")
 		return nil, err
 	}
 
-	for _, zookeeperHost := range zookeeperHosts {
-		// Set port to default if unset
-		if zookeeperHost.Port == 0 {
-			zookeeperHost.Port = defaultZookeeperPort
-		}
+	zookeeperObserverHosts, err := unmarshalZookeeperHosts(a.ZookeeperObserverHosts)
+	if err != nil {
+		log.Error("Failed to parse zookeeper observers from json")
+		return nil, err
+	}
+
+	// Zookeeper clusters are discovered through zookeeper_hosts and KRaft clusters through bootstrap_brokers
+	bootstrapBrokers, err := unmarshalBootstrapBrokers(a.BootstrapBrokers)
+	if err != nil {
+		return nil, err
+	}
+	if len(bootstrapBrokers) > 0 && len(zookeeperHosts) > 0 {
+		return nil, errors.New("zookeeper_hosts and bootstrap_brokers are mutually exclusive, use bootstrap_brokers_file to add brokers to those registered in Zookeeper")
 	}
 
 	// Parse consumers
@@ -93,6 +207,26 @@ func ParseArgs(a ArgumentList) (*KafkaArguments, error) {
 		return nil, err
 	}
 
+	brokerJMXPorts, err := unmarshalBrokerJMXPorts(a.BrokerJMXPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	jmxBrokerIDs, err := unmarshalBrokerIDs("jmx_broker_ids", a.JMXBrokerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	brokerJMXBeans, err := unmarshalBrokerJMXBeans(a.BrokerJMXBeans)
+	if err != nil {
+		return nil, err
+	}
+
+	customAttributes, err := unmarshalCustomAttributes(a.CustomAttributes)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse topics
 	var topics []string
 	if err = json.Unmarshal([]byte(a.TopicList), &topics); err != nil {
@@ -107,44 +241,386 @@ func ParseArgs(a ArgumentList) (*KafkaArguments, error) {
 		return nil, err
 	}
 
-	var consumerGroupRegex *regexp.Regexp
-	if a.ConsumerGroupRegex != "" {
-		consumerGroupRegex, err = regexp.Compile(a.ConsumerGroupRegex)
-		if err != nil {
-			log.Error("Error parsing consumer_group_regex as a regex pattern")
-			return nil, err
+	consumerGroupPartitions, err := unmarshalConsumerGroupPartitions(a.ConsumerGroupPartitions)
+	if err != nil {
+		return nil, err
+	}
+
+	lagKeys, err := unmarshalLagKeys(a.LagKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	coordinatorBrokerIDs, err := unmarshalBrokerIDs("coordinator_broker_ids", a.CoordinatorBrokerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	regexes, err := compileRegexArgs(a)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyURL, err := parseProxyURL(a.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateClusterName(a.ClusterName); err != nil {
+		return nil, fmt.Errorf("invalid cluster_name '%s': %s", a.ClusterName, err)
+	}
+	if a.ClusterNamespace != "" {
+		if err := validateClusterName(a.ClusterNamespace); err != nil {
+			return nil, fmt.Errorf("invalid cluster_namespace '%s': %s", a.ClusterNamespace, err)
+		}
+	}
+
+	// An empty sample name uses the default
+	for _, sampleName := range []struct {
+		arg          string
+		value        *string
+		defaultValue string
+	}{
+		{"broker_sample_name", &a.BrokerSampleName, DefaultBrokerSampleName},
+		{"topic_sample_name", &a.TopicSampleName, DefaultTopicSampleName},
+		{"offset_sample_name", &a.OffsetSampleName, DefaultOffsetSampleName},
+	} {
+		if *sampleName.value == "" {
+			*sampleName.value = sampleName.defaultValue
+		}
+		if err := validateSampleName(*sampleName.value); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %s", sampleName.arg, *sampleName.value, err)
 		}
 	}
 
+	switch a.OffsetCollectionStrategy {
+	case OffsetStrategyAdmin, OffsetStrategyTopic:
+	default:
+		return nil, fmt.Errorf("invalid offset_collection_strategy '%s', must be one of '%s' or '%s'", a.OffsetCollectionStrategy, OffsetStrategyAdmin, OffsetStrategyTopic)
+	}
+
+	topicWorkerPoolSize := a.TopicWorkerPoolSize
+	if topicWorkerPoolSize <= 0 {
+		topicWorkerPoolSize = DefaultTopicWorkerPoolSize
+	}
+
+	describeBatchSize := a.DescribeBatchSize
+	if describeBatchSize <= 0 {
+		describeBatchSize = DefaultDescribeBatchSize
+	}
+
+	lagThresholdMode := a.LagThresholdMode
+	switch lagThresholdMode {
+	case "":
+		lagThresholdMode = LagThresholdTotal
+	case LagThresholdTotal, LagThresholdMax:
+	default:
+		return nil, fmt.Errorf("invalid lag_threshold_mode '%s', must be one of '%s' or '%s'", a.LagThresholdMode, LagThresholdTotal, LagThresholdMax)
+	}
+
+	hwmOffsetType := a.HWMOffsetType
+	switch hwmOffsetType {
+	case "":
+		hwmOffsetType = HWMOffsetLogEnd
+	case HWMOffsetLogEnd, HWMOffsetLastStable:
+	default:
+		return nil, fmt.Errorf("invalid hwm_offset_type '%s', must be one of '%s' or '%s'", a.HWMOffsetType, HWMOffsetLogEnd, HWMOffsetLastStable)
+	}
+
+	kafkaVersion, err := parseKafkaVersion(a.KafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+
 	parsedArgs := &KafkaArguments{
-		DefaultArgumentList:    a.DefaultArgumentList,
-		ClusterName:            a.ClusterName,
-		ZookeeperHosts:         zookeeperHosts,
-		ZookeeperAuthScheme:    a.ZookeeperAuthScheme,
-		ZookeeperAuthSecret:    a.ZookeeperAuthSecret,
-		ZookeeperPath:          a.ZookeeperPath,
-		DefaultJMXUser:         a.DefaultJMXUser,
-		DefaultJMXPassword:     a.DefaultJMXPassword,
-		CollectBrokerTopicData: a.CollectBrokerTopicData,
-		Producers:              producers,
-		Consumers:              consumers,
-		TopicMode:              a.TopicMode,
-		TopicList:              topics,
-		TopicRegex:             a.TopicRegex,
-		Timeout:                a.Timeout,
-		KeyStore:               a.KeyStore,
-		KeyStorePassword:       a.KeyStorePassword,
-		TrustStore:             a.TrustStore,
-		TrustStorePassword:     a.TrustStorePassword,
-		CollectTopicSize:       a.CollectTopicSize,
-		ConsumerOffset:         a.ConsumerOffset,
-		ConsumerGroups:         consumerGroups,
-		ConsumerGroupRegex:     consumerGroupRegex,
+		DefaultArgumentList:       a.DefaultArgumentList,
+		ClusterName:               a.ClusterName,
+		ClusterNamespace:          a.ClusterNamespace,
+		CustomAttributes:          customAttributes,
+		ZookeeperHosts:            zookeeperHosts,
+		ZookeeperObserverHosts:    zookeeperObserverHosts,
+		ZookeeperAuthScheme:       a.ZookeeperAuthScheme,
+		ZookeeperAuthSecret:       a.ZookeeperAuthSecret,
+		ZookeeperPath:             a.ZookeeperPath,
+		ZookeeperConnectRetries:   a.ZookeeperConnectRetries,
+		ZookeeperConnectBackoffMs: a.ZookeeperConnectBackoffMs,
+		BootstrapBrokers:          bootstrapBrokers,
+		BootstrapBrokersFile:      a.BootstrapBrokersFile,
+		RequireZookeeper:          a.RequireZookeeper,
+		MetadataRefreshIntervalMs: a.MetadataRefreshIntervalMs,
+		TLSServerName:             a.TLSServerName,
+		ProxyURL:                  proxyURL,
+		KafkaVersion:              kafkaVersion,
+		DefaultJMXPort:            a.DefaultJMXPort,
+		DefaultJMXUser:            a.DefaultJMXUser,
+		DefaultJMXPassword:        a.DefaultJMXPassword,
+		BrokerJMXPorts:            brokerJMXPorts,
+		CollectBrokerTopicData:    a.CollectBrokerTopicData,
+		JMXBrokerIDs:              jmxBrokerIDs,
+		BrokerJMXBeans:            brokerJMXBeans,
+		BrokerCircuitFailures:     a.BrokerCircuitFailures,
+		BrokerCircuitCooldownMs:   a.BrokerCircuitCooldownMs,
+		BrokerCollectionStaggerMs: a.BrokerCollectionStaggerMs,
+		Producers:                 producers,
+		Consumers:                 consumers,
+		TopicMode:                 a.TopicMode,
+		TopicList:                 topics,
+		TopicRegex:                regexes.Topic,
+		TopicRelabelRules:         regexes.TopicRelabel,
+		TopicOverrides:            regexes.TopicOverride,
+		IncludeInternalTopics:     a.IncludeInternalTopics,
+		Timeout:                   a.Timeout,
+		TestConnection:            a.TestConnection,
+		CheckLag:                  a.CheckLag,
+		FailOnError:               a.FailOnError,
+		CollectClientMetrics:      a.CollectClientMetrics,
+		LagThreshold:              int64(a.LagThreshold),
+		LagThresholdMode:          lagThresholdMode,
+		BrokerSampleName:          a.BrokerSampleName,
+		TopicSampleName:           a.TopicSampleName,
+		OffsetSampleName:          a.OffsetSampleName,
+		KeyStore:                  a.KeyStore,
+		KeyStorePassword:          a.KeyStorePassword,
+		TrustStore:                a.TrustStore,
+		TrustStorePassword:        a.TrustStorePassword,
+		CollectTopicSize:          a.CollectTopicSize,
+		CollectTopicThroughput:    a.CollectTopicThroughput,
+		TopicWorkerPoolSize:       topicWorkerPoolSize,
+		CollectInventory:          a.CollectInventory,
+		CollectTransactions:       a.CollectTransactions,
+		CollectOffsetsTopic:       a.CollectOffsetsTopic,
+		CollectZookeeperMetrics:   a.CollectZookeeperMetrics,
+		CollectLogDirSizes:        a.CollectLogDirSizes,
+		CollectQuotas:             a.CollectQuotas,
+		MaxQuotaPrincipals:        a.MaxQuotaPrincipals,
+		ConsumerOffset:            a.ConsumerOffset,
+		CollectHighWaterMarks:     a.CollectHighWaterMarks,
+		HWMOffsetType:             hwmOffsetType,
+		ConsumerGroups:            consumerGroups,
+		ConsumerGroupRegex:        regexes.ConsumerGroup,
+		ConsumerGroupPartitions:   consumerGroupPartitions,
+		LagKeys:                   lagKeys,
+		CoordinatorBrokerIDs:      coordinatorBrokerIDs,
+		RefreshMetadataOnCollect:  a.RefreshMetadataOnCollect,
+		ConsumerOffsetStaggerMs:   a.ConsumerOffsetStaggerMs,
+		BestEffortDescribe:        a.BestEffortDescribe,
+		DescribeBatchSize:         describeBatchSize,
+		OffsetStatePath:           a.OffsetStatePath,
+		OffsetCollectionStrategy:  a.OffsetCollectionStrategy,
+		StallDetectionCycles:      a.StallDetectionCycles,
+		MaxPartitionsPerGroup:     a.MaxPartitionsPerGroup,
+		SkipGroupsOverPartitions:  a.SkipGroupsOverPartitions,
+		CollectGroupMembers:       a.CollectGroupMembers,
+		MaxGroupMembers:           a.MaxGroupMembers,
+		MinLagToReport:            a.MinLagToReport,
+		PartitionLagThreshold:     a.PartitionLagThreshold,
+	}
+
+	if err := checkFeatureVersions(parsedArgs); err != nil {
+		return nil, err
 	}
 
 	return parsedArgs, nil
 }
 
+// maxClusterNameLength is the longest cluster_name, which is part of the name of every entity of the cluster
+const maxClusterNameLength = 128
+
+// clusterNamePattern matches the characters allowed in cluster names, which can't include the colons and slashes
+// entity names are made of
+var clusterNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// validateClusterName checks that a cluster_name or cluster_namespace can be used in the entity ID attributes:
+// not empty, at most 128 characters, and made up of alphanumerics, dots, underscores and hyphens
+func validateClusterName(name string) error {
+	if name == "" {
+		return errors.New("cluster name is required")
+	}
+	if len(name) > maxClusterNameLength {
+		return fmt.Errorf("cluster name is longer than %d characters", maxClusterNameLength)
+	}
+	if !clusterNamePattern.MatchString(name) {
+		return errors.New("cluster name can only contain alphanumeric characters, dots, underscores and hyphens")
+	}
+
+	return nil
+}
+
+// parseProxyURL parses the proxy_url, which must be a socks5:// URL with a host. An empty proxy_url is no proxy.
+func parseProxyURL(arg string) (*url.URL, error) {
+	if arg == "" {
+		return nil, nil
+	}
+
+	// The error of url.Parse has the URL in it, and with it the proxy password
+	proxyURL, err := url.Parse(arg)
+	if err != nil {
+		return nil, errors.New("invalid proxy_url: not a URL")
+	}
+	if proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h" {
+		return nil, fmt.Errorf("invalid proxy_url: scheme '%s' is not socks5", proxyURL.Scheme)
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, errors.New("invalid proxy_url: no proxy host")
+	}
+	if port := proxyURL.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid proxy_url: port '%s' must be between 1 and 65535", port)
+		}
+	}
+
+	return proxyURL, nil
+}
+
+// maxSampleNameLength is the longest event type name New Relic accepts
+const maxSampleNameLength = 255
+
+// sampleNamePattern matches the characters New Relic allows in event type names
+var sampleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_:]+$`)
+
+// validateSampleName checks a sample name against the New Relic event type naming rules: at most
+// 255 characters, made up of alphanumerics, underscores and colons
+func validateSampleName(name string) error {
+	if len(name) > maxSampleNameLength {
+		return fmt.Errorf("sample name is longer than %d characters", maxSampleNameLength)
+	}
+	if !sampleNamePattern.MatchString(name) {
+		return errors.New("sample name can only contain alphanumeric characters, underscores and colons")
+	}
+
+	return nil
+}
+
+// envVarPattern matches ${NAME} references to environment variables, and the escaped $${NAME} form
+var envVarPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// camelCase splits a field name into words the same way the SDK derives argument names from field names
+var camelCase = regexp.MustCompile("(^[^A-Z]*|[A-Z]*)([A-Z][^A-Z]+|$)")
+
+// expandEnvVars replaces ${NAME} in every string argument with the value of the environment variable NAME.
+// An error is returned if a referenced variable is not set. $${NAME} is left as the literal ${NAME}.
+func expandEnvVars(a *ArgumentList) error {
+	val := reflect.ValueOf(a).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		if field.Kind() != reflect.String {
+			continue
+		}
+
+		name := argumentName(val.Type().Field(i).Name)
+		var missing []string
+		expanded := envVarPattern.ReplaceAllStringFunc(field.String(), func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+
+			envName := envVarPattern.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(envName)
+			if !ok {
+				missing = append(missing, envName)
+			}
+			return value
+		})
+
+		if len(missing) > 0 {
+			return fmt.Errorf("argument %s references environment variables that are not set: %s", name, strings.Join(missing, ", "))
+		}
+		field.SetString(expanded)
+	}
+
+	return nil
+}
+
+// argumentName returns the argument name of an ArgumentList field, such as zookeeper_auth_secret for ZookeeperAuthSecret
+func argumentName(fieldName string) string {
+	var words []string
+	for _, sub := range camelCase.FindAllStringSubmatch(fieldName, -1) {
+		if sub[1] != "" {
+			words = append(words, sub[1])
+		}
+		if sub[2] != "" {
+			words = append(words, sub[2])
+		}
+	}
+	return strings.ToLower(strings.Join(words, "_"))
+}
+
+// RegexArgs holds the compiled regex arguments. A nil regex means the argument is unset.
+type RegexArgs struct {
+	ConsumerGroup *regexp.Regexp
+	Topic         *regexp.Regexp
+	MetricAllow   *regexp.Regexp
+	MetricDeny    *regexp.Regexp
+	TopicRelabel  []*TopicRelabelRule
+	TopicOverride []*TopicOverride
+}
+
+// ValidateArgs compiles every regex argument, after expanding environment variables, so an invalid
+// pattern fails the integration at startup before any connection is made. Secret references are resolved
+// too, so a missing secret file fails at startup as well. The error names the argument.
+func ValidateArgs(a ArgumentList) (*RegexArgs, error) {
+	if err := expandEnvVars(&a); err != nil {
+		return nil, err
+	}
+
+	if err := resolveSecretArgs(&a); err != nil {
+		return nil, err
+	}
+
+	return compileRegexArgs(a)
+}
+
+// compileRegexArgs compiles the regex arguments of arguments that already had their environment variables expanded
+func compileRegexArgs(a ArgumentList) (*RegexArgs, error) {
+	regexes := &RegexArgs{}
+	for _, arg := range []struct {
+		name    string
+		pattern string
+		regex   **regexp.Regexp
+	}{
+		{"consumer_group_regex", a.ConsumerGroupRegex, &regexes.ConsumerGroup},
+		{"topic_regex", a.TopicRegex, &regexes.Topic},
+		{"metric_allow_regex", a.MetricAllowRegex, &regexes.MetricAllow},
+		{"metric_deny_regex", a.MetricDenyRegex, &regexes.MetricDeny},
+	} {
+		regex, err := compileRegexArg(arg.name, arg.pattern)
+		if err != nil {
+			return nil, err
+		}
+		*arg.regex = regex
+	}
+
+	topicRelabelRules, err := unmarshalTopicRelabelRules(a.TopicRelabelRules)
+	if err != nil {
+		return nil, err
+	}
+	regexes.TopicRelabel = topicRelabelRules
+
+	topicOverrides, err := unmarshalTopicOverrides(a.TopicOverrides)
+	if err != nil {
+		return nil, err
+	}
+	regexes.TopicOverride = topicOverrides
+
+	return regexes, nil
+}
+
+// compileRegexArg compiles the regex argument called name. Surrounding whitespace
+// is ignored and an empty pattern returns a nil regex, meaning the argument is unset.
+func compileRegexArg(name, pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, nil
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s '%s' is not a valid regular expression: %s", name, pattern, err)
+	}
+
+	return regex, nil
+}
+
 // unmarshalJMXHosts parses the user-provided JSON map for a producer
 // or consumers into a jmxHost structs and sets default values
 func unmarshalJMXHosts(data []byte, a *ArgumentList) ([]*JMXHost, error) {
@@ -165,6 +641,12 @@ func unmarshalJMXHosts(data []byte, a *ArgumentList) ([]*JMXHost, error) {
 		}
 		if p.Password == "" {
 			p.Password = a.DefaultJMXPassword
+		} else {
+			password, err := resolveSecret(p.Password)
+			if err != nil {
+				return nil, fmt.Errorf("password of %s: %s", p.Name, err)
+			}
+			p.Password = password
 		}
 		if p.Port == 0 {
 			p.Port = a.DefaultJMXPort
@@ -177,6 +659,148 @@ func unmarshalJMXHosts(data []byte, a *ArgumentList) ([]*JMXHost, error) {
 	return v, nil
 }
 
+// unmarshalZookeeperHosts parses a JSON array of Zookeeper hosts, setting the port of the hosts without one to the default
+// and removing the brackets around IPv6 hosts
+func unmarshalZookeeperHosts(zookeeperHostsArg string) ([]*ZookeeperHost, error) {
+	if strings.TrimSpace(zookeeperHostsArg) == "" {
+		return nil, nil
+	}
+
+	var zookeeperHosts []*ZookeeperHost
+	if err := json.Unmarshal([]byte(zookeeperHostsArg), &zookeeperHosts); err != nil {
+		return nil, err
+	}
+
+	for _, zookeeperHost := range zookeeperHosts {
+		// IPv6 literals may be written with or without brackets, the port is a separate field
+		zookeeperHost.Host = strings.TrimSuffix(strings.TrimPrefix(zookeeperHost.Host, "["), "]")
+
+		// Set port to default if unset
+		if zookeeperHost.Port == 0 {
+			zookeeperHost.Port = defaultZookeeperPort
+		}
+	}
+
+	return zookeeperHosts, nil
+}
+
+// unmarshalBootstrapBrokers parses the bootstrap_brokers JSON array of host:port broker addresses, with IPv6
+// hosts in brackets
+func unmarshalBootstrapBrokers(bootstrapBrokersArg string) ([]string, error) {
+	if strings.TrimSpace(bootstrapBrokersArg) == "" {
+		return nil, nil
+	}
+
+	var brokers []string
+	if err := json.Unmarshal([]byte(bootstrapBrokersArg), &brokers); err != nil {
+		return nil, fmt.Errorf("failed to parse bootstrap_brokers from json: %s", err)
+	}
+
+	for _, broker := range brokers {
+		host, port, err := net.SplitHostPort(broker)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("bootstrap_brokers address '%s' is not of the form host:port", broker)
+		}
+		if portNumber, err := strconv.Atoi(port); err != nil || portNumber < 1 || portNumber > 65535 {
+			return nil, fmt.Errorf("bootstrap_brokers address '%s' has an invalid port", broker)
+		}
+	}
+	if len(brokers) == 0 {
+		return nil, nil
+	}
+
+	return brokers, nil
+}
+
+// unmarshalBrokerIDs parses a JSON array of broker IDs, such as jmx_broker_ids, of the argument named argName
+func unmarshalBrokerIDs(argName, brokerIDsArg string) ([]int, error) {
+	if strings.TrimSpace(brokerIDsArg) == "" {
+		return nil, nil
+	}
+
+	var ids []int
+	if err := json.Unmarshal([]byte(brokerIDsArg), &ids); err != nil {
+		return nil, fmt.Errorf("failed to parse %s from json: %s", argName, err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return ids, nil
+}
+
+// unmarshalBrokerJMXPorts parses the broker_jmx_ports JSON object of broker IDs or hosts to JMX ports
+func unmarshalBrokerJMXPorts(brokerJMXPortsArg string) (map[string]int, error) {
+	if strings.TrimSpace(brokerJMXPortsArg) == "" {
+		return nil, nil
+	}
+
+	var ports map[string]int
+	if err := json.Unmarshal([]byte(brokerJMXPortsArg), &ports); err != nil {
+		return nil, fmt.Errorf("failed to parse broker_jmx_ports from json: %s", err)
+	}
+
+	for broker, port := range ports {
+		if broker == "" {
+			return nil, errors.New("broker_jmx_ports keys must be a broker ID or host")
+		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid broker_jmx_ports port %d for broker '%s', must be between 1 and 65535", port, broker)
+		}
+	}
+
+	if len(ports) == 0 {
+		return nil, nil
+	}
+
+	return ports, nil
+}
+
+// BrokerJMXPort returns the JMX port of the broker from broker_jmx_ports, by broker ID and then by host,
+// or registeredPort, the port the broker registered in Zookeeper, if it isn't in the map
+func (k *KafkaArguments) BrokerJMXPort(brokerID int, host string, registeredPort int) int {
+	if port, ok := k.BrokerJMXPorts[strconv.Itoa(brokerID)]; ok {
+		return port
+	}
+	if port, ok := k.BrokerJMXPorts[host]; ok {
+		return port
+	}
+
+	return registeredPort
+}
+
+// CollectsBrokerJMX returns whether the JMX metrics of the broker are collected, which they are for every
+// broker unless jmx_broker_ids lists the brokers to collect them from
+func (k *KafkaArguments) CollectsBrokerJMX(brokerID int) bool {
+	if len(k.JMXBrokerIDs) == 0 {
+		return true
+	}
+
+	for _, id := range k.JMXBrokerIDs {
+		if id == brokerID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CollectsCoordinator returns whether the offsets of the consumer groups coordinated by the broker are collected,
+// which they are for every broker unless coordinator_broker_ids lists the coordinators to collect them from
+func (k *KafkaArguments) CollectsCoordinator(brokerID int) bool {
+	if len(k.CoordinatorBrokerIDs) == 0 {
+		return true
+	}
+
+	for _, id := range k.CoordinatorBrokerIDs {
+		if id == brokerID {
+			return true
+		}
+	}
+
+	return false
+}
+
 // ConsumerGroups is the structure to represent the whitelist for
 // consumer_groups argument
 type ConsumerGroups map[string]map[string][]int32
@@ -196,6 +820,59 @@ func unmarshalConsumerGroups(consumerOffset bool, consumerGroupsArg string) (Con
 	return consumerGroups, validateConsumerGroups(consumerGroups)
 }
 
+// unmarshalConsumerGroupPartitions parses the consumer_group_partitions JSON object of topics to the partitions
+// collected of them. Every topic must list at least one partition.
+func unmarshalConsumerGroupPartitions(arg string) (map[string][]int32, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil
+	}
+
+	var topicPartitions map[string][]int32
+	if err := json.Unmarshal([]byte(arg), &topicPartitions); err != nil {
+		return nil, fmt.Errorf("consumer_group_partitions is not a JSON object of topics to partitions: %s", err)
+	}
+	if len(topicPartitions) == 0 {
+		return nil, nil
+	}
+
+	for topic, partitions := range topicPartitions {
+		if len(partitions) == 0 {
+			return nil, fmt.Errorf("consumer_group_partitions lists no partitions for topic '%s', leave it out to collect all of them", topic)
+		}
+		for _, partition := range partitions {
+			if partition < 0 {
+				return nil, fmt.Errorf("consumer_group_partitions has invalid partition %d for topic '%s'", partition, topic)
+			}
+		}
+	}
+
+	return topicPartitions, nil
+}
+
+// unmarshalLagKeys parses the lag_keys JSON object of topics to the message keys whose lag is reported. Every
+// topic must list at least one key.
+func unmarshalLagKeys(arg string) (map[string][]string, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil
+	}
+
+	var topicKeys map[string][]string
+	if err := json.Unmarshal([]byte(arg), &topicKeys); err != nil {
+		return nil, fmt.Errorf("lag_keys is not a JSON object of topics to keys: %s", err)
+	}
+	if len(topicKeys) == 0 {
+		return nil, nil
+	}
+
+	for topic, keys := range topicKeys {
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("lag_keys lists no keys for topic '%s'", topic)
+		}
+	}
+
+	return topicKeys, nil
+}
+
 func validateConsumerGroups(groups ConsumerGroups) error {
 	for groupName, topics := range groups {
 		if len(topics) == 0 {
@@ -205,3 +882,30 @@ func validateConsumerGroups(groups ConsumerGroups) error {
 
 	return nil
 }
+
+// NamespaceIDAttributes returns the ID attribute of the cluster_namespace, if set, to tell apart the entities of
+// clusters with the same cluster_name in different namespaces
+func (k *KafkaArguments) NamespaceIDAttributes() []integration.IDAttribute {
+	if k.ClusterNamespace == "" {
+		return nil
+	}
+
+	return []integration.IDAttribute{integration.NewIDAttribute("clusterNamespace", k.ClusterNamespace)}
+}
+
+// ClusterIDAttributes returns the ID attributes of the entities that belong to the cluster: the cluster_name
+// and, if set, the cluster_namespace
+func (k *KafkaArguments) ClusterIDAttributes() []integration.IDAttribute {
+	return append([]integration.IDAttribute{integration.NewIDAttribute("clusterName", k.ClusterName)}, k.NamespaceIDAttributes()...)
+}
+
+// StateKeyPrefix returns the prefix of the keys of the state persisted between runs for the cluster: the
+// cluster_name, preceded by the cluster_namespace if set so clusters with the same name don't share state.
+// Neither can contain a slash, so the prefixes of different clusters never collide.
+func (k *KafkaArguments) StateKeyPrefix() string {
+	if k.ClusterNamespace == "" {
+		return k.ClusterName
+	}
+
+	return k.ClusterNamespace + "/" + k.ClusterName
+}
@@ -0,0 +1,116 @@
+package args
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// versionedFeature is a feature that makes protocol requests older brokers don't support
+type versionedFeature struct {
+	arg     string
+	version sarama.KafkaVersion
+	enabled func(*KafkaArguments) bool
+	// disable turns the feature off, nil if the collection can't run without it
+	disable func(*KafkaArguments)
+}
+
+// versionedFeatures lists the minimum Kafka version of each feature that needs one
+var versionedFeatures = []versionedFeature{
+	{
+		// DescribeConfigs
+		arg:     "collect_inventory",
+		version: sarama.V0_11_0_0,
+		enabled: func(k *KafkaArguments) bool { return k.AnyTopicCollectsInventory() },
+		disable: func(k *KafkaArguments) {
+			k.CollectInventory = false
+			k.clearTopicOption(func(o *TopicOverride) { o.CollectInventory = nil })
+		},
+	},
+	{
+		// The __transaction_state topic
+		arg:     "collect_transactions",
+		version: sarama.V0_11_0_0,
+		enabled: func(k *KafkaArguments) bool { return k.CollectTransactions },
+		disable: func(k *KafkaArguments) { k.CollectTransactions = false },
+	},
+	{
+		// DescribeLogDirs
+		arg:     "collect_log_dir_sizes",
+		version: sarama.V1_0_0_0,
+		enabled: func(k *KafkaArguments) bool { return k.AnyTopicCollectsLogDirSizes() },
+		disable: func(k *KafkaArguments) {
+			k.CollectLogDirSizes = false
+			k.clearTopicOption(func(o *TopicOverride) { o.CollectLogDirSizes = nil })
+		},
+	},
+	{
+		// ListOffsets v2, the first with an isolation level
+		arg:     "hwm_offset_type 'last_stable'",
+		version: sarama.V0_11_0_0,
+		enabled: func(k *KafkaArguments) bool {
+			return k.ConsumerOffset && k.CollectHighWaterMarks && k.HWMOffsetType == HWMOffsetLastStable
+		},
+		disable: func(k *KafkaArguments) { k.HWMOffsetType = HWMOffsetLogEnd },
+	},
+	{
+		// ListGroups, DescribeGroups and OffsetFetch for every partition of a group
+		arg:     "offset_collection_strategy 'admin'",
+		version: sarama.V0_10_2_0,
+		enabled: func(k *KafkaArguments) bool {
+			return k.ConsumerOffset && k.OffsetCollectionStrategy == OffsetStrategyAdmin
+		},
+	},
+}
+
+// parseKafkaVersion parses the kafka_version argument. An empty version returns nil, meaning it's detected.
+func parseKafkaVersion(version string) (*sarama.KafkaVersion, error) {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return nil, nil
+	}
+
+	kafkaVersion, err := sarama.ParseKafkaVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka_version '%s', must be of the form 2.0.0 or 0.10.2.0: %s", version, err)
+	}
+
+	return &kafkaVersion, nil
+}
+
+// checkFeatureVersions returns an error for the first enabled feature that needs a newer Kafka version than the
+// configured kafka_version
+func checkFeatureVersions(k *KafkaArguments) error {
+	if k.KafkaVersion == nil {
+		return nil
+	}
+
+	for _, feature := range versionedFeatures {
+		if feature.enabled(k) && !k.KafkaVersion.IsAtLeast(feature.version) {
+			return fmt.Errorf("%s requires Kafka %s or newer, but kafka_version is %s", feature.arg, feature.version, k.KafkaVersion)
+		}
+	}
+
+	return nil
+}
+
+// GateFeatures turns off the enabled features the detected Kafka version doesn't support, with a warning, so
+// their requests aren't attempted. Returns an error if the collection can't run without one of them.
+func (k *KafkaArguments) GateFeatures(detected sarama.KafkaVersion) error {
+	for _, feature := range versionedFeatures {
+		if !feature.enabled(k) || detected.IsAtLeast(feature.version) {
+			continue
+		}
+
+		if feature.disable == nil {
+			return fmt.Errorf("%s requires Kafka %s or newer, but the detected version is %s", feature.arg, feature.version, detected)
+		}
+
+		log.Warn("%s requires Kafka %s or newer, but the detected version is %s. Not collecting it", feature.arg, feature.version, detected)
+		feature.disable(k)
+	}
+
+	return nil
+}
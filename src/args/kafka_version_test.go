@@ -0,0 +1,94 @@
+package args
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func Test_parseKafkaVersion(t *testing.T) {
+	version, err := parseKafkaVersion(" ")
+	if err != nil || version != nil {
+		t.Errorf("Expected nil version and error for empty version, got %v and %v", version, err)
+	}
+
+	for input, expected := range map[string]sarama.KafkaVersion{
+		"2.0.0":    sarama.V2_0_0_0,
+		"0.10.2.0": sarama.V0_10_2_0,
+	} {
+		version, err := parseKafkaVersion(input)
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", input, err.Error())
+		} else if *version != expected {
+			t.Errorf("Expected %s got %s", expected, version)
+		}
+	}
+
+	_, err = parseKafkaVersion("2.0")
+	if err == nil || !strings.Contains(err.Error(), "invalid kafka_version '2.0'") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseArgs_FeatureRequiresNewerVersion(t *testing.T) {
+	a := ArgumentList{
+		ClusterName:              "testcluster",
+		ZookeeperHosts:           "[]",
+		Producers:                "[]",
+		Consumers:                "[]",
+		TopicList:                "[]",
+		OffsetCollectionStrategy: "admin",
+		KafkaVersion:             "0.10.2.0",
+		CollectInventory:         true,
+	}
+
+	_, err := ParseArgs(a)
+	if err == nil {
+		t.Fatal("Expected error for collect_inventory with kafka_version 0.10.2.0")
+	}
+	if err.Error() != "collect_inventory requires Kafka 0.11.0.0 or newer, but kafka_version is 0.10.2.0" {
+		t.Errorf("Unexpected error message: %s", err.Error())
+	}
+
+	a.KafkaVersion = "1.0.0"
+	parsedArgs, err := ParseArgs(a)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if *parsedArgs.KafkaVersion != sarama.V1_0_0_0 {
+		t.Errorf("Expected version 1.0.0 got %s", parsedArgs.KafkaVersion)
+	}
+}
+
+func TestGateFeatures(t *testing.T) {
+	k := &KafkaArguments{CollectInventory: true, CollectTransactions: true}
+	if err := k.GateFeatures(sarama.V0_10_2_0); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if k.CollectInventory || k.CollectTransactions {
+		t.Error("Expected features unsupported by 0.10.2.0 to be turned off")
+	}
+
+	k = &KafkaArguments{CollectInventory: true}
+	if err := k.GateFeatures(sarama.V2_0_0_0); err != nil || !k.CollectInventory {
+		t.Errorf("Expected collect_inventory to stay on with 2.0.0, got error %v", err)
+	}
+
+	// The high water marks fall back to the log end offset
+	k = &KafkaArguments{ConsumerOffset: true, CollectHighWaterMarks: true, HWMOffsetType: HWMOffsetLastStable}
+	if err := k.GateFeatures(sarama.V0_10_2_0); err != nil || k.HWMOffsetType != HWMOffsetLogEnd {
+		t.Errorf("Expected hwm_offset_type log_end with 0.10.2.0, got %s and error %v", k.HWMOffsetType, err)
+	}
+
+	// The offset collection can't run without its requests
+	k = &KafkaArguments{ConsumerOffset: true, OffsetCollectionStrategy: OffsetStrategyAdmin}
+	if err := k.GateFeatures(sarama.V0_10_0_0); err == nil {
+		t.Error("Expected error for the admin offset strategy with 0.10.0.0")
+	}
+
+	k.OffsetCollectionStrategy = OffsetStrategyTopic
+	if err := k.GateFeatures(sarama.V0_10_0_0); err != nil {
+		t.Errorf("Unexpected error for the topic offset strategy: %s", err.Error())
+	}
+}
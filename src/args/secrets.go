@@ -0,0 +1,58 @@
+package args
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Prefixes of secret references, which read a secret argument from a file or an environment variable
+// instead of having it in the config
+const (
+	secretFilePrefix = "file://"
+	secretEnvPrefix  = "env:"
+)
+
+// secretArgs are the ArgumentList fields holding secrets, which may be secret references
+var secretArgs = []string{"ZookeeperAuthSecret", "DefaultJMXPassword", "KeyStorePassword", "TrustStorePassword", "ProxyURL"}
+
+// resolveSecretArgs replaces every secret argument that is a secret reference with the secret it references
+func resolveSecretArgs(a *ArgumentList) error {
+	val := reflect.ValueOf(a).Elem()
+	for _, fieldName := range secretArgs {
+		field := val.FieldByName(fieldName)
+		secret, err := resolveSecret(field.String())
+		if err != nil {
+			return fmt.Errorf("argument %s: %s", argumentName(fieldName), err)
+		}
+		field.SetString(secret)
+	}
+
+	return nil
+}
+
+// resolveSecret returns the secret referenced by value, either file:///path/to/secret, read from the file
+// without its trailing newline, or env:NAME, read from the environment variable NAME. Any other value is
+// the secret itself.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		path := strings.TrimPrefix(value, secretFilePrefix)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read secret file: %s", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,91 @@
+package args
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_resolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jmx_password")
+	if err := ioutil.WriteFile(path, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("NRI_KAFKA_TEST_SECRET", "env-secret")
+	defer os.Unsetenv("NRI_KAFKA_TEST_SECRET")
+
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{"file://" + path, "file-secret"},
+		{"env:NRI_KAFKA_TEST_SECRET", "env-secret"},
+		{"plain-secret", "plain-secret"},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		secret, err := resolveSecret(tc.value)
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", tc.value, err.Error())
+		}
+		if secret != tc.expected {
+			t.Errorf("Expected '%s' got '%s'", tc.expected, secret)
+		}
+	}
+}
+
+func Test_resolveSecret_Errors(t *testing.T) {
+	if _, err := resolveSecret("file:///nonexistent/nri-kafka/secret"); err == nil {
+		t.Error("Expected error for a missing secret file")
+	}
+
+	os.Unsetenv("NRI_KAFKA_TEST_MISSING")
+	if _, err := resolveSecret("env:NRI_KAFKA_TEST_MISSING"); err == nil {
+		t.Error("Expected error for an unset secret environment variable")
+	}
+}
+
+func TestParseArgs_SecretReferences(t *testing.T) {
+	os.Setenv("NRI_KAFKA_TEST_SECRET", "env-secret")
+	defer os.Unsetenv("NRI_KAFKA_TEST_SECRET")
+
+	a := ArgumentList{
+		ClusterName:              "testcluster",
+		ZookeeperHosts:           "[]",
+		ZookeeperAuthSecret:      "env:NRI_KAFKA_TEST_SECRET",
+		DefaultJMXPassword:       "env:NRI_KAFKA_TEST_SECRET",
+		TrustStorePassword:       "env:NRI_KAFKA_TEST_SECRET",
+		Producers:                `[{"name":"producer1","password":"env:NRI_KAFKA_TEST_SECRET"}]`,
+		Consumers:                "[]",
+		TopicList:                "[]",
+		ConsumerGroups:           "{}",
+		OffsetCollectionStrategy: OffsetStrategyAdmin,
+		BrokerSampleName:         DefaultBrokerSampleName,
+		TopicSampleName:          DefaultTopicSampleName,
+		OffsetSampleName:         DefaultOffsetSampleName,
+	}
+
+	parsed, err := ParseArgs(a)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	for _, secret := range []string{parsed.ZookeeperAuthSecret, parsed.DefaultJMXPassword, parsed.TrustStorePassword, parsed.Producers[0].Password} {
+		if secret != "env-secret" {
+			t.Errorf("Expected the secret to be resolved, got '%s'", secret)
+		}
+	}
+
+	a.KeyStorePassword = "file:///nonexistent/nri-kafka/secret"
+	if _, err := ValidateArgs(a); err == nil || !strings.Contains(err.Error(), "key_store_password") {
+		t.Errorf("Expected error naming key_store_password, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package args
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TopicOverride sets the collection of the topics it matches, overriding the global argument of the same name
+// for every option that isn't nil
+type TopicOverride struct {
+	// Topic is the name of the topic the override matches, empty if it matches Regex instead
+	Topic string `json:"-"`
+	// Regex matches the whole name of the topics the override matches, nil if it matches Topic instead
+	Regex *regexp.Regexp `json:"-"`
+
+	CollectTopicSize   *bool `json:"collect_topic_size"`
+	CollectInventory   *bool `json:"collect_inventory"`
+	CollectLogDirSizes *bool `json:"collect_log_dir_sizes"`
+}
+
+// matches returns true if the override applies to topic
+func (o *TopicOverride) matches(topic string) bool {
+	if o.Regex != nil {
+		return o.Regex.MatchString(topic)
+	}
+
+	return o.Topic == topic
+}
+
+// unmarshalTopicOverrides parses the topic_overrides JSON object mapping topic names, or regexes between
+// slashes such as "/payments-.*/", to the collection options of the topics they match. Overrides of a topic
+// name are returned first, then the regex overrides sorted by regex.
+func unmarshalTopicOverrides(arg string) ([]*TopicOverride, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil
+	}
+
+	var rawOverrides map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(arg), &rawOverrides); err != nil {
+		return nil, fmt.Errorf("topic_overrides is not a JSON object of overrides: %s", err)
+	}
+
+	keys := make([]string, 0, len(rawOverrides))
+	for key := range rawOverrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var topicOverrides, regexOverrides []*TopicOverride
+	for _, key := range keys {
+		override := &TopicOverride{}
+		decoder := json.NewDecoder(bytes.NewReader(rawOverrides[key]))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(override); err != nil {
+			return nil, fmt.Errorf("invalid topic_overrides entry for %s: %s", key, err)
+		}
+		if override.CollectTopicSize == nil && override.CollectInventory == nil && override.CollectLogDirSizes == nil {
+			return nil, fmt.Errorf("topic_overrides entry for %s doesn't override anything", key)
+		}
+
+		switch {
+		case len(key) > 2 && strings.HasPrefix(key, "/") && strings.HasSuffix(key, "/"):
+			regex, err := regexp.Compile("^(?:" + key[1:len(key)-1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex in topic_overrides for %s: %s", key, err)
+			}
+			override.Regex = regex
+			regexOverrides = append(regexOverrides, override)
+		case strings.TrimSpace(key) == "" || strings.HasPrefix(key, "/"):
+			return nil, fmt.Errorf("topic_overrides key '%s' is neither a topic name nor a regex between slashes", key)
+		default:
+			override.Topic = key
+			topicOverrides = append(topicOverrides, override)
+		}
+	}
+
+	return append(topicOverrides, regexOverrides...), nil
+}
+
+// topicOption returns the value of an option of topic: the value set by the override of the topic's name, or
+// else true if a matching regex override enables it, false if one disables it, and the global value otherwise
+func (k *KafkaArguments) topicOption(topic string, global bool, option func(*TopicOverride) *bool) bool {
+	var regexValue *bool
+	for _, override := range k.TopicOverrides {
+		value := option(override)
+		if value == nil || !override.matches(topic) {
+			continue
+		}
+
+		if override.Regex == nil {
+			return *value
+		}
+		if regexValue == nil || *value {
+			regexValue = value
+		}
+	}
+
+	if regexValue != nil {
+		return *regexValue
+	}
+
+	return global
+}
+
+// anyTopicEnables returns true if an override enables the option for the topics it matches
+func (k *KafkaArguments) anyTopicEnables(option func(*TopicOverride) *bool) bool {
+	for _, override := range k.TopicOverrides {
+		if value := option(override); value != nil && *value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clearTopicOption removes the option from every override, so only the global value applies
+func (k *KafkaArguments) clearTopicOption(clear func(*TopicOverride)) {
+	for _, override := range k.TopicOverrides {
+		clear(override)
+	}
+}
+
+func topicSizeOption(o *TopicOverride) *bool   { return o.CollectTopicSize }
+func inventoryOption(o *TopicOverride) *bool   { return o.CollectInventory }
+func logDirSizesOption(o *TopicOverride) *bool { return o.CollectLogDirSizes }
+
+// TopicCollectsSize returns true if the JMX disk size of topic is collected, as set by collect_topic_size and
+// topic_overrides
+func (k *KafkaArguments) TopicCollectsSize(topic string) bool {
+	return k.topicOption(topic, k.CollectTopicSize, topicSizeOption)
+}
+
+// TopicCollectsInventory returns true if the DescribeConfigs configuration of topic is reported as inventory,
+// as set by collect_inventory and topic_overrides
+func (k *KafkaArguments) TopicCollectsInventory(topic string) bool {
+	return k.topicOption(topic, k.CollectInventory, inventoryOption)
+}
+
+// TopicCollectsLogDirSizes returns true if the log directory size of topic and its partitions is collected, as
+// set by collect_log_dir_sizes and topic_overrides
+func (k *KafkaArguments) TopicCollectsLogDirSizes(topic string) bool {
+	return k.topicOption(topic, k.CollectLogDirSizes, logDirSizesOption)
+}
+
+// AnyTopicCollectsInventory returns true if collect_inventory or a topic override enables the configuration
+// inventory collection
+func (k *KafkaArguments) AnyTopicCollectsInventory() bool {
+	return k.CollectInventory || k.anyTopicEnables(inventoryOption)
+}
+
+// AnyTopicCollectsLogDirSizes returns true if collect_log_dir_sizes or a topic override enables the log
+// directory size collection
+func (k *KafkaArguments) AnyTopicCollectsLogDirSizes() bool {
+	return k.CollectLogDirSizes || k.anyTopicEnables(logDirSizesOption)
+}
@@ -0,0 +1,93 @@
+package args
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func Test_unmarshalTopicOverrides(t *testing.T) {
+	overrides, err := unmarshalTopicOverrides(`{
+		"/payments-.*/": {"collect_topic_size": true},
+		"orders": {"collect_inventory": true, "collect_log_dir_sizes": false}
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(overrides) != 2 {
+		t.Fatalf("Expected 2 overrides, got %d", len(overrides))
+	}
+	if overrides[0].Topic != "orders" || overrides[0].Regex != nil {
+		t.Errorf("Expected the topic name override first, got %+v", overrides[0])
+	}
+	if overrides[1].Regex == nil || !overrides[1].matches("payments-eu") || overrides[1].matches("old-payments-eu") {
+		t.Errorf("Expected the regex override to match whole topic names, got %+v", overrides[1])
+	}
+
+	for _, arg := range []string{"", "{}"} {
+		if overrides, err := unmarshalTopicOverrides(arg); err != nil || overrides != nil {
+			t.Errorf("Expected no overrides for %q, got %v, %v", arg, overrides, err)
+		}
+	}
+
+	for _, arg := range []string{
+		`["orders"]`,
+		`{"orders": {}}`,
+		`{"orders": {"collect_partitions": true}}`,
+		`{"orders": {"collect_inventory": "yes"}}`,
+		`{"/payments-(/": {"collect_inventory": true}}`,
+		`{"/payments": {"collect_inventory": true}}`,
+		`{"": {"collect_inventory": true}}`,
+	} {
+		if _, err := unmarshalTopicOverrides(arg); err == nil {
+			t.Errorf("Expected error for %s", arg)
+		}
+	}
+}
+
+func TestKafkaArguments_TopicOverrides(t *testing.T) {
+	overrides, err := unmarshalTopicOverrides(`{
+		"payments-internal": {"collect_topic_size": false},
+		"/payments-.*/": {"collect_topic_size": true, "collect_inventory": false},
+		"/.*-internal/": {"collect_inventory": true, "collect_log_dir_sizes": true}
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	k := &KafkaArguments{CollectInventory: true, TopicOverrides: overrides}
+
+	testCases := []struct {
+		topic       string
+		size        bool
+		inventory   bool
+		logDirSizes bool
+	}{
+		// No override, the global arguments apply
+		{"orders", false, true, false},
+		{"payments-eu", true, false, false},
+		// The topic name override wins over the regexes, and enabling wins between regexes
+		{"payments-internal", false, true, true},
+	}
+	for _, tc := range testCases {
+		if size := k.TopicCollectsSize(tc.topic); size != tc.size {
+			t.Errorf("Expected size %t for %s, got %t", tc.size, tc.topic, size)
+		}
+		if inventory := k.TopicCollectsInventory(tc.topic); inventory != tc.inventory {
+			t.Errorf("Expected inventory %t for %s, got %t", tc.inventory, tc.topic, inventory)
+		}
+		if logDirSizes := k.TopicCollectsLogDirSizes(tc.topic); logDirSizes != tc.logDirSizes {
+			t.Errorf("Expected log dir sizes %t for %s, got %t", tc.logDirSizes, tc.topic, logDirSizes)
+		}
+	}
+
+	if !k.AnyTopicCollectsLogDirSizes() {
+		t.Error("Expected an override to enable log directory sizes")
+	}
+
+	if err := k.GateFeatures(sarama.V0_10_2_0); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if k.AnyTopicCollectsInventory() || k.AnyTopicCollectsLogDirSizes() || k.TopicCollectsInventory("payments-internal") {
+		t.Error("Expected a Kafka version without DescribeConfigs or DescribeLogDirs to disable them for every topic")
+	}
+}
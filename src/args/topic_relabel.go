@@ -0,0 +1,106 @@
+package args
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+)
+
+// defaultRelabelReplacement is the replacement of a topic_relabel_rules rule without one, the first capture group
+const defaultRelabelReplacement = "$1"
+
+// reservedAttributes are the attributes the integration sets on the samples with a topic, which a
+// topic_relabel_rules rule can't replace
+var reservedAttributes = map[string]bool{
+	"event_type":    true,
+	"displayName":   true,
+	"entityName":    true,
+	"topic":         true,
+	"clusterName":   true,
+	"consumerGroup": true,
+	"partition":     true,
+	"clientID":      true,
+	"clientHost":    true,
+}
+
+// TopicRelabelRule derives an attribute of the samples of the topics whose name matches Regex. The attribute
+// is Replacement with $1 and the like replaced by the capture groups of the match.
+type TopicRelabelRule struct {
+	Regex       *regexp.Regexp
+	Attribute   string
+	Replacement string
+}
+
+// unmarshalTopicRelabelRules parses the topic_relabel_rules JSON array of {"regex", "attribute", "replacement"}
+// objects. replacement defaults to the first capture group.
+func unmarshalTopicRelabelRules(arg string) ([]*TopicRelabelRule, error) {
+	if arg == "" {
+		return nil, nil
+	}
+
+	var rawRules []struct {
+		Regex       string  `json:"regex"`
+		Attribute   string  `json:"attribute"`
+		Replacement *string `json:"replacement"`
+	}
+	if err := json.Unmarshal([]byte(arg), &rawRules); err != nil {
+		return nil, fmt.Errorf("topic_relabel_rules is not a JSON array of rules: %s", err)
+	}
+	if len(rawRules) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]*TopicRelabelRule, 0, len(rawRules))
+	for _, rawRule := range rawRules {
+		if rawRule.Attribute == "" {
+			return nil, errors.New("topic_relabel_rules has a rule without an attribute")
+		}
+		if reservedAttributes[rawRule.Attribute] {
+			return nil, fmt.Errorf("topic_relabel_rules can't set the %s attribute the integration reports", rawRule.Attribute)
+		}
+
+		regex, err := regexp.Compile(rawRule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in topic_relabel_rules for attribute %s: %s", rawRule.Attribute, err)
+		}
+
+		replacement := defaultRelabelReplacement
+		if rawRule.Replacement != nil {
+			replacement = *rawRule.Replacement
+		}
+		rules = append(rules, &TopicRelabelRule{Regex: regex, Attribute: rawRule.Attribute, Replacement: replacement})
+	}
+
+	return rules, nil
+}
+
+// TopicAttributes returns the attributes the topic_relabel_rules derive from topic, to add to the samples of
+// the topic. Rules that don't match the topic or expand to an empty value add no attribute. When several rules
+// set the same attribute the first that matches wins.
+func (k *KafkaArguments) TopicAttributes(topic string) []metric.Attribute {
+	var attributes []metric.Attribute
+	set := make(map[string]bool)
+	for _, rule := range k.TopicRelabelRules {
+		if set[rule.Attribute] {
+			continue
+		}
+
+		match := rule.Regex.FindStringSubmatchIndex(topic)
+		if match == nil {
+			continue
+		}
+
+		value := string(rule.Regex.ExpandString(nil, rule.Replacement, topic, match))
+		if value == "" {
+			continue
+		}
+
+		set[rule.Attribute] = true
+		attributes = append(attributes, metric.Attribute{Key: rule.Attribute, Value: value})
+	}
+
+	return attributes
+}
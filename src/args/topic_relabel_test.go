@@ -0,0 +1,78 @@
+package args
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+)
+
+func Test_unmarshalTopicRelabelRules(t *testing.T) {
+	rules, err := unmarshalTopicRelabelRules(`[{"regex": "^([a-z]+)\\.", "attribute": "topicDomain"}, {"regex": "\\.v([0-9]+)$", "attribute": "topicVersion", "replacement": "v$1"}]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	if rules[0].Attribute != "topicDomain" || rules[0].Replacement != "$1" || rules[0].Regex.String() != `^([a-z]+)\.` {
+		t.Errorf("Unexpected first rule %+v", rules[0])
+	}
+	if rules[1].Attribute != "topicVersion" || rules[1].Replacement != "v$1" {
+		t.Errorf("Unexpected second rule %+v", rules[1])
+	}
+
+	for _, arg := range []string{"", "[]"} {
+		if rules, err := unmarshalTopicRelabelRules(arg); err != nil || rules != nil {
+			t.Errorf("Expected no rules for %q, got %v, %v", arg, rules, err)
+		}
+	}
+
+	testCases := []struct {
+		arg      string
+		contains string
+	}{
+		{`{"regex": "a"}`, "not a JSON array"},
+		{`[{"regex": "a"}]`, "without an attribute"},
+		{`[{"regex": "a", "attribute": "topic"}]`, "topic attribute"},
+		{`[{"regex": "a(", "attribute": "topicDomain"}]`, "invalid regex"},
+	}
+	for _, tc := range testCases {
+		_, err := unmarshalTopicRelabelRules(tc.arg)
+		if err == nil || !strings.Contains(err.Error(), tc.contains) {
+			t.Errorf("Expected error containing %q for %s, got %v", tc.contains, tc.arg, err)
+		}
+	}
+}
+
+func TestKafkaArguments_TopicAttributes(t *testing.T) {
+	rules, err := unmarshalTopicRelabelRules(`[
+		{"regex": "^([a-z]+)\\.", "attribute": "topicDomain"},
+		{"regex": "^legacy-.*", "attribute": "topicDomain", "replacement": "legacy"},
+		{"regex": "\\.v([0-9]+)$", "attribute": "topicVersion"},
+		{"regex": "^(x?)", "attribute": "topicPrefix"}
+	]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	k := &KafkaArguments{TopicRelabelRules: rules}
+
+	testCases := []struct {
+		topic    string
+		expected []metric.Attribute
+	}{
+		{"orders.created.v2", []metric.Attribute{{Key: "topicDomain", Value: "orders"}, {Key: "topicVersion", Value: "2"}}},
+		{"legacy-payments", []metric.Attribute{{Key: "topicDomain", Value: "legacy"}}},
+		{"Unmatched", nil},
+	}
+	for _, tc := range testCases {
+		if attributes := k.TopicAttributes(tc.topic); !reflect.DeepEqual(attributes, tc.expected) {
+			t.Errorf("Expected attributes %v for topic %s, got %v", tc.expected, tc.topic, attributes)
+		}
+	}
+
+	if attributes := (&KafkaArguments{}).TopicAttributes("orders.created"); attributes != nil {
+		t.Errorf("Expected no attributes without rules, got %v", attributes)
+	}
+}
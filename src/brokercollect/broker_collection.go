@@ -3,9 +3,10 @@ package brokercollect
 
 import (
 	"encoding/json"
-	"fmt"
+	"math/rand"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/jmx"
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
 	"github.com/newrelic/nri-kafka/src/jmxwrapper"
 	"github.com/newrelic/nri-kafka/src/metrics"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
@@ -32,14 +34,15 @@ type broker struct {
 // StartBrokerPool starts a pool of brokerWorkers to handle collecting data for Broker entities.
 // The returned channel can be fed brokerIDs to collect, and is to be closed by the user
 // (or closed by feedBrokerPool)
-func StartBrokerPool(poolSize int, wg *sync.WaitGroup, zkConn zookeeper.Connection, integration *integration.Integration, collectedTopics []string) chan int {
+func StartBrokerPool(kafkaArgs *args.KafkaArguments, poolSize int, wg *sync.WaitGroup, zkConn zookeeper.Connection, integration *integration.Integration, collectedTopics []string, throughput *TopicThroughput) chan int {
 	brokerChan := make(chan int)
+	start := time.Now()
 
 	// Only spin off brokerWorkers if signaled
-	if args.GlobalArgs.CollectBrokerTopicData && zkConn != nil {
+	if kafkaArgs.CollectBrokerTopicData && zkConn != nil {
 		for i := 0; i < poolSize; i++ {
 			wg.Add(1)
-			go brokerWorker(brokerChan, collectedTopics, wg, zkConn, integration)
+			go brokerWorker(kafkaArgs, brokerChan, collectedTopics, throughput, start, wg, zkConn, integration)
 		}
 	}
 
@@ -48,33 +51,48 @@ func StartBrokerPool(poolSize int, wg *sync.WaitGroup, zkConn zookeeper.Connecti
 
 // FeedBrokerPool collects a list of brokerIDs from ZooKeeper and feeds them into a
 // channel to be read by a broker worker pool.
-func FeedBrokerPool(zkConn zookeeper.Connection, brokerChan chan<- int) error {
+func FeedBrokerPool(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, brokerChan chan<- int) error {
 	defer close(brokerChan) // close the broker channel when done feeding
 
 	// Don't make API calls or feed down channel if we don't want to collect brokers
-	if args.GlobalArgs.CollectBrokerTopicData && zkConn != nil {
+	if kafkaArgs.CollectBrokerTopicData && zkConn != nil {
 		brokerIDs, err := zookeeper.GetBrokerIDs(zkConn)
 		if err != nil {
 			return err
 		}
 
+		knownIDs := make(map[int]bool, len(brokerIDs))
 		for _, id := range brokerIDs {
 			intID, err := strconv.Atoi(id)
 			if err != nil {
-				log.Error("Unable to parse integer broker ID from %s", id)
+				collecterrors.Error("Unable to parse integer broker ID from %s", id)
 				continue
 			}
+			knownIDs[intID] = true
 			brokerChan <- intID
 		}
+
+		warnUnknownJMXBrokerIDs(kafkaArgs, knownIDs)
 	}
 
 	return nil
 }
 
+// warnUnknownJMXBrokerIDs warns about the brokers listed in jmx_broker_ids that aren't registered in the
+// cluster, likely a typo or a decommissioned broker, since no JMX metrics are collected for them
+func warnUnknownJMXBrokerIDs(kafkaArgs *args.KafkaArguments, knownIDs map[int]bool) {
+	for _, id := range kafkaArgs.JMXBrokerIDs {
+		if !knownIDs[id] {
+			log.Warn("Broker %d in jmx_broker_ids is not registered in the cluster, no JMX metrics are collected for it", id)
+		}
+	}
+}
+
 // Reads brokerIDs from a channel, creates an entity for each broker, and collects
 // inventory and metrics data for that broker. Exits when it determines the channel has
-// been closed
-func brokerWorker(brokerChan <-chan int, collectedTopics []string, wg *sync.WaitGroup, zkConn zookeeper.Connection, i *integration.Integration) {
+// been closed. The JMX collection of each broker is staggered over broker_collection_stagger_ms
+// from start, when the pool was started.
+func brokerWorker(kafkaArgs *args.KafkaArguments, brokerChan <-chan int, collectedTopics []string, throughput *TopicThroughput, start time.Time, wg *sync.WaitGroup, zkConn zookeeper.Connection, i *integration.Integration) {
 	defer wg.Done()
 
 	for {
@@ -86,14 +104,28 @@ func brokerWorker(brokerChan <-chan int, collectedTopics []string, wg *sync.Wait
 		}
 
 		// Create Broker
-		brokers, err := createBrokerConnectionVariants(brokerID, zkConn, i)
+		brokers, err := createBrokerConnectionVariants(kafkaArgs, brokerID, zkConn, i)
 		if err != nil {
 			continue
 		}
 
+		// The metrics of the broker are all collected over JMX, which jmx_broker_ids can limit to some brokers
+		collectMetrics := kafkaArgs.HasMetrics() && kafkaArgs.CollectsBrokerJMX(brokerID)
+		if kafkaArgs.HasMetrics() && !collectMetrics {
+			log.Debug("Skipping JMX metrics of broker %d, which is not in jmx_broker_ids", brokerID)
+		}
+		circuitOpen := collectMetrics && !allowBroker(kafkaArgs, circuitJMX, brokerID)
+		collectMetrics = collectMetrics && !circuitOpen
+
+		// Only the JMX queries of the metrics are staggered
+		if collectMetrics {
+			time.Sleep(staggerDelay(start, kafkaArgs.BrokerCollectionStaggerMs))
+		}
+
+		metricsAttempted, metricsCollected := false, false
 		for _, broker := range brokers {
 			// Populate inventory for broker
-			if args.GlobalArgs.All() || args.GlobalArgs.Inventory {
+			if kafkaArgs.HasInventory() {
 				log.Debug("Collecting inventory for broker %s", broker.Entity.Metadata.Name)
 				if err := populateBrokerInventory(broker); err != nil {
 					continue
@@ -102,51 +134,86 @@ func brokerWorker(brokerChan <-chan int, collectedTopics []string, wg *sync.Wait
 			}
 
 			// Populate metrics for broker
-			if args.GlobalArgs.All() || args.GlobalArgs.Metrics {
+			if collectMetrics {
 				log.Debug("Collecting metrics for broker %s", broker.Entity.Metadata.Name)
-				if err := collectBrokerMetrics(broker, collectedTopics); err != nil {
+				metricsAttempted = true
+				if err := collectBrokerMetrics(kafkaArgs, broker, collectedTopics, throughput); err != nil {
 					continue
 				}
+				metricsCollected = true
+				setCircuitOpen(kafkaArgs, broker.Entity, false)
 				log.Debug("Done Collecting metrics for broker %s", broker.Entity.Metadata.Name)
 			}
 			break
 		}
+
+		// Without its metrics, the open circuit is the only thing reported on the broker sample
+		if circuitOpen {
+			for _, broker := range brokers {
+				setCircuitOpen(kafkaArgs, broker.Entity, true)
+			}
+		}
+
+		// The broker only failed if none of its connection variants could be collected
+		if metricsAttempted {
+			recordBrokerResult(kafkaArgs, circuitJMX, brokerID, metricsCollected)
+		}
+	}
+}
+
+// staggerDelay returns how long to wait for a random point in the window of windowMs milliseconds from
+// start. The point is relative to start rather than to the previous broker, so however many brokers
+// there are the stagger adds at most windowMs to the collection. A non-positive windowMs disables it.
+func staggerDelay(start time.Time, windowMs int) time.Duration {
+	if windowMs <= 0 {
+		return 0
+	}
+
+	point := time.Duration(rand.Int63n(int64(windowMs))) * time.Millisecond
+	if delay := point - time.Since(start); delay > 0 {
+		return delay
 	}
+
+	return 0
 }
 
 // Creates and populates an array of different ways to connect to one broker.
-func createBrokerConnectionVariants(brokerID int, zkConn zookeeper.Connection, i *integration.Integration) ([]*broker, error) {
+func createBrokerConnectionVariants(kafkaArgs *args.KafkaArguments, brokerID int, zkConn zookeeper.Connection, i *integration.Integration) ([]*broker, error) {
 
 	// Collect broker connection information from ZooKeeper
 	brokerConnections, err := zookeeper.GetBrokerConnections(brokerID, zkConn)
 	if err != nil {
-		log.Error("Unable to get broker JMX information for broker id %d: %s", brokerID, err)
+		collecterrors.Error("Unable to get broker JMX information for broker id %d: %s", brokerID, err)
 		return nil, err
 	}
 
-	// Gather broker configuration from ZooKeeper
-	brokerConfig, err := getBrokerConfig(brokerID, zkConn)
-	if err != nil {
-		log.Error("Unable to get broker configuration information for broker id %d: %s", brokerID, err)
+	// Gather broker configuration from ZooKeeper. The configuration is only
+	// reported as inventory, so skip the znode read when inventory is disabled.
+	var brokerConfig map[string]string
+	if kafkaArgs.HasInventory() {
+		brokerConfig, err = getBrokerConfig(brokerID, zkConn)
+		if err != nil {
+			collecterrors.Error("Unable to get broker configuration information for broker id %d: %s", brokerID, err)
+		}
 	}
 
 	var brokers []*broker
 	for _, brokerConnection := range brokerConnections {
 		// Create broker entity
-		clusterIDAttr := integration.NewIDAttribute("clusterName", args.GlobalArgs.ClusterName)
+		clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
 		brokerEntity, err := i.Entity(
-			fmt.Sprintf("%s:%d", brokerConnection.BrokerHost, brokerConnection.BrokerPort),
+			brokerConnection.Addr(),
 			"ka-broker",
-			clusterIDAttr)
+			clusterIDAttrs...)
 
 		if err != nil {
-			log.Error("Unable to create entity for broker ID %d: %s", brokerID, err)
+			collecterrors.Error("Unable to create entity for broker ID %d: %s", brokerID, err)
 			return nil, err
 		}
 
 		brokers = append(brokers, &broker{
 			Host:      brokerConnection.BrokerHost,
-			JMXPort:   brokerConnection.JmxPort,
+			JMXPort:   kafkaArgs.BrokerJMXPort(brokerID, brokerConnection.BrokerHost, brokerConnection.JmxPort),
 			KafkaPort: brokerConnection.BrokerPort,
 			Entity:    brokerEntity,
 			ID:        brokerID,
@@ -161,52 +228,57 @@ func createBrokerConnectionVariants(brokerID int, zkConn zookeeper.Connection, i
 func populateBrokerInventory(b *broker) error {
 	// Populate connection information
 	if err := b.Entity.SetInventoryItem("broker.hostname", "value", b.Host); err != nil {
-		log.Error("Unable to set Hostinventory item for broker %d: %s", b.ID, err)
+		collecterrors.Error("Unable to set Hostinventory item for broker %d: %s", b.ID, err)
 	}
 	if err := b.Entity.SetInventoryItem("broker.jmxPort", "value", b.JMXPort); err != nil {
-		log.Error("Unable to set JMX Port inventory item for broker %d: %s", b.ID, err)
+		collecterrors.Error("Unable to set JMX Port inventory item for broker %d: %s", b.ID, err)
 	}
 	if err := b.Entity.SetInventoryItem("broker.kafkaPort", "value", b.KafkaPort); err != nil {
-		log.Error("Unable to set Kafka Port inventory item for broker %d: %s", b.ID, err)
+		collecterrors.Error("Unable to set Kafka Port inventory item for broker %d: %s", b.ID, err)
 	}
 
 	// Populate configuration information
 	for key, value := range b.Config {
 		if err := b.Entity.SetInventoryItem("broker."+key, "value", value); err != nil {
-			log.Error("Unable to set inventory item for broker %d: %s", b.ID, err)
+			collecterrors.Error("Unable to set inventory item for broker %d: %s", b.ID, err)
 		}
 	}
 
 	return nil
 }
 
-func collectBrokerMetrics(b *broker, collectedTopics []string) error {
+func collectBrokerMetrics(kafkaArgs *args.KafkaArguments, b *broker, collectedTopics []string, throughput *TopicThroughput) error {
 	// Lock since we can only make a single JMX connection at a time.
 	jmxwrapper.JMXLock.Lock()
 
 	// Open JMX connection
 	options := make([]jmx.Option, 0)
-	if args.GlobalArgs.KeyStore != "" && args.GlobalArgs.KeyStorePassword != "" && args.GlobalArgs.TrustStore != "" && args.GlobalArgs.TrustStorePassword != "" {
-		ssl := jmx.WithSSL(args.GlobalArgs.KeyStore, args.GlobalArgs.KeyStorePassword, args.GlobalArgs.TrustStore, args.GlobalArgs.TrustStorePassword)
+	if kafkaArgs.KeyStore != "" && kafkaArgs.KeyStorePassword != "" && kafkaArgs.TrustStore != "" && kafkaArgs.TrustStorePassword != "" {
+		ssl := jmx.WithSSL(kafkaArgs.KeyStore, kafkaArgs.KeyStorePassword, kafkaArgs.TrustStore, kafkaArgs.TrustStorePassword)
 		options = append(options, ssl)
 	}
 
-	if err := jmxwrapper.JMXOpen(b.Host, strconv.Itoa(b.JMXPort), args.GlobalArgs.DefaultJMXUser, args.GlobalArgs.DefaultJMXPassword, options...); err != nil {
-		log.Error("Unable to make JMX connection for Broker '%s': %s", b.Host, err.Error())
+	if err := jmxwrapper.JMXOpen(b.Host, strconv.Itoa(b.JMXPort), kafkaArgs.DefaultJMXUser, kafkaArgs.DefaultJMXPassword, options...); err != nil {
+		collecterrors.Error("Unable to make JMX connection for Broker '%s': %s", b.Host, err.Error())
 		jmxwrapper.JMXClose() // Close needs to be called even on a failed open to clear out any set variables
 		jmxwrapper.JMXLock.Unlock()
 		return err
 	}
 
 	// Collect broker metrics
-	populateBrokerMetrics(b)
+	populateBrokerMetrics(kafkaArgs, b)
 
 	// Gather Broker specific Topic metrics
-	topicSampleLookup := collectBrokerTopicMetrics(b, collectedTopics)
+	topicSampleLookup := collectBrokerTopicMetrics(kafkaArgs, b, collectedTopics)
+
+	// Collect the sizes of the topics collect_topic_size and topic_overrides enable it for
+	if sizeSamples := topicSizeSamples(kafkaArgs, topicSampleLookup); len(sizeSamples) > 0 {
+		gatherTopicSizes(kafkaArgs, b, sizeSamples)
+	}
 
-	// If enabled collect topic sizes
-	if args.GlobalArgs.CollectTopicSize {
-		gatherTopicSizes(b, topicSampleLookup)
+	// Gather this broker's share of the cluster-wide topic throughput
+	if throughput != nil {
+		gatherTopicThroughput(kafkaArgs, b, collectedTopics, throughput)
 	}
 
 	// Close connection and release lock so another process can make JMX Connections
@@ -216,33 +288,33 @@ func collectBrokerMetrics(b *broker, collectedTopics []string) error {
 }
 
 // For a given broker struct, collect and populate its entity with broker metrics
-func populateBrokerMetrics(b *broker) {
+func populateBrokerMetrics(kafkaArgs *args.KafkaArguments, b *broker) {
 	// Create a metric set on the broker entity
-	sample := b.Entity.NewMetricSet("KafkaBrokerSample",
+	sample := b.Entity.NewMetricSet(kafkaArgs.BrokerSampleName,
 		metric.Attribute{Key: "displayName", Value: b.Entity.Metadata.Name},
 		metric.Attribute{Key: "entityName", Value: "broker:" + b.Entity.Metadata.Name},
 	)
 
 	// Populate metrics set with broker metrics
-	metrics.GetBrokerMetrics(sample)
+	metrics.GetBrokerMetrics(kafkaArgs, sample)
 }
 
 // collectBrokerTopicMetrics gathers Broker specific Topic metrics.
 // Returns a map of Topic names to the corresponding entity *metric.Set
-func collectBrokerTopicMetrics(b *broker, collectedTopics []string) map[string]*metric.Set {
+func collectBrokerTopicMetrics(kafkaArgs *args.KafkaArguments, b *broker, collectedTopics []string) map[string]*metric.Set {
 	topicSampleLookup := make(map[string]*metric.Set)
 
 	for _, topicName := range collectedTopics {
-		sample := b.Entity.NewMetricSet("KafkaBrokerSample",
-			metric.Attribute{Key: "displayName", Value: b.Entity.Metadata.Name},
-			metric.Attribute{Key: "entityName", Value: "broker:" + b.Entity.Metadata.Name},
-			metric.Attribute{Key: "topic", Value: topicName},
-		)
+		sample := b.Entity.NewMetricSet(kafkaArgs.BrokerSampleName, append([]metric.Attribute{
+			{Key: "displayName", Value: b.Entity.Metadata.Name},
+			{Key: "entityName", Value: "broker:" + b.Entity.Metadata.Name},
+			{Key: "topic", Value: topicName},
+		}, kafkaArgs.TopicAttributes(topicName)...)...)
 
 		// Insert into map
 		topicSampleLookup[topicName] = sample
 
-		metrics.CollectMetricDefintions(sample, metrics.BrokerTopicMetricDefs, metrics.ApplyTopicName(topicName))
+		metrics.CollectMetricDefintions(kafkaArgs, sample, metrics.BrokerTopicMetricDefs, metrics.ApplyTopicName(topicName))
 	}
 
 	return topicSampleLookup
@@ -252,7 +324,7 @@ func collectBrokerTopicMetrics(b *broker, collectedTopics []string) map[string]*
 func getBrokerConfig(brokerID int, zkConn zookeeper.Connection) (map[string]string, error) {
 
 	// Query Zookeeper for broker configuration
-	rawBrokerConfig, _, err := zkConn.Get(zookeeper.Path("/config/brokers/" + strconv.Itoa(brokerID)))
+	rawBrokerConfig, _, err := zkConn.Get("/config/brokers/" + strconv.Itoa(brokerID))
 	if err != nil {
 		if err == zk.ErrNoNode {
 			return map[string]string{}, nil
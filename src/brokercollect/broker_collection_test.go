@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -18,6 +19,7 @@ import (
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 var (
@@ -27,17 +29,17 @@ var (
 )
 
 func TestStartBrokerPool(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	var wg sync.WaitGroup
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 	collectedTopics := make([]string, 0)
 	i, err := integration.New("kafka", "1.0.0")
 	if err != nil {
 		t.Error(err)
 	}
 
-	brokerChan := StartBrokerPool(3, &wg, &zkConn, i, collectedTopics)
+	brokerChan := StartBrokerPool(kafkaArgs, 3, &wg, zkConn, i, collectedTopics, nil)
 	close(brokerChan)
 
 	c := make(chan int)
@@ -62,34 +64,76 @@ func TestBrokerWorker(t *testing.T) {
 	brokerChan := make(chan int, 10)
 	i, _ := integration.New("kafka", "1.0.0")
 	testutils.SetupJmxTesting()
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
+
+	wg.Add(1)
+	brokerChan <- 0
+	close(brokerChan)
+	brokerWorker(kafkaArgs, brokerChan, []string{}, nil, time.Now(), &wg, zkConn, i)
+
+	wg.Wait()
+}
+
+func TestBrokerWorker_JMXBrokerIDs(t *testing.T) {
+	zkConn := &zookeeper.MockConnection{}
+	for _, id := range []int{0, 1, 2} {
+		connectionBytes := []byte(fmt.Sprintf(`{"endpoints":["PLAINTEXT://kafkabroker%d:9092"],"jmx_port":9999,"host":"kafkabroker%d","port":9092,"version":4}`, id, id))
+		zkConn.On("Get", fmt.Sprintf("/brokers/ids/%d", id)).Return(connectionBytes, new(zk.Stat), nil)
+		zkConn.On("Get", fmt.Sprintf("/config/brokers/%d", id)).Return(brokerConfigBytes, new(zk.Stat), nil)
+	}
+
+	var wg sync.WaitGroup
+	brokerChan := make(chan int, 10)
+	i, _ := integration.New("kafka", "1.0.0")
+	testutils.SetupJmxTesting()
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.JMXBrokerIDs = []int{0, 2}
+
+	var queriedHosts []string
+	jmxwrapper.JMXOpen = func(hostname, port, username, password string, options ...jmx.Option) error {
+		queriedHosts = append(queriedHosts, hostname)
+		return nil
+	}
 
 	wg.Add(1)
 	brokerChan <- 0
+	brokerChan <- 1
+	brokerChan <- 2
 	close(brokerChan)
-	brokerWorker(brokerChan, []string{}, &wg, zkConn, i)
+	brokerWorker(kafkaArgs, brokerChan, []string{}, nil, time.Now(), &wg, zkConn, i)
 
 	wg.Wait()
+
+	assert.Equal(t, []string{"kafkabroker0", "kafkabroker2"}, queriedHosts)
+
+	// Every broker still has its inventory
+	for _, id := range []int{0, 1, 2} {
+		e, err := i.Entity(fmt.Sprintf("kafkabroker%d:9092", id), "ka-broker", integration.NewIDAttribute("clusterName", ""))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, e.Inventory.Items(), "broker %d", id)
+	}
 }
 
 func TestCreateBroker_ZKError(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	brokerID, zkConn := 0, &zookeeper.MockConnection{}
 	zkConn.On("Get", "/brokers/ids/0").Return([]byte{}, new(zk.Stat), errors.New("this is a test error"))
 	i, _ := integration.New("kafka", "1.0.0")
 
-	_, err := createBrokerConnectionVariants(brokerID, zkConn, i)
+	_, err := createBrokerConnectionVariants(kafkaArgs, brokerID, zkConn, i)
 	if err == nil {
 		t.Error("Expected error")
 	}
 }
 
 func TestCreateBroker_Normal(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	brokerID, zkConn := 0, &zookeeper.MockConnection{}
 	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
 	zkConn.On("Get", "/config/brokers/0").Return(brokerConfigBytes, new(zk.Stat), nil)
 	i, _ := integration.New("kafka", "1.0.0")
 
-	brokers, err := createBrokerConnectionVariants(brokerID, zkConn, i)
+	brokers, err := createBrokerConnectionVariants(kafkaArgs, brokerID, zkConn, i)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 	}
@@ -170,7 +214,7 @@ func TestPopulateBrokerInventory(t *testing.T) {
 }
 
 func TestPopulateBrokerMetrics_JMXOpenError(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	testutils.SetupJmxTesting()
 	errorText := "jmx error"
 
@@ -187,7 +231,7 @@ func TestPopulateBrokerMetrics_JMXOpenError(t *testing.T) {
 
 	testBroker.Entity, _ = i.Entity(testBroker.Host, "ka-broker")
 
-	err := collectBrokerMetrics(testBroker, []string{})
+	err := collectBrokerMetrics(kafkaArgs, testBroker, []string{}, nil)
 	if err == nil {
 		t.Error("Did not get expected error")
 	} else if err.Error() != errorText {
@@ -196,7 +240,7 @@ func TestPopulateBrokerMetrics_JMXOpenError(t *testing.T) {
 }
 
 func TestPopulateBrokerMetrics_Normal(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	testutils.SetupJmxTesting()
 
 	testBroker := &broker{
@@ -209,7 +253,7 @@ func TestPopulateBrokerMetrics_Normal(t *testing.T) {
 
 	testBroker.Entity, _ = i.Entity(testBroker.Host, "ka-broker")
 
-	populateBrokerMetrics(testBroker)
+	populateBrokerMetrics(kafkaArgs, testBroker)
 
 	// MetricSet should still be created during a failed query.
 	if len(testBroker.Entity.Metrics) != 1 {
@@ -231,10 +275,8 @@ func TestPopulateBrokerMetrics_Normal(t *testing.T) {
 }
 
 func TestGetBrokerJMX(t *testing.T) {
-	testutils.SetupTestArgs()
-
 	brokerID := 0
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
 	expectedBrokers := []zookeeper.BrokerConnection{
 		{
@@ -251,7 +293,7 @@ func TestGetBrokerJMX(t *testing.T) {
 		},
 	}
 
-	brokerConnections, err := zookeeper.GetBrokerConnections(brokerID, &zkConn)
+	brokerConnections, err := zookeeper.GetBrokerConnections(brokerID, zkConn)
 	if err != nil {
 		t.Error(err)
 	}
@@ -273,7 +315,6 @@ func TestGetBrokerJMX(t *testing.T) {
 }
 
 func TestGetBrokerConfig(t *testing.T) {
-
 	testCases := []struct {
 		brokerID       int
 		expectedConfig map[string]string
@@ -284,11 +325,11 @@ func TestGetBrokerConfig(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		zkConn := zookeeper.MockConnection{}
+		zkConn := &zookeeper.MockConnection{}
 		zkConn.On("Get", "/config/brokers/0").Return(brokerConfigBytes2, new(zk.Stat), nil)
 		zkConn.On("Get", "/config/brokers/1").Return([]byte{}, new(zk.Stat), errors.New("this is a test error"))
 
-		brokerConfig, err := getBrokerConfig(tc.brokerID, &zkConn)
+		brokerConfig, err := getBrokerConfig(tc.brokerID, zkConn)
 		if (err != nil) != tc.expectedError {
 			t.Error(err)
 			t.FailNow()
@@ -301,7 +342,7 @@ func TestGetBrokerConfig(t *testing.T) {
 }
 
 func TestCollectBrokerTopicMetrics(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	testutils.SetupJmxTesting()
 
 	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
@@ -343,9 +384,71 @@ func TestCollectBrokerTopicMetrics(t *testing.T) {
 		"topic": sample,
 	}
 
-	out := collectBrokerTopicMetrics(testBroker, []string{"topic"})
+	out := collectBrokerTopicMetrics(kafkaArgs, testBroker, []string{"topic"})
 
 	if !reflect.DeepEqual(out, expected) {
 		t.Errorf("Expected %+v got %+v", expected, out)
 	}
 }
+
+func TestCreateBroker_MetricsOnly(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.Metrics = true
+	defer testutils.SetupTestArgs()
+
+	// No mock is registered for the broker config znode, so reading it would panic
+	brokerID, zkConn := 0, &zookeeper.MockConnection{}
+	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	i, _ := integration.New("kafka", "1.0.0")
+
+	brokers, err := createBrokerConnectionVariants(kafkaArgs, brokerID, zkConn, i)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(brokers))
+	for _, b := range brokers {
+		assert.Empty(t, b.Config)
+	}
+}
+
+func TestCreateBroker_JMXPortOverride(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	defer testutils.SetupTestArgs()
+
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	zkConn.On("Get", "/brokers/ids/1").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	zkConn.On("Get", mock.MatchedBy(func(path string) bool { return strings.HasPrefix(path, "/config/brokers/") })).Return(brokerConfigBytes, new(zk.Stat), nil)
+	i, _ := integration.New("kafka", "1.0.0")
+
+	// Broker 1 isn't mapped by its ID, but every broker has the same host so the host mapping applies
+	for _, tc := range []struct {
+		ports    map[string]int
+		brokerID int
+		expected int
+	}{
+		{nil, 0, 9999},
+		{map[string]int{"0": 9001}, 0, 9001},
+		{map[string]int{"0": 9001}, 1, 9999},
+		{map[string]int{"0": 9001, "kafkabroker": 9002}, 0, 9001},
+		{map[string]int{"0": 9001, "kafkabroker": 9002}, 1, 9002},
+	} {
+		kafkaArgs.BrokerJMXPorts = tc.ports
+		brokers, err := createBrokerConnectionVariants(kafkaArgs, tc.brokerID, zkConn, i)
+		assert.Nil(t, err)
+		for _, b := range brokers {
+			assert.Equal(t, tc.expected, b.JMXPort, "broker %d with ports %v", tc.brokerID, tc.ports)
+		}
+	}
+}
+
+func Test_staggerDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), staggerDelay(time.Now(), 0))
+	assert.Equal(t, time.Duration(0), staggerDelay(time.Now(), -5))
+
+	// The window has passed already, so there is nothing left to wait for
+	assert.Equal(t, time.Duration(0), staggerDelay(time.Now().Add(-time.Second), 100))
+
+	for n := 0; n < 100; n++ {
+		delay := staggerDelay(time.Now(), 100)
+		assert.True(t, delay >= 0 && delay < 100*time.Millisecond, "delay %s outside the window", delay)
+	}
+}
@@ -0,0 +1,173 @@
+package brokercollect
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/statestore"
+)
+
+const (
+	// circuitStateName is the name of the file the broker circuit breakers are persisted in between runs
+	circuitStateName = "com.newrelic.kafka-broker-circuits"
+
+	// circuitStateTTL is how long the persisted circuit breakers stay valid. Every run saves them, so they're
+	// only dropped when the integration hasn't run for this long.
+	circuitStateTTL = 24 * time.Hour
+
+	// circuitJMX and circuitMetadata are the broker collections with a circuit breaker
+	circuitJMX      = "jmx"
+	circuitMetadata = "metadata"
+)
+
+// now is the current time, overridable for testing
+var now = time.Now
+
+// circuitState is the state of the circuit breaker of a broker, persisted between runs
+type circuitState struct {
+	// ConsecutiveFailures is the number of collections of the broker that failed in a row
+	ConsecutiveFailures int
+	// OpenUntil is when the open circuit lets a collection of the broker through again, in milliseconds since
+	// the epoch. Zero if the circuit is closed.
+	OpenUntil int64
+}
+
+// circuitBreakers skips the brokers that failed broker_circuit_failures collections in a row for
+// broker_circuit_cooldown_ms, so a broker that is down doesn't slow every run down with its timeouts. After
+// the cooldown the broker is collected again, closing its circuit if that succeeds and opening it for another
+// cooldown if it fails.
+type circuitBreakers struct {
+	lock  sync.Mutex
+	store persist.Storer
+}
+
+var (
+	brokerCircuitsLock sync.Mutex
+	// brokerCircuits are the broker circuit breakers of the clusters being collected, by state key prefix
+	brokerCircuits = make(map[string]*circuitBreakers)
+)
+
+// OpenBrokerCircuits loads the broker circuit breakers of the previous runs if broker_circuit_cooldown_ms is
+// set. Breakers are disabled if they can't be loaded. Called before collecting each cluster.
+func OpenBrokerCircuits(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) {
+	brokerCircuitsLock.Lock()
+	defer brokerCircuitsLock.Unlock()
+
+	delete(brokerCircuits, kafkaArgs.StateKeyPrefix())
+	if kafkaArgs.BrokerCircuitCooldownMs <= 0 || kafkaArgs.BrokerCircuitFailures <= 0 {
+		return
+	}
+
+	store, err := statestore.Open(persist.DefaultPath(circuitStateName), kafkaIntegration.Logger(), circuitStateTTL)
+	if err != nil {
+		log.Warn("Unable to open the broker circuit breaker state, failing brokers won't be skipped: %s", err.Error())
+		return
+	}
+
+	brokerCircuits[kafkaArgs.StateKeyPrefix()] = &circuitBreakers{store: store}
+}
+
+// SaveBrokerCircuits persists the broker circuit breakers of the cluster for the next run
+func SaveBrokerCircuits(kafkaArgs *args.KafkaArguments) error {
+	if circuits := currentCircuits(kafkaArgs); circuits != nil {
+		return circuits.store.Save()
+	}
+
+	return nil
+}
+
+// currentCircuits returns the broker circuit breakers of the cluster, nil if they're disabled
+func currentCircuits(kafkaArgs *args.KafkaArguments) *circuitBreakers {
+	brokerCircuitsLock.Lock()
+	defer brokerCircuitsLock.Unlock()
+
+	return brokerCircuits[kafkaArgs.StateKeyPrefix()]
+}
+
+// circuitKey is the key of the circuit breaker of a collection, such as jmx or metadata, of a broker of the
+// cluster being collected. A broker can fail one collection and not the other.
+func circuitKey(kafkaArgs *args.KafkaArguments, collection string, brokerID int) string {
+	return fmt.Sprintf("%s:%s:%d", kafkaArgs.StateKeyPrefix(), collection, brokerID)
+}
+
+func (c *circuitBreakers) get(key string) circuitState {
+	var state circuitState
+	if _, err := c.store.Get(key, &state); err != nil && err != persist.ErrNotFound {
+		log.Debug("Unable to read the circuit breaker state of %s: %s", key, err.Error())
+	}
+
+	return state
+}
+
+// allowBroker returns false if the circuit breaker of the collection of the broker is open, logging the skip
+func allowBroker(kafkaArgs *args.KafkaArguments, collection string, brokerID int) bool {
+	c := currentCircuits(kafkaArgs)
+	if c == nil {
+		return true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	state := c.get(circuitKey(kafkaArgs, collection, brokerID))
+	remaining := time.Duration(state.OpenUntil-now().UnixNano()/int64(time.Millisecond)) * time.Millisecond
+	if remaining <= 0 {
+		return true
+	}
+
+	log.Warn("Skipping the %s collection of broker %d for another %s, after %d consecutive failures", collection, brokerID, remaining, state.ConsecutiveFailures)
+	return false
+}
+
+// recordBrokerResult records whether the collection of the broker succeeded. A success closes its circuit
+// breaker and broker_circuit_failures failures in a row open it for broker_circuit_cooldown_ms.
+func recordBrokerResult(kafkaArgs *args.KafkaArguments, collection string, brokerID int, succeeded bool) {
+	c := currentCircuits(kafkaArgs)
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := circuitKey(kafkaArgs, collection, brokerID)
+	if succeeded {
+		if state := c.get(key); state.ConsecutiveFailures > 0 {
+			log.Debug("The %s collection of broker %d succeeded again, closing its circuit breaker", collection, brokerID)
+		}
+		c.store.Set(key, circuitState{})
+		return
+	}
+
+	state := c.get(key)
+	state.ConsecutiveFailures++
+	if state.ConsecutiveFailures >= kafkaArgs.BrokerCircuitFailures {
+		cooldown := time.Duration(kafkaArgs.BrokerCircuitCooldownMs) * time.Millisecond
+		state.OpenUntil = now().Add(cooldown).UnixNano() / int64(time.Millisecond)
+		log.Warn("The %s collection of broker %d failed %d times in a row, skipping it for %s", collection, brokerID, state.ConsecutiveFailures, cooldown)
+	}
+	c.store.Set(key, state)
+}
+
+// setCircuitOpen reports on the broker sample of the entity whether the circuit breaker of the JMX collection of
+// the broker is open, skipping its metrics. Nothing is reported when the circuit breakers are disabled.
+func setCircuitOpen(kafkaArgs *args.KafkaArguments, brokerEntity *integration.Entity, open bool) {
+	if currentCircuits(kafkaArgs) == nil {
+		return
+	}
+
+	circuitOpen := 0
+	if open {
+		circuitOpen = 1
+	}
+	if err := brokerSample(kafkaArgs, brokerEntity).SetMetric("kafka.broker.circuitOpen", circuitOpen, metric.GAUGE); err != nil {
+		collecterrors.Error("Unable to set metric kafka.broker.circuitOpen for broker %s: %s", brokerEntity.Metadata.Name, err.Error())
+	}
+}
@@ -0,0 +1,144 @@
+package brokercollect
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/jmx"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerWorker_CircuitBreaker(t *testing.T) {
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	zkConn.On("Get", "/config/brokers/0").Return(brokerConfigBytes, new(zk.Stat), nil)
+
+	testutils.SetupJmxTesting()
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.BrokerCircuitFailures = 2
+	kafkaArgs.BrokerCircuitCooldownMs = 60000
+
+	// Shared between the runs like the persisted state
+	brokerCircuits[kafkaArgs.StateKeyPrefix()] = &circuitBreakers{store: persist.NewInMemoryStore()}
+	defer delete(brokerCircuits, kafkaArgs.StateKeyPrefix())
+
+	current := time.Unix(1000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	var jmxErr error
+	opens := 0
+	jmxwrapper.JMXOpen = func(hostname, port, username, password string, options ...jmx.Option) error {
+		opens++
+		return jmxErr
+	}
+
+	// collect runs the broker worker for broker 0 and returns the number of JMX connections it opened
+	collect := func() int {
+		opens = 0
+		var wg sync.WaitGroup
+		brokerChan := make(chan int, 1)
+		i, _ := integration.New("kafka", "1.0.0")
+		wg.Add(1)
+		brokerChan <- 0
+		close(brokerChan)
+		brokerWorker(kafkaArgs, brokerChan, []string{}, nil, time.Now(), &wg, zkConn, i)
+		wg.Wait()
+		return opens
+	}
+
+	// Both connection variants of the broker fail twice in a row, tripping the breaker
+	jmxErr = errors.New("connection refused")
+	assert.Equal(t, 2, collect())
+	assert.Equal(t, 2, collect())
+
+	// The broker is skipped during the cooldown
+	assert.Equal(t, 0, collect())
+	current = current.Add(59 * time.Second)
+	assert.Equal(t, 0, collect())
+
+	// After the cooldown the broker is tried again, and a failure opens the breaker for another cooldown
+	current = current.Add(2 * time.Second)
+	assert.Equal(t, 2, collect())
+	assert.Equal(t, 0, collect())
+
+	// A success closes the breaker, so a single failure afterwards doesn't trip it
+	current = current.Add(61 * time.Second)
+	jmxErr = nil
+	assert.Equal(t, 1, collect())
+	jmxErr = errors.New("connection refused")
+	assert.Equal(t, 2, collect())
+	assert.Equal(t, 2, collect())
+	assert.Equal(t, 0, collect())
+}
+
+func TestBrokerWorker_CircuitOpenMetric(t *testing.T) {
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	zkConn.On("Get", "/config/brokers/0").Return(brokerConfigBytes, new(zk.Stat), nil)
+
+	testutils.SetupJmxTesting()
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.BrokerCircuitFailures = 1
+	kafkaArgs.BrokerCircuitCooldownMs = 60000
+
+	brokerCircuits[kafkaArgs.StateKeyPrefix()] = &circuitBreakers{store: persist.NewInMemoryStore()}
+	defer delete(brokerCircuits, kafkaArgs.StateKeyPrefix())
+
+	var jmxErr error
+	jmxwrapper.JMXOpen = func(hostname, port, username, password string, options ...jmx.Option) error {
+		return jmxErr
+	}
+
+	// collect runs the broker worker for broker 0 and returns the kafka.broker.circuitOpen of each entity
+	collect := func() map[string]interface{} {
+		var wg sync.WaitGroup
+		brokerChan := make(chan int, 1)
+		i, _ := integration.New("kafka", "1.0.0")
+		wg.Add(1)
+		brokerChan <- 0
+		close(brokerChan)
+		brokerWorker(kafkaArgs, brokerChan, []string{}, nil, time.Now(), &wg, zkConn, i)
+		wg.Wait()
+
+		circuitOpen := make(map[string]interface{})
+		for _, entity := range i.Entities {
+			for _, sample := range entity.Metrics {
+				if value, ok := sample.Metrics["kafka.broker.circuitOpen"]; ok {
+					circuitOpen[entity.Metadata.Name] = value
+				}
+			}
+		}
+		return circuitOpen
+	}
+
+	// The collected variant reports the closed circuit
+	assert.Equal(t, map[string]interface{}{"kafkabroker:9092": float64(0)}, collect())
+
+	// A failed collection opens the circuit without reporting it, the skipped ones report it on every variant
+	jmxErr = errors.New("connection refused")
+	assert.Empty(t, collect())
+	assert.Equal(t, map[string]interface{}{"kafkabroker:9092": float64(1), "kafkabroker:9093": float64(1)}, collect())
+}
+
+func TestBrokerCircuits_Disabled(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	i, _ := integration.New("kafka", "1.0.0")
+
+	OpenBrokerCircuits(kafkaArgs, i)
+	assert.Nil(t, currentCircuits(kafkaArgs))
+
+	for run := 0; run < 5; run++ {
+		recordBrokerResult(kafkaArgs, circuitJMX, 0, false)
+	}
+	assert.True(t, allowBroker(kafkaArgs, circuitJMX, 0))
+	assert.NoError(t, SaveBrokerCircuits(kafkaArgs))
+}
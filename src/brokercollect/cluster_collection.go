@@ -0,0 +1,243 @@
+package brokercollect
+
+import (
+	"errors"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// noController is the controller ID Kafka reports while a controller election is in progress
+const noController = -1
+
+// CollectClusterMetrics reports the cluster topology from a single metadata request on the cluster entity
+func CollectClusterMetrics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) error {
+	client, err := zkConn.CreateClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Debug("Error closing client connection: %s", err.Error())
+		}
+	}()
+
+	metadata, err := fetchClusterMetadata(kafkaArgs, client)
+	if err != nil {
+		return err
+	}
+
+	return setClusterMetrics(kafkaArgs, metadata, kafkaIntegration)
+}
+
+// fetchClusterMetadata requests the metadata of every broker and topic from the first broker that responds
+func fetchClusterMetadata(kafkaArgs *args.KafkaArguments, client connection.Client) (*sarama.MetadataResponse, error) {
+	for _, broker := range client.Brokers() {
+		brokerID := int(broker.ID())
+		if !allowBroker(kafkaArgs, circuitMetadata, brokerID) {
+			continue
+		}
+
+		if connected, _ := broker.Connected(); !connected {
+			if err := broker.Open(client.Config()); err != nil {
+				log.Debug("Unable to open broker connection for metadata request: %s", err.Error())
+				recordBrokerResult(kafkaArgs, circuitMetadata, brokerID, false)
+				continue
+			}
+		}
+
+		// Version 1 is the first to include the controller. No topics means all topics.
+		metadata, err := broker.GetMetadata(&sarama.MetadataRequest{Version: 1})
+		if err != nil {
+			log.Debug("Metadata request failed: %s", err.Error())
+			recordBrokerResult(kafkaArgs, circuitMetadata, brokerID, false)
+			continue
+		}
+
+		recordBrokerResult(kafkaArgs, circuitMetadata, brokerID, true)
+		return metadata, nil
+	}
+
+	return nil, errors.New("no broker responded to the metadata request")
+}
+
+// unknownRack is the rack reported for brokers without broker.rack configured
+const unknownRack = "unknown"
+
+// clusterMetadata is the part of the cluster metadata used for the cluster metrics
+type clusterMetadata struct {
+	brokers      []brokerMetadata
+	controllerID int32
+	// replicas holds the replica broker IDs of every partition in the cluster
+	replicas [][]int32
+}
+
+type brokerMetadata struct {
+	id   int32
+	addr string
+	rack string
+	// leaderCount is the number of partitions the broker is the leader of
+	leaderCount int
+}
+
+func newClusterMetadata(response *sarama.MetadataResponse) *clusterMetadata {
+	metadata := &clusterMetadata{controllerID: response.ControllerID}
+	leaderCounts := make(map[int32]int)
+	for _, topic := range response.Topics {
+		for _, partition := range topic.Partitions {
+			metadata.replicas = append(metadata.replicas, partition.Replicas)
+			leaderCounts[partition.Leader]++
+		}
+	}
+	for _, b := range response.Brokers {
+		rack := b.Rack()
+		if rack == "" {
+			rack = unknownRack
+		}
+		metadata.brokers = append(metadata.brokers, brokerMetadata{id: b.ID(), addr: b.Addr(), rack: rack, leaderCount: leaderCounts[b.ID()]})
+	}
+
+	return metadata
+}
+
+// rackAwareness returns the number of distinct racks and the number of partitions with more than one
+// replica whose replicas are all in the same rack. Replicas on brokers missing from the metadata are in
+// the unknown rack. Partitions are only checked if at least one broker has a rack configured, since
+// otherwise every replicated partition would be reported.
+func (c *clusterMetadata) rackAwareness() (racks int, violations int) {
+	brokerRacks := make(map[int32]string, len(c.brokers))
+	distinct := make(map[string]bool)
+	configured := false
+	for _, b := range c.brokers {
+		brokerRacks[b.id] = b.rack
+		distinct[b.rack] = true
+		configured = configured || b.rack != unknownRack
+	}
+
+	if !configured {
+		return len(distinct), 0
+	}
+
+	for _, replicas := range c.replicas {
+		if len(replicas) < 2 {
+			continue
+		}
+
+		partitionRacks := make(map[string]bool)
+		for _, id := range replicas {
+			rack, ok := brokerRacks[id]
+			if !ok {
+				rack = unknownRack
+			}
+			partitionRacks[rack] = true
+		}
+		if len(partitionRacks) == 1 {
+			violations++
+		}
+	}
+
+	return len(distinct), violations
+}
+
+// leaderSkew returns the ratio of the most partitions led by a single broker to the average number of
+// partitions led per broker, where 1 means leadership is evenly spread. Partitions without a leader or led
+// by brokers missing from the metadata aren't counted. Returns false if no broker leads any partition.
+func (c *clusterMetadata) leaderSkew() (float64, bool) {
+	total, max := 0, 0
+	for _, b := range c.brokers {
+		total += b.leaderCount
+		if b.leaderCount > max {
+			max = b.leaderCount
+		}
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	average := float64(total) / float64(len(c.brokers))
+	return float64(max) / average, true
+}
+
+// setClusterMetrics reports the broker count, controller ID, total partition count, rack awareness and
+// leader skew on the cluster entity, and adds the rack and leader count of each broker to its KafkaBrokerSample
+func setClusterMetrics(kafkaArgs *args.KafkaArguments, response *sarama.MetadataResponse, kafkaIntegration *integration.Integration) error {
+	metadata := newClusterMetadata(response)
+
+	clusterEntity, err := kafkaIntegration.Entity(kafkaArgs.ClusterName, "ka-cluster", kafkaArgs.NamespaceIDAttributes()...)
+	if err != nil {
+		return err
+	}
+
+	if metadata.controllerID == noController {
+		log.Debug("No controller reported for cluster '%s', a controller election may be in progress", kafkaArgs.ClusterName)
+	}
+
+	metricSet := clusterEntity.NewMetricSet("KafkaClusterSample",
+		metric.Attribute{Key: "displayName", Value: clusterEntity.Metadata.Name},
+		metric.Attribute{Key: "entityName", Value: "cluster:" + clusterEntity.Metadata.Name},
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+	)
+
+	racks, violations := metadata.rackAwareness()
+	for name, value := range map[string]int{
+		"cluster.brokerCount":            len(metadata.brokers),
+		"cluster.controllerId":           int(metadata.controllerID),
+		"cluster.partitionCount":         len(metadata.replicas),
+		"cluster.rackCount":              racks,
+		"cluster.nonRackAwarePartitions": violations,
+	} {
+		if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set metric %s: %s", name, err.Error())
+		}
+	}
+
+	if skew, ok := metadata.leaderSkew(); ok {
+		if err := metricSet.SetMetric("cluster.leaderSkewRatio", skew, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set metric cluster.leaderSkewRatio: %s", err.Error())
+		}
+	} else {
+		log.Debug("No partition leaders reported for cluster '%s', not reporting leader skew", kafkaArgs.ClusterName)
+	}
+
+	setBrokerMetadata(kafkaArgs, metadata.brokers, kafkaIntegration)
+
+	return nil
+}
+
+// setBrokerMetadata adds the rack attribute and the leader count to the broker sample of each broker entity
+// already collected. Broker entities are named after the address the broker advertises, which is the address in
+// the metadata.
+func setBrokerMetadata(kafkaArgs *args.KafkaArguments, brokers []brokerMetadata, kafkaIntegration *integration.Integration) {
+	brokersByAddr := make(map[string]brokerMetadata, len(brokers))
+	for _, b := range brokers {
+		brokersByAddr[b.addr] = b
+	}
+
+	for _, entity := range kafkaIntegration.Entities {
+		if entity.Metadata == nil || entity.Metadata.Namespace != "ka-broker" {
+			continue
+		}
+
+		b, ok := brokersByAddr[entity.Metadata.Name]
+		if !ok {
+			log.Debug("Broker %s not found in cluster metadata, not reporting its rack and leader count", entity.Metadata.Name)
+			continue
+		}
+
+		// Only on the broker sample itself, not the per topic and log directory samples sharing its event type
+		sample := brokerSample(kafkaArgs, entity)
+		if err := sample.SetMetric("rack", b.rack, metric.ATTRIBUTE); err != nil {
+			collecterrors.Error("Failed to set rack for broker %s: %s", entity.Metadata.Name, err.Error())
+		}
+		if err := sample.SetMetric("broker.leaderCount", b.leaderCount, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set leader count for broker %s: %s", entity.Metadata.Name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package brokercollect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testMetadataResponse(controllerID int32) *sarama.MetadataResponse {
+	metadata := &sarama.MetadataResponse{ControllerID: controllerID}
+	metadata.AddBroker("broker0:9092", 0)
+	metadata.AddBroker("broker1:9092", 1)
+	metadata.AddTopicPartition("topic1", 0, 0, []int32{0, 1}, []int32{0, 1}, nil, sarama.ErrNoError)
+	metadata.AddTopicPartition("topic1", 1, 1, []int32{1, 0}, []int32{1, 0}, nil, sarama.ErrNoError)
+	metadata.AddTopicPartition("topic2", 0, 0, []int32{0}, []int32{0}, nil, sarama.ErrNoError)
+	return metadata
+}
+
+func TestSetClusterMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "1.0.0")
+
+	err := setClusterMetrics(kafkaArgs, testMetadataResponse(1), i)
+	assert.Nil(t, err)
+
+	clusterEntity, _ := i.Entity("testcluster", "ka-cluster")
+	metrics := clusterEntity.Metrics[0].Metrics
+	assert.Equal(t, "KafkaClusterSample", metrics["event_type"])
+	assert.Equal(t, float64(2), metrics["cluster.brokerCount"])
+	assert.Equal(t, float64(1), metrics["cluster.controllerId"])
+	assert.Equal(t, float64(3), metrics["cluster.partitionCount"])
+	// Neither broker has a rack configured
+	assert.Equal(t, float64(1), metrics["cluster.rackCount"])
+	assert.Equal(t, float64(0), metrics["cluster.nonRackAwarePartitions"])
+	// Broker 0 leads two of the three partitions
+	assert.Equal(t, float64(2)/1.5, metrics["cluster.leaderSkewRatio"])
+}
+
+func TestClusterMetadataLeaderSkew(t *testing.T) {
+	balanced := clusterMetadata{brokers: []brokerMetadata{{id: 0, leaderCount: 2}, {id: 1, leaderCount: 2}}}
+	skew, ok := balanced.leaderSkew()
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), skew)
+
+	// Brokers leading no partitions still count towards the average
+	idle := clusterMetadata{brokers: []brokerMetadata{{id: 0, leaderCount: 4}, {id: 1}, {id: 2}, {id: 3}}}
+	skew, ok = idle.leaderSkew()
+	assert.True(t, ok)
+	assert.Equal(t, float64(4), skew)
+
+	noLeaders := clusterMetadata{brokers: []brokerMetadata{{id: 0}, {id: 1}}}
+	_, ok = noLeaders.leaderSkew()
+	assert.False(t, ok)
+
+	_, ok = (&clusterMetadata{}).leaderSkew()
+	assert.False(t, ok)
+}
+
+func TestClusterMetadataRackAwareness(t *testing.T) {
+	testCases := []struct {
+		name               string
+		metadata           clusterMetadata
+		expectedRacks      int
+		expectedViolations int
+	}{
+		{
+			"No racks configured",
+			clusterMetadata{
+				brokers:  []brokerMetadata{{id: 0, rack: unknownRack}, {id: 1, rack: unknownRack}},
+				replicas: [][]int32{{0, 1}},
+			},
+			1, 0,
+		},
+		{
+			"Spread across racks",
+			clusterMetadata{
+				brokers:  []brokerMetadata{{id: 0, rack: "a"}, {id: 1, rack: "b"}, {id: 2, rack: "a"}},
+				replicas: [][]int32{{0, 1}, {1, 2}, {2}},
+			},
+			2, 0,
+		},
+		{
+			"Replicas in a single rack",
+			clusterMetadata{
+				brokers:  []brokerMetadata{{id: 0, rack: "a"}, {id: 1, rack: "b"}, {id: 2, rack: "a"}},
+				replicas: [][]int32{{0, 2}, {0, 1}, {2, 0, 2}},
+			},
+			2, 2,
+		},
+		{
+			"Unknown and missing brokers",
+			clusterMetadata{
+				brokers:  []brokerMetadata{{id: 0, rack: "a"}, {id: 1, rack: unknownRack}},
+				replicas: [][]int32{{1, 5}, {0, 5}},
+			},
+			2, 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		racks, violations := tc.metadata.rackAwareness()
+		assert.Equal(t, tc.expectedRacks, racks, tc.name)
+		assert.Equal(t, tc.expectedViolations, violations, tc.name)
+	}
+}
+
+func TestSetBrokerMetadata(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", BrokerSampleName: "KafkaBrokerSample"}
+	i, _ := integration.New("test", "1.0.0")
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+
+	broker0, _ := i.Entity("broker0:9092", "ka-broker", clusterIDAttr)
+	broker0.NewMetricSet("KafkaBrokerSample")
+	// A per topic broker sample
+	broker0.NewMetricSet("KafkaBrokerSample", metric.Attribute{Key: "topic", Value: "topic1"})
+	broker1, _ := i.Entity("broker1:9092", "ka-broker", clusterIDAttr)
+	broker1.NewMetricSet("KafkaBrokerSample")
+
+	setBrokerMetadata(kafkaArgs, []brokerMetadata{{id: 0, addr: "broker0:9092", rack: "us-east-1a", leaderCount: 3}, {id: 1, addr: "broker1:9092", rack: unknownRack}}, i)
+
+	assert.Equal(t, "us-east-1a", broker0.Metrics[0].Metrics["rack"])
+	assert.Equal(t, "unknown", broker1.Metrics[0].Metrics["rack"])
+	assert.Equal(t, float64(3), broker0.Metrics[0].Metrics["broker.leaderCount"])
+	assert.Equal(t, float64(0), broker1.Metrics[0].Metrics["broker.leaderCount"])
+
+	_, ok := broker0.Metrics[1].Metrics["broker.leaderCount"]
+	assert.False(t, ok)
+	_, ok = broker0.Metrics[1].Metrics["rack"]
+	assert.False(t, ok)
+}
+
+func TestSetClusterMetrics_NoController(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "1.0.0")
+
+	err := setClusterMetrics(kafkaArgs, testMetadataResponse(noController), i)
+	assert.Nil(t, err)
+
+	clusterEntity, _ := i.Entity("testcluster", "ka-cluster")
+	assert.Equal(t, float64(-1), clusterEntity.Metrics[0].Metrics["cluster.controllerId"])
+}
+
+func TestFetchClusterMetadata(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	fakeClient := new(connection.MockClient)
+	failingBroker := new(connection.MockBroker)
+	workingBroker := new(connection.MockBroker)
+	metadata := testMetadataResponse(0)
+
+	fakeClient.On("Brokers").Return([]connection.Broker{failingBroker, workingBroker})
+	fakeClient.On("Config").Return(sarama.NewConfig())
+	failingBroker.On("ID").Return(int32(1))
+	workingBroker.On("ID").Return(int32(2))
+	failingBroker.On("Connected").Return(false, nil)
+	failingBroker.On("Open", mock.Anything).Return(errors.New("connection refused"))
+	workingBroker.On("Connected").Return(true, nil)
+	workingBroker.On("GetMetadata", mock.MatchedBy(func(r *sarama.MetadataRequest) bool {
+		return r.Version == 1 && len(r.Topics) == 0
+	})).Return(metadata, nil).Once()
+
+	result, err := fetchClusterMetadata(kafkaArgs, fakeClient)
+	assert.Nil(t, err)
+	assert.Equal(t, metadata, result)
+}
+
+func TestFetchClusterMetadata_NoBrokers(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	fakeClient := new(connection.MockClient)
+	fakeClient.On("Brokers").Return([]connection.Broker{})
+
+	_, err := fetchClusterMetadata(kafkaArgs, fakeClient)
+	assert.NotNil(t, err)
+}
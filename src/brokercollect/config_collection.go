@@ -0,0 +1,123 @@
+package brokercollect
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// redactedValue replaces the value of sensitive config entries in inventory
+const redactedValue = "(redacted)"
+
+// CollectConfigInventory reports the configuration of every broker and of the collected topics collect_inventory or
+// topic_overrides enable it for, as returned by DescribeConfigs, as inventory on the broker and topic entities
+func CollectConfigInventory(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, collectedTopics []string, kafkaIntegration *integration.Integration) error {
+	clusterAdmin, err := zkConn.CreateClusterAdmin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := clusterAdmin.Close(); err != nil {
+			log.Debug("Error closing cluster admin connection: %s", err.Error())
+		}
+	}()
+
+	// topic_overrides only apply to topics, the broker configuration is only collected with collect_inventory
+	if kafkaArgs.CollectInventory {
+		brokerIDs, err := zookeeper.GetBrokerIDs(zkConn)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range brokerIDs {
+			if err := collectBrokerConfigInventory(kafkaArgs, id, clusterAdmin, zkConn, kafkaIntegration); err != nil {
+				collecterrors.Error("Unable to collect configuration inventory for broker ID %s: %s", id, err.Error())
+			}
+		}
+	}
+
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+
+	for _, topic := range collectedTopics {
+		if !kafkaArgs.TopicCollectsInventory(topic) {
+			continue
+		}
+
+		entries, err := clusterAdmin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic})
+		if err != nil {
+			collecterrors.Error("Unable to describe configuration of topic %s: %s", topic, err.Error())
+			continue
+		}
+
+		topicEntity, err := kafkaIntegration.Entity(topic, "ka-topic", clusterIDAttrs...)
+		if err != nil {
+			collecterrors.Error("Unable to create entity for topic %s: %s", topic, err.Error())
+			continue
+		}
+
+		setConfigInventory(topicEntity, "topic.", entries)
+	}
+
+	return nil
+}
+
+// collectBrokerConfigInventory describes the configuration of a broker and sets it on the entity of
+// each of the broker's connections, which are named the same way as by the broker workers
+func collectBrokerConfigInventory(kafkaArgs *args.KafkaArguments, id string, clusterAdmin sarama.ClusterAdmin, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) error {
+	brokerID, err := strconv.Atoi(id)
+	if err != nil {
+		return err
+	}
+
+	brokerConnections, err := zookeeper.GetBrokerConnections(brokerID, zkConn)
+	if err != nil {
+		return err
+	}
+
+	entries, err := clusterAdmin.DescribeConfig(sarama.ConfigResource{Type: sarama.BrokerResource, Name: id})
+	if err != nil {
+		return err
+	}
+
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+	for _, brokerConnection := range brokerConnections {
+		brokerEntity, err := kafkaIntegration.Entity(
+			brokerConnection.Addr(),
+			"ka-broker",
+			clusterIDAttrs...)
+		if err != nil {
+			return err
+		}
+
+		setConfigInventory(brokerEntity, "broker.", entries)
+	}
+
+	return nil
+}
+
+// setConfigInventory sets an inventory item for each config entry, using the same keys as the
+// configuration read from Zookeeper so that the values reported by both agree
+func setConfigInventory(entity *integration.Entity, prefix string, entries []sarama.ConfigEntry) {
+	for _, entry := range entries {
+		value := entry.Value
+		if isSensitiveConfig(entry) {
+			value = redactedValue
+		}
+
+		if err := entity.SetInventoryItem(prefix+entry.Name, "value", value); err != nil {
+			collecterrors.Error("Unable to set inventory item %s%s for %s: %s", prefix, entry.Name, entity.Metadata.Name, err.Error())
+		}
+	}
+}
+
+// isSensitiveConfig returns true for entries the broker flags as sensitive, and for any password entry
+// in case the broker is too old to flag them
+func isSensitiveConfig(entry sarama.ConfigEntry) bool {
+	return entry.Sensitive || strings.Contains(strings.ToLower(entry.Name), "password")
+}
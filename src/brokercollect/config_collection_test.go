@@ -0,0 +1,66 @@
+package brokercollect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectConfigInventory(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", CollectInventory: true}
+	i, _ := integration.New("test", "1.0.0")
+
+	zkConn := &zookeeper.MockConnection{}
+	clusterAdmin := connection.MockClusterAdmin{}
+	zkConn.On("CreateClusterAdmin").Return(&clusterAdmin, nil)
+	zkConn.On("Children", "/brokers/ids").Return([]string{"0"}, new(zk.Stat), nil)
+	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	clusterAdmin.On("Close").Return(nil)
+	clusterAdmin.On("DescribeConfig", sarama.ConfigResource{Type: sarama.BrokerResource, Name: "0"}).Return([]sarama.ConfigEntry{
+		{Name: "log.retention.hours", Value: "168"},
+		{Name: "ssl.keystore.password", Value: "", Sensitive: true},
+		{Name: "ssl.key.password", Value: "secret"},
+	}, nil)
+	clusterAdmin.On("DescribeConfig", sarama.ConfigResource{Type: sarama.TopicResource, Name: "topic1"}).Return([]sarama.ConfigEntry{
+		{Name: "retention.ms", Value: "604800000"},
+	}, nil)
+	clusterAdmin.On("DescribeConfig", sarama.ConfigResource{Type: sarama.TopicResource, Name: "topic2"}).Return([]sarama.ConfigEntry{}, errors.New("unknown topic"))
+
+	err := CollectConfigInventory(kafkaArgs, zkConn, []string{"topic1", "topic2"}, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	brokerEntity, _ := i.Entity("kafkabroker:9092", "ka-broker", clusterIDAttr)
+	item, _ := brokerEntity.Inventory.Item("broker.log.retention.hours")
+	assert.Equal(t, "168", item["value"])
+	item, _ = brokerEntity.Inventory.Item("broker.ssl.keystore.password")
+	assert.Equal(t, redactedValue, item["value"])
+	item, _ = brokerEntity.Inventory.Item("broker.ssl.key.password")
+	assert.Equal(t, redactedValue, item["value"])
+
+	topicEntity, _ := i.Entity("topic1", "ka-topic", clusterIDAttr)
+	item, _ = topicEntity.Inventory.Item("topic.retention.ms")
+	assert.Equal(t, "604800000", item["value"])
+
+	// Topics that can't be described don't get an entity
+	assert.Len(t, i.Entities, 3)
+}
+
+func TestCollectConfigInventory_NoClusterAdmin(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", CollectInventory: true}
+	i, _ := integration.New("test", "1.0.0")
+
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("CreateClusterAdmin").Return(&connection.MockClusterAdmin{}, errors.New("connection refused"))
+
+	err := CollectConfigInventory(kafkaArgs, zkConn, []string{"topic1"}, i)
+	assert.NotNil(t, err)
+	assert.Empty(t, i.Entities)
+}
@@ -0,0 +1,227 @@
+package brokercollect
+
+import (
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// brokerLogDirs is the size of the log directories of a broker and of the partition replicas in them
+type brokerLogDirs struct {
+	// dirs holds the size of each log directory, by path
+	dirs map[string]int64
+	// replicas holds the size of each partition replica on the broker, by topic and partition
+	replicas map[string]map[int32]int64
+}
+
+// newBrokerLogDirs sums the DescribeLogDirs response of broker brokerID. Log directories the broker returned an
+// error for, such as offline ones, are skipped. Future replicas being moved between directories count towards
+// the size of their directory, but not of their partition, which is its current replica.
+func newBrokerLogDirs(brokerID int, response *sarama.DescribeLogDirsResponse) *brokerLogDirs {
+	logDirs := &brokerLogDirs{dirs: make(map[string]int64), replicas: make(map[string]map[int32]int64)}
+	for _, dir := range response.LogDirs {
+		if dir.ErrorCode != sarama.ErrNoError {
+			log.Warn("Skipping log directory %s of broker %d: %s", dir.Path, brokerID, dir.ErrorCode.Error())
+			continue
+		}
+
+		var dirSize int64
+		for _, topic := range dir.Topics {
+			for _, partition := range topic.Partitions {
+				dirSize += partition.Size
+				if partition.IsTemporary {
+					continue
+				}
+
+				if logDirs.replicas[topic.Topic] == nil {
+					logDirs.replicas[topic.Topic] = make(map[int32]int64)
+				}
+				logDirs.replicas[topic.Topic][partition.PartitionID] += partition.Size
+			}
+		}
+		logDirs.dirs[dir.Path] = dirSize
+	}
+
+	return logDirs
+}
+
+// size returns the size of every log directory of the broker
+func (l *brokerLogDirs) size() int64 {
+	var total int64
+	for _, dirSize := range l.dirs {
+		total += dirSize
+	}
+
+	return total
+}
+
+// topicLogDirs is the disk usage of the collected topics across the brokers
+type topicLogDirs struct {
+	// topics holds the size of every replica of each topic
+	topics map[string]int64
+	// partitions holds the size of the largest replica of each partition, by topic and partition
+	partitions map[string]map[int32]int64
+}
+
+func newTopicLogDirs() *topicLogDirs {
+	return &topicLogDirs{topics: make(map[string]int64), partitions: make(map[string]map[int32]int64)}
+}
+
+// add adds the replicas of the collected topics collect_log_dir_sizes or topic_overrides enable it for on a broker
+func (t *topicLogDirs) add(kafkaArgs *args.KafkaArguments, logDirs *brokerLogDirs, collectedTopics map[string]bool) {
+	for topic, replicas := range logDirs.replicas {
+		if !collectedTopics[topic] || !kafkaArgs.TopicCollectsLogDirSizes(topic) {
+			continue
+		}
+
+		if t.partitions[topic] == nil {
+			t.partitions[topic] = make(map[int32]int64)
+		}
+		for partition, size := range replicas {
+			t.topics[topic] += size
+			if size > t.partitions[topic][partition] {
+				t.partitions[topic][partition] = size
+			}
+		}
+	}
+}
+
+// CollectLogDirMetrics reports the size of the log directories of each broker from the DescribeLogDirs API, on
+// the broker entities, and the size of each collected topic and its partitions on the topic entities
+func CollectLogDirMetrics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, collectedTopics []string, kafkaIntegration *integration.Integration) error {
+	client, err := zkConn.CreateClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Debug("Error closing client connection: %s", err.Error())
+		}
+	}()
+
+	collected := make(map[string]bool, len(collectedTopics))
+	for _, topic := range collectedTopics {
+		collected[topic] = true
+	}
+
+	topics := newTopicLogDirs()
+	for _, broker := range client.Brokers() {
+		brokerID := int(broker.ID())
+		if !allowBroker(kafkaArgs, circuitMetadata, brokerID) {
+			continue
+		}
+
+		response, err := describeLogDirs(broker, client.Config())
+		if err != nil {
+			collecterrors.Error("Unable to describe the log directories of broker %d: %s", brokerID, err.Error())
+			continue
+		}
+
+		// topic_overrides only apply to topics, the broker sizes are only reported with collect_log_dir_sizes
+		logDirs := newBrokerLogDirs(brokerID, response)
+		if kafkaArgs.CollectLogDirSizes {
+			setBrokerLogDirMetrics(kafkaArgs, brokerID, logDirs, zkConn, kafkaIntegration)
+		}
+		topics.add(kafkaArgs, logDirs, collected)
+	}
+
+	setTopicLogDirMetrics(kafkaArgs, topics, kafkaIntegration)
+
+	return nil
+}
+
+// describeLogDirs requests the size of every log directory and partition replica of broker
+func describeLogDirs(broker connection.Broker, config *sarama.Config) (*sarama.DescribeLogDirsResponse, error) {
+	if connected, _ := broker.Connected(); !connected {
+		if err := broker.Open(config); err != nil {
+			return nil, err
+		}
+	}
+
+	// No topics means every topic
+	return broker.DescribeLogDirs(&sarama.DescribeLogDirsRequest{})
+}
+
+// setBrokerLogDirMetrics reports the size of each log directory of the broker in a broker sample with a logDir
+// attribute, and the size of all of them on the broker sample of the broker collection
+func setBrokerLogDirMetrics(kafkaArgs *args.KafkaArguments, brokerID int, logDirs *brokerLogDirs, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) {
+	brokerConnections, err := zookeeper.GetBrokerConnections(brokerID, zkConn)
+	if err != nil {
+		collecterrors.Error("Unable to get the connections of broker %d: %s", brokerID, err.Error())
+		return
+	}
+
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+	for _, brokerConnection := range brokerConnections {
+		brokerEntity, err := kafkaIntegration.Entity(brokerConnection.Addr(), "ka-broker", clusterIDAttrs...)
+		if err != nil {
+			collecterrors.Error("Unable to create entity for broker ID %d: %s", brokerID, err.Error())
+			continue
+		}
+
+		if err := brokerSample(kafkaArgs, brokerEntity).SetMetric("kafka.broker.logDirs.sizeBytes", logDirs.size(), metric.GAUGE); err != nil {
+			collecterrors.Error("Unable to set log directory size for broker %d: %s", brokerID, err.Error())
+		}
+
+		for path, size := range logDirs.dirs {
+			sample := brokerEntity.NewMetricSet(kafkaArgs.BrokerSampleName,
+				metric.Attribute{Key: "displayName", Value: brokerEntity.Metadata.Name},
+				metric.Attribute{Key: "entityName", Value: "broker:" + brokerEntity.Metadata.Name},
+				metric.Attribute{Key: "logDir", Value: path},
+			)
+			if err := sample.SetMetric("kafka.broker.logDir.sizeBytes", size, metric.GAUGE); err != nil {
+				collecterrors.Error("Unable to set size of log directory %s for broker %d: %s", path, brokerID, err.Error())
+			}
+		}
+	}
+}
+
+// brokerSample returns the broker sample already created by the broker collection, which is the first one
+// created on the entity, or a new one if the broker's JMX wasn't collected
+func brokerSample(kafkaArgs *args.KafkaArguments, brokerEntity *integration.Entity) *metric.Set {
+	for _, sample := range brokerEntity.Metrics {
+		if sample.Metrics["event_type"] == kafkaArgs.BrokerSampleName {
+			return sample
+		}
+	}
+
+	return brokerEntity.NewMetricSet(kafkaArgs.BrokerSampleName,
+		metric.Attribute{Key: "displayName", Value: brokerEntity.Metadata.Name},
+		metric.Attribute{Key: "entityName", Value: "broker:" + brokerEntity.Metadata.Name},
+	)
+}
+
+// setTopicLogDirMetrics reports the size of every replica of each topic on its topic sample, and the size of the
+// largest replica of each of its partitions in a topic sample with a partition attribute
+func setTopicLogDirMetrics(kafkaArgs *args.KafkaArguments, topics *topicLogDirs, kafkaIntegration *integration.Integration) {
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+	for topicName, size := range topics.topics {
+		topicEntity, err := kafkaIntegration.Entity(topicName, "ka-topic", clusterIDAttrs...)
+		if err != nil {
+			collecterrors.Error("Unable to create an entity for topic %s", topicName)
+			continue
+		}
+
+		if err := topicSample(kafkaArgs, topicEntity).SetMetric("kafka.topic.logDir.sizeBytes", size, metric.GAUGE); err != nil {
+			collecterrors.Error("Unable to set log directory size for Topic %s: %s", topicName, err.Error())
+		}
+
+		for partition, partitionSize := range topics.partitions[topicName] {
+			sample := topicEntity.NewMetricSet(kafkaArgs.TopicSampleName, append([]metric.Attribute{
+				{Key: "displayName", Value: topicEntity.Metadata.Name},
+				{Key: "entityName", Value: "topic:" + topicEntity.Metadata.Name},
+				{Key: "partition", Value: strconv.Itoa(int(partition))},
+			}, kafkaArgs.TopicAttributes(topicName)...)...)
+			if err := sample.SetMetric("kafka.partition.logDir.sizeBytes", partitionSize, metric.GAUGE); err != nil {
+				collecterrors.Error("Unable to set log directory size for partition %d of Topic %s: %s", partition, topicName, err.Error())
+			}
+		}
+	}
+}
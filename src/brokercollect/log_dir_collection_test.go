@@ -0,0 +1,138 @@
+package brokercollect
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testLogDirsResponse(sizes ...int64) *sarama.DescribeLogDirsResponse {
+	return &sarama.DescribeLogDirsResponse{LogDirs: []sarama.DescribeLogDirsResponseDirMetadata{
+		{
+			Path: "/data/1",
+			Topics: []sarama.DescribeLogDirsResponseTopic{
+				{Topic: "topic1", Partitions: []sarama.DescribeLogDirsResponsePartition{
+					{PartitionID: 0, Size: sizes[0]},
+					{PartitionID: 1, Size: sizes[1]},
+				}},
+				{Topic: "uncollected", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 0, Size: 1000}}},
+			},
+		},
+		{
+			Path: "/data/2",
+			Topics: []sarama.DescribeLogDirsResponseTopic{
+				// A replica being moved into this directory
+				{Topic: "topic1", Partitions: []sarama.DescribeLogDirsResponsePartition{{PartitionID: 1, Size: 7, IsTemporary: true}}},
+			},
+		},
+		{Path: "/data/offline", ErrorCode: sarama.ErrKafkaStorageError},
+	}}
+}
+
+func TestNewBrokerLogDirs(t *testing.T) {
+	logDirs := newBrokerLogDirs(0, testLogDirsResponse(10, 20))
+
+	assert.Equal(t, map[string]int64{"/data/1": 1030, "/data/2": 7}, logDirs.dirs)
+	assert.Equal(t, int64(1037), logDirs.size())
+	assert.Equal(t, map[int32]int64{0: 10, 1: 20}, logDirs.replicas["topic1"])
+}
+
+// testLogDirConnection returns a connection to three brokers, the last of which fails DescribeLogDirs
+func testLogDirConnection() *zookeeper.MockConnection {
+	zkConn := &zookeeper.MockConnection{}
+	fakeClient := &connection.MockClient{}
+	zkConn.On("CreateClient").Return(fakeClient, nil)
+	fakeClient.On("Config").Return(sarama.NewConfig())
+	fakeClient.On("Close").Return(nil)
+
+	var brokers []connection.Broker
+	for id, response := range []*sarama.DescribeLogDirsResponse{testLogDirsResponse(10, 20), testLogDirsResponse(12, 15), nil} {
+		broker := &connection.MockBroker{}
+		broker.On("ID").Return(int32(id))
+		broker.On("Connected").Return(true, nil)
+		if response != nil {
+			broker.On("DescribeLogDirs", mock.Anything).Return(response, nil)
+		} else {
+			broker.On("DescribeLogDirs", mock.Anything).Return((*sarama.DescribeLogDirsResponse)(nil), errors.New("unsupported version"))
+		}
+		brokers = append(brokers, broker)
+
+		connectionBytes := []byte(fmt.Sprintf(`{"endpoints":["PLAINTEXT://kafkabroker%d:9092"],"jmx_port":9999}`, id))
+		zkConn.On("Get", fmt.Sprintf("/brokers/ids/%d", id)).Return(connectionBytes, new(zk.Stat), nil)
+	}
+	fakeClient.On("Brokers").Return(brokers)
+
+	return zkConn
+}
+
+func TestCollectLogDirMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", CollectLogDirSizes: true, BrokerSampleName: "KafkaBrokerSample", TopicSampleName: "KafkaTopicSample"}
+	i, _ := integration.New("test", "1.0.0")
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+
+	assert.Nil(t, CollectLogDirMetrics(kafkaArgs, testLogDirConnection(), []string{"topic1"}, i))
+
+	brokerEntity, _ := i.Entity("kafkabroker0:9092", "ka-broker", clusterIDAttr)
+	assert.Len(t, brokerEntity.Metrics, 3)
+	assert.Equal(t, float64(1037), brokerEntity.Metrics[0].Metrics["kafka.broker.logDirs.sizeBytes"])
+	for _, sample := range brokerEntity.Metrics[1:] {
+		switch sample.Metrics["logDir"] {
+		case "/data/1":
+			assert.Equal(t, float64(1030), sample.Metrics["kafka.broker.logDir.sizeBytes"])
+		case "/data/2":
+			assert.Equal(t, float64(7), sample.Metrics["kafka.broker.logDir.sizeBytes"])
+		default:
+			t.Errorf("Unexpected log directory sample %v", sample.Metrics)
+		}
+	}
+
+	failedEntity, _ := i.Entity("kafkabroker2:9092", "ka-broker", clusterIDAttr)
+	assert.Empty(t, failedEntity.Metrics)
+
+	topicEntity, _ := i.Entity("topic1", "ka-topic", clusterIDAttr)
+	assert.Len(t, topicEntity.Metrics, 3)
+	assert.Equal(t, float64(57), topicEntity.Metrics[0].Metrics["kafka.topic.logDir.sizeBytes"])
+	partitionSizes := make(map[string]interface{})
+	for _, sample := range topicEntity.Metrics[1:] {
+		partitionSizes[sample.Metrics["partition"].(string)] = sample.Metrics["kafka.partition.logDir.sizeBytes"]
+	}
+	assert.Equal(t, map[string]interface{}{"0": float64(12), "1": float64(20)}, partitionSizes)
+
+	uncollectedEntity, _ := i.Entity("uncollected", "ka-topic", clusterIDAttr)
+	assert.Empty(t, uncollectedEntity.Metrics)
+}
+
+func TestCollectLogDirMetrics_TopicOverrides(t *testing.T) {
+	enabled := true
+	kafkaArgs := &args.KafkaArguments{
+		ClusterName:      "testcluster",
+		BrokerSampleName: "KafkaBrokerSample",
+		TopicSampleName:  "KafkaTopicSample",
+		TopicOverrides:   []*args.TopicOverride{{Topic: "topic2", CollectLogDirSizes: &enabled}},
+	}
+	i, _ := integration.New("test", "1.0.0")
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+
+	assert.Nil(t, CollectLogDirMetrics(kafkaArgs, testLogDirConnection(), []string{"topic1", "uncollected"}, i))
+
+	// Only the topics the overrides enable it for are reported without collect_log_dir_sizes
+	brokerEntity, _ := i.Entity("kafkabroker0:9092", "ka-broker", clusterIDAttr)
+	assert.Empty(t, brokerEntity.Metrics)
+	topicEntity, _ := i.Entity("topic1", "ka-topic", clusterIDAttr)
+	assert.Empty(t, topicEntity.Metrics)
+
+	kafkaArgs.TopicOverrides[0].Topic = "uncollected"
+	assert.Nil(t, CollectLogDirMetrics(kafkaArgs, testLogDirConnection(), []string{"topic1", "uncollected"}, i))
+	uncollectedEntity, _ := i.Entity("uncollected", "ka-topic", clusterIDAttr)
+	assert.Len(t, uncollectedEntity.Metrics, 2)
+	assert.Equal(t, float64(2000), uncollectedEntity.Metrics[0].Metrics["kafka.topic.logDir.sizeBytes"])
+}
@@ -0,0 +1,164 @@
+package brokercollect
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// offsetsTopic is the internal topic the group coordinators store the committed offsets and group metadata in
+const offsetsTopic = "__consumer_offsets"
+
+// offsetsTopicSampleName is the event type of the samples of the __consumer_offsets entity
+const offsetsTopicSampleName = "KafkaOffsetsTopicSample"
+
+// offsetsTopicPartition is the offsets of a partition of __consumer_offsets. The leader of a partition is the
+// coordinator of the groups stored in it.
+type offsetsTopicPartition struct {
+	partition int32
+	// leader is the ID of the leader broker, -1 if the partition has no leader
+	leader        int32
+	highWaterMark int64
+	oldestOffset  int64
+}
+
+// retained returns the number of offsets between the oldest retained offset and the high water mark. Compaction
+// leaves gaps between them, so it's an upper bound of the records in the partition.
+func (p offsetsTopicPartition) retained() int64 {
+	return p.highWaterMark - p.oldestOffset
+}
+
+// CollectOffsetsTopicMetrics reports the high water mark and retained offsets of every partition of the
+// __consumer_offsets topic, whatever offsets.topic.num.partitions is, on a ka-offsets-topic entity. A partition
+// that keeps growing while the others don't points at a coordinator under pressure. Clusters on which no group
+// has committed offsets don't have the topic, so nothing is reported for them.
+func CollectOffsetsTopicMetrics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) error {
+	client, err := zkConn.CreateClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Debug("Error closing client connection: %s", err.Error())
+		}
+	}()
+
+	partitions, err := readOffsetsTopicPartitions(client)
+	if err == sarama.ErrUnknownTopicOrPartition {
+		log.Info("Topic %s doesn't exist on cluster '%s', no consumer group has committed offsets. Not collecting offsets topic metrics",
+			offsetsTopic, kafkaArgs.ClusterName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return setOffsetsTopicMetrics(kafkaArgs, partitions, kafkaIntegration)
+}
+
+// readOffsetsTopicPartitions requests the offsets of every partition of __consumer_offsets, sorted by partition.
+// Partitions whose offsets can't be requested are skipped with a warning.
+func readOffsetsTopicPartitions(client connection.Client) ([]offsetsTopicPartition, error) {
+	partitionIDs, err := client.Partitions(offsetsTopic)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	partitions := make([]offsetsTopicPartition, 0, len(partitionIDs))
+	for _, partitionID := range partitionIDs {
+		wg.Add(1)
+		go func(partitionID int32) {
+			defer wg.Done()
+
+			partition, err := readOffsetsTopicPartition(client, partitionID)
+			if err != nil {
+				log.Warn("Failed to get the offsets of partition %d of %s: %s", partitionID, offsetsTopic, err)
+				return
+			}
+
+			lock.Lock()
+			partitions = append(partitions, partition)
+			lock.Unlock()
+		}(partitionID)
+	}
+	wg.Wait()
+
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].partition < partitions[j].partition })
+	return partitions, nil
+}
+
+func readOffsetsTopicPartition(client connection.Client, partitionID int32) (offsetsTopicPartition, error) {
+	partition := offsetsTopicPartition{partition: partitionID, leader: -1}
+
+	leader, err := client.Leader(offsetsTopic, partitionID)
+	if err == nil {
+		partition.leader = leader.ID()
+	} else {
+		log.Debug("No leader for partition %d of %s: %s", partitionID, offsetsTopic, err)
+	}
+
+	if partition.highWaterMark, err = client.GetOffset(offsetsTopic, partitionID, sarama.OffsetNewest); err != nil {
+		return partition, err
+	}
+	if partition.oldestOffset, err = client.GetOffset(offsetsTopic, partitionID, sarama.OffsetOldest); err != nil {
+		return partition, err
+	}
+
+	return partition, nil
+}
+
+// setOffsetsTopicMetrics reports a sample with the partition count and retained offsets of the whole topic, and
+// a sample with a partition attribute for each partition
+func setOffsetsTopicMetrics(kafkaArgs *args.KafkaArguments, partitions []offsetsTopicPartition, kafkaIntegration *integration.Integration) error {
+	topicEntity, err := kafkaIntegration.Entity(offsetsTopic, "ka-offsets-topic", kafkaArgs.ClusterIDAttributes()...)
+	if err != nil {
+		return err
+	}
+
+	attributes := []metric.Attribute{
+		{Key: "displayName", Value: topicEntity.Metadata.Name},
+		{Key: "entityName", Value: "offsetsTopic:" + topicEntity.Metadata.Name},
+		{Key: "clusterName", Value: kafkaArgs.ClusterName},
+	}
+
+	var retained int64
+	for _, partition := range partitions {
+		retained += partition.retained()
+	}
+	topicSample := topicEntity.NewMetricSet(offsetsTopicSampleName, attributes...)
+	for name, value := range map[string]int64{
+		"kafka.offsetsTopic.partitions":      int64(len(partitions)),
+		"kafka.offsetsTopic.retainedOffsets": retained,
+	} {
+		if err := topicSample.SetMetric(name, value, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set metric %s: %s", name, err.Error())
+		}
+	}
+
+	for _, partition := range partitions {
+		sample := topicEntity.NewMetricSet(offsetsTopicSampleName,
+			append(attributes, metric.Attribute{Key: "partition", Value: strconv.Itoa(int(partition.partition))})...)
+		for name, value := range map[string]int64{
+			"kafka.offsetsTopic.partition.highWaterMark":   partition.highWaterMark,
+			"kafka.offsetsTopic.partition.oldestOffset":    partition.oldestOffset,
+			"kafka.offsetsTopic.partition.retainedOffsets": partition.retained(),
+			"kafka.offsetsTopic.partition.leader":          int64(partition.leader),
+		} {
+			if err := sample.SetMetric(name, value, metric.GAUGE); err != nil {
+				collecterrors.Error("Failed to set metric %s for partition %d of %s: %s", name, partition.partition, offsetsTopic, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
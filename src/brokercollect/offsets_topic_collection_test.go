@@ -0,0 +1,73 @@
+package brokercollect
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectOffsetsTopicMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "1.0.0")
+
+	zkConn := &zookeeper.MockConnection{}
+	fakeClient := &connection.MockClient{}
+	zkConn.On("CreateClient").Return(fakeClient, nil)
+	fakeClient.On("Close").Return(nil)
+	// Not the default 50 partitions, and partition 2 has no leader and partition 3 fails
+	fakeClient.On("Partitions", "__consumer_offsets").Return([]int32{3, 0, 1, 2}, nil)
+	broker := &connection.MockBroker{}
+	broker.On("ID").Return(int32(1))
+	for partition, offsets := range map[int32][2]int64{0: {100, 40}, 1: {10, 10}, 2: {7, 0}} {
+		if partition == 2 {
+			fakeClient.On("Leader", "__consumer_offsets", partition).Return((*connection.MockBroker)(nil), errors.New("no leader"))
+		} else {
+			fakeClient.On("Leader", "__consumer_offsets", partition).Return(broker, nil)
+		}
+		fakeClient.On("GetOffset", "__consumer_offsets", partition, sarama.OffsetNewest).Return(offsets[0], nil)
+		fakeClient.On("GetOffset", "__consumer_offsets", partition, sarama.OffsetOldest).Return(offsets[1], nil)
+	}
+	fakeClient.On("Leader", "__consumer_offsets", int32(3)).Return(broker, nil)
+	fakeClient.On("GetOffset", "__consumer_offsets", int32(3), sarama.OffsetNewest).Return(int64(0), errors.New("timeout"))
+
+	assert.Nil(t, CollectOffsetsTopicMetrics(kafkaArgs, zkConn, i))
+
+	topicEntity, _ := i.Entity("__consumer_offsets", "ka-offsets-topic", integration.NewIDAttribute("clusterName", "testcluster"))
+	assert.Len(t, topicEntity.Metrics, 4)
+	assert.Equal(t, float64(3), topicEntity.Metrics[0].Metrics["kafka.offsetsTopic.partitions"])
+	assert.Equal(t, float64(67), topicEntity.Metrics[0].Metrics["kafka.offsetsTopic.retainedOffsets"])
+
+	for partition, expected := range []map[string]interface{}{
+		{"kafka.offsetsTopic.partition.highWaterMark": float64(100), "kafka.offsetsTopic.partition.retainedOffsets": float64(60), "kafka.offsetsTopic.partition.leader": float64(1)},
+		{"kafka.offsetsTopic.partition.highWaterMark": float64(10), "kafka.offsetsTopic.partition.retainedOffsets": float64(0), "kafka.offsetsTopic.partition.leader": float64(1)},
+		{"kafka.offsetsTopic.partition.highWaterMark": float64(7), "kafka.offsetsTopic.partition.retainedOffsets": float64(7), "kafka.offsetsTopic.partition.leader": float64(-1)},
+	} {
+		sample := topicEntity.Metrics[partition+1].Metrics
+		assert.Equal(t, "KafkaOffsetsTopicSample", sample["event_type"])
+		assert.Equal(t, strconv.Itoa(partition), sample["partition"])
+		for name, value := range expected {
+			assert.Equal(t, value, sample[name], name)
+		}
+	}
+}
+
+func TestCollectOffsetsTopicMetrics_NoTopic(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "1.0.0")
+
+	zkConn := &zookeeper.MockConnection{}
+	fakeClient := &connection.MockClient{}
+	zkConn.On("CreateClient").Return(fakeClient, nil)
+	fakeClient.On("Close").Return(nil)
+	fakeClient.On("Partitions", "__consumer_offsets").Return([]int32(nil), sarama.ErrUnknownTopicOrPartition)
+
+	assert.Nil(t, CollectOffsetsTopicMetrics(kafkaArgs, zkConn, i))
+	assert.Empty(t, i.Entities)
+}
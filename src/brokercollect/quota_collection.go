@@ -0,0 +1,109 @@
+package brokercollect
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/metrics"
+)
+
+// quotaPrincipal is the user and client ID a quota MBean is kept for. Either can be empty, depending on
+// whether the quotas are configured per user, per client ID or per both.
+type quotaPrincipal struct {
+	user     string
+	clientID string
+}
+
+// gatherQuotaMetrics reports the throttle time and quota usage of each principal the broker keeps quota
+// MBeans for, in a sample per principal with user and clientID attributes. Only the first max_quota_principals
+// principals, ordered by user and client ID, are reported.
+func gatherQuotaMetrics(kafkaArgs *args.KafkaArguments, b *broker) {
+	values := make(map[quotaPrincipal]map[string]float64)
+	for _, metricSet := range metrics.QuotaMetricDefs {
+		results, err := jmxwrapper.JMXQuery(metricSet.MBean, kafkaArgs.Timeout)
+		if err != nil {
+			collecterrors.Error("Broker '%s' failed to make JMX Query: %s", b.Host, err.Error())
+			continue
+		}
+
+		for key, result := range results {
+			value, ok := result.(float64)
+			if !ok {
+				continue
+			}
+
+			principal, attr, ok := parseQuotaKey(key)
+			if !ok {
+				continue
+			}
+
+			for _, metricDef := range metricSet.MetricDefs {
+				if metricDef.JMXAttr != attr {
+					continue
+				}
+				if _, ok := values[principal]; !ok {
+					values[principal] = make(map[string]float64)
+				}
+				values[principal][metricDef.Name] = value
+			}
+		}
+	}
+
+	principals := make([]quotaPrincipal, 0, len(values))
+	for principal := range values {
+		principals = append(principals, principal)
+	}
+	sort.Slice(principals, func(i, j int) bool {
+		if principals[i].user != principals[j].user {
+			return principals[i].user < principals[j].user
+		}
+		return principals[i].clientID < principals[j].clientID
+	})
+
+	if maxPrincipals := kafkaArgs.MaxQuotaPrincipals; maxPrincipals > 0 && len(principals) > maxPrincipals {
+		log.Warn("Broker '%s' has quota metrics for %d principals, more than the max_quota_principals limit of %d. Only reporting the first %d", b.Host, len(principals), maxPrincipals, maxPrincipals)
+		principals = principals[:maxPrincipals]
+	}
+
+	for _, principal := range principals {
+		sample := b.Entity.NewMetricSet(kafkaArgs.BrokerSampleName,
+			metric.Attribute{Key: "displayName", Value: b.Entity.Metadata.Name},
+			metric.Attribute{Key: "entityName", Value: "broker:" + b.Entity.Metadata.Name},
+			metric.Attribute{Key: "user", Value: principal.user},
+			metric.Attribute{Key: "clientID", Value: principal.clientID},
+		)
+
+		for metricName, value := range values[principal] {
+			if err := sample.SetMetric(metricName, value, metric.GAUGE); err != nil {
+				collecterrors.Error("Unable to set quota metric %s for Broker %s: %s", metricName, b.Entity.Metadata.Name, err.Error())
+			}
+		}
+	}
+}
+
+// parseQuotaKey returns the principal and the attr=<attribute> property of a quota MBean attribute key such as
+// kafka.server:type=Fetch,user=alice,client-id=app,attr=throttle-time. Keys without a user or client-id, such
+// as the delay queue MBean of each type, aren't quota MBeans.
+func parseQuotaKey(key string) (quotaPrincipal, string, bool) {
+	var principal quotaPrincipal
+	var attr string
+
+	domainEnd := strings.Index(key, ":")
+	for _, property := range strings.Split(key[domainEnd+1:], ",") {
+		switch {
+		case strings.HasPrefix(property, "user="):
+			principal.user = strings.TrimPrefix(property, "user=")
+		case strings.HasPrefix(property, "client-id="):
+			principal.clientID = strings.TrimPrefix(property, "client-id=")
+		case strings.HasPrefix(property, "attr="):
+			attr = property
+		}
+	}
+
+	return principal, attr, principal != quotaPrincipal{} && attr != ""
+}
@@ -0,0 +1,85 @@
+package brokercollect
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatherQuotaMetrics(t *testing.T) {
+	testutils.SetupJmxTesting()
+	kafkaArgs := testutils.SetupTestArgs()
+
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		switch {
+		case strings.HasPrefix(query, "kafka.server:type=Fetch,"):
+			return map[string]interface{}{
+				"kafka.server:type=Fetch,user=alice,client-id=app,attr=throttle-time": float64(5),
+				"kafka.server:type=Fetch,user=alice,client-id=app,attr=byte-rate":     float64(1024),
+				"kafka.server:type=Fetch,client-id=legacy,attr=throttle-time":         float64(0),
+				"kafka.server:type=Fetch,attr=queue-size":                             float64(3),
+			}, nil
+		case strings.HasPrefix(query, "kafka.server:type=Request,"):
+			return map[string]interface{}{
+				"kafka.server:type=Request,user=alice,client-id=app,attr=request-time": float64(2.5),
+			}, nil
+		}
+		return map[string]interface{}{}, nil
+	}
+
+	i, _ := integration.New("test", "1.0.0")
+	testBroker := &broker{Host: "brokerHost", ID: 0}
+	testBroker.Entity, _ = i.Entity(testBroker.Host, "ka-broker")
+
+	gatherQuotaMetrics(kafkaArgs, testBroker)
+
+	assert.Equal(t, 2, len(testBroker.Entity.Metrics))
+	legacy := testBroker.Entity.Metrics[0].Metrics
+	assert.Equal(t, "", legacy["user"])
+	assert.Equal(t, "legacy", legacy["clientID"])
+	assert.Equal(t, float64(0), legacy["kafka.quota.fetchThrottleTimeMs"])
+
+	alice := testBroker.Entity.Metrics[1].Metrics
+	assert.Equal(t, "alice", alice["user"])
+	assert.Equal(t, "app", alice["clientID"])
+	assert.Equal(t, float64(5), alice["kafka.quota.fetchThrottleTimeMs"])
+	assert.Equal(t, float64(1024), alice["kafka.quota.fetchBytesPerSecond"])
+	assert.Equal(t, 2.5, alice["kafka.quota.requestTimePercent"])
+	assert.Nil(t, alice["kafka.quota.produceThrottleTimeMs"])
+}
+
+func TestGatherQuotaMetrics_MaxPrincipals(t *testing.T) {
+	testutils.SetupJmxTesting()
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.MaxQuotaPrincipals = 1
+
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"kafka.server:type=Produce,user=bob,attr=throttle-time":   float64(1),
+			"kafka.server:type=Produce,user=alice,attr=throttle-time": float64(2),
+		}, nil
+	}
+
+	i, _ := integration.New("test", "1.0.0")
+	testBroker := &broker{Host: "brokerHost", ID: 0}
+	testBroker.Entity, _ = i.Entity(testBroker.Host, "ka-broker")
+
+	gatherQuotaMetrics(kafkaArgs, testBroker)
+
+	assert.Equal(t, 1, len(testBroker.Entity.Metrics))
+	assert.Equal(t, "alice", testBroker.Entity.Metrics[0].Metrics["user"])
+}
+
+func TestParseQuotaKey(t *testing.T) {
+	principal, attr, ok := parseQuotaKey("kafka.server:type=Produce,user=alice,client-id=app,attr=byte-rate")
+	assert.True(t, ok)
+	assert.Equal(t, quotaPrincipal{user: "alice", clientID: "app"}, principal)
+	assert.Equal(t, "attr=byte-rate", attr)
+
+	_, _, ok = parseQuotaKey("kafka.server:type=Produce,attr=queue-size")
+	assert.False(t, ok)
+}
@@ -4,20 +4,32 @@ import (
 	"fmt"
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
-	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
 	"github.com/newrelic/nri-kafka/src/jmxwrapper"
 	"github.com/newrelic/nri-kafka/src/metrics"
 )
 
-func gatherTopicSizes(b *broker, topicSampleLookup map[string]*metric.Set) {
+// topicSizeSamples returns the samples of the topics whose size is collected
+func topicSizeSamples(kafkaArgs *args.KafkaArguments, topicSampleLookup map[string]*metric.Set) map[string]*metric.Set {
+	samples := make(map[string]*metric.Set)
+	for topicName, sample := range topicSampleLookup {
+		if kafkaArgs.TopicCollectsSize(topicName) {
+			samples[topicName] = sample
+		}
+	}
+
+	return samples
+}
+
+func gatherTopicSizes(kafkaArgs *args.KafkaArguments, b *broker, topicSampleLookup map[string]*metric.Set) {
 	for topicName, sample := range topicSampleLookup {
 		beanModifier := metrics.ApplyTopicName(topicName)
 
 		beanName := beanModifier(metrics.TopicSizeMetricDef.MBean)
-		results, err := jmxwrapper.JMXQuery(beanName, args.GlobalArgs.Timeout)
+		results, err := jmxwrapper.JMXQuery(beanName, kafkaArgs.Timeout)
 		if err != nil {
-			log.Error("Broker '%s' failed to make JMX Query: %s", b.Host, err.Error())
+			collecterrors.Error("Broker '%s' failed to make JMX Query: %s", b.Host, err.Error())
 			continue
 		} else if len(results) == 0 {
 			continue
@@ -25,12 +37,12 @@ func gatherTopicSizes(b *broker, topicSampleLookup map[string]*metric.Set) {
 
 		topicSize, err := aggregateTopicSize(results)
 		if err != nil {
-			log.Error("Unable to calculate size for Topic %s: %s", topicName, err.Error())
+			collecterrors.Error("Unable to calculate size for Topic %s: %s", topicName, err.Error())
 			continue
 		}
 
 		if err := sample.SetMetric("topic.diskSize", topicSize, metric.GAUGE); err != nil {
-			log.Error("Unable to collect topic size for Topic %s on Broker %s: %s", topicName, b.Entity.Metadata.Name, err.Error())
+			collecterrors.Error("Unable to collect topic size for Topic %s on Broker %s: %s", topicName, b.Entity.Metadata.Name, err.Error())
 		}
 	}
 	return
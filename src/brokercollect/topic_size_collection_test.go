@@ -13,7 +13,7 @@ import (
 
 func TestGatherTopicSize_Single(t *testing.T) {
 	testutils.SetupJmxTesting()
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
@@ -50,7 +50,7 @@ func TestGatherTopicSize_Single(t *testing.T) {
 		Entity:  e,
 	}
 
-	gatherTopicSizes(broker, collectedTopics)
+	gatherTopicSizes(kafkaArgs, broker, collectedTopics)
 
 	expected := map[string]interface{}{
 		"topic.diskSize": float64(10),
@@ -68,7 +68,7 @@ func TestGatherTopicSize_Single(t *testing.T) {
 
 func TestGatherTopicSize_QueryError(t *testing.T) {
 	testutils.SetupJmxTesting()
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
@@ -98,7 +98,7 @@ func TestGatherTopicSize_QueryError(t *testing.T) {
 		Entity:  e,
 	}
 
-	gatherTopicSizes(broker, collectedTopics)
+	gatherTopicSizes(kafkaArgs, broker, collectedTopics)
 
 	if _, ok := broker.Entity.Metrics[0].Metrics["topic.diskSize"]; ok {
 		t.Error("topic.diskSize metric set was created")
@@ -107,7 +107,7 @@ func TestGatherTopicSize_QueryError(t *testing.T) {
 
 func TestGatherTopicSize_QueryBlank(t *testing.T) {
 	testutils.SetupJmxTesting()
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
@@ -139,7 +139,7 @@ func TestGatherTopicSize_QueryBlank(t *testing.T) {
 		Entity:  e,
 	}
 
-	gatherTopicSizes(broker, collectedTopics)
+	gatherTopicSizes(kafkaArgs, broker, collectedTopics)
 
 	if _, ok := broker.Entity.Metrics[0].Metrics["topic.diskSize"]; ok {
 		t.Error("topic.diskSize metric set was created")
@@ -148,7 +148,7 @@ func TestGatherTopicSize_QueryBlank(t *testing.T) {
 
 func TestGatherTopicSize_AggregateError(t *testing.T) {
 	testutils.SetupJmxTesting()
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
@@ -183,7 +183,7 @@ func TestGatherTopicSize_AggregateError(t *testing.T) {
 		Entity:  e,
 	}
 
-	gatherTopicSizes(broker, collectedTopics)
+	gatherTopicSizes(kafkaArgs, broker, collectedTopics)
 
 	if _, ok := broker.Entity.Metrics[0].Metrics["topic.diskSize"]; ok {
 		t.Error("topic.diskSize metric set was created")
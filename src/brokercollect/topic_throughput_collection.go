@@ -0,0 +1,124 @@
+package brokercollect
+
+import (
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/metrics"
+)
+
+// TopicThroughput accumulates the per-broker throughput rates of each topic so they
+// can be summed into a cluster-wide rate on the Topic entity.
+// Rates are stored per broker ID, so a broker that is collected more than once
+// (for example through several listeners) only contributes its rate once.
+type TopicThroughput struct {
+	lock  sync.Mutex
+	rates map[string]map[int]map[string]float64
+}
+
+// NewTopicThroughput creates an empty TopicThroughput
+func NewTopicThroughput() *TopicThroughput {
+	return &TopicThroughput{
+		rates: make(map[string]map[int]map[string]float64),
+	}
+}
+
+// set records the value of metricName for topic as collected from brokerID
+func (t *TopicThroughput) set(topic string, brokerID int, metricName string, value float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.rates[topic]; !ok {
+		t.rates[topic] = make(map[int]map[string]float64)
+	}
+	if _, ok := t.rates[topic][brokerID]; !ok {
+		t.rates[topic][brokerID] = make(map[string]float64)
+	}
+	t.rates[topic][brokerID][metricName] = value
+}
+
+// totals returns the sum across brokers of each metric collected for topic
+func (t *TopicThroughput) totals(topic string) map[string]float64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	totals := make(map[string]float64)
+	for _, brokerRates := range t.rates[topic] {
+		for metricName, value := range brokerRates {
+			totals[metricName] += value
+		}
+	}
+
+	return totals
+}
+
+// Populate adds the summed throughput of each topic to the KafkaTopicSample of its Topic entity.
+// It must only be called after all broker workers have finished.
+func (t *TopicThroughput) Populate(kafkaArgs *args.KafkaArguments, i *integration.Integration) {
+	t.lock.Lock()
+	topics := make([]string, 0, len(t.rates))
+	for topic := range t.rates {
+		topics = append(topics, topic)
+	}
+	t.lock.Unlock()
+
+	for _, topicName := range topics {
+		clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+		topicEntity, err := i.Entity(topicName, "ka-topic", clusterIDAttrs...)
+		if err != nil {
+			collecterrors.Error("Unable to create an entity for topic %s", topicName)
+			continue
+		}
+
+		sample := topicSample(kafkaArgs, topicEntity)
+		for metricName, value := range t.totals(topicName) {
+			if err := sample.SetMetric(metricName, value, metric.GAUGE); err != nil {
+				collecterrors.Error("Unable to set throughput metric %s for Topic %s: %s", metricName, topicName, err.Error())
+			}
+		}
+	}
+}
+
+// topicSample returns the KafkaTopicSample already created by the topic collection,
+// or a new one if the topic was not collected
+func topicSample(kafkaArgs *args.KafkaArguments, topicEntity *integration.Entity) *metric.Set {
+	for _, sample := range topicEntity.Metrics {
+		if sample.Metrics["event_type"] == kafkaArgs.TopicSampleName {
+			return sample
+		}
+	}
+
+	return topicEntity.NewMetricSet(kafkaArgs.TopicSampleName, append([]metric.Attribute{
+		{Key: "displayName", Value: topicEntity.Metadata.Name},
+		{Key: "entityName", Value: "topic:" + topicEntity.Metadata.Name},
+	}, kafkaArgs.TopicAttributes(topicEntity.Metadata.Name)...)...)
+}
+
+func gatherTopicThroughput(kafkaArgs *args.KafkaArguments, b *broker, collectedTopics []string, throughput *TopicThroughput) {
+	for _, topicName := range collectedTopics {
+		beanModifier := metrics.ApplyTopicName(topicName)
+
+		for _, metricSet := range metrics.TopicThroughputMetricDefs {
+			beanName := beanModifier(metricSet.MBean)
+			results, err := jmxwrapper.JMXQuery(beanName, kafkaArgs.Timeout)
+			if err != nil {
+				collecterrors.Error("Broker '%s' failed to make JMX Query: %s", b.Host, err.Error())
+				continue
+			}
+
+			for _, metricDef := range metricSet.MetricDefs {
+				mBeanKey := beanModifier(metricSet.MetricPrefix + metricDef.JMXAttr)
+				value, ok := results[mBeanKey].(float64)
+				if !ok {
+					continue
+				}
+
+				throughput.set(topicName, b.ID, metricDef.Name, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package brokercollect
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatherTopicThroughput(t *testing.T) {
+	testutils.SetupJmxTesting()
+	kafkaArgs := testutils.SetupTestArgs()
+
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec,topic=topic,attr=OneMinuteRate": float64(10),
+			"kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec,topic=topic,attr=OneMinuteRate":    float64(100),
+			"kafka.server:type=BrokerTopicMetrics,name=BytesOutPerSec,topic=topic,attr=OneMinuteRate":   float64(200),
+		}, nil
+	}
+
+	throughput := NewTopicThroughput()
+	gatherTopicThroughput(kafkaArgs, &broker{ID: 0, Host: "broker0"}, []string{"topic"}, throughput)
+	gatherTopicThroughput(kafkaArgs, &broker{ID: 1, Host: "broker1"}, []string{"topic"}, throughput)
+	// Collecting the same broker again must not double count it
+	gatherTopicThroughput(kafkaArgs, &broker{ID: 1, Host: "broker1"}, []string{"topic"}, throughput)
+
+	expected := map[string]float64{
+		"kafka.topic.messagesInPerSec": 20,
+		"kafka.topic.bytesInPerSec":    200,
+		"kafka.topic.bytesOutPerSec":   400,
+	}
+	assert.Equal(t, expected, throughput.totals("topic"))
+}
+
+func TestTopicThroughputPopulate(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	i, _ := integration.New("test", "1.0.0")
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "")
+	topicEntity, _ := i.Entity("topic", "ka-topic", clusterIDAttr)
+	existing := topicSample(kafkaArgs, topicEntity)
+
+	throughput := NewTopicThroughput()
+	throughput.set("topic", 0, "kafka.topic.messagesInPerSec", 3)
+	throughput.set("topic", 1, "kafka.topic.messagesInPerSec", 4)
+	throughput.Populate(kafkaArgs, i)
+
+	assert.Equal(t, 1, len(topicEntity.Metrics))
+	assert.Equal(t, float64(7), existing.Metrics["kafka.topic.messagesInPerSec"])
+}
@@ -0,0 +1,308 @@
+package brokercollect
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// transactionStateTopic is the internal topic the transaction coordinators store the state of each transactional ID in
+const transactionStateTopic = "__transaction_state"
+
+// Transaction statuses as stored in the __transaction_state topic
+const (
+	txnEmpty int8 = iota
+	txnOngoing
+	txnPrepareCommit
+	txnPrepareAbort
+	txnCompleteCommit
+	txnCompleteAbort
+	txnDead
+	txnPrepareEpochFence
+)
+
+// transactionState is the decoded state of a single transactional ID
+type transactionState struct {
+	ProducerID int64
+	TimeoutMs  int32
+	Status     int8
+	// StartTimestamp is the time in milliseconds the current transaction started, -1 if there is none
+	StartTimestamp int64
+}
+
+// active returns true if the transaction has started and is not yet complete
+func (t *transactionState) active() bool {
+	switch t.Status {
+	case txnOngoing, txnPrepareCommit, txnPrepareAbort, txnPrepareEpochFence:
+		return true
+	default:
+		return false
+	}
+}
+
+// transactionLog holds the latest state of each transactional ID
+type transactionLog map[string]*transactionState
+
+// apply updates the log with a single record from the __transaction_state topic.
+// A nil value is a tombstone, which means the transactional ID expired.
+func (l transactionLog) apply(transactionalID string, value []byte) error {
+	if value == nil {
+		delete(l, transactionalID)
+		return nil
+	}
+
+	state, err := decodeTransactionStateValue(value)
+	if err != nil {
+		return err
+	}
+	l[transactionalID] = state
+
+	return nil
+}
+
+// transactionMetrics summarizes the transaction log at the time now
+type transactionMetrics struct {
+	transactionalIDs  int
+	active            int
+	hanging           int
+	oldestActiveAgeMs int64
+}
+
+// summarize counts the active transactions and those open for longer than their timeout. The coordinator aborts
+// transactions that time out, so a transaction older than its timeout is one the coordinator failed to abort.
+func (l transactionLog) summarize(now time.Time) transactionMetrics {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	var result transactionMetrics
+	for _, state := range l {
+		if state.Status == txnDead {
+			continue
+		}
+		result.transactionalIDs++
+
+		if !state.active() {
+			continue
+		}
+		result.active++
+
+		if state.StartTimestamp < 0 {
+			continue
+		}
+		age := nowMs - state.StartTimestamp
+		if age > int64(state.TimeoutMs) {
+			result.hanging++
+		}
+		if age > result.oldestActiveAgeMs {
+			result.oldestActiveAgeMs = age
+		}
+	}
+
+	return result
+}
+
+// CollectTransactionMetrics reports the state of the transaction coordinators, read from the __transaction_state
+// topic up to its current high water mark, on the cluster entity. Clusters with brokers older than 0.11, or on
+// which no transactional producer has ever run, don't have the topic, so nothing is reported for them.
+func CollectTransactionMetrics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) error {
+	client, err := zkConn.CreateClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Debug("Error closing client connection: %s", err.Error())
+		}
+	}()
+
+	consumer, err := zkConn.CreateConsumer()
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %s", err)
+	}
+	defer func() {
+		if err := consumer.Close(); err != nil {
+			log.Debug("Error closing consumer connection: %s", err.Error())
+		}
+	}()
+
+	transactions, err := readTransactionStateTopic(kafkaArgs, consumer, client)
+	if err == sarama.ErrUnknownTopicOrPartition {
+		log.Info("Topic %s doesn't exist on cluster '%s', the brokers don't support transactions or none have been used. Not collecting transaction metrics",
+			transactionStateTopic, kafkaArgs.ClusterName)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return setTransactionMetrics(kafkaArgs, transactions.summarize(time.Now()), kafkaIntegration)
+}
+
+// setTransactionMetrics reports the transaction metrics in a KafkaTransactionSample on the cluster entity
+func setTransactionMetrics(kafkaArgs *args.KafkaArguments, summary transactionMetrics, kafkaIntegration *integration.Integration) error {
+	clusterEntity, err := kafkaIntegration.Entity(kafkaArgs.ClusterName, "ka-cluster", kafkaArgs.NamespaceIDAttributes()...)
+	if err != nil {
+		return err
+	}
+
+	metricSet := clusterEntity.NewMetricSet("KafkaTransactionSample",
+		metric.Attribute{Key: "displayName", Value: clusterEntity.Metadata.Name},
+		metric.Attribute{Key: "entityName", Value: "cluster:" + clusterEntity.Metadata.Name},
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+	)
+
+	for name, value := range map[string]int64{
+		"transactions.transactionalIds":  int64(summary.transactionalIDs),
+		"transactions.active":            int64(summary.active),
+		"transactions.hanging":           int64(summary.hanging),
+		"transactions.oldestActiveAgeMs": summary.oldestActiveAgeMs,
+	} {
+		if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set metric %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// readTransactionStateTopic reads every partition of the __transaction_state topic from the oldest retained
+// offset up to the high water mark at the time of the call, so the latest state of each transactional ID wins
+func readTransactionStateTopic(kafkaArgs *args.KafkaArguments, consumer sarama.Consumer, client connection.Client) (transactionLog, error) {
+	partitions, err := consumer.Partitions(transactionStateTopic)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	transactions := make(transactionLog)
+	for _, partition := range partitions {
+		wg.Add(1)
+		go func(partition int32) {
+			defer wg.Done()
+
+			partitionTransactions, err := readTransactionStatePartition(kafkaArgs, consumer, client, partition)
+			if err != nil {
+				log.Warn("Failed to read partition %d of %s: %s", partition, transactionStateTopic, err)
+				return
+			}
+
+			// Each transactional ID is stored in a single partition, so merging can't conflict
+			lock.Lock()
+			for id, state := range partitionTransactions {
+				transactions[id] = state
+			}
+			lock.Unlock()
+		}(partition)
+	}
+	wg.Wait()
+
+	return transactions, nil
+}
+
+func readTransactionStatePartition(kafkaArgs *args.KafkaArguments, consumer sarama.Consumer, client connection.Client, partition int32) (transactionLog, error) {
+	transactions := make(transactionLog)
+
+	timeout := time.Duration(kafkaArgs.Timeout) * time.Millisecond
+	err := connection.ReadPartition(consumer, client, transactionStateTopic, partition, timeout, func(msg *sarama.ConsumerMessage) {
+		transactionalID, err := decodeTransactionStateKey(msg.Key)
+		if err == nil {
+			err = transactions.apply(transactionalID, msg.Value)
+		}
+		if err != nil {
+			log.Debug("Skipping record at offset %d of %s partition %d: %s", msg.Offset, transactionStateTopic, partition, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// decodeTransactionStateKey decodes the transactional ID from the key of a record in the __transaction_state topic
+func decodeTransactionStateKey(key []byte) (string, error) {
+	if len(key) < 2 {
+		return "", errors.New("key too short")
+	}
+
+	if version := int16(binary.BigEndian.Uint16(key)); version != 0 {
+		return "", fmt.Errorf("unknown transaction log key version %d", version)
+	}
+
+	transactionalID, _, err := readString(key[2:])
+	return transactionalID, err
+}
+
+// decodeTransactionStateValue decodes version 0 of the value of a record in the __transaction_state topic.
+// Newer versions use the flexible encoding and are reported as unknown.
+func decodeTransactionStateValue(value []byte) (*transactionState, error) {
+	if len(value) < 19 {
+		return nil, errors.New("value too short")
+	}
+
+	if version := int16(binary.BigEndian.Uint16(value)); version != 0 {
+		return nil, fmt.Errorf("unknown transaction log value version %d", version)
+	}
+
+	state := &transactionState{
+		ProducerID: int64(binary.BigEndian.Uint64(value[2:])),
+		// The producer epoch at value[10:12] isn't needed
+		TimeoutMs: int32(binary.BigEndian.Uint32(value[12:])),
+		Status:    int8(value[16]),
+	}
+
+	// Skip the partitions in the transaction, an array of topics each with an array of partition IDs
+	rest := value[17:]
+	if len(rest) < 4 {
+		return nil, errors.New("value missing partitions")
+	}
+	topics := int32(binary.BigEndian.Uint32(rest))
+	rest = rest[4:]
+	for i := int32(0); i < topics; i++ {
+		var err error
+		if _, rest, err = readString(rest); err != nil {
+			return nil, err
+		}
+		if len(rest) < 4 {
+			return nil, errors.New("value missing partition IDs")
+		}
+		partitionIDs := int(binary.BigEndian.Uint32(rest))
+		if len(rest) < 4+4*partitionIDs {
+			return nil, errors.New("value partition IDs shorter than their length")
+		}
+		rest = rest[4+4*partitionIDs:]
+	}
+
+	// The last update timestamp is followed by the start timestamp
+	if len(rest) < 16 {
+		return nil, errors.New("value missing timestamps")
+	}
+	state.StartTimestamp = int64(binary.BigEndian.Uint64(rest[8:]))
+
+	return state, nil
+}
+
+// readString reads a Kafka protocol string (int16 length followed by the bytes)
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("string missing length")
+	}
+
+	length := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < length {
+		return "", nil, errors.New("string shorter than its length")
+	}
+
+	return string(data[:length]), data[length:], nil
+}
@@ -0,0 +1,113 @@
+package brokercollect
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func transactionStateKey(version int16, transactionalID string) []byte {
+	key := make([]byte, 2)
+	binary.BigEndian.PutUint16(key, uint16(version))
+	return append(key, encodeString(transactionalID)...)
+}
+
+func transactionStateValue(version int16, status int8, timeoutMs int32, partitions map[string][]int32, startTimestamp int64) []byte {
+	value := make([]byte, 17)
+	binary.BigEndian.PutUint16(value, uint16(version))
+	binary.BigEndian.PutUint64(value[2:], 1000)
+	binary.BigEndian.PutUint16(value[10:], 3)
+	binary.BigEndian.PutUint32(value[12:], uint32(timeoutMs))
+	value[16] = byte(status)
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(partitions)))
+	value = append(value, b...)
+	for topic, ids := range partitions {
+		value = append(value, encodeString(topic)...)
+		binary.BigEndian.PutUint32(b, uint32(len(ids)))
+		value = append(value, b...)
+		for _, id := range ids {
+			binary.BigEndian.PutUint32(b, uint32(id))
+			value = append(value, b...)
+		}
+	}
+
+	timestamps := make([]byte, 16)
+	binary.BigEndian.PutUint64(timestamps, uint64(startTimestamp+1))
+	binary.BigEndian.PutUint64(timestamps[8:], uint64(startTimestamp))
+	return append(value, timestamps...)
+}
+
+func Test_decodeTransactionStateKey(t *testing.T) {
+	transactionalID, err := decodeTransactionStateKey(transactionStateKey(0, "producer-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "producer-1", transactionalID)
+
+	_, err = decodeTransactionStateKey(transactionStateKey(1, "producer-1"))
+	assert.NotNil(t, err)
+
+	_, err = decodeTransactionStateKey([]byte{0, 0, 0, 10, 'a'})
+	assert.NotNil(t, err)
+}
+
+func Test_decodeTransactionStateValue(t *testing.T) {
+	value := transactionStateValue(0, txnOngoing, 60000, map[string][]int32{"topic1": {0, 1}}, 1500)
+	state, err := decodeTransactionStateValue(value)
+	assert.Nil(t, err)
+	assert.Equal(t, &transactionState{ProducerID: 1000, TimeoutMs: 60000, Status: txnOngoing, StartTimestamp: 1500}, state)
+
+	_, err = decodeTransactionStateValue(transactionStateValue(1, txnOngoing, 60000, nil, 1500))
+	assert.NotNil(t, err)
+
+	_, err = decodeTransactionStateValue(value[:len(value)-4])
+	assert.NotNil(t, err)
+}
+
+func Test_transactionLog_summarize(t *testing.T) {
+	now := time.Unix(100, 0)
+	nowMs := int64(100000)
+
+	transactions := make(transactionLog)
+	for id, value := range map[string][]byte{
+		"ongoing":  transactionStateValue(0, txnOngoing, 60000, nil, nowMs-1000),
+		"hanging":  transactionStateValue(0, txnPrepareCommit, 60000, nil, nowMs-90000),
+		"complete": transactionStateValue(0, txnCompleteCommit, 60000, nil, -1),
+		"dead":     transactionStateValue(0, txnDead, 60000, nil, -1),
+		"expired":  transactionStateValue(0, txnOngoing, 60000, nil, nowMs-1000),
+	} {
+		assert.Nil(t, transactions.apply(id, value))
+	}
+	// A tombstone removes the transactional ID
+	assert.Nil(t, transactions.apply("expired", nil))
+
+	expected := transactionMetrics{transactionalIDs: 3, active: 2, hanging: 1, oldestActiveAgeMs: 90000}
+	assert.Equal(t, expected, transactions.summarize(now))
+}
+
+func TestSetTransactionMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "1.0.0")
+
+	err := setTransactionMetrics(kafkaArgs, transactionMetrics{transactionalIDs: 3, active: 2, hanging: 1, oldestActiveAgeMs: 90000}, i)
+	assert.Nil(t, err)
+
+	clusterEntity, _ := i.Entity("testcluster", "ka-cluster")
+	metrics := clusterEntity.Metrics[0].Metrics
+	assert.Equal(t, "KafkaTransactionSample", metrics["event_type"])
+	assert.Equal(t, "testcluster", metrics["clusterName"])
+	assert.Equal(t, float64(3), metrics["transactions.transactionalIds"])
+	assert.Equal(t, float64(2), metrics["transactions.active"])
+	assert.Equal(t, float64(1), metrics["transactions.hanging"])
+	assert.Equal(t, float64(90000), metrics["transactions.oldestActiveAgeMs"])
+}
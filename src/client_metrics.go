@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+)
+
+// setClientMetrics reports the metrics of the Kafka clients of the collection in a KafkaIntegrationSample on the
+// cluster entity, if collect_client_metrics is set
+func setClientMetrics(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) error {
+	clientMetrics, ok := connection.GetClientMetrics(kafkaArgs.StateKeyPrefix())
+	if !ok {
+		return nil
+	}
+
+	clusterEntity, err := kafkaIntegration.Entity(kafkaArgs.ClusterName, "ka-cluster", kafkaArgs.NamespaceIDAttributes()...)
+	if err != nil {
+		return err
+	}
+
+	metricSet := clusterEntity.NewMetricSet("KafkaIntegrationSample",
+		metric.Attribute{Key: "displayName", Value: clusterEntity.Metadata.Name},
+		metric.Attribute{Key: "entityName", Value: "cluster:" + clusterEntity.Metadata.Name},
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+	)
+
+	values := map[string]interface{}{
+		"kafka.client.connectionsOpened":   clientMetrics.ConnectionsOpened,
+		"kafka.client.requests":            clientMetrics.Requests,
+		"kafka.client.requestErrors":       clientMetrics.RequestErrors,
+		"kafka.client.requestLatencyMsAvg": clientMetrics.RequestLatencyMsAvg,
+		"kafka.client.requestLatencyMsMax": clientMetrics.RequestLatencyMsMax,
+	}
+	for name, value := range values {
+		if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+			log.Error("Failed to set client metric %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_setClientMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connection.ResetClientMetrics(kafkaArgs.StateKeyPrefix(), false)
+	assert.Nil(t, setClientMetrics(kafkaArgs, i))
+	assert.Empty(t, i.Entities)
+
+	connection.ResetClientMetrics(kafkaArgs.StateKeyPrefix(), true)
+	defer connection.ResetClientMetrics(kafkaArgs.StateKeyPrefix(), false)
+	assert.Nil(t, setClientMetrics(kafkaArgs, i))
+
+	clusterEntity, _ := i.Entity("testcluster", "ka-cluster")
+	assert.Equal(t, 1, len(clusterEntity.Metrics))
+	metrics := clusterEntity.Metrics[0].Metrics
+	assert.Equal(t, "KafkaIntegrationSample", metrics["event_type"])
+	assert.Equal(t, "testcluster", metrics["clusterName"])
+	for _, name := range []string{"kafka.client.connectionsOpened", "kafka.client.requests", "kafka.client.requestErrors", "kafka.client.requestLatencyMsAvg", "kafka.client.requestLatencyMsMax"} {
+		assert.Equal(t, float64(0), metrics[name], name)
+	}
+}
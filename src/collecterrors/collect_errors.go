@@ -0,0 +1,50 @@
+// Package collecterrors records the errors that collectors log and continue past, so fail_on_error can fail
+// the integration once whatever was collected has been published.
+package collecterrors
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+var (
+	lock  sync.Mutex
+	count int
+	first string
+)
+
+// Error logs the error like log.Error and records it for the current collection
+func Error(format string, args ...interface{}) {
+	log.Error(format, args...)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if count == 0 {
+		first = fmt.Sprintf(format, args...)
+	}
+	count++
+}
+
+// Reset forgets the errors recorded so far. Called before each collection of the clusters, which record their
+// errors together.
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	count, first = 0, ""
+}
+
+// Err returns an error summarizing the errors recorded since the last Reset, or nil if there are none
+func Err() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if count == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d collection errors, the first being: %s", count, first)
+}
@@ -0,0 +1,22 @@
+package collecterrors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErr(t *testing.T) {
+	Reset()
+	assert.Nil(t, Err())
+
+	Error("Unable to collect broker %d: %s", 1, "connection refused")
+	Error("Unable to collect topic %s", "topic1")
+
+	err := Err()
+	assert.NotNil(t, err)
+	assert.Equal(t, "2 collection errors, the first being: Unable to collect broker 1: connection refused", err.Error())
+
+	Reset()
+	assert.Nil(t, Err())
+}
@@ -0,0 +1,110 @@
+package connection
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+// Names of the metrics sarama registers in the MetricRegistry of its config
+const (
+	saramaRequestRate    = "request-rate"
+	saramaResponseRate   = "response-rate"
+	saramaRequestLatency = "request-latency-in-ms"
+)
+
+var (
+	clientMetricsLock sync.Mutex
+	// clientRegistries are the registries of the clusters with client metrics enabled, by cluster
+	clientRegistries = make(map[string]*countingRegistry)
+)
+
+// countingRegistry is a metrics registry that also counts the broker connections opened with it. Sarama gets or
+// registers the request-rate meter every time a broker connection is opened successfully.
+type countingRegistry struct {
+	metrics.Registry
+	connections metrics.Counter
+}
+
+// GetOrRegister wraps metrics.Registry.GetOrRegister, counting the broker connections opened
+func (r *countingRegistry) GetOrRegister(name string, metric interface{}) interface{} {
+	if name == saramaRequestRate {
+		r.connections.Inc(1)
+	}
+
+	return r.Registry.GetOrRegister(name, metric)
+}
+
+// ClientMetrics are the metrics of the sarama clients and brokers of a collection
+type ClientMetrics struct {
+	ConnectionsOpened   int64
+	Requests            int64
+	RequestErrors       int64
+	RequestLatencyMsAvg float64
+	RequestLatencyMsMax int64
+}
+
+// ResetClientMetrics discards the client metrics of cluster recorded so far and, if enabled, starts recording
+// them in the configs NewConfig returns for cluster. Called before collecting each cluster.
+func ResetClientMetrics(cluster string, enabled bool) {
+	clientMetricsLock.Lock()
+	defer clientMetricsLock.Unlock()
+
+	if clientRegistry, ok := clientRegistries[cluster]; ok {
+		// Stops the goroutines of the meters
+		clientRegistry.UnregisterAll()
+		delete(clientRegistries, cluster)
+	}
+
+	if enabled {
+		clientRegistries[cluster] = &countingRegistry{Registry: metrics.NewRegistry(), connections: metrics.NewCounter()}
+	}
+}
+
+// NewConfig returns sarama.NewConfig() for the clients of cluster, recording the client metrics in it if
+// they're enabled
+func NewConfig(cluster string) *sarama.Config {
+	config := sarama.NewConfig()
+
+	clientMetricsLock.Lock()
+	defer clientMetricsLock.Unlock()
+
+	if clientRegistry, ok := clientRegistries[cluster]; ok {
+		config.MetricRegistry = clientRegistry
+	}
+
+	return config
+}
+
+// GetClientMetrics returns the client metrics of cluster recorded since the last ResetClientMetrics, or false
+// if they aren't enabled. Requests that got no response, because sending failed, the response timed out or the
+// connection was closed, are counted as request errors.
+func GetClientMetrics(cluster string) (ClientMetrics, bool) {
+	clientMetricsLock.Lock()
+	defer clientMetricsLock.Unlock()
+
+	clientRegistry, ok := clientRegistries[cluster]
+	if !ok {
+		return ClientMetrics{}, false
+	}
+
+	clientMetrics := ClientMetrics{ConnectionsOpened: clientRegistry.connections.Count()}
+	var responses int64
+	if requests, ok := clientRegistry.Get(saramaRequestRate).(metrics.Meter); ok {
+		clientMetrics.Requests = requests.Count()
+	}
+	if responseMeter, ok := clientRegistry.Get(saramaResponseRate).(metrics.Meter); ok {
+		responses = responseMeter.Count()
+	}
+	if clientMetrics.Requests > responses {
+		clientMetrics.RequestErrors = clientMetrics.Requests - responses
+	}
+
+	if latency, ok := clientRegistry.Get(saramaRequestLatency).(metrics.Histogram); ok && latency.Count() > 0 {
+		clientMetrics.RequestLatencyMsAvg = latency.Mean()
+		clientMetrics.RequestLatencyMsMax = latency.Max()
+	}
+
+	return clientMetrics, true
+}
@@ -0,0 +1,53 @@
+package connection
+
+import (
+	"testing"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientMetrics(t *testing.T) {
+	ResetClientMetrics("cluster", true)
+	defer ResetClientMetrics("cluster", false)
+
+	// Record what sarama does for two broker connections, three requests and two responses
+	for _, latency := range []int64{10, 30} {
+		config := NewConfig("cluster")
+		metrics.GetOrRegisterMeter(saramaRequestRate, config.MetricRegistry).Mark(1)
+		metrics.GetOrRegisterMeter(saramaResponseRate, config.MetricRegistry).Mark(1)
+		metrics.GetOrRegisterHistogram(saramaRequestLatency, config.MetricRegistry, metrics.NewUniformSample(10)).Update(latency)
+	}
+	metrics.GetOrRegisterMeter(saramaResponseRate, NewConfig("cluster").MetricRegistry).Mark(0)
+	NewConfig("cluster").MetricRegistry.Get(saramaRequestRate).(metrics.Meter).Mark(1)
+
+	clientMetrics, ok := GetClientMetrics("cluster")
+	assert.True(t, ok)
+	assert.Equal(t, ClientMetrics{
+		ConnectionsOpened:   2,
+		Requests:            3,
+		RequestErrors:       1,
+		RequestLatencyMsAvg: 20,
+		RequestLatencyMsMax: 30,
+	}, clientMetrics)
+
+	// Each collection starts over
+	ResetClientMetrics("cluster", true)
+	clientMetrics, _ = GetClientMetrics("cluster")
+	assert.Equal(t, ClientMetrics{}, clientMetrics)
+
+	// The clients of other clusters aren't counted
+	_, ok = GetClientMetrics("other")
+	assert.False(t, ok)
+	_, counting := NewConfig("other").MetricRegistry.(*countingRegistry)
+	assert.False(t, counting)
+}
+
+func TestClientMetrics_Disabled(t *testing.T) {
+	ResetClientMetrics("cluster", false)
+
+	_, ok := GetClientMetrics("cluster")
+	assert.False(t, ok)
+	_, counting := NewConfig("cluster").MetricRegistry.(*countingRegistry)
+	assert.False(t, counting)
+}
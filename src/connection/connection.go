@@ -14,6 +14,8 @@ type Client interface {
 	Leader(string, int32) (Broker, error)
 	Close() error
 	GetOffset(string, int32, int64) (int64, error)
+	RefreshMetadata(...string) error
+	Config() *sarama.Config
 }
 
 // SaramaClient is a wrapper struct for sarama.Client
@@ -26,7 +28,7 @@ func (c SaramaClient) Brokers() []Broker {
 	saramaBrokers := c.Client.Brokers()
 	brokers := make([]Broker, len(saramaBrokers))
 	for i, broker := range saramaBrokers {
-		saramaBrokers[i] = broker
+		brokers[i] = broker
 	}
 
 	return brokers
@@ -44,11 +46,15 @@ func (c SaramaClient) Leader(topic string, partition int32) (Broker, error) {
 
 // Broker is an interface for mocking
 type Broker interface {
+	ID() int32
 	Connected() (bool, error)
 	FetchOffset(*sarama.OffsetFetchRequest) (*sarama.OffsetFetchResponse, error)
 	Fetch(*sarama.FetchRequest) (*sarama.FetchResponse, error)
+	GetAvailableOffsets(*sarama.OffsetRequest) (*sarama.OffsetResponse, error)
+	GetMetadata(*sarama.MetadataRequest) (*sarama.MetadataResponse, error)
 	Open(*sarama.Config) error
 	DescribeGroups(*sarama.DescribeGroupsRequest) (*sarama.DescribeGroupsResponse, error)
 	ListGroups(*sarama.ListGroupsRequest) (*sarama.ListGroupsResponse, error)
+	DescribeLogDirs(*sarama.DescribeLogDirsRequest) (*sarama.DescribeLogDirsResponse, error)
 	Close() error
 }
@@ -47,11 +47,35 @@ func (m MockClient) GetOffset(topic string, partitionID int32, time int64) (int6
 	return args.Get(0).(int64), args.Error(1)
 }
 
+// RefreshMetadata is for implementing sarama.Client
+func (m MockClient) RefreshMetadata(topics ...string) error {
+	args := m.Called(topics)
+	return args.Error(0)
+}
+
+// Partitions is for implementing sarama.Client
+func (m MockClient) Partitions(topic string) ([]int32, error) {
+	args := m.Called(topic)
+	return args.Get(0).([]int32), args.Error(1)
+}
+
+// Config is for implementing sarama.Client
+func (m MockClient) Config() *sarama.Config {
+	args := m.Called()
+	return args.Get(0).(*sarama.Config)
+}
+
 // MockBroker is a mock implementation of the Broker interface
 type MockBroker struct {
 	mock.Mock
 }
 
+// ID is a mocked implementation of the sarama.Broker.ID() method
+func (b MockBroker) ID() int32 {
+	args := b.Called()
+	return args.Get(0).(int32)
+}
+
 // Connected is a mocked implementation of the sarama.Broker.Connected() method
 func (b MockBroker) Connected() (bool, error) {
 	args := b.Called()
@@ -70,6 +94,18 @@ func (b MockBroker) Fetch(request *sarama.FetchRequest) (*sarama.FetchResponse,
 	return args.Get(0).(*sarama.FetchResponse), args.Error(1)
 }
 
+// GetAvailableOffsets is a mocked implementation of the sarama.Broker.GetAvailableOffsets() method
+func (b MockBroker) GetAvailableOffsets(request *sarama.OffsetRequest) (*sarama.OffsetResponse, error) {
+	args := b.Called(request)
+	return args.Get(0).(*sarama.OffsetResponse), args.Error(1)
+}
+
+// GetMetadata is a mocked implementation of the sarama.Broker.GetMetadata() method
+func (b MockBroker) GetMetadata(request *sarama.MetadataRequest) (*sarama.MetadataResponse, error) {
+	args := b.Called(request)
+	return args.Get(0).(*sarama.MetadataResponse), args.Error(1)
+}
+
 // Open is a mocked implementation of the sarama.Broker.Open() method
 func (b MockBroker) Open(config *sarama.Config) error {
 	args := b.Called(config)
@@ -88,6 +124,12 @@ func (b MockBroker) ListGroups(request *sarama.ListGroupsRequest) (*sarama.ListG
 	return args.Get(0).(*sarama.ListGroupsResponse), args.Error(1)
 }
 
+// DescribeLogDirs is a mocked implementation of the sarama.Broker.DescribeLogDirs() method
+func (b MockBroker) DescribeLogDirs(request *sarama.DescribeLogDirsRequest) (*sarama.DescribeLogDirsResponse, error) {
+	args := b.Called(request)
+	return args.Get(0).(*sarama.DescribeLogDirsResponse), args.Error(1)
+}
+
 // Close is a mocked implementation of the sarama.Broker.Close() method
 func (b MockBroker) Close() error {
 	args := b.Called()
@@ -129,6 +171,18 @@ func (c MockClusterAdmin) CreatePartitions(topic string, count int32, assignment
 	return args.Error(0)
 }
 
+// AlterPartitionReassignments is for implementing sarama.ClusterAdmin
+func (c MockClusterAdmin) AlterPartitionReassignments(topic string, assignment [][]int32) error {
+	args := c.Called(topic, assignment)
+	return args.Error(0)
+}
+
+// ListPartitionReassignments is for implementing sarama.ClusterAdmin
+func (c MockClusterAdmin) ListPartitionReassignments(topic string, partitions []int32) (map[string]map[int32]*sarama.PartitionReplicaReassignmentsStatus, error) {
+	args := c.Called(topic, partitions)
+	return args.Get(0).(map[string]map[int32]*sarama.PartitionReplicaReassignmentsStatus), args.Error(1)
+}
+
 // DeleteRecords is for implementing sarama.ClusterAdmin
 func (c MockClusterAdmin) DeleteRecords(topic string, partitionOffsets map[int32]int64) error {
 	args := c.Called(topic, partitionOffsets)
@@ -195,6 +249,30 @@ func (c MockClusterAdmin) DescribeCluster() (brokers []*sarama.Broker, controlle
 	return args.Get(0).([]*sarama.Broker), args.Get(1).(int32), args.Error(2)
 }
 
+// DescribeLogDirs is for implementing sarama.ClusterAdmin
+func (c MockClusterAdmin) DescribeLogDirs(brokers []int32) (map[int32][]sarama.DescribeLogDirsResponseDirMetadata, error) {
+	args := c.Called(brokers)
+	return args.Get(0).(map[int32][]sarama.DescribeLogDirsResponseDirMetadata), args.Error(1)
+}
+
+// DescribeUserScramCredentials is for implementing sarama.ClusterAdmin
+func (c MockClusterAdmin) DescribeUserScramCredentials(users []string) ([]*sarama.DescribeUserScramCredentialsResult, error) {
+	args := c.Called(users)
+	return args.Get(0).([]*sarama.DescribeUserScramCredentialsResult), args.Error(1)
+}
+
+// DeleteUserScramCredentials is for implementing sarama.ClusterAdmin
+func (c MockClusterAdmin) DeleteUserScramCredentials(delete []sarama.AlterUserScramCredentialsDelete) ([]*sarama.AlterUserScramCredentialsResult, error) {
+	args := c.Called(delete)
+	return args.Get(0).([]*sarama.AlterUserScramCredentialsResult), args.Error(1)
+}
+
+// UpsertUserScramCredentials is for implementing sarama.ClusterAdmin
+func (c MockClusterAdmin) UpsertUserScramCredentials(upsert []sarama.AlterUserScramCredentialsUpsert) ([]*sarama.AlterUserScramCredentialsResult, error) {
+	args := c.Called(upsert)
+	return args.Get(0).([]*sarama.AlterUserScramCredentialsResult), args.Error(1)
+}
+
 // Close is for implementing sarama.ClusterAdmin
 func (c MockClusterAdmin) Close() error {
 	args := c.Called()
@@ -0,0 +1,59 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// ReadPartition passes every record of the partition of topic, from the oldest offset up to the high water mark
+// at the time of the call, to handle. Reading stops at the record before the high water mark, or once no record
+// arrives for timeout after the consumer has fetched up to the high water mark: the last offsets can then only
+// hold control records, such as transaction markers, which the consumer doesn't return. Fails if no record
+// arrives for timeout before that.
+func ReadPartition(consumer sarama.Consumer, client Client, topic string, partition int32, timeout time.Duration, handle func(*sarama.ConsumerMessage)) error {
+	hwm, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	if hwm <= oldest {
+		return nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(topic, partition, oldest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := partitionConsumer.Close(); err != nil {
+			log.Debug("Error closing partition consumer: %s", err.Error())
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-partitionConsumer.Messages():
+			if !ok {
+				return errors.New("partition consumer closed before reaching the high water mark")
+			}
+			handle(msg)
+
+			if msg.Offset+1 >= hwm {
+				return nil
+			}
+		case <-time.After(timeout):
+			if partitionConsumer.HighWaterMarkOffset() >= hwm {
+				log.Debug("No records left before the high water mark %d of %s partition %d, the last offsets are control records", hwm, topic, partition)
+				return nil
+			}
+			return fmt.Errorf("timed out waiting for records after %s", timeout)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsumer returns a partition consumer that delivers the given records and reports hwm as the high water mark
+// of its last fetch
+type fakeConsumer struct {
+	sarama.Consumer
+	records []*sarama.ConsumerMessage
+	hwm     int64
+}
+
+func (c fakeConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	messages := make(chan *sarama.ConsumerMessage, len(c.records))
+	for _, record := range c.records {
+		messages <- record
+	}
+
+	return &fakePartitionConsumer{messages: messages, hwm: c.hwm}, nil
+}
+
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages chan *sarama.ConsumerMessage
+	hwm      int64
+}
+
+func (p *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage { return p.messages }
+func (p *fakePartitionConsumer) HighWaterMarkOffset() int64               { return p.hwm }
+func (p *fakePartitionConsumer) Close() error                             { return nil }
+
+func partitionClient(oldest, hwm int64) *MockClient {
+	client := &MockClient{}
+	client.On("GetOffset", "topic", int32(0), sarama.OffsetNewest).Return(hwm, nil)
+	client.On("GetOffset", "topic", int32(0), sarama.OffsetOldest).Return(oldest, nil)
+	return client
+}
+
+func TestReadPartition(t *testing.T) {
+	consumer := fakeConsumer{records: []*sarama.ConsumerMessage{{Offset: 0}, {Offset: 1}, {Offset: 2}}, hwm: 3}
+
+	var offsets []int64
+	err := ReadPartition(consumer, partitionClient(0, 3), "topic", 0, time.Second, func(msg *sarama.ConsumerMessage) {
+		offsets = append(offsets, msg.Offset)
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0, 1, 2}, offsets)
+}
+
+func TestReadPartition_TrailingControlRecord(t *testing.T) {
+	// Offset 2 is a transaction marker, which the consumer never returns
+	consumer := fakeConsumer{records: []*sarama.ConsumerMessage{{Offset: 0}, {Offset: 1}}, hwm: 3}
+
+	var offsets []int64
+	err := ReadPartition(consumer, partitionClient(0, 3), "topic", 0, 10*time.Millisecond, func(msg *sarama.ConsumerMessage) {
+		offsets = append(offsets, msg.Offset)
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0, 1}, offsets)
+}
+
+func TestReadPartition_Timeout(t *testing.T) {
+	// The consumer hasn't fetched up to the high water mark, so the records are missing rather than control records
+	consumer := fakeConsumer{records: []*sarama.ConsumerMessage{{Offset: 0}}, hwm: 1}
+
+	err := ReadPartition(consumer, partitionClient(0, 3), "topic", 0, 10*time.Millisecond, func(*sarama.ConsumerMessage) {})
+	assert.Error(t, err)
+}
+
+func TestReadPartition_Empty(t *testing.T) {
+	client := partitionClient(5, 5)
+
+	err := ReadPartition(nil, client, "topic", 0, time.Second, func(*sarama.ConsumerMessage) {
+		t.Error("Expected no records")
+	})
+	assert.Nil(t, err)
+	client.AssertExpectations(t)
+}
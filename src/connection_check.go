@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/newrelic/infra-integrations-sdk/jmx"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// errNoZookeeper is reported by the checks that need a Zookeeper connection when it couldn't be made
+var errNoZookeeper = errors.New("no Zookeeper connection")
+
+// connectionCheck is a single subsystem checked by --test_connection
+type connectionCheck struct {
+	name  string
+	check func() error
+}
+
+// testConnection checks each subsystem the integration connects to and writes a PASS or FAIL line
+// for each of them to w. Every check runs regardless of the result of the others.
+// Returns true if every check passed.
+func testConnection(kafkaArgs *args.KafkaArguments, w io.Writer, zkConn zookeeper.Connection, zkErr error) bool {
+	// A KRaft cluster is discovered through its bootstrap brokers instead of Zookeeper
+	discoveryCheck := "Zookeeper"
+	if zkErr == nil && zookeeper.IsBootstrapConnection(zkConn) {
+		discoveryCheck = "Bootstrap brokers"
+	}
+
+	checks := []connectionCheck{
+		{discoveryCheck, func() error {
+			if zkErr != nil {
+				return zkErr
+			}
+			_, err := zookeeper.GetBrokerIDs(zkConn)
+			return err
+		}},
+		{"Kafka client", func() error {
+			if zkErr != nil {
+				return errNoZookeeper
+			}
+			client, err := zkConn.CreateClient()
+			if err != nil {
+				return err
+			}
+			return client.Close()
+		}},
+		{"Kafka cluster admin", func() error {
+			if zkErr != nil {
+				return errNoZookeeper
+			}
+			clusterAdmin, err := zkConn.CreateClusterAdmin()
+			if err != nil {
+				return err
+			}
+			return clusterAdmin.Close()
+		}},
+	}
+
+	checks = append(checks, jmxConnectionChecks(kafkaArgs, zkConn, zkErr)...)
+
+	passed := true
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			fmt.Fprintf(w, "FAIL %s: %s\n", c.name, err.Error())
+			passed = false
+		} else {
+			fmt.Fprintf(w, "PASS %s\n", c.name)
+		}
+	}
+
+	return passed
+}
+
+// jmxConnectionChecks returns a check per JMX endpoint the integration would connect to: the brokers
+// registered in Zookeeper when collecting broker data, and every configured producer and consumer
+func jmxConnectionChecks(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, zkErr error) []connectionCheck {
+	var checks []connectionCheck
+
+	if kafkaArgs.CollectBrokerTopicData && !kafkaArgs.ConsumerOffset {
+		if zkErr != nil {
+			checks = append(checks, connectionCheck{"JMX brokers", func() error { return errNoZookeeper }})
+		} else {
+			checks = append(checks, brokerJMXChecks(kafkaArgs, zkConn)...)
+		}
+	}
+
+	for _, producer := range kafkaArgs.Producers {
+		checks = append(checks, jmxCheck(kafkaArgs, "JMX producer "+producer.Name, producer.Host, producer.Port, producer.User, producer.Password))
+	}
+	for _, consumer := range kafkaArgs.Consumers {
+		checks = append(checks, jmxCheck(kafkaArgs, "JMX consumer "+consumer.Name, consumer.Host, consumer.Port, consumer.User, consumer.Password))
+	}
+
+	return checks
+}
+
+func brokerJMXChecks(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection) []connectionCheck {
+	brokerIDs, err := zookeeper.GetBrokerIDs(zkConn)
+	if err != nil {
+		return []connectionCheck{{"JMX brokers", func() error { return err }}}
+	}
+
+	var checks []connectionCheck
+	for _, id := range brokerIDs {
+		name := "JMX broker " + id
+		brokerID, err := strconv.Atoi(id)
+		if err != nil {
+			checks = append(checks, connectionCheck{name, func() error { return err }})
+			continue
+		}
+
+		connections, err := zookeeper.GetBrokerConnections(brokerID, zkConn)
+		if err != nil || len(connections) == 0 {
+			checks = append(checks, connectionCheck{name, func() error {
+				return fmt.Errorf("unable to get broker connection info from Zookeeper: %v", err)
+			}})
+			continue
+		}
+
+		jmxPort := kafkaArgs.BrokerJMXPort(brokerID, connections[0].BrokerHost, connections[0].JmxPort)
+		checks = append(checks, jmxCheck(kafkaArgs, name, connections[0].BrokerHost, jmxPort, kafkaArgs.DefaultJMXUser, kafkaArgs.DefaultJMXPassword))
+	}
+
+	return checks
+}
+
+// jmxCheck returns a check that opens and closes a JMX connection
+func jmxCheck(kafkaArgs *args.KafkaArguments, name, host string, port int, user, password string) connectionCheck {
+	return connectionCheck{name, func() error {
+		options := make([]jmx.Option, 0)
+		if kafkaArgs.KeyStore != "" && kafkaArgs.KeyStorePassword != "" && kafkaArgs.TrustStore != "" && kafkaArgs.TrustStorePassword != "" {
+			ssl := jmx.WithSSL(kafkaArgs.KeyStore, kafkaArgs.KeyStorePassword, kafkaArgs.TrustStore, kafkaArgs.TrustStorePassword)
+			options = append(options, ssl)
+		}
+
+		jmxwrapper.JMXLock.Lock()
+		defer jmxwrapper.JMXLock.Unlock()
+
+		// Close needs to be called even on a failed open to clear out any set variables
+		defer jmxwrapper.JMXClose()
+		return jmxwrapper.JMXOpen(host, strconv.Itoa(port), user, password, options...)
+	}}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/jmx"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var brokerConnectionBytes = []byte(`{"listener_security_protocol_map":{"PLAINTEXT":"PLAINTEXT"},"endpoints":["PLAINTEXT://kafkabroker:9092"],"jmx_port":9999,"host":"kafkabroker","timestamp":"1530886155628","port":9092,"version":4}`)
+
+func Test_testConnection(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{
+		CollectBrokerTopicData: true,
+		Producers:              []*args.JMXHost{{Name: "producer1", Host: "producerhost", Port: 9998}},
+	}
+
+	mockZk := &zookeeper.MockConnection{}
+	mockClient := connection.MockClient{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	mockZk.On("Children", "/brokers/ids").Return([]string{"0"}, new(zk.Stat), nil)
+	mockZk.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	mockZk.On("CreateClient").Return(&mockClient, nil)
+	mockZk.On("CreateClusterAdmin").Return(&mockClusterAdmin, errors.New("admin failed"))
+	mockClient.On("Close").Return(nil)
+
+	jmxOpen, jmxClose := jmxwrapper.JMXOpen, jmxwrapper.JMXClose
+	defer func() { jmxwrapper.JMXOpen, jmxwrapper.JMXClose = jmxOpen, jmxClose }()
+	jmxwrapper.JMXOpen = func(hostname, port, username, password string, options ...jmx.Option) error {
+		if hostname == "producerhost" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	jmxwrapper.JMXClose = func() {}
+
+	var out bytes.Buffer
+	passed := testConnection(kafkaArgs, &out, mockZk, nil)
+
+	if passed {
+		t.Error("Expected failed checks to fail the test")
+	}
+	expected := []string{
+		"PASS Zookeeper",
+		"PASS Kafka client",
+		"FAIL Kafka cluster admin: admin failed",
+		"PASS JMX broker 0",
+		"FAIL JMX producer producer1: connection refused",
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
+
+func Test_testConnection_NoZookeeper(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{CollectBrokerTopicData: true}
+
+	var out bytes.Buffer
+	passed := testConnection(kafkaArgs, &out, nil, errors.New("no Zookeeper hosts specified"))
+
+	if passed {
+		t.Error("Expected failed checks to fail the test")
+	}
+	expected := "FAIL Zookeeper: no Zookeeper hosts specified\n" +
+		"FAIL Kafka client: no Zookeeper connection\n" +
+		"FAIL Kafka cluster admin: no Zookeeper connection\n" +
+		"FAIL JMX brokers: no Zookeeper connection\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
@@ -4,28 +4,135 @@ package conoffsetcollect
 import (
 	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+	tc "github.com/newrelic/nri-kafka/src/topiccollect"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 )
 
 type partitionOffsets struct {
-	Topic          string `metric_name:"topic" source_type:"attribute"`
-	Partition      string `metric_name:"partition" source_type:"attribute"`
-	ConsumerOffset *int64 `metric_name:"kafka.consumerOffset" source_type:"gauge"`
-	HighWaterMark  *int64 `metric_name:"kafka.highWaterMark" source_type:"gauge"`
-	ConsumerLag    *int64 `metric_name:"kafka.consumerLag" source_type:"gauge"`
+	Topic          string   `metric_name:"topic" source_type:"attribute"`
+	Partition      string   `metric_name:"partition" source_type:"attribute"`
+	ConsumerOffset *int64   `metric_name:"kafka.consumerOffset" source_type:"gauge"`
+	HighWaterMark  *int64   `metric_name:"kafka.highWaterMark" source_type:"gauge"`
+	ConsumerLag    *int64   `metric_name:"kafka.consumerLag" source_type:"gauge"`
+	LagClamped     *int     `metric_name:"kafka.consumerLag.clampedNegative" source_type:"gauge"`
+	ResetDetected  *int     `metric_name:"kafka.consumerOffset.resetDetected" source_type:"gauge"`
+	ConsumeRate    *float64 `metric_name:"kafka.consumerOffset.consumeRate" source_type:"gauge"`
+}
+
+// sortPartitionOffsets orders offsets by topic and then partition number, so samples are emitted in the
+// same order every run regardless of map iteration and goroutine scheduling
+func sortPartitionOffsets(offsets []*partitionOffsets) {
+	sort.SliceStable(offsets, func(i, j int) bool {
+		if offsets[i].Topic != offsets[j].Topic {
+			return offsets[i].Topic < offsets[j].Topic
+		}
+
+		// Partitions are numbers, compare them as such so partition 10 comes after partition 9
+		pi, errI := strconv.Atoi(offsets[i].Partition)
+		pj, errJ := strconv.Atoi(offsets[j].Partition)
+		if errI != nil || errJ != nil {
+			return offsets[i].Partition < offsets[j].Partition
+		}
+		return pi < pj
+	})
+}
+
+// describeConsumerGroups describes consumerGroups in batches of describe_batch_size, so clusters with many
+// groups don't exceed the request size limit of the brokers, and merges the descriptions. A batch that fails
+// fails the description of every group, unless best_effort_describe is set, in which case it is logged and
+// its groups skipped so only the groups that could be described are returned.
+func describeConsumerGroups(kafkaArgs *args.KafkaArguments, clusterAdmin sarama.ClusterAdmin, consumerGroups []string) ([]*sarama.GroupDescription, error) {
+	batchSize := kafkaArgs.DescribeBatchSize
+	if batchSize <= 0 {
+		batchSize = args.DefaultDescribeBatchSize
+	}
+
+	var descriptions []*sarama.GroupDescription
+	for start := 0; start < len(consumerGroups); start += batchSize {
+		end := start + batchSize
+		if end > len(consumerGroups) {
+			end = len(consumerGroups)
+		}
+
+		batch := consumerGroups[start:end]
+		batchDescriptions, err := clusterAdmin.DescribeConsumerGroups(batch)
+		if err != nil {
+			if !kafkaArgs.BestEffortDescribe {
+				return nil, err
+			}
+			log.Warn("Failed to describe consumer groups, skipping consumer groups %v: %s", batch, err)
+			continue
+		}
+		descriptions = append(descriptions, batchDescriptions...)
+	}
+
+	return descriptions, nil
+}
+
+// refreshMetadata refreshes the metadata of every topic if refresh_metadata_on_collect is set, so the leaders
+// the offsets are requested from are current. Collection goes on with the cached metadata if it fails.
+func refreshMetadata(kafkaArgs *args.KafkaArguments, client connection.Client) {
+	if !kafkaArgs.RefreshMetadataOnCollect {
+		return
+	}
+
+	if err := client.RefreshMetadata(); err != nil {
+		log.Warn("Unable to refresh the metadata, collecting with the cached metadata: %s", err.Error())
+	}
 }
 
 // TopicPartitions is the substructure within the consumer group structure
 type TopicPartitions map[string][]int32
 
+// selects returns true if the topic partitions select partition of topic: a topic they don't list is selected
+// in full, and nil topic partitions select every partition
+func (t TopicPartitions) selects(topic string, partition int32) bool {
+	partitions, ok := t[topic]
+	if !ok {
+		return true
+	}
+
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+
+	return false
+}
+
+// merge adds the partitions of other to the topic partitions
+func (t TopicPartitions) merge(other TopicPartitions) {
+	for topic, partitions := range other {
+		t[topic] = append(t[topic], partitions...)
+	}
+}
+
+// count returns the number of partitions of every topic
+func (t TopicPartitions) count() int {
+	count := 0
+	for _, partitions := range t {
+		count += len(partitions)
+	}
+	return count
+}
+
 // Collect collects offset data per consumer group specified in the arguments
-func Collect(zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) error {
+func Collect(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) error {
 	client, err := zkConn.CreateClient()
 	if err != nil {
 		return err
@@ -36,6 +143,8 @@ func Collect(zkConn zookeeper.Connection, kafkaIntegration *integration.Integrat
 		}
 	}()
 
+	refreshMetadata(kafkaArgs, client)
+
 	clusterAdmin, err := zkConn.CreateClusterAdmin()
 	if err != nil {
 		return err
@@ -46,39 +155,81 @@ func Collect(zkConn zookeeper.Connection, kafkaIntegration *integration.Integrat
 		}
 	}()
 
-	// Use the more modern collection method if the configuration exists
-	if args.GlobalArgs.ConsumerGroupRegex != nil {
+	// Offsets from the previous run are used to detect offset resets. Collection
+	// continues without reset detection if the state can't be opened. check_lag
+	// doesn't use the state, and leaves it alone so the regular runs aren't affected.
+	var offsetStore persist.Storer
+	if !kafkaArgs.CheckLag {
+		offsetStore, err = openOffsetStore(kafkaArgs, kafkaIntegration)
 		if err != nil {
-			return fmt.Errorf("failed to create cluster admin from client: %s", err)
+			log.Warn("Unable to open offset state, offset reset detection disabled: %s", err.Error())
+		} else {
+			defer func() {
+				if err := offsetStore.Save(); err != nil {
+					collecterrors.Error("Error saving offset state: %s", err.Error())
+				}
+			}()
 		}
+	}
+
+	// Looked up once per group for the whole run
+	coordinators := newCoordinatorCache(client)
+
+	// The internal topics are told apart once for every group
+	var internalTopics tc.InternalTopics
+	if !kafkaArgs.IncludeInternalTopics {
+		internalTopics = tc.DescribeInternalTopics(kafkaArgs, clusterAdmin)
+	}
+
+	if kafkaArgs.OffsetCollectionStrategy == args.OffsetStrategyTopic {
+		retention := offsetsRetention(kafkaArgs, zkConn, clusterAdmin)
+		return collectFromOffsetsTopic(kafkaArgs, zkConn, client, coordinators, offsetStore, internalTopics, retention, kafkaIntegration)
+	}
 
+	// Use the more modern collection method if the configuration exists
+	if kafkaArgs.ConsumerGroupRegex != nil {
 		consumerGroupMap, err := clusterAdmin.ListConsumerGroups()
 		if err != nil {
 			return fmt.Errorf("failed to get list of consumer groups: %s", err)
 		}
-		consumerGroupList := make([]string, len(consumerGroupMap))
+		consumerGroupList := make([]string, 0, len(consumerGroupMap))
+		var uncoordinatedConsumerGroups []string
 		for consumerGroup := range consumerGroupMap {
+			// Only the coordinators of the matching groups are looked up, before describing them
+			if kafkaArgs.ConsumerGroupRegex.MatchString(consumerGroup) && !coordinators.collects(kafkaArgs, consumerGroup) {
+				uncoordinatedConsumerGroups = append(uncoordinatedConsumerGroups, consumerGroup)
+				continue
+			}
 			consumerGroupList = append(consumerGroupList, consumerGroup)
 		}
+		if len(uncoordinatedConsumerGroups) > 0 {
+			log.Debug("Skipped collecting consumer offsets for consumer groups not coordinated by coordinator_broker_ids %v", uncoordinatedConsumerGroups)
+		}
 
-		consumerGroups, err := clusterAdmin.DescribeConsumerGroups(consumerGroupList)
+		consumerGroups, err := describeConsumerGroups(kafkaArgs, clusterAdmin, consumerGroupList)
 		if err != nil {
 			return fmt.Errorf("failed to get consumer group descriptions: %s", err)
 		}
 
+		// The partitions consumer_group_partitions selects are checked once for every group
+		selected := selectedPartitions(kafkaArgs, client)
+
 		var unmatchedConsumerGroups []string
 		var wg sync.WaitGroup
 		numCollected := 0
 		skippedConsumerGroups := []string{}
 		for _, consumerGroup := range consumerGroups {
-			if args.GlobalArgs.ConsumerGroupRegex.MatchString(consumerGroup.GroupId) {
+			if kafkaArgs.ConsumerGroupRegex.MatchString(consumerGroup.GroupId) {
 				numCollected++
 				if numCollected > 200 {
 					skippedConsumerGroups = append(skippedConsumerGroups, consumerGroup.GroupId)
 					continue
 				}
 				wg.Add(1)
-				go collectOffsetsForConsumerGroup(client, clusterAdmin, consumerGroup.GroupId, consumerGroup.Members, kafkaIntegration, &wg)
+				go func(consumerGroup *sarama.GroupDescription) {
+					time.Sleep(staggerDelay(kafkaArgs.ConsumerOffsetStaggerMs))
+					collectOffsetsForConsumerGroup(kafkaArgs, client, coordinators, clusterAdmin, offsetStore, selected, internalTopics, consumerGroup.GroupId, consumerGroup.Protocol, consumerGroup.Members, kafkaIntegration, &wg)
+				}(consumerGroup)
 			} else {
 				unmatchedConsumerGroups = append(unmatchedConsumerGroups, consumerGroup.GroupId)
 			}
@@ -92,31 +243,88 @@ func Collect(zkConn zookeeper.Connection, kafkaIntegration *integration.Integrat
 			log.Debug("Reached 200 consumer group limit. Skipping consumer groups %v", skippedConsumerGroups)
 		}
 
+		if numCollected == 0 {
+			log.Warn("consumer_group_regex '%s' did not match any of the %d discovered consumer groups", kafkaArgs.ConsumerGroupRegex.String(), len(consumerGroups))
+		}
+
+		if err := setMatchedConsumerGroups(kafkaArgs, numCollected, kafkaIntegration); err != nil {
+			collecterrors.Error("Error setting matched consumer group count: %s", err.Error())
+		}
+
 		wg.Wait()
-	} else if len(args.GlobalArgs.ConsumerGroups) != 0 {
+	} else if len(kafkaArgs.ConsumerGroups) != 0 {
 		log.Warn("Argument 'consumer_groups' is deprecated and will be removed in a future version. Use 'consumer_group_regex' instead.")
+		if err := setDeprecatedConfigInUse(kafkaArgs, "consumer_groups", kafkaIntegration); err != nil {
+			collecterrors.Error("Error setting deprecated config metric: %s", err.Error())
+		}
 		// We retrieve the offsets for each group before calculating the high water mark
 		// so that the lag is never negative
-		for consumerGroup, topics := range args.GlobalArgs.ConsumerGroups {
-			topicPartitions := fillTopicPartitions(consumerGroup, topics, client)
+		for consumerGroup, topics := range kafkaArgs.ConsumerGroups {
+			if !coordinators.collects(kafkaArgs, consumerGroup) {
+				log.Debug("Skipped collecting consumer offsets for consumer group '%s', not coordinated by coordinator_broker_ids", consumerGroup)
+				continue
+			}
+
+			start := time.Now()
+			topicPartitions := fillTopicPartitions(fmt.Sprintf("consumer group '%s'", consumerGroup), topics, client)
+			if !kafkaArgs.IncludeInternalTopics {
+				for topic := range topicPartitions {
+					if internalTopics.IsInternal(topic) {
+						log.Debug("Skipping internal topic %s of consumer group '%s', set include_internal_topics to collect it", topic, consumerGroup)
+						delete(topicPartitions, topic)
+					}
+				}
+			}
 			if len(topicPartitions) == 0 {
-				log.Error("No topics specified for consumer group '%s'", consumerGroup)
+				collecterrors.Error("No topics specified for consumer group '%s'", consumerGroup)
 				continue
 			}
+			if partitions := topicPartitions.count(); exceedsPartitionLimit(partitions, kafkaArgs.SkipGroupsOverPartitions) {
+				log.Warn("Consumer group '%s' has %d partitions, more than the skip_groups_over_partitions limit of %d. Skipping consumer group", consumerGroup, partitions, kafkaArgs.SkipGroupsOverPartitions)
+				continue
+			}
+			topicPartitions, skipped := limitTopicPartitions(topicPartitions, kafkaArgs.MaxPartitionsPerGroup)
+			if skipped > 0 {
+				log.Warn("Consumer group '%s' exceeds the max_partitions_per_group limit of %d partitions, skipping %d partitions", consumerGroup, kafkaArgs.MaxPartitionsPerGroup, skipped)
+			}
 
-			offsetData, err := getConsumerOffsets(consumerGroup, topicPartitions, client)
+			// Without the offsets nothing can be reported, and reporting the group as having
+			// no committed offsets would be indistinguishable from a group that hasn't committed
+			offsetData, err := getConsumerOffsets(kafkaArgs, consumerGroup, topicPartitions, client)
 			if err != nil {
 				log.Info("Failed to collect consumerOffsets for group %s: %v", consumerGroup, err)
+				continue
 			}
-			highWaterMarks, err := getHighWaterMarks(topicPartitions, client)
-			if err != nil {
-				log.Info("Failed to collect highWaterMarks for group %s: %v", consumerGroup, err)
+			// Without high water marks only the committed offsets are reported
+			var highWaterMarks groupOffsets
+			if kafkaArgs.CollectHighWaterMarks {
+				highWaterMarks = getHighWaterMarks(kafkaArgs, topicPartitions, client)
 			}
 
 			offsetStructs := populateOffsetStructs(offsetData, highWaterMarks)
+			if lag, hasLag := offsetsLag(offsetStructs); hasLag && belowMinLag(kafkaArgs, lag) {
+				log.Debug("Consumer group '%s' has a total lag of %d, below min_lag_to_report. Only reporting its lag", consumerGroup, lag)
+				if err := setBelowMinLagMetrics(kafkaArgs, consumerGroup, lag, kafkaIntegration); err != nil {
+					collecterrors.Error("Error setting metrics for consumer group '%s': %s", consumerGroup, err.Error())
+				}
+				continue
+			}
+
+			stats := trackOffsets(kafkaArgs, offsetStore, consumerGroup, offsetStructs)
+			stats.started = start
+			stats.coordinatorID = coordinators.coordinatorID(consumerGroup)
+			stats.skippedPartitions = skipped
+
+			if err := setMetrics(kafkaArgs, consumerGroup, offsetStructs, kafkaIntegration); err != nil {
+				collecterrors.Error("Error setting metrics for consumer group '%s': %s", consumerGroup, err.Error())
+			}
+
+			if len(kafkaArgs.LagKeys) > 0 {
+				setKeyLagMetrics(kafkaArgs, consumerGroup, offsetsPartitionLags(offsetStructs), client, kafkaIntegration)
+			}
 
-			if err := setMetrics(consumerGroup, offsetStructs, kafkaIntegration); err != nil {
-				log.Error("Error setting metrics for consumer group '%s': %s", consumerGroup, err.Error())
+			if err := setGroupMetrics(kafkaArgs, consumerGroup, stats, kafkaIntegration); err != nil {
+				collecterrors.Error("Error setting metrics for consumer group '%s': %s", consumerGroup, err.Error())
 			}
 		}
 	} else {
@@ -126,24 +334,55 @@ func Collect(zkConn zookeeper.Connection, kafkaIntegration *integration.Integrat
 	return nil
 }
 
+// staggerDelay returns a random delay in [0, maxMs) milliseconds used to spread out the start
+// of consumer group collection. A non-positive maxMs disables the delay.
+func staggerDelay(maxMs int) time.Duration {
+	if maxMs <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(maxMs))) * time.Millisecond
+}
+
 // setMetrics adds the metrics from an array of partitionOffsets to the integration
-func setMetrics(consumerGroup string, offsetData []*partitionOffsets, kafkaIntegration *integration.Integration) error {
-	clusterIDAttr := integration.NewIDAttribute("clusterName", args.GlobalArgs.ClusterName)
-	groupEntity, err := kafkaIntegration.Entity(consumerGroup, "ka-consumerGroup", clusterIDAttr)
+func setMetrics(kafkaArgs *args.KafkaArguments, consumerGroup string, offsetData []*partitionOffsets, kafkaIntegration *integration.Integration) error {
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+	groupEntity, err := kafkaIntegration.Entity(consumerGroup, "ka-consumerGroup", clusterIDAttrs...)
 	if err != nil {
 		return err
 	}
 
+	sortPartitionOffsets(offsetData)
 	for _, offsetData := range offsetData {
-		metricSet := groupEntity.NewMetricSet("KafkaOffsetSample",
-			metric.Attribute{Key: "displayName", Value: groupEntity.Metadata.Name},
-			metric.Attribute{Key: "entityName", Value: "consumerGroup:" + groupEntity.Metadata.Name})
+		metricSet := groupEntity.NewMetricSet(kafkaArgs.OffsetSampleName, append([]metric.Attribute{
+			{Key: "displayName", Value: groupEntity.Metadata.Name},
+			{Key: "entityName", Value: "consumerGroup:" + groupEntity.Metadata.Name},
+		}, kafkaArgs.TopicAttributes(offsetData.Topic)...)...)
 
 		if err := metricSet.MarshalMetrics(offsetData); err != nil {
-			log.Error("Error Marshaling offset metrics for consumer group '%s': %s", consumerGroup, err.Error())
+			collecterrors.Error("Error Marshaling offset metrics for consumer group '%s': %s", consumerGroup, err.Error())
 			continue
 		}
 	}
 
 	return nil
 }
+
+// setMatchedConsumerGroups reports the number of consumer groups matched by consumer_group_regex
+// on the local entity so that a regex which matches nothing can be alerted on
+func setMatchedConsumerGroups(kafkaArgs *args.KafkaArguments, matched int, kafkaIntegration *integration.Integration) error {
+	metricSet := kafkaIntegration.LocalEntity().NewMetricSet(kafkaArgs.OffsetSampleName,
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName})
+
+	return metricSet.SetMetric("matchedConsumerGroups", matched, metric.GAUGE)
+}
+
+// setDeprecatedConfigInUse reports on the local entity that a deprecated argument is in use, so the agents
+// still using it can be found before it is removed
+func setDeprecatedConfigInUse(kafkaArgs *args.KafkaArguments, argument string, kafkaIntegration *integration.Integration) error {
+	metricSet := kafkaIntegration.LocalEntity().NewMetricSet(kafkaArgs.OffsetSampleName,
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+		metric.Attribute{Key: "deprecatedArgument", Value: argument})
+
+	return metricSet.SetMetric("deprecatedConfigInUse", 1, metric.GAUGE)
+}
@@ -1,7 +1,10 @@
 package conoffsetcollect
 
 import (
+	"errors"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/newrelic/infra-integrations-sdk/integration"
@@ -20,17 +23,18 @@ var (
 )
 
 func TestCollect(t *testing.T) {
-	mockZk := zookeeper.MockConnection{}
+	mockZk := &zookeeper.MockConnection{}
 	i, _ := integration.New("test", "test")
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	mockClient := connection.MockClient{}
 	mockClusterAdmin := connection.MockClusterAdmin{}
 	mockBroker := connection.MockBroker{}
 
-	args.GlobalArgs = &args.KafkaArguments{
-		ClusterName: "testcluster",
+	kafkaArgs = &args.KafkaArguments{
+		ClusterName:           "testcluster",
+		CollectHighWaterMarks: true,
 	}
-	args.GlobalArgs.ConsumerGroups = map[string]map[string][]int32{
+	kafkaArgs.ConsumerGroups = map[string]map[string][]int32{
 		"testGroup": {
 			"testTopic": {
 				0,
@@ -50,20 +54,104 @@ func TestCollect(t *testing.T) {
 	mockBroker.On("ListGroups", mock.Anything).Return(&sarama.ListGroupsResponse{}, nil)
 	mockBroker.On("DescribeGroups", mock.Anything).Return(&sarama.DescribeGroupsResponse{}, nil)
 	mockBroker.On("Connected").Return(true, nil)
+	mockBroker.On("ID").Return(int32(2))
 	mockBroker.On("FetchOffset", mock.Anything).Return(&sarama.OffsetFetchResponse{}, nil)
 	mockClient.On("Leader", "testTopic", int32(0)).Return(&mockBroker, nil)
+	mockClient.On("Partitions", "testTopic").Return([]int32{0}, nil)
 	mockClient.On("RefreshCoordinator", mock.Anything).Return(nil)
 	mockClient.On("Coordinator", mock.Anything).Return(&mockBroker, nil)
-	mockClient.On("GetOffset", "testTopic", int32(0), int64(-2)).Return(int64(123), nil)
-	mockBroker.On("Fetch", mock.Anything).Return(&sarama.FetchResponse{}, nil)
+	mockBroker.On("GetAvailableOffsets", mock.Anything).Return(&sarama.OffsetResponse{}, nil)
 	mockClusterAdmin.On("Close").Return(nil)
+	mockClusterAdmin.On("DescribeTopics", []string(nil)).Return([]*sarama.TopicMetadata{}, nil)
 
-	err := Collect(mockZk, i)
+	err := Collect(kafkaArgs, mockZk, i)
 	assert.Nil(t, err)
 
+	localEntity := i.LocalEntity()
+	assert.Equal(t, 1, len(localEntity.Metrics))
+	assert.Equal(t, float64(1), localEntity.Metrics[0].Metrics["deprecatedConfigInUse"])
+	assert.Equal(t, "consumer_groups", localEntity.Metrics[0].Metrics["deprecatedArgument"])
+
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", integration.NewIDAttribute("clusterName", "testcluster"))
+	groupMetrics := groupEntity.Metrics[len(groupEntity.Metrics)-1].Metrics
+	assert.Equal(t, float64(2), groupMetrics["kafka.consumerGroup.coordinatorId"])
+}
+
+func TestCollect_RegexMatchesNoGroups(t *testing.T) {
+	mockZk := &zookeeper.MockConnection{}
+	i, _ := integration.New("test", "test")
+	mockClient := connection.MockClient{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+
+	kafkaArgs := &args.KafkaArguments{
+		ClusterName:        "testcluster",
+		ConsumerGroupRegex: regexp.MustCompile("^nomatch$"),
+	}
+
+	mockZk.On("CreateClient").Return(&mockClient, nil)
+	mockZk.On("CreateClusterAdmin").Return(&mockClusterAdmin, nil)
+	mockClient.On("Close").Return(nil)
+	mockClusterAdmin.On("Close").Return(nil)
+	mockClusterAdmin.On("DescribeTopics", []string(nil)).Return([]*sarama.TopicMetadata{}, nil)
+	mockClusterAdmin.On("ListConsumerGroups").Return(map[string]string{"groupA": "consumer", "groupB": "consumer"}, nil)
+	mockClusterAdmin.On("DescribeConsumerGroups", mock.Anything).Return([]*sarama.GroupDescription{
+		{GroupId: "groupA"},
+		{GroupId: "groupB"},
+	}, nil)
+
+	err := Collect(kafkaArgs, mockZk, i)
+	assert.Nil(t, err)
+
+	localEntity := i.LocalEntity()
+	assert.Equal(t, 1, len(localEntity.Metrics))
+	assert.Equal(t, float64(0), localEntity.Metrics[0].Metrics["matchedConsumerGroups"])
+}
+
+func TestCollect_CoordinatorBrokerIDs(t *testing.T) {
+	mockZk := &zookeeper.MockConnection{}
+	i, _ := integration.New("test", "test")
+	mockClient := connection.MockClient{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	coordinator1 := connection.MockBroker{}
+	coordinator2 := connection.MockBroker{}
+
+	kafkaArgs := &args.KafkaArguments{
+		ClusterName:          "testcluster",
+		OffsetSampleName:     "KafkaOffsetSample",
+		ConsumerGroupRegex:   regexp.MustCompile("^group"),
+		CoordinatorBrokerIDs: []int{1},
+	}
+
+	mockZk.On("CreateClient").Return(&mockClient, nil)
+	mockZk.On("CreateClusterAdmin").Return(&mockClusterAdmin, nil)
+	mockClient.On("Close").Return(nil)
+	mockClient.On("Coordinator", "groupA").Return(&coordinator1, nil)
+	mockClient.On("Coordinator", "groupB").Return(&coordinator2, nil)
+	mockClient.On("Coordinator", "groupC").Return(&coordinator1, errors.New("no coordinator"))
+	coordinator1.On("ID").Return(int32(1))
+	coordinator2.On("ID").Return(int32(2))
+	mockClusterAdmin.On("Close").Return(nil)
+	mockClusterAdmin.On("DescribeTopics", []string(nil)).Return([]*sarama.TopicMetadata{}, nil)
+	mockClusterAdmin.On("ListConsumerGroups").Return(map[string]string{"groupA": "consumer", "groupB": "consumer", "groupC": "consumer", "other": "consumer"}, nil)
+	// Only the matching group of coordinator 1 and the unmatched group are described
+	mockClusterAdmin.On("DescribeConsumerGroups", mock.MatchedBy(func(groups []string) bool {
+		return len(groups) == 2 && (groups[0] == "groupA" && groups[1] == "other" || groups[0] == "other" && groups[1] == "groupA")
+	})).Return([]*sarama.GroupDescription{
+		{GroupId: "groupA"},
+		{GroupId: "other"},
+	}, nil)
+	mockClusterAdmin.On("ListConsumerGroupOffsets", "groupA", mock.Anything).Return(&sarama.OffsetFetchResponse{}, nil).Once()
+
+	err := Collect(kafkaArgs, mockZk, i)
+	assert.Nil(t, err)
+
+	mockClusterAdmin.AssertExpectations(t)
+	localEntity := i.LocalEntity()
+	assert.Equal(t, float64(1), localEntity.Metrics[0].Metrics["matchedConsumerGroups"])
 }
 
 func Test_setMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: args.DefaultOffsetSampleName}
 	i, _ := integration.New("test", "test")
 	offsetData := []*partitionOffsets{
 		{
@@ -75,7 +163,7 @@ func Test_setMetrics(t *testing.T) {
 		},
 	}
 
-	err := setMetrics("testGroup", offsetData, i)
+	err := setMetrics(kafkaArgs, "testGroup", offsetData, i)
 
 	assert.Nil(t, err)
 	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
@@ -84,3 +172,82 @@ func Test_setMetrics(t *testing.T) {
 	assert.Equal(t, 8, len(resultEntity.Metrics[0].Metrics))
 
 }
+
+func Test_staggerDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), staggerDelay(0))
+	assert.Equal(t, time.Duration(0), staggerDelay(-5))
+
+	for i := 0; i < 100; i++ {
+		delay := staggerDelay(10)
+		assert.True(t, delay >= 0 && delay < 10*time.Millisecond, "delay %s out of range", delay)
+	}
+}
+
+func Test_describeConsumerGroups_BestEffort(t *testing.T) {
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	kafkaArgs := &args.KafkaArguments{
+		BestEffortDescribe: true,
+		DescribeBatchSize:  2,
+	}
+
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupA", "groupB"}).Return([]*sarama.GroupDescription{
+		{GroupId: "groupA"},
+		{GroupId: "groupB"},
+	}, nil).Once()
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupC", "groupD"}).Return([]*sarama.GroupDescription{}, errors.New("describe failed")).Once()
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupE"}).Return([]*sarama.GroupDescription{
+		{GroupId: "groupE"},
+	}, nil).Once()
+
+	descriptions, err := describeConsumerGroups(kafkaArgs, mockClusterAdmin, []string{"groupA", "groupB", "groupC", "groupD", "groupE"})
+	assert.Nil(t, err)
+
+	var groups []string
+	for _, description := range descriptions {
+		groups = append(groups, description.GroupId)
+	}
+	assert.Equal(t, []string{"groupA", "groupB", "groupE"}, groups)
+	mockClusterAdmin.AssertExpectations(t)
+}
+
+func Test_describeConsumerGroups_Batches(t *testing.T) {
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	kafkaArgs := &args.KafkaArguments{DescribeBatchSize: 2}
+
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupA", "groupB"}).Return([]*sarama.GroupDescription{
+		{GroupId: "groupA"},
+		{GroupId: "groupB"},
+	}, nil).Once()
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupC"}).Return([]*sarama.GroupDescription{
+		{GroupId: "groupC"},
+	}, nil).Once()
+
+	descriptions, err := describeConsumerGroups(kafkaArgs, mockClusterAdmin, []string{"groupA", "groupB", "groupC"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(descriptions))
+	assert.Equal(t, "groupC", descriptions[2].GroupId)
+}
+
+func Test_describeConsumerGroups_Fatal(t *testing.T) {
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	kafkaArgs := &args.KafkaArguments{DescribeBatchSize: 1}
+
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupA"}).Return([]*sarama.GroupDescription{{GroupId: "groupA"}}, nil).Once()
+	mockClusterAdmin.On("DescribeConsumerGroups", []string{"groupB"}).Return([]*sarama.GroupDescription{}, errors.New("describe failed")).Once()
+
+	_, err := describeConsumerGroups(kafkaArgs, mockClusterAdmin, []string{"groupA", "groupB"})
+	assert.NotNil(t, err)
+}
+
+func Test_refreshMetadata(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	fakeClient := new(connection.MockClient)
+	refreshMetadata(kafkaArgs, fakeClient)
+	fakeClient.AssertNotCalled(t, "RefreshMetadata", mock.Anything)
+
+	// A failed refresh doesn't stop the collection
+	kafkaArgs.RefreshMetadataOnCollect = true
+	fakeClient.On("RefreshMetadata", []string(nil)).Return(errors.New("controller unavailable")).Once()
+	refreshMetadata(kafkaArgs, fakeClient)
+	fakeClient.AssertExpectations(t)
+}
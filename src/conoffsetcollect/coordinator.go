@@ -0,0 +1,68 @@
+package conoffsetcollect
+
+import (
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+)
+
+// coordinatorCache remembers the coordinator broker ID of each consumer group for a single run, so
+// FindCoordinator is only requested once per group however many times the coordinator is needed.
+// Failed lookups aren't cached.
+type coordinatorCache struct {
+	client connection.Client
+	lock   sync.Mutex
+	ids    map[string]int32
+}
+
+func newCoordinatorCache(client connection.Client) *coordinatorCache {
+	return &coordinatorCache{client: client, ids: make(map[string]int32)}
+}
+
+// coordinatorID returns the broker ID of the coordinator of consumerGroup, or nil if it can't be found.
+// A nil cache finds no coordinators. The lock isn't held during FindCoordinator so the groups collected
+// concurrently look up their coordinators concurrently, at the cost of a duplicate lookup if a group is
+// looked up twice at once.
+func (c *coordinatorCache) coordinatorID(consumerGroup string) *int32 {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	id, ok := c.ids[consumerGroup]
+	c.lock.Unlock()
+	if ok {
+		return &id
+	}
+
+	coordinator, err := c.client.Coordinator(consumerGroup)
+	if err != nil {
+		log.Debug("Unable to get the coordinator of consumer group '%s': %s", consumerGroup, err.Error())
+		return nil
+	}
+
+	id = coordinator.ID()
+	c.lock.Lock()
+	c.ids[consumerGroup] = id
+	c.lock.Unlock()
+	return &id
+}
+
+// collects returns whether the offsets of consumerGroup are collected, which they are for every group unless
+// coordinator_broker_ids lists the coordinators whose groups are collected. A group whose coordinator can't be
+// found isn't collected then, since it may be collected by the integration handling its coordinator.
+func (c *coordinatorCache) collects(kafkaArgs *args.KafkaArguments, consumerGroup string) bool {
+	if len(kafkaArgs.CoordinatorBrokerIDs) == 0 {
+		return true
+	}
+
+	id := c.coordinatorID(consumerGroup)
+	if id == nil {
+		log.Warn("Unable to get the coordinator of consumer group '%s', not collecting it with coordinator_broker_ids", consumerGroup)
+		return false
+	}
+
+	return kafkaArgs.CollectsCoordinator(int(*id))
+}
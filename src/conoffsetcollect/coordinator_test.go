@@ -0,0 +1,87 @@
+package conoffsetcollect
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_coordinatorCache(t *testing.T) {
+	mockClient := connection.MockClient{}
+	mockBroker := connection.MockBroker{}
+	// Only expected once, the second lookup must come from the cache
+	mockClient.On("Coordinator", "group").Return(&mockBroker, nil).Once()
+	mockClient.On("Coordinator", "missing").Return(&mockBroker, errors.New("no coordinator"))
+	mockBroker.On("ID").Return(int32(3))
+
+	coordinators := newCoordinatorCache(&mockClient)
+	for n := 0; n < 2; n++ {
+		id := coordinators.coordinatorID("group")
+		if assert.NotNil(t, id) {
+			assert.Equal(t, int32(3), *id)
+		}
+	}
+	assert.Nil(t, coordinators.coordinatorID("missing"))
+
+	var noCache *coordinatorCache
+	assert.Nil(t, noCache.coordinatorID("group"))
+}
+
+// coordinatorBroker is a coordinator broker safe to use from concurrent lookups
+type coordinatorBroker struct {
+	connection.MockBroker
+	id int32
+}
+
+func (b *coordinatorBroker) ID() int32 {
+	return b.id
+}
+
+// concurrentClient answers FindCoordinator only once lookups of every group are in flight
+type concurrentClient struct {
+	connection.MockClient
+	inFlight sync.WaitGroup
+}
+
+func (c *concurrentClient) Coordinator(consumerGroup string) (connection.Broker, error) {
+	c.inFlight.Done()
+	c.inFlight.Wait()
+	return &coordinatorBroker{id: 3}, nil
+}
+
+func Test_coordinatorCache_ConcurrentLookups(t *testing.T) {
+	groups := []string{"group1", "group2", "group3"}
+	client := &concurrentClient{}
+	client.inFlight.Add(len(groups))
+	coordinators := newCoordinatorCache(client)
+
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+			coordinators.coordinatorID(group)
+		}(group)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("coordinator lookups were serialized")
+	}
+
+	for _, group := range groups {
+		if id := coordinators.coordinatorID(group); assert.NotNil(t, id) {
+			assert.Equal(t, int32(3), *id)
+		}
+	}
+}
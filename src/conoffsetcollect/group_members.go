@@ -0,0 +1,71 @@
+package conoffsetcollect
+
+import (
+	"sort"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+)
+
+// groupMember is a member of a consumer group with the number of collected partitions assigned to it
+type groupMember struct {
+	memberID           string
+	clientID           string
+	clientHost         string
+	assignedPartitions int
+}
+
+// groupMembers returns the members of a group sorted by member ID, with their number of assigned partitions from
+// assignedPartitions by member ID. Members without assigned partitions are idle, which is reported as 0.
+func groupMembers(members map[string]*sarama.GroupMemberDescription, assignedPartitions map[string]int) []groupMember {
+	groupMembers := make([]groupMember, 0, len(members))
+	for memberID, description := range members {
+		groupMembers = append(groupMembers, groupMember{
+			memberID:           memberID,
+			clientID:           description.ClientId,
+			clientHost:         description.ClientHost,
+			assignedPartitions: assignedPartitions[memberID],
+		})
+	}
+	sort.Slice(groupMembers, func(i, j int) bool { return groupMembers[i].memberID < groupMembers[j].memberID })
+
+	return groupMembers
+}
+
+// setGroupMemberMetrics reports a sample for each member of a consumer group on the group entity, with its member
+// ID, client ID and client host as attributes and its number of assigned partitions as
+// kafka.member.assignedPartitions. Only the first max_group_members members are reported.
+func setGroupMemberMetrics(kafkaArgs *args.KafkaArguments, consumerGroup string, members []groupMember, kafkaIntegration *integration.Integration) {
+	if maxMembers := kafkaArgs.MaxGroupMembers; maxMembers > 0 && len(members) > maxMembers {
+		log.Warn("Consumer group '%s' has %d members, more than the max_group_members limit of %d. Only reporting the first %d", consumerGroup, len(members), maxMembers, maxMembers)
+		members = members[:maxMembers]
+	}
+	if len(members) == 0 {
+		return
+	}
+
+	groupEntity, err := kafkaIntegration.Entity(consumerGroup, "ka-consumerGroup", kafkaArgs.ClusterIDAttributes()...)
+	if err != nil {
+		collecterrors.Error("Unable to create entity for consumer group '%s': %s", consumerGroup, err.Error())
+		return
+	}
+
+	for _, member := range members {
+		metricSet := groupEntity.NewMetricSet(kafkaArgs.OffsetSampleName,
+			metric.Attribute{Key: "displayName", Value: groupEntity.Metadata.Name},
+			metric.Attribute{Key: "entityName", Value: "consumerGroup:" + groupEntity.Metadata.Name},
+			metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+			metric.Attribute{Key: "consumerGroup", Value: consumerGroup},
+			metric.Attribute{Key: "memberID", Value: member.memberID},
+			metric.Attribute{Key: "clientID", Value: member.clientID},
+			metric.Attribute{Key: "clientHost", Value: member.clientHost},
+		)
+		if err := metricSet.SetMetric("kafka.member.assignedPartitions", member.assignedPartitions, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set metric kafka.member.assignedPartitions: %s", err.Error())
+		}
+	}
+}
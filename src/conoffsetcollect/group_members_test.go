@@ -0,0 +1,68 @@
+package conoffsetcollect
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_groupMembers(t *testing.T) {
+	members := map[string]*sarama.GroupMemberDescription{
+		"member2": {ClientId: "client2", ClientHost: "/10.0.0.2"},
+		"member1": {ClientId: "client1", ClientHost: "/10.0.0.1"},
+	}
+
+	assert.Equal(t, []groupMember{
+		{memberID: "member1", clientID: "client1", clientHost: "/10.0.0.1", assignedPartitions: 3},
+		{memberID: "member2", clientID: "client2", clientHost: "/10.0.0.2", assignedPartitions: 0},
+	}, groupMembers(members, map[string]int{"member1": 3}))
+}
+
+func Test_setGroupMemberMetrics_MaxMembers(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample", MaxGroupMembers: 1}
+	i, _ := integration.New("test", "1.0.0")
+
+	setGroupMemberMetrics(kafkaArgs, "testGroup", []groupMember{
+		{memberID: "member1", clientID: "client1", clientHost: "/10.0.0.1", assignedPartitions: 3},
+		{memberID: "member2", clientID: "client2", clientHost: "/10.0.0.2"},
+	}, i)
+
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", integration.NewIDAttribute("clusterName", "testcluster"))
+	assert.Equal(t, 1, len(groupEntity.Metrics))
+	assert.Equal(t, "member1", groupEntity.Metrics[0].Metrics["memberID"])
+	assert.Equal(t, "client1", groupEntity.Metrics[0].Metrics["clientID"])
+	assert.Equal(t, "/10.0.0.1", groupEntity.Metrics[0].Metrics["clientHost"])
+	assert.Equal(t, float64(3), groupEntity.Metrics[0].Metrics["kafka.member.assignedPartitions"])
+}
+
+func Test_collectOffsetsForConsumerGroup_Members(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample", CollectGroupMembers: true}
+	i, _ := integration.New("test", "1.0.0")
+	fakeClusterAdmin := new(connection.MockClusterAdmin)
+
+	members := map[string]*sarama.GroupMemberDescription{
+		"member1": {ClientId: "client1", ClientHost: "/10.0.0.1", MemberAssignment: encodeMemberAssignment(map[string][]int32{"topic": {0, 1}})},
+		"member2": {ClientId: "client2", ClientHost: "/10.0.0.2", MemberAssignment: encodeMemberAssignment(map[string][]int32{})},
+	}
+
+	// Members are reported even though the offsets fail to be collected
+	fakeClusterAdmin.On("ListConsumerGroupOffsets", "testGroup", mock.Anything).Return(&sarama.OffsetFetchResponse{}, assert.AnError)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collectOffsetsForConsumerGroup(kafkaArgs, new(connection.MockClient), nil, fakeClusterAdmin, nil, nil, nil, "testGroup", "range", members, i, &wg)
+	wg.Wait()
+
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", integration.NewIDAttribute("clusterName", "testcluster"))
+	assigned := make(map[interface{}]interface{})
+	for _, metricSet := range groupEntity.Metrics {
+		assigned[metricSet.Metrics["memberID"]] = metricSet.Metrics["kafka.member.assignedPartitions"]
+	}
+	assert.Equal(t, map[interface{}]interface{}{"member1": float64(2), "member2": float64(0)}, assigned)
+}
@@ -2,19 +2,24 @@ package conoffsetcollect
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
 	"github.com/newrelic/nri-kafka/src/connection"
+	tc "github.com/newrelic/nri-kafka/src/topiccollect"
 )
 
 // getConsumerOffsets collects consumer offsets from Kafka brokers rather than Zookeeper
-func getConsumerOffsets(groupName string, topicPartitions TopicPartitions, client connection.Client) (groupOffsets, error) {
+func getConsumerOffsets(kafkaArgs *args.KafkaArguments, groupName string, topicPartitions TopicPartitions, client connection.Client) (groupOffsets, error) {
 
 	// refresh coordinator cache (suggested by sarama to do so)
 	if err := client.RefreshCoordinator(groupName); err != nil {
@@ -26,16 +31,16 @@ func getConsumerOffsets(groupName string, topicPartitions TopicPartitions, clien
 		return nil, fmt.Errorf("unable to get the coordinator broker for group %s", groupName)
 	}
 
-	return getConsumerOffsetsFromBroker(groupName, topicPartitions, []connection.Broker{coordinator})
+	return getConsumerOffsetsFromBroker(kafkaArgs, groupName, topicPartitions, []connection.Broker{coordinator})
 }
 
 // getConsumerOffsetsFromBroker collects a consumer groups offsets from the given brokers
-func getConsumerOffsetsFromBroker(groupName string, topicPartitions TopicPartitions, brokers []connection.Broker) (groupOffsets, error) {
+func getConsumerOffsetsFromBroker(kafkaArgs *args.KafkaArguments, groupName string, topicPartitions TopicPartitions, brokers []connection.Broker) (groupOffsets, error) {
 	offsetRequest := createOffsetFetchRequest(groupName, topicPartitions)
 
 	offsets := make(groupOffsets)
 	for _, broker := range brokers {
-		err := resetBrokerConnection(broker, sarama.NewConfig())
+		err := resetBrokerConnection(broker, connection.NewConfig(kafkaArgs.StateKeyPrefix()))
 		if err != nil {
 			return nil, err
 		}
@@ -89,26 +94,54 @@ type groupOffsets map[string]topicOffsets
 
 type topicOffsets map[int32]int64
 
-// getHighWaterMarkFromBrokers retrieves the high water mark for every partition in topicPartitions.
+// getHighWaterMarks retrieves the high water mark for every partition in topicPartitions.
 // To do this, it first must determine which broker is the leader for a partition because a request
 // can only be made to the leader of a partition.
-// Next, for each broker, it creates a fetch request that fetches a block per partition that that broker
-// is the leader for. This is more complicated than it sounds, see createFetchRequest for details.
-// Finally, it makes the request, and for each partition in the request, it inserts the highWaterMarkOffset
-// from the partition-associated block into the hwms map to be returned.
-func getHighWaterMarks(topicPartitions TopicPartitions, client connection.Client) (groupOffsets, error) {
+// Next, for each broker, it makes a single ListOffsets request for the newest offset of every partition
+// that broker is the leader for, so the number of requests grows with the number of brokers rather
+// than the number of partitions.
+// Partitions without an available leader, or whose leadership moved since the metadata was fetched, are
+// retried once against their new leaders after refreshing the metadata. Partitions that still fail are
+// left out of the returned map rather than failing the whole request.
+func getHighWaterMarks(kafkaArgs *args.KafkaArguments, topicPartitions TopicPartitions, client connection.Client) groupOffsets {
 	// Determine which broker is the leader for each partition
-	brokerLeaderMap, err := getBrokerLeaderMap(topicPartitions, client)
-	if err != nil {
-		return nil, err
-	}
+	brokerLeaderMap, failed := getBrokerLeaderMap(topicPartitions, client)
 
 	hwms := make(groupOffsets)
+	failed.merge(requestHighWaterMarks(kafkaArgs, brokerLeaderMap, hwms))
+	if len(failed) == 0 {
+		return hwms
+	}
+
+	log.Debug("No leader available for partitions %v, refreshing metadata", failed)
+	topics := make([]string, 0, len(failed))
+	for topic := range failed {
+		topics = append(topics, topic)
+	}
+	if err := client.RefreshMetadata(topics...); err != nil {
+		collecterrors.Error("Failed to refresh metadata for topics %v: %s", topics, err.Error())
+		return hwms
+	}
+
+	brokerLeaderMap, failed = getBrokerLeaderMap(failed, client)
+	failed.merge(requestHighWaterMarks(kafkaArgs, brokerLeaderMap, hwms))
+	if len(failed) != 0 {
+		log.Debug("Skipping hwm for partitions %v: no leader available", failed)
+	}
+
+	return hwms
+}
+
+// requestHighWaterMarks makes a ListOffsets request to each broker for the partitions it leads and inserts
+// the high water marks into hwms. The partitions that failed because the broker is not or no longer the
+// leader are returned so they can be retried with refreshed metadata.
+func requestHighWaterMarks(kafkaArgs *args.KafkaArguments, brokerLeaderMap map[connection.Broker]TopicPartitions, hwms groupOffsets) TopicPartitions {
+	failed := make(TopicPartitions)
 	for broker, tps := range brokerLeaderMap {
 
-		resp, err := fetchHighWaterMarkResponse(broker, tps, client)
+		resp, err := fetchHighWaterMarkResponse(kafkaArgs, broker, tps)
 		if err != nil {
-			log.Error("Failed to collect high water marks for topics %v: %s", tps, err.Error())
+			collecterrors.Error("Failed to collect high water marks for topics %v: %s", tps, err.Error())
 			continue
 		}
 
@@ -126,43 +159,45 @@ func getHighWaterMarks(topicPartitions TopicPartitions, client connection.Client
 			for _, partition := range partitions {
 				block := resp.GetBlock(topic, partition)
 				if block == nil {
-					log.Error("Failed to collect hwm for partition %v: no blocks returned for topic %s", partition, topic)
+					collecterrors.Error("Failed to collect hwm for partition %v: no blocks returned for topic %s", partition, topic)
+				} else if block.Err == sarama.ErrNotLeaderForPartition || block.Err == sarama.ErrLeaderNotAvailable {
+					failed[topic] = append(failed[topic], partition)
 				} else if block.Err != sarama.ErrNoError {
-					log.Error("Failed to collect hwm for partition %v: %s", partition, block.Err.Error())
+					collecterrors.Error("Failed to collect hwm for partition %v: %s", partition, block.Err.Error())
+				} else if len(block.Offsets) == 0 {
+					collecterrors.Error("Failed to collect hwm for partition %v: no offsets returned for topic %s", partition, topic)
 				} else {
-					hwms[topic][partition] = block.HighWaterMarkOffset
+					hwms[topic][partition] = block.Offsets[0]
 				}
 			}
 		}
 	}
 
-	return hwms, nil
-
+	return failed
 }
 
-func fetchHighWaterMarkResponse(broker connection.Broker, tps TopicPartitions, client connection.Client) (*sarama.FetchResponse, error) {
+func fetchHighWaterMarkResponse(kafkaArgs *args.KafkaArguments, broker connection.Broker, tps TopicPartitions) (*sarama.OffsetResponse, error) {
 	// Open the connection if necessary
-	if err := resetBrokerConnection(broker, sarama.NewConfig()); err != nil {
+	if err := resetBrokerConnection(broker, connection.NewConfig(kafkaArgs.StateKeyPrefix())); err != nil {
 		return nil, err
 	}
 
-	// Create the fetch request for the correct partitions
-	fetchRequest := createFetchRequest(tps, client)
-
 	// Run the request
-	resp, err := broker.Fetch(fetchRequest)
-
-	return resp, err
-
+	return broker.GetAvailableOffsets(createOffsetRequest(kafkaArgs, tps))
 }
 
-func getBrokerLeaderMap(topicPartitions TopicPartitions, client connection.Client) (map[connection.Broker]TopicPartitions, error) {
+// getBrokerLeaderMap groups the partitions by their leader broker. Partitions whose leader
+// can't be determined are returned separately.
+func getBrokerLeaderMap(topicPartitions TopicPartitions, client connection.Client) (map[connection.Broker]TopicPartitions, TopicPartitions) {
 	brokerLeaderMap := make(map[connection.Broker]TopicPartitions)
+	leaderless := make(TopicPartitions)
 	for topic, partitions := range topicPartitions {
 		for _, partition := range partitions {
 			leader, err := client.Leader(topic, partition)
 			if err != nil {
-				return nil, fmt.Errorf("cannot determine leader for partition %v", partition)
+				log.Debug("Cannot determine leader for topic %s, partition %d: %s", topic, partition, err.Error())
+				leaderless[topic] = append(leaderless[topic], partition)
+				continue
 			}
 
 			if _, ok := brokerLeaderMap[leader]; !ok {
@@ -172,35 +207,73 @@ func getBrokerLeaderMap(topicPartitions TopicPartitions, client connection.Clien
 		}
 	}
 
-	return brokerLeaderMap, nil
+	return brokerLeaderMap, leaderless
 }
 
-// fillOutTopicPartitionsFromKafka checks all topics for the consumer group.
+// fillTopicPartitions checks all topics requested by requester, such as a consumer group.
 // If a topic has no partition then all partitions of a topic will be added.
+// If a topic lists explicit partitions, only those are collected, and partitions
+// the topic doesn't have are skipped with a warning.
 // All calls will query Kafka rather than Zookeeper
-func fillTopicPartitions(groupID string, topicPartitions TopicPartitions, client connection.Client) TopicPartitions {
+func fillTopicPartitions(requester string, topicPartitions TopicPartitions, client connection.Client) TopicPartitions {
 
 	// If no topics return error
 	if len(topicPartitions) == 0 {
 		return nil
 	}
 
-	// For each topic, if it has no partitions, collect all partitions
 	for topic, partitions := range topicPartitions {
-		if len(partitions) == 0 {
-			var err error
-			if partitions, err = client.Partitions(topic); err != nil {
-				log.Warn("Unable to gather partitions for topic '%s': %s", topic, err.Error())
-				continue
-			}
+		existing, err := client.Partitions(topic)
+		if err != nil {
+			log.Warn("Unable to gather partitions for topic '%s': %s", topic, err.Error())
+			continue
+		}
 
-			topicPartitions[topic] = partitions
+		// If it has no partitions, collect all partitions
+		if len(partitions) == 0 {
+			topicPartitions[topic] = existing
+			continue
 		}
+
+		topicPartitions[topic] = existingPartitions(requester, topic, partitions, existing)
 	}
 
 	return topicPartitions
 }
 
+// selectedPartitions returns the consumer_group_partitions that exist, or nil if it isn't set
+func selectedPartitions(kafkaArgs *args.KafkaArguments, client connection.Client) TopicPartitions {
+	if len(kafkaArgs.ConsumerGroupPartitions) == 0 {
+		return nil
+	}
+
+	selected := make(TopicPartitions, len(kafkaArgs.ConsumerGroupPartitions))
+	for topic, partitions := range kafkaArgs.ConsumerGroupPartitions {
+		selected[topic] = append([]int32(nil), partitions...)
+	}
+
+	return fillTopicPartitions("consumer_group_partitions", selected, client)
+}
+
+// existingPartitions returns the requested partitions that are in existing
+func existingPartitions(requester, topic string, requested, existing []int32) []int32 {
+	exists := make(map[int32]bool, len(existing))
+	for _, partition := range existing {
+		exists[partition] = true
+	}
+
+	valid := make([]int32, 0, len(requested))
+	for _, partition := range requested {
+		if !exists[partition] {
+			log.Warn("Partition %d requested for %s does not exist in topic '%s', skipping it", partition, requester, topic)
+			continue
+		}
+		valid = append(valid, partition)
+	}
+
+	return valid
+}
+
 // createOffsetFetchRequest creates an offsetFetchRequest for the partitions in topicPartitions
 func createOffsetFetchRequest(groupName string, topicPartitions TopicPartitions) *sarama.OffsetFetchRequest {
 	request := &sarama.OffsetFetchRequest{
@@ -223,34 +296,36 @@ func createOffsetFetchRequest(groupName string, topicPartitions TopicPartitions)
 	return request
 }
 
-func createFetchRequest(topicPartitions TopicPartitions, client connection.Client) *sarama.FetchRequest {
-	// Explanation:
-	// To add a block, you have to specify an offset to start reading from. Unfortunately, unlike
-	// other parts of the library, this offset cannot use the standard enums OffsetOldest or OffsetNewest.
-	// Thus, in order to make a fetch request, we first have to get the oldest offset with client.GetOffset.
-	// This significantly slows things down, and we will likely need to run this concurrently for decent performance
-
-	request := &sarama.FetchRequest{
-		MaxWaitTime: int32(1000),
-		MinBytes:    int32(0),
-		MaxBytes:    int32(10000),
-		Version:     int16(0),
-		Isolation:   sarama.ReadUncommitted,
+// createOffsetRequest creates a ListOffsets request for the newest offset of the partitions in topicPartitions.
+// With hwm_offset_type last_stable it's a v2 request reading committed records, which returns the last stable
+// offset instead of the log end offset.
+func createOffsetRequest(kafkaArgs *args.KafkaArguments, topicPartitions TopicPartitions) *sarama.OffsetRequest {
+	request := &sarama.OffsetRequest{}
+	if kafkaArgs.HWMOffsetType == args.HWMOffsetLastStable {
+		request.Version = 2
+		request.IsolationLevel = sarama.ReadCommitted
 	}
 
 	for topic, partitions := range topicPartitions {
 		for _, partition := range partitions {
-			offset, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
-			if err != nil {
-				log.Error("Failed to get offset for partition %d: %s", partition, err.Error())
-			}
-			request.AddBlock(topic, partition, offset, 10000)
+			request.AddBlock(topic, partition, sarama.OffsetNewest, 1)
 		}
 	}
 
 	return request
 }
 
+// clampLag returns the lag of a partition with the given high water mark and committed offset. The hwm is
+// fetched after the offset so the lag should never be negative, but a rebalance between the two requests can
+// still commit past it. A lag of 0 is returned rather than a negative one, and true if the lag was clamped.
+func clampLag(hwm, offset int64) (int64, bool) {
+	lag := hwm - offset
+	if lag < 0 {
+		return 0, true
+	}
+	return lag, false
+}
+
 // populateOffsetStructs takes a map of offsets and high water marks and
 // populates an array of partitionOffsets which can then be marshalled into metric
 // sets
@@ -259,122 +334,416 @@ func populateOffsetStructs(offsets, hwms groupOffsets) []*partitionOffsets {
 	var poffsets []*partitionOffsets
 	for topic, partitions := range hwms {
 		for partition, hwm := range partitions {
-			offsetPointer := func() *int64 {
-				topicOffsets, ok := offsets[topic]
-				if !ok || len(topicOffsets) == 0 {
-					log.Error("Offset not collected for topic %s", topic, partition)
-					return nil
-				}
-
-				offset, ok := topicOffsets[partition]
-				if !ok || offset == -1 {
-					log.Error("Offset not collected for topic %s, partition %d", topic, partition)
-					return nil
-				}
-
-				return &offset
-			}()
-
-			lag := func() *int64 {
-				if offsetPointer == nil {
-					return nil
-				}
-
-				returnLag := hwm - *offsetPointer
-				return &returnLag
-			}()
+			// Partitions without a committed offset have no lag to report. They're
+			// accounted for by kafka.consumerGroup.hasCommittedOffsets on the group.
+			// OffsetFetch returns -1 for partitions the group never committed to, which
+			// still report their hwm.
+			hwm := hwm
+			offset, ok := offsets[topic][partition]
+			if !ok {
+				log.Debug("No committed offset for topic %s, partition %d", topic, partition)
+				continue
+			}
+			if offset == -1 {
+				log.Debug("No committed offset for topic %s, partition %d. Reporting only the high water mark", topic, partition)
+				poffsets = append(poffsets, &partitionOffsets{
+					Topic:         topic,
+					Partition:     strconv.Itoa(int(partition)),
+					HighWaterMark: &hwm,
+				})
+				continue
+			}
 
+			lag, wasClamped := clampLag(hwm, offset)
+			clamped := 0
+			if wasClamped {
+				log.Warn("Consumer offset %d is past the high water mark %d for topic %s, partition %d. Reporting a lag of 0", offset, hwm, topic, partition)
+				clamped = 1
+			}
 			poffset := &partitionOffsets{
 				Topic:          topic,
 				Partition:      strconv.Itoa(int(partition)),
-				ConsumerOffset: offsetPointer,
+				ConsumerOffset: &offset,
 				HighWaterMark:  &hwm,
-				ConsumerLag:    lag,
+				ConsumerLag:    &lag,
+				LagClamped:     &clamped,
 			}
 
 			poffsets = append(poffsets, poffset)
 		}
 	}
 
+	// Partitions whose hwm couldn't be collected still report their offset, without hwm or lag
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			if _, ok := hwms[topic][partition]; ok || offset == -1 {
+				continue
+			}
+
+			offset := offset
+			poffsets = append(poffsets, &partitionOffsets{
+				Topic:          topic,
+				Partition:      strconv.Itoa(int(partition)),
+				ConsumerOffset: &offset,
+			})
+		}
+	}
+
+	sortPartitionOffsets(poffsets)
 	return poffsets
+}
+
+// memberPartitionOffset is the committed offset of a single partition assigned to a consumer group member
+type memberPartitionOffset struct {
+	Member    *sarama.GroupMemberDescription
+	Topic     string
+	Partition int32
+	Block     *sarama.OffsetFetchResponseBlock
+	// CommitTimestamp is when the offset was committed in milliseconds since the epoch, nil if it isn't known.
+	// OffsetFetch responses don't include it.
+	CommitTimestamp *int64
+	// HasActiveMember is whether a member of the group is assigned the partition, nil if it isn't known.
+	// Partitions committed to without an active member may be stuck after a consumer died mid-rebalance.
+	HasActiveMember *bool
+}
+
+// excludeInternalTopics returns the partitions that aren't of an internal topic
+func excludeInternalTopics(partitionOffsets []*memberPartitionOffset, internalTopics tc.InternalTopics) []*memberPartitionOffset {
+	filtered := make([]*memberPartitionOffset, 0, len(partitionOffsets))
+	for _, p := range partitionOffsets {
+		if internalTopics.IsInternal(p.Topic) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+
+	return filtered
+}
+
+// exceedsPartitionLimit returns true if a consumer group with the given number of partitions should be
+// skipped because of skip_groups_over_partitions. A non-positive max means no limit.
+func exceedsPartitionLimit(partitions, max int) bool {
+	return max > 0 && partitions > max
+}
 
+// limitPartitions caps the number of partitions collected for a consumer group at max, keeping the
+// first partitions sorted by topic and partition so the same subset is collected every run.
+// Returns the partitions to collect and the number skipped. A non-positive max means no limit.
+func limitPartitions(partitionOffsets []*memberPartitionOffset, max int) ([]*memberPartitionOffset, int) {
+	if max <= 0 || len(partitionOffsets) <= max {
+		return partitionOffsets, 0
+	}
+
+	sort.Slice(partitionOffsets, func(i, j int) bool {
+		if partitionOffsets[i].Topic != partitionOffsets[j].Topic {
+			return partitionOffsets[i].Topic < partitionOffsets[j].Topic
+		}
+		return partitionOffsets[i].Partition < partitionOffsets[j].Partition
+	})
+
+	return partitionOffsets[:max], len(partitionOffsets) - max
 }
 
-func collectOffsetsForConsumerGroup(client connection.Client, clusterAdmin sarama.ClusterAdmin, consumerGroup string, members map[string]*sarama.GroupMemberDescription, kafkaIntegration *integration.Integration, wg *sync.WaitGroup) {
+// limitTopicPartitions applies limitPartitions to the partitions of a consumer group before their offsets are fetched
+func limitTopicPartitions(topicPartitions TopicPartitions, max int) (TopicPartitions, int) {
+	var partitionOffsets []*memberPartitionOffset
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			partitionOffsets = append(partitionOffsets, &memberPartitionOffset{Topic: topic, Partition: partition})
+		}
+	}
+
+	limited, skipped := limitPartitions(partitionOffsets, max)
+	if skipped == 0 {
+		return topicPartitions, 0
+	}
+
+	limitedTopicPartitions := make(TopicPartitions)
+	for _, p := range limited {
+		limitedTopicPartitions[p.Topic] = append(limitedTopicPartitions[p.Topic], p.Partition)
+	}
+	return limitedTopicPartitions, skipped
+}
+
+func collectOffsetsForConsumerGroup(kafkaArgs *args.KafkaArguments, client connection.Client, coordinators *coordinatorCache, clusterAdmin sarama.ClusterAdmin, offsetStore persist.Storer, selected TopicPartitions, internalTopics tc.InternalTopics, consumerGroup, assignmentStrategy string, members map[string]*sarama.GroupMemberDescription, kafkaIntegration *integration.Integration, wg *sync.WaitGroup) {
 	defer wg.Done()
+	start := time.Now()
 
+	// Combine the assignments of all members so a single offset fetch covers the whole group,
+	// and remember which member each partition is assigned to
+	topicPartitions := make(map[string][]int32)
+	assignedMembers := make(map[string]map[int32]*sarama.GroupMemberDescription)
+	assignedPartitions := make(map[string]int)
 	for memberName, description := range members {
-		assignment, err := description.GetMemberAssignment()
+		assignment, err := decodeMemberAssignment(description.MemberAssignment)
 		if err != nil {
-			log.Error("Failed to get group member assignment for member %s: %s", memberName, err)
+			collecterrors.Error("Failed to get group member assignment for member %s: %s", memberName, err)
 			continue
 		}
 
-		listGroupsResponse, err := clusterAdmin.ListConsumerGroupOffsets(consumerGroup, assignment.Topics)
-		if err != nil {
-			log.Error("Failed to get consumer group offsets for member %s: %s", memberName, err)
-			continue
+		for topic, partitions := range assignment {
+			if _, ok := assignedMembers[topic]; !ok {
+				assignedMembers[topic] = make(map[int32]*sarama.GroupMemberDescription)
+			}
+			for _, partition := range partitions {
+				if !selected.selects(topic, partition) {
+					continue
+				}
+				topicPartitions[topic] = append(topicPartitions[topic], partition)
+				assignedMembers[topic][partition] = description
+				assignedPartitions[memberName]++
+			}
 		}
-		for topic, partitionMap := range listGroupsResponse.Blocks {
-			for partition, block := range partitionMap {
-				if block.Err != sarama.ErrNoError {
-					log.Error("Error in consumer group offset reponse for topic %s, partition %d: %s", block.Err.Error())
+	}
+
+	// Members are reported even if the offsets of the group can't be collected, to find the idle ones
+	if kafkaArgs.CollectGroupMembers {
+		setGroupMemberMetrics(kafkaArgs, consumerGroup, groupMembers(members, assignedPartitions), kafkaIntegration)
+	}
+
+	// Fetching every committed offset of the group also covers the partitions it committed to that aren't
+	// currently assigned, which needs OffsetFetch v2. Older brokers only return the requested partitions.
+	fetchAll := fetchesAllCommittedOffsets(kafkaArgs)
+	if !fetchAll && len(topicPartitions) == 0 {
+		return
+	}
+
+	requestPartitions := topicPartitions
+	if fetchAll {
+		requestPartitions = nil
+	}
+	listGroupsResponse, err := clusterAdmin.ListConsumerGroupOffsets(consumerGroup, requestPartitions)
+	if err != nil {
+		collecterrors.Error("Failed to get consumer group offsets for group %s: %s", consumerGroup, err)
+		return
+	}
+
+	if fetchAll {
+		// Only committed partitions are returned. Assigned partitions without a commit are reported like older
+		// brokers return them, with an offset of -1.
+		for topic, partitions := range topicPartitions {
+			for _, partition := range partitions {
+				if listGroupsResponse.GetBlock(topic, partition) == nil {
+					listGroupsResponse.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: -1, Err: sarama.ErrNoError})
 				}
-				wg.Add(1)
-				go collectPartitionOffsetMetrics(client, consumerGroup, description, topic, partition, block, wg, kafkaIntegration)
 			}
 		}
 	}
+
+	var partitionOffsets []*memberPartitionOffset
+	for topic, partitionMap := range listGroupsResponse.Blocks {
+		for partition, block := range partitionMap {
+			// Every committed partition is returned when fetching all of them
+			if !selected.selects(topic, partition) {
+				continue
+			}
+			if block.Err != sarama.ErrNoError {
+				collecterrors.Error("Error in consumer group offset reponse for topic %s, partition %d: %s", topic, partition, block.Err.Error())
+			}
+
+			member, assigned := assignedMembers[topic][partition]
+			if !assigned {
+				member = &sarama.GroupMemberDescription{}
+			}
+			partitionOffsets = append(partitionOffsets, &memberPartitionOffset{
+				Member:          member,
+				Topic:           topic,
+				Partition:       partition,
+				Block:           block,
+				HasActiveMember: &assigned,
+			})
+		}
+	}
+
+	// DescribeGroups doesn't return the generation of the group
+	collectGroupPartitionOffsets(kafkaArgs, client, coordinators, offsetStore, internalTopics, consumerGroup, partitionOffsets, nil, assignmentStrategy, summarizeMembers(members), 0, start, kafkaIntegration)
 }
 
-func collectPartitionOffsetMetrics(client connection.Client, consumerGroup string, memberDescription *sarama.GroupMemberDescription, topic string, partition int32, block *sarama.OffsetFetchResponseBlock, wg *sync.WaitGroup, kafkaIntegration *integration.Integration) {
-	defer wg.Done()
+// fetchesAllCommittedOffsets returns true if the brokers support fetching every committed offset of a group at
+// once, which OffsetFetch does since v2 of Kafka 0.10.2. The version is assumed to be recent if it isn't known.
+func fetchesAllCommittedOffsets(kafkaArgs *args.KafkaArguments) bool {
+	return kafkaArgs.KafkaVersion == nil || kafkaArgs.KafkaVersion.IsAtLeast(sarama.V0_10_2_0)
+}
 
-	hwm, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
-	if err != nil {
-		log.Error("Failed to get hwm for topic %s, partition %d: %s", topic, partition, err)
+// collectGroupPartitionOffsets collects the metrics of every partition of a consumer group, followed
+// by the group level metrics which need the results of every partition. The time since start, when
+// the collection of the group began, is reported as the offset collection time of the group. The
+// rebalances of the group are tracked if its generation is known.
+func collectGroupPartitionOffsets(kafkaArgs *args.KafkaArguments, client connection.Client, coordinators *coordinatorCache, offsetStore persist.Storer, internalTopics tc.InternalTopics, consumerGroup string, partitionOffsets []*memberPartitionOffset, generation *int32, assignmentStrategy string, members *memberSummary, retention time.Duration, start time.Time, kafkaIntegration *integration.Integration) {
+	if !kafkaArgs.IncludeInternalTopics {
+		partitionOffsets = excludeInternalTopics(partitionOffsets, internalTopics)
+	}
+
+	if exceedsPartitionLimit(len(partitionOffsets), kafkaArgs.SkipGroupsOverPartitions) {
+		log.Warn("Consumer group '%s' has %d partitions, more than the skip_groups_over_partitions limit of %d. Skipping consumer group", consumerGroup, len(partitionOffsets), kafkaArgs.SkipGroupsOverPartitions)
+		return
+	}
+
+	stats := &groupStats{
+		started:            start,
+		coordinatorID:      coordinators.coordinatorID(consumerGroup),
+		assignmentStrategy: assignmentStrategy,
+		members:            members,
+	}
+	partitionOffsets, stats.skippedPartitions = limitPartitions(partitionOffsets, kafkaArgs.MaxPartitionsPerGroup)
+	if stats.skippedPartitions > 0 {
+		log.Warn("Consumer group '%s' exceeds the max_partitions_per_group limit of %d partitions, skipping %d partitions", consumerGroup, kafkaArgs.MaxPartitionsPerGroup, stats.skippedPartitions)
+	}
+	if generation != nil {
+		stats.generation = generation
+		stats.rebalances = trackGeneration(kafkaArgs, offsetStore, consumerGroup, *generation)
+	}
+
+	stats.retentionRemainingMs = retentionRemaining(partitionOffsets, retention, time.Now())
+	if remaining := stats.retentionRemainingMs; remaining != nil && *remaining < int64(float64(retention/time.Millisecond)*retentionWarningFraction) {
+		log.Warn("Committed offsets of consumer group '%s' expire in %s unless it commits again, %s is %s", consumerGroup, time.Duration(*remaining)*time.Millisecond, offsetsRetentionConfig, retention)
+	}
+
+	stats.oldestCommitTimestamp, stats.oldestCommitAgeMs = oldestCommit(partitionOffsets, time.Now())
+	if stats.oldestCommitTimestamp == nil {
+		log.Debug("No commit timestamps for consumer group '%s', not reporting kafka.oldestCommitTimestamp", consumerGroup)
+	}
+
+	// Fetch the high water marks of all the group's partitions with one request per leader broker, unless
+	// only the committed offsets are collected
+	var hwms groupOffsets
+	if kafkaArgs.CollectHighWaterMarks {
+		topicPartitions := make(TopicPartitions)
+		for _, p := range partitionOffsets {
+			topicPartitions[p.Topic] = append(topicPartitions[p.Topic], p.Partition)
+		}
+		hwms = getHighWaterMarks(kafkaArgs, topicPartitions, client)
+	}
+
+	// Groups without a lag can't be compared to min_lag_to_report, so they are always reported
+	if lag, hasLag := groupLag(partitionOffsets, hwms); hasLag && belowMinLag(kafkaArgs, lag) {
+		log.Debug("Consumer group '%s' has a total lag of %d, below min_lag_to_report. Only reporting its lag", consumerGroup, lag)
+		if err := setBelowMinLagMetrics(kafkaArgs, consumerGroup, lag, kafkaIntegration); err != nil {
+			collecterrors.Error("Error setting metrics for consumer group '%s': %s", consumerGroup, err.Error())
+		}
 		return
 	}
 
-	lag := hwm - block.Offset
+	var partitionWg sync.WaitGroup
+	for _, p := range partitionOffsets {
+		// Partitions without a hwm are still reported, without hwm or lag
+		var hwm *int64
+		if offset, ok := hwms[p.Topic][p.Partition]; ok {
+			hwm = &offset
+		}
+
+		partitionWg.Add(1)
+		go collectPartitionOffsetMetrics(kafkaArgs, offsetStore, stats, consumerGroup, p.Member, p.HasActiveMember, p.Topic, p.Partition, p.Block, p.CommitTimestamp, hwm, &partitionWg, kafkaIntegration)
+	}
+
+	partitionWg.Wait()
+	if len(kafkaArgs.LagKeys) > 0 {
+		setKeyLagMetrics(kafkaArgs, consumerGroup, memberPartitionLags(partitionOffsets, hwms), client, kafkaIntegration)
+	}
+
+	if err := setGroupMetrics(kafkaArgs, consumerGroup, stats, kafkaIntegration); err != nil {
+		collecterrors.Error("Error setting metrics for consumer group '%s': %s", consumerGroup, err.Error())
+	}
+}
 
-	clusterIDAttr := integration.NewIDAttribute("clusterName", args.GlobalArgs.ClusterName)
+func collectPartitionOffsetMetrics(kafkaArgs *args.KafkaArguments, offsetStore persist.Storer, stats *groupStats, consumerGroup string, memberDescription *sarama.GroupMemberDescription, hasActiveMember *bool, topic string, partition int32, block *sarama.OffsetFetchResponseBlock, commitTimestamp *int64, hwm *int64, wg *sync.WaitGroup, kafkaIntegration *integration.Integration) {
+	defer wg.Done()
+
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
 	consumerGroupIDAttr := integration.NewIDAttribute("consumerGroup", consumerGroup)
 	topicIDAttr := integration.NewIDAttribute("topic", topic)
 	partitionIDAttr := integration.NewIDAttribute("partition", strconv.Itoa(int(partition)))
 
-	partitionConsumerEntity, err := kafkaIntegration.Entity(strconv.Itoa(int(partition)), "ka-partition-consumer", clusterIDAttr, consumerGroupIDAttr, topicIDAttr, partitionIDAttr)
+	partitionConsumerEntity, err := kafkaIntegration.Entity(strconv.Itoa(int(partition)), "ka-partition-consumer", append(clusterIDAttrs, consumerGroupIDAttr, topicIDAttr, partitionIDAttr)...)
 	if err != nil {
-		log.Error("Failed to get entity for partition consumer")
+		collecterrors.Error("Failed to get entity for partition consumer")
 		return
 	}
 
-	ms := partitionConsumerEntity.NewMetricSet("KafkaOffsetSample",
-		metric.Attribute{Key: "clusterName", Value: args.GlobalArgs.ClusterName},
-		metric.Attribute{Key: "consumerGroup", Value: consumerGroup},
-		metric.Attribute{Key: "topic", Value: topic},
-		metric.Attribute{Key: "partition", Value: strconv.Itoa(int(partition))},
-		metric.Attribute{Key: "clientID", Value: memberDescription.ClientId},
-		metric.Attribute{Key: "clientHost", Value: memberDescription.ClientHost},
-	)
+	ms := partitionConsumerEntity.NewMetricSet(kafkaArgs.OffsetSampleName, append([]metric.Attribute{
+		{Key: "clusterName", Value: kafkaArgs.ClusterName},
+		{Key: "consumerGroup", Value: consumerGroup},
+		{Key: "topic", Value: topic},
+		{Key: "partition", Value: strconv.Itoa(int(partition))},
+		{Key: "clientID", Value: memberDescription.ClientId},
+		{Key: "clientHost", Value: memberDescription.ClientHost},
+	}, kafkaArgs.TopicAttributes(topic)...)...)
+
+	if hasActiveMember != nil {
+		activeMember := 0
+		if *hasActiveMember {
+			activeMember = 1
+		}
+		if err := ms.SetMetric("kafka.partition.hasActiveMember", activeMember, metric.GAUGE); err != nil {
+			collecterrors.Error("Failed to set metric kafka.partition.hasActiveMember: %s", err)
+		}
+	}
 
 	if block.Offset == -1 {
 		log.Warn("Offset for topic %s, partition %d has expired (past retention period). Skipping offset and lag metrics", topic, partition)
 	} else {
+		stats.addCommitted()
 		err = ms.SetMetric("consumer.offset", block.Offset, metric.GAUGE)
 		if err != nil {
-			log.Error("Failed to set metric consumer.lag: %s", err)
+			collecterrors.Error("Failed to set metric consumer.lag: %s", err)
 		}
 
-		err = ms.SetMetric("consumer.lag", lag, metric.GAUGE)
-		if err != nil {
-			log.Error("Failed to set metric consumer.lag: %s", err)
+		if commitTimestamp != nil {
+			err = ms.SetMetric("kafka.consumerOffset.lastCommitTimestamp", *commitTimestamp, metric.GAUGE)
+			if err != nil {
+				collecterrors.Error("Failed to set metric kafka.consumerOffset.lastCommitTimestamp: %s", err)
+			}
 		}
-	}
 
-	err = ms.SetMetric("consumer.hwm", hwm, metric.GAUGE)
-	if err != nil {
-		log.Error("Failed to set metric consumer.lag: %s", err)
+		// The committed offset is tracked without a high water mark too, only the lag needs it
+		var lag *int64
+		if hwm == nil {
+			log.Debug("No hwm for topic %s, partition %d. Skipping lag metrics", topic, partition)
+		} else {
+			partitionLag, wasClamped := clampLag(*hwm, block.Offset)
+			clamped := 0
+			if wasClamped {
+				log.Warn("Consumer offset %d is past the high water mark %d for topic %s, partition %d. Reporting a lag of 0", block.Offset, *hwm, topic, partition)
+				clamped = 1
+			}
+			lag = &partitionLag
+
+			stats.addLag(partitionLag)
+			err = ms.SetMetric("consumer.lag", partitionLag, metric.GAUGE)
+			if err != nil {
+				collecterrors.Error("Failed to set metric consumer.lag: %s", err)
+			}
+			err = ms.SetMetric("kafka.consumerLag.clampedNegative", clamped, metric.GAUGE)
+			if err != nil {
+				collecterrors.Error("Failed to set metric kafka.consumerLag.clampedNegative: %s", err)
+			}
+		}
+
+		// An unknown lag is tracked as 0, which doesn't count as stalled
+		trackedLag := int64(0)
+		if lag != nil {
+			trackedLag = *lag
+		}
+		if status, ok := trackOffset(kafkaArgs, offsetStore, consumerGroup, topic, strconv.Itoa(int(partition)), block.Offset, trackedLag); ok {
+			stats.add(status, lag)
+			err = ms.SetMetric("kafka.consumerOffset.resetDetected", status.ResetDetected, metric.GAUGE)
+			if err != nil {
+				collecterrors.Error("Failed to set metric kafka.consumerOffset.resetDetected: %s", err)
+			}
+
+			if status.ConsumeRate != nil {
+				err = ms.SetMetric("kafka.consumerOffset.consumeRate", *status.ConsumeRate, metric.GAUGE)
+				if err != nil {
+					collecterrors.Error("Failed to set metric kafka.consumerOffset.consumeRate: %s", err)
+				}
+			}
+		}
 	}
 
+	if hwm != nil {
+		err = ms.SetMetric("consumer.hwm", *hwm, metric.GAUGE)
+		if err != nil {
+			collecterrors.Error("Failed to set metric consumer.hwm: %s", err)
+		}
+	}
 }
@@ -2,15 +2,24 @@ package conoffsetcollect
 
 import (
 	"errors"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
 	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	tc "github.com/newrelic/nri-kafka/src/topiccollect"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 func Test_getConsumerOffsets(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	groupName := "testGroup"
 	topicPartitions := TopicPartitions{"testTopic": {0}}
 	fakeClient := new(connection.MockClient)
@@ -30,7 +39,7 @@ func Test_getConsumerOffsets(t *testing.T) {
 	fakeBroker.On("Close").Return(nil)
 	fakeBroker.On("Open", mock.Anything).Return(nil)
 
-	offsets, err := getConsumerOffsets(groupName, topicPartitions, fakeClient)
+	offsets, err := getConsumerOffsets(kafkaArgs, groupName, topicPartitions, fakeClient)
 
 	assert.Nil(t, err)
 
@@ -38,68 +47,249 @@ func Test_getConsumerOffsets(t *testing.T) {
 }
 
 func Test_getHighWaterMarks(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{HWMOffsetType: args.HWMOffsetLogEnd}
 	topicPartitions := TopicPartitions{"testTopic": {0}}
 	fakeClient := new(connection.MockClient)
 	fakeBroker := new(connection.MockBroker)
-	fakeFetchResponse := &sarama.FetchResponse{}
-	fakeFetchResponse.Blocks = map[string]map[int32]*sarama.FetchResponseBlock{
-		"testTopic": {0: {HighWaterMarkOffset: 20}},
-	}
+	fakeOffsetResponse := &sarama.OffsetResponse{}
+	fakeOffsetResponse.AddTopicPartition("testTopic", 0, 20)
 
 	fakeClient.On("Leader", "testTopic", int32(0)).Return(fakeBroker, nil)
 	fakeBroker.On("Connected").Return(true, nil)
-	fakeClient.On("GetOffset", "testTopic", int32(0), int64(-2)).Return(int64(20), nil)
-	fakeBroker.On("Fetch", mock.Anything).Return(fakeFetchResponse, nil)
+	fakeBroker.On("Close").Return(nil)
+	fakeBroker.On("Open", mock.Anything).Return(nil)
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(fakeOffsetResponse, nil)
 	fakeBroker.On("Close").Return(nil)
 	fakeBroker.On("Open", mock.Anything).Return(nil)
 
-	hwms, err := getHighWaterMarks(topicPartitions, fakeClient)
+	hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
 
-	assert.Nil(t, err)
 	assert.Equal(t, int64(20), hwms["testTopic"][0])
 }
 
 func Test_getHighWaterMarks_FetchErr(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{HWMOffsetType: args.HWMOffsetLogEnd}
 	topicPartitions := TopicPartitions{"testTopic": {0}}
 	fakeClient := new(connection.MockClient)
 	fakeBroker := new(connection.MockBroker)
-	fakeFetchResponse := &sarama.FetchResponse{}
-	fakeFetchResponse.Blocks = map[string]map[int32]*sarama.FetchResponseBlock{
-		"testTopic": {0: {HighWaterMarkOffset: 20}},
-	}
 
 	fakeClient.On("Leader", "testTopic", int32(0)).Return(fakeBroker, nil)
 	fakeBroker.On("Connected").Return(true, nil)
-	fakeClient.On("GetOffset", "testTopic", int32(0), int64(-2)).Return(int64(20), nil)
-	fakeBroker.On("Fetch", mock.Anything).Return(&sarama.FetchResponse{}, errors.New("this is a test error"))
+	fakeBroker.On("Close").Return(nil)
+	fakeBroker.On("Open", mock.Anything).Return(nil)
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(&sarama.OffsetResponse{}, errors.New("this is a test error"))
 	fakeBroker.On("Close").Return(nil)
 	fakeBroker.On("Open", mock.Anything).Return(nil)
 
-	hwms, err := getHighWaterMarks(topicPartitions, fakeClient)
+	hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
 
-	assert.Nil(t, err)
 	assert.Equal(t, 0, len(hwms))
 }
 
 func Test_getHighWaterMarks_ClosedErr(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{HWMOffsetType: args.HWMOffsetLogEnd}
 	topicPartitions := TopicPartitions{"testTopic": {0}}
 	fakeClient := new(connection.MockClient)
 	fakeBroker := new(connection.MockBroker)
-	fakeFetchResponse := &sarama.FetchResponse{}
-	fakeFetchResponse.Blocks = map[string]map[int32]*sarama.FetchResponseBlock{
-		"testTopic": {0: {HighWaterMarkOffset: 20}},
-	}
+	fakeOffsetResponse := &sarama.OffsetResponse{}
+	fakeOffsetResponse.AddTopicPartition("testTopic", 0, 20)
 
 	fakeClient.On("Leader", "testTopic", int32(0)).Return(fakeBroker, nil)
 	fakeBroker.On("Connected").Return(false, nil)
 	fakeBroker.On("Open", mock.Anything).Return(errors.New("this is a test error"))
 
-	fakeClient.On("GetOffset", "testTopic", int32(0), int64(-2)).Return(int64(20), nil)
-	fakeBroker.On("Fetch", mock.Anything).Return(fakeFetchResponse, nil)
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(fakeOffsetResponse, nil)
 
-	_, err := getHighWaterMarks(topicPartitions, fakeClient)
+	hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
 
-	assert.Nil(t, err, "Expected an error, but it was nil")
+	assert.Equal(t, 0, len(hwms))
+}
+
+func Test_getHighWaterMarks_RequestPerBroker(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{HWMOffsetType: args.HWMOffsetLogEnd}
+	topicPartitions := TopicPartitions{"topic1": {0, 1, 2, 3}, "topic2": {0, 1, 2, 3}}
+	fakeClient := new(connection.MockClient)
+	brokers := []*connection.MockBroker{new(connection.MockBroker), new(connection.MockBroker)}
+
+	requests := 0
+	for i, broker := range brokers {
+		response := &sarama.OffsetResponse{}
+		for topic, partitions := range topicPartitions {
+			for _, partition := range partitions {
+				// Each broker leads every other partition
+				if int(partition)%len(brokers) != i {
+					continue
+				}
+				fakeClient.On("Leader", topic, partition).Return(broker, nil)
+				response.AddTopicPartition(topic, partition, int64(100+partition))
+			}
+		}
+
+		broker.On("Connected").Return(true, nil)
+		broker.On("Close").Return(nil)
+		broker.On("Open", mock.Anything).Return(nil)
+		broker.On("GetAvailableOffsets", mock.Anything).Return(response, nil).Run(func(mock.Arguments) { requests++ })
+	}
+
+	hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
+
+	assert.Equal(t, len(brokers), requests, "Expected a single request per broker rather than per partition")
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			assert.Equal(t, int64(100+partition), hwms[topic][partition])
+		}
+	}
+}
+
+// BenchmarkGetHighWaterMarks reports the ListOffsets requests made for the high water marks of 600 partitions led
+// by 3 brokers, which is a request per broker rather than per partition
+func BenchmarkGetHighWaterMarks(b *testing.B) {
+	kafkaArgs := testutils.SetupTestArgs()
+	topicPartitions := make(TopicPartitions)
+	for _, topic := range []string{"topic1", "topic2", "topic3"} {
+		for partition := int32(0); partition < 200; partition++ {
+			topicPartitions[topic] = append(topicPartitions[topic], partition)
+		}
+	}
+
+	fakeClient := new(connection.MockClient)
+	brokers := []*connection.MockBroker{new(connection.MockBroker), new(connection.MockBroker), new(connection.MockBroker)}
+	requests := 0
+	for i, broker := range brokers {
+		response := &sarama.OffsetResponse{}
+		for topic, partitions := range topicPartitions {
+			for _, partition := range partitions {
+				if int(partition)%len(brokers) != i {
+					continue
+				}
+				fakeClient.On("Leader", topic, partition).Return(broker, nil)
+				response.AddTopicPartition(topic, partition, 100)
+			}
+		}
+
+		broker.On("Connected").Return(true, nil)
+		broker.On("Close").Return(nil)
+		broker.On("Open", mock.Anything).Return(nil)
+		broker.On("GetAvailableOffsets", mock.Anything).Return(response, nil).Run(func(mock.Arguments) { requests++ })
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(requests)/float64(b.N), "requests/op")
+	b.ReportMetric(600, "partitions/op")
+}
+
+func Test_getHighWaterMarks_LeaderChanged(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{HWMOffsetType: args.HWMOffsetLogEnd}
+	topicPartitions := TopicPartitions{"testTopic": {0, 1}}
+	fakeClient := new(connection.MockClient)
+	oldLeader := new(connection.MockBroker)
+	newLeader := new(connection.MockBroker)
+
+	oldResponse := &sarama.OffsetResponse{}
+	oldResponse.AddTopicPartition("testTopic", 0, 10)
+	oldResponse.Blocks["testTopic"][1] = &sarama.OffsetResponseBlock{Err: sarama.ErrNotLeaderForPartition}
+	newResponse := &sarama.OffsetResponse{}
+	newResponse.AddTopicPartition("testTopic", 1, 11)
+
+	fakeClient.On("Leader", "testTopic", int32(0)).Return(oldLeader, nil)
+	fakeClient.On("Leader", "testTopic", int32(1)).Return(oldLeader, nil).Once()
+	fakeClient.On("RefreshMetadata", []string{"testTopic"}).Return(nil).Once()
+	fakeClient.On("Leader", "testTopic", int32(1)).Return(newLeader, nil).Once()
+	oldLeader.On("Connected").Return(true, nil)
+	oldLeader.On("Close").Return(nil)
+	oldLeader.On("Open", mock.Anything).Return(nil)
+	oldLeader.On("GetAvailableOffsets", mock.Anything).Return(oldResponse, nil).Once()
+	newLeader.On("Connected").Return(true, nil)
+	newLeader.On("Close").Return(nil)
+	newLeader.On("Open", mock.Anything).Return(nil)
+	newLeader.On("GetAvailableOffsets", mock.Anything).Return(newResponse, nil).Once()
+
+	hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
+
+	assert.Equal(t, int64(10), hwms["testTopic"][0])
+	assert.Equal(t, int64(11), hwms["testTopic"][1])
+}
+
+func Test_getHighWaterMarks_LeaderNotAvailable(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{HWMOffsetType: args.HWMOffsetLogEnd}
+	topicPartitions := TopicPartitions{"testTopic": {0, 1, 2}}
+	fakeClient := new(connection.MockClient)
+	fakeBroker := new(connection.MockBroker)
+
+	firstResponse := &sarama.OffsetResponse{}
+	firstResponse.AddTopicPartition("testTopic", 0, 10)
+	firstResponse.Blocks["testTopic"][1] = &sarama.OffsetResponseBlock{Err: sarama.ErrLeaderNotAvailable}
+	retryResponse := &sarama.OffsetResponse{}
+	retryResponse.AddTopicPartition("testTopic", 2, 12)
+	retryResponse.Blocks["testTopic"][1] = &sarama.OffsetResponseBlock{Err: sarama.ErrLeaderNotAvailable}
+
+	fakeClient.On("Leader", "testTopic", int32(0)).Return(fakeBroker, nil)
+	fakeClient.On("Leader", "testTopic", int32(1)).Return(fakeBroker, nil)
+	fakeClient.On("Leader", "testTopic", int32(2)).Return(fakeBroker, sarama.ErrLeaderNotAvailable).Once()
+	fakeClient.On("Leader", "testTopic", int32(2)).Return(fakeBroker, nil).Once()
+	fakeClient.On("RefreshMetadata", []string{"testTopic"}).Return(nil).Once()
+	fakeBroker.On("Connected").Return(true, nil)
+	fakeBroker.On("Close").Return(nil)
+	fakeBroker.On("Open", mock.Anything).Return(nil)
+	// Metadata is only refreshed and retried once, so a third request would fail
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(firstResponse, nil).Once()
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(retryResponse, nil).Once()
+
+	hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
+
+	assert.Equal(t, int64(10), hwms["testTopic"][0])
+	assert.Equal(t, int64(12), hwms["testTopic"][2])
+	_, ok := hwms["testTopic"][1]
+	assert.False(t, ok)
+
+	offsets := groupOffsets{"testTopic": {0: 5, 1: 5, 2: 5}}
+	for _, p := range populateOffsetStructs(offsets, hwms) {
+		assert.Equal(t, int64(5), *p.ConsumerOffset)
+		if p.Partition == "1" {
+			assert.Nil(t, p.HighWaterMark)
+			assert.Nil(t, p.ConsumerLag)
+		} else {
+			assert.NotNil(t, p.ConsumerLag)
+		}
+	}
+}
+
+func Test_getHighWaterMarks_OffsetType(t *testing.T) {
+	testCases := []struct {
+		offsetType     string
+		version        int16
+		isolationLevel sarama.IsolationLevel
+	}{
+		{args.HWMOffsetLogEnd, 0, sarama.ReadUncommitted},
+		{args.HWMOffsetLastStable, 2, sarama.ReadCommitted},
+	}
+
+	for _, tc := range testCases {
+		kafkaArgs := &args.KafkaArguments{HWMOffsetType: tc.offsetType}
+		topicPartitions := TopicPartitions{"testTopic": {0}}
+		fakeClient := new(connection.MockClient)
+		fakeBroker := new(connection.MockBroker)
+		fakeOffsetResponse := &sarama.OffsetResponse{Version: tc.version}
+		fakeOffsetResponse.AddTopicPartition("testTopic", 0, 20)
+
+		fakeClient.On("Leader", "testTopic", int32(0)).Return(fakeBroker, nil)
+		fakeBroker.On("Connected").Return(true, nil)
+		fakeBroker.On("Close").Return(nil)
+		fakeBroker.On("Open", mock.Anything).Return(nil)
+		fakeBroker.On("GetAvailableOffsets", mock.MatchedBy(func(request *sarama.OffsetRequest) bool {
+			return request.Version == tc.version && request.IsolationLevel == tc.isolationLevel
+		})).Return(fakeOffsetResponse, nil).Once()
+
+		hwms := getHighWaterMarks(kafkaArgs, topicPartitions, fakeClient)
+
+		assert.Equal(t, int64(20), hwms["testTopic"][0], tc.offsetType)
+		fakeBroker.AssertExpectations(t)
+	}
 }
 
 func Test_fillTopicPartitions(t *testing.T) {
@@ -112,6 +302,25 @@ func Test_fillTopicPartitions(t *testing.T) {
 	assert.Equal(t, 0, len(newTopicPartitions["testTopic"]))
 }
 
+func Test_fillTopicPartitions_ExplicitPartitions(t *testing.T) {
+	topicPartitions := TopicPartitions{
+		"explicitTopic": {1, 5, 2},
+		"allTopic":      {},
+		"missingTopic":  {0},
+	}
+	fakeClient := new(connection.MockClient)
+	fakeClient.On("Partitions", "explicitTopic").Return([]int32{0, 1, 2, 3}, nil)
+	fakeClient.On("Partitions", "allTopic").Return([]int32{0, 1}, nil)
+	fakeClient.On("Partitions", "missingTopic").Return([]int32{}, errors.New("unknown topic"))
+
+	newTopicPartitions := fillTopicPartitions("testGroup", topicPartitions, fakeClient)
+
+	assert.Equal(t, []int32{1, 2}, newTopicPartitions["explicitTopic"])
+	assert.Equal(t, []int32{0, 1}, newTopicPartitions["allTopic"])
+	// Partitions can't be validated without the topic's metadata, so they're left as requested
+	assert.Equal(t, []int32{0}, newTopicPartitions["missingTopic"])
+}
+
 func Test_populateOffsetStructs(t *testing.T) {
 	inputOffsets := groupOffsets{"testTopic": {0: 12}}
 	inputHwms := groupOffsets{"testTopic": {0: 13}}
@@ -123,3 +332,470 @@ func Test_populateOffsetStructs(t *testing.T) {
 	assert.Equal(t, int64(1), *partitionOffsets[0].ConsumerLag)
 
 }
+
+func Test_populateOffsetStructs_NoHighWaterMarks(t *testing.T) {
+	// Without high water marks every committed offset is reported without hwm or lag
+	partitionOffsets := populateOffsetStructs(groupOffsets{"testTopic": {0: 12, 1: -1}}, nil)
+	assert.Equal(t, 1, len(partitionOffsets))
+	assert.Equal(t, int64(12), *partitionOffsets[0].ConsumerOffset)
+	assert.Nil(t, partitionOffsets[0].HighWaterMark)
+	assert.Nil(t, partitionOffsets[0].ConsumerLag)
+}
+
+func Test_populateOffsetStructs_NegativeLag(t *testing.T) {
+	inputOffsets := groupOffsets{"testTopic": {0: 15, 1: 10}}
+	inputHwms := groupOffsets{"testTopic": {0: 13, 1: 12}}
+
+	partitionOffsets := populateOffsetStructs(inputOffsets, inputHwms)
+	assert.Equal(t, 2, len(partitionOffsets))
+	assert.Equal(t, int64(0), *partitionOffsets[0].ConsumerLag)
+	assert.Equal(t, 1, *partitionOffsets[0].LagClamped)
+	assert.Equal(t, int64(2), *partitionOffsets[1].ConsumerLag)
+	assert.Equal(t, 0, *partitionOffsets[1].LagClamped)
+}
+
+func Test_populateOffsetStructs_NoCommittedOffsets(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	// A group that never committed has no offsets, or -1 for partitions it never committed to
+	inputHwms := groupOffsets{"testTopic": {0: 13, 1: 20}}
+
+	assert.Equal(t, 0, len(populateOffsetStructs(groupOffsets{}, inputHwms)))
+
+	// A -1 offset is no offset, the partition only reports its hwm
+	partitionOffsets := populateOffsetStructs(groupOffsets{"testTopic": {0: -1, 1: 15}}, inputHwms)
+	assert.Equal(t, 2, len(partitionOffsets))
+	assert.Equal(t, "0", partitionOffsets[0].Partition)
+	assert.Nil(t, partitionOffsets[0].ConsumerOffset)
+	assert.Nil(t, partitionOffsets[0].ConsumerLag)
+	assert.Nil(t, partitionOffsets[0].LagClamped)
+	assert.Equal(t, int64(13), *partitionOffsets[0].HighWaterMark)
+	assert.Equal(t, "1", partitionOffsets[1].Partition)
+	assert.Equal(t, int64(5), *partitionOffsets[1].ConsumerLag)
+
+	stats := trackOffsets(kafkaArgs, nil, "testGroup", partitionOffsets)
+	assert.Equal(t, 1, stats.committedPartitions)
+	assert.Equal(t, []int64{5}, stats.lags)
+
+	// Without a hwm either a -1 offset reports nothing
+	assert.Equal(t, 0, len(populateOffsetStructs(groupOffsets{"otherTopic": {0: -1}}, inputHwms)))
+}
+
+func Test_getConsumerOffsets_NoCommittedOffset(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	fakeClient := new(connection.MockClient)
+	fakeBroker := new(connection.MockBroker)
+	fetchOffsetResponse := new(sarama.OffsetFetchResponse)
+	fetchOffsetResponse.AddBlock("testTopic", 0, &sarama.OffsetFetchResponseBlock{Offset: -1, Err: sarama.ErrNoError})
+	fetchOffsetResponse.AddBlock("testTopic", 1, &sarama.OffsetFetchResponseBlock{Offset: 7, Err: sarama.ErrNoError})
+
+	fakeClient.On("RefreshCoordinator", "testGroup").Return(nil)
+	fakeClient.On("Coordinator", "testGroup").Return(fakeBroker, nil)
+	fakeBroker.On("Connected").Return(false, nil)
+	fakeBroker.On("Open", mock.Anything).Return(nil)
+	fakeBroker.On("FetchOffset", mock.Anything).Return(fetchOffsetResponse, nil)
+
+	offsets, err := getConsumerOffsets(kafkaArgs, "testGroup", TopicPartitions{"testTopic": {0, 1}}, fakeClient)
+	assert.Nil(t, err)
+
+	partitionOffsets := populateOffsetStructs(offsets, groupOffsets{"testTopic": {0: 20, 1: 30}})
+	assert.Equal(t, 2, len(partitionOffsets))
+	assert.Nil(t, partitionOffsets[0].ConsumerOffset)
+	assert.Nil(t, partitionOffsets[0].ConsumerLag)
+	assert.Equal(t, int64(20), *partitionOffsets[0].HighWaterMark)
+	assert.Equal(t, int64(7), *partitionOffsets[1].ConsumerOffset)
+	assert.Equal(t, int64(23), *partitionOffsets[1].ConsumerLag)
+}
+
+func Test_populateOffsetStructs_Sorted(t *testing.T) {
+	inputOffsets := groupOffsets{"topicB": {0: 1}, "topicA": {10: 1, 2: 1, 9: 1}}
+	inputHwms := groupOffsets{"topicB": {0: 5}, "topicA": {10: 5, 2: 5}}
+
+	var order []string
+	for _, p := range populateOffsetStructs(inputOffsets, inputHwms) {
+		order = append(order, p.Topic+":"+p.Partition)
+	}
+	// Partitions without a hwm are sorted in with the rest
+	assert.Equal(t, []string{"topicA:2", "topicA:9", "topicA:10", "topicB:0"}, order)
+}
+
+func Test_excludeInternalTopics(t *testing.T) {
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "__consumer_offsets", Partition: 0},
+		{Topic: "topic", Partition: 0},
+		{Topic: "_single_underscore", Partition: 0},
+	}
+
+	assert.Equal(t, []*memberPartitionOffset{
+		{Topic: "topic", Partition: 0},
+		{Topic: "_single_underscore", Partition: 0},
+	}, excludeInternalTopics(partitionOffsets, nil))
+
+	// The metadata flag wins over the name
+	internalTopics := tc.InternalTopics{"__consumer_offsets": false, "topic": true}
+	assert.Equal(t, []*memberPartitionOffset{
+		{Topic: "__consumer_offsets", Partition: 0},
+		{Topic: "_single_underscore", Partition: 0},
+	}, excludeInternalTopics(partitionOffsets, internalTopics))
+}
+
+func Test_collectGroupPartitionOffsets_IncludeInternalTopics(t *testing.T) {
+	for _, includeInternal := range []bool{false, true} {
+		kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", IncludeInternalTopics: includeInternal}
+		i, _ := integration.New("test", "test")
+		partitionOffsets := []*memberPartitionOffset{
+			{Topic: "__consumer_offsets", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: -1}, Member: &sarama.GroupMemberDescription{}},
+			{Topic: "topic", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: -1}, Member: &sarama.GroupMemberDescription{}},
+		}
+
+		collectGroupPartitionOffsets(kafkaArgs, new(connection.MockClient), nil, nil, nil, "testGroup", partitionOffsets, nil, "", nil, 0, time.Now(), i)
+
+		var topics []interface{}
+		for _, entity := range i.Entities {
+			for _, metricSet := range entity.Metrics {
+				if topic, ok := metricSet.Metrics["topic"]; ok {
+					topics = append(topics, topic)
+				}
+			}
+		}
+		assert.Equal(t, includeInternal, len(topics) == 2)
+		assert.Contains(t, topics, "topic")
+	}
+}
+
+func Test_collectGroupPartitionOffsets_NoHighWaterMarks(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample", CollectHighWaterMarks: false}
+	i, _ := integration.New("test", "test")
+	// No Leader or GetAvailableOffsets requests are expected
+	fakeClient := new(connection.MockClient)
+
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "topic", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: 10}, Member: &sarama.GroupMemberDescription{}},
+	}
+	collectGroupPartitionOffsets(kafkaArgs, fakeClient, nil, nil, nil, "testGroup", partitionOffsets, nil, "", nil, 0, time.Now(), i)
+
+	fakeClient.AssertNotCalled(t, "Leader", mock.Anything, mock.Anything)
+	found := false
+	for _, entity := range i.Entities {
+		for _, metricSet := range entity.Metrics {
+			if metricSet.Metrics["partition"] != "0" {
+				continue
+			}
+			found = true
+			assert.Equal(t, float64(10), metricSet.Metrics["consumer.offset"])
+			assert.Nil(t, metricSet.Metrics["consumer.hwm"])
+			assert.Nil(t, metricSet.Metrics["consumer.lag"])
+		}
+	}
+	assert.True(t, found)
+}
+
+func Test_collectPartitionOffsetMetrics_CommitTimestamp(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	member := &sarama.GroupMemberDescription{}
+	block := &sarama.OffsetFetchResponseBlock{Offset: 10}
+	hwm, timestamp := int64(15), int64(1530886155628)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	collectPartitionOffsetMetrics(kafkaArgs, nil, &groupStats{}, "testGroup", member, nil, "topic", 0, block, &timestamp, &hwm, &wg, i)
+	collectPartitionOffsetMetrics(kafkaArgs, nil, &groupStats{}, "testGroup", member, nil, "topic", 1, block, nil, &hwm, &wg, i)
+
+	for partition, expected := range []interface{}{float64(timestamp), nil} {
+		metrics := i.Entities[partition].Metrics[0].Metrics
+		assert.Equal(t, strconv.Itoa(partition), metrics["partition"])
+		assert.Equal(t, expected, metrics["kafka.consumerOffset.lastCommitTimestamp"])
+	}
+}
+
+func Test_collectPartitionOffsetMetrics_NegativeLag(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	member := &sarama.GroupMemberDescription{}
+	stats := &groupStats{}
+	// The first hwm is below the committed offset
+	hwms := []int64{10, 20}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for partition := range hwms {
+		collectPartitionOffsetMetrics(kafkaArgs, nil, stats, "testGroup", member, nil, "topic", int32(partition), &sarama.OffsetFetchResponseBlock{Offset: 15}, nil, &hwms[partition], &wg, i)
+	}
+
+	for partition, expected := range []struct{ lag, clamped float64 }{{0, 1}, {5, 0}} {
+		metrics := i.Entities[partition].Metrics[0].Metrics
+		assert.Equal(t, expected.lag, metrics["consumer.lag"])
+		assert.Equal(t, expected.clamped, metrics["kafka.consumerLag.clampedNegative"])
+	}
+	assert.Equal(t, []int64{0, 5}, stats.lags)
+}
+
+func Test_collectPartitionOffsetMetrics_NoHighWaterMark(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", StallDetectionCycles: 1}
+	store := persist.NewInMemoryStore()
+	member := &sarama.GroupMemberDescription{}
+	stats := &groupStats{}
+
+	// The committed offset goes backwards between the runs, without a high water mark either time
+	var wg sync.WaitGroup
+	for _, offset := range []int64{20, 10} {
+		i, _ := integration.New("test", "test")
+		wg.Add(1)
+		collectPartitionOffsetMetrics(kafkaArgs, store, stats, "testGroup", member, nil, "topic", 0, &sarama.OffsetFetchResponseBlock{Offset: offset}, nil, nil, &wg, i)
+
+		metrics := i.Entities[0].Metrics[0].Metrics
+		assert.Equal(t, float64(offset), metrics["consumer.offset"])
+		_, ok := metrics["consumer.lag"]
+		assert.False(t, ok)
+		if offset == 10 {
+			assert.Equal(t, float64(1), metrics["kafka.consumerOffset.resetDetected"])
+		}
+	}
+
+	var state offsetState
+	_, err := store.Get(kafkaArgs.StateKeyPrefix()+":testGroup:topic:0", &state)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), state.Offset)
+	assert.Equal(t, 1, stats.tracked)
+	assert.Equal(t, 0, stats.stalledPartitions)
+	assert.Empty(t, stats.lags)
+}
+
+func Test_exceedsPartitionLimit(t *testing.T) {
+	assert.False(t, exceedsPartitionLimit(100000, 0))
+	assert.False(t, exceedsPartitionLimit(10, 10))
+	assert.True(t, exceedsPartitionLimit(11, 10))
+}
+
+func Test_collectGroupPartitionOffsets_SkipGroupsOverPartitions(t *testing.T) {
+	// The whole group is skipped, even with a subset cap that would collect some of its partitions
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", SkipGroupsOverPartitions: 2, MaxPartitionsPerGroup: 1}
+	i, _ := integration.New("test", "test")
+	// No requests are expected for a skipped group
+	fakeClient := new(connection.MockClient)
+
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "a", Partition: 0},
+		{Topic: "a", Partition: 1},
+		{Topic: "b", Partition: 0},
+	}
+	collectGroupPartitionOffsets(kafkaArgs, fakeClient, nil, nil, nil, "testGroup", partitionOffsets, nil, "", nil, 0, time.Now(), i)
+
+	assert.Empty(t, i.Entities)
+}
+
+func Test_limitPartitions(t *testing.T) {
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "b", Partition: 0},
+		{Topic: "a", Partition: 1},
+		{Topic: "a", Partition: 0},
+	}
+
+	out, skipped := limitPartitions(partitionOffsets, 0)
+	assert.Equal(t, 3, len(out))
+	assert.Equal(t, 0, skipped)
+
+	out, skipped = limitPartitions(partitionOffsets, 2)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, []*memberPartitionOffset{{Topic: "a", Partition: 0}, {Topic: "a", Partition: 1}}, out)
+}
+
+func Test_limitTopicPartitions(t *testing.T) {
+	topicPartitions := TopicPartitions{"b": {0}, "a": {1, 0}}
+	assert.Equal(t, 3, topicPartitions.count())
+
+	out, skipped := limitTopicPartitions(topicPartitions, 0)
+	assert.Equal(t, topicPartitions, out)
+	assert.Equal(t, 0, skipped)
+
+	out, skipped = limitTopicPartitions(topicPartitions, 2)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, TopicPartitions{"a": {0, 1}}, out)
+}
+
+func Test_collectGroupPartitionOffsets_MaxPartitionsPerGroup(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", MaxPartitionsPerGroup: 2}
+	i, _ := integration.New("test", "test")
+
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "b", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: -1}, Member: &sarama.GroupMemberDescription{}},
+		{Topic: "a", Partition: 1, Block: &sarama.OffsetFetchResponseBlock{Offset: -1}, Member: &sarama.GroupMemberDescription{}},
+		{Topic: "a", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: -1}, Member: &sarama.GroupMemberDescription{}},
+	}
+	collectGroupPartitionOffsets(kafkaArgs, new(connection.MockClient), nil, nil, nil, "testGroup", partitionOffsets, nil, "", nil, 0, time.Now(), i)
+
+	var topics []interface{}
+	for _, entity := range i.Entities {
+		for _, metricSet := range entity.Metrics {
+			if topic, ok := metricSet.Metrics["topic"]; ok {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	assert.Equal(t, []interface{}{"a", "a"}, topics)
+
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", integration.NewIDAttribute("clusterName", "testcluster"))
+	assert.Equal(t, "1", groupEntity.Metrics[0].Metrics["skippedPartitions"])
+}
+
+func Test_getConsumerOffsets_SingleRequest(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	groupName := "testGroup"
+	topicPartitions := TopicPartitions{"topicA": {0, 1, 2, 3}, "topicB": {0, 1, 2, 3}}
+	fakeClient := new(connection.MockClient)
+	fakeBroker := new(connection.MockBroker)
+	fetchOffsetResponse := new(sarama.OffsetFetchResponse)
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			fetchOffsetResponse.AddBlock(topic, partition, &sarama.OffsetFetchResponseBlock{Offset: int64(partition) + 10, Err: sarama.ErrNoError})
+		}
+	}
+
+	fakeClient.On("RefreshCoordinator", mock.Anything).Return(nil)
+	fakeClient.On("Coordinator", groupName).Return(fakeBroker, nil)
+	fakeBroker.On("Connected").Return(true, nil)
+	fakeBroker.On("FetchOffset", mock.MatchedBy(func(request *sarama.OffsetFetchRequest) bool {
+		return request.ConsumerGroup == groupName
+	})).Return(fetchOffsetResponse, nil).Once()
+	fakeBroker.On("Close").Return(nil)
+	fakeBroker.On("Open", mock.Anything).Return(nil)
+
+	offsets, err := getConsumerOffsets(kafkaArgs, groupName, topicPartitions, fakeClient)
+
+	// FetchOffset is only mocked once, so a request per partition would fail
+	assert.Nil(t, err)
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			assert.Equal(t, int64(partition)+10, offsets[topic][partition])
+		}
+	}
+}
+
+// encodeMemberAssignment encodes a consumer group member assignment in the Kafka protocol format
+func encodeMemberAssignment(topics map[string][]int32) []byte {
+	buf := []byte{0, 0} // version
+	buf = appendInt32(buf, int32(len(topics)))
+	for topic, partitions := range topics {
+		buf = append(buf, byte(len(topic)>>8), byte(len(topic)))
+		buf = append(buf, topic...)
+		buf = appendInt32(buf, int32(len(partitions)))
+		for _, partition := range partitions {
+			buf = appendInt32(buf, partition)
+		}
+	}
+	return appendInt32(buf, -1) // no user data
+}
+
+func appendInt32(buf []byte, i int32) []byte {
+	return append(buf, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+}
+
+func Test_collectOffsetsForConsumerGroup_SingleRequest(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	fakeClient := new(connection.MockClient)
+	fakeClusterAdmin := new(connection.MockClusterAdmin)
+
+	members := map[string]*sarama.GroupMemberDescription{
+		"member1": {ClientId: "client1", ClientHost: "/10.0.0.1", MemberAssignment: encodeMemberAssignment(map[string][]int32{"topic": {0, 1}})},
+		"member2": {ClientId: "client2", ClientHost: "/10.0.0.1", MemberAssignment: encodeMemberAssignment(map[string][]int32{"topic": {2, 3}})},
+	}
+
+	response := new(sarama.OffsetFetchResponse)
+	for partition := int32(0); partition < 4; partition++ {
+		response.AddBlock("topic", partition, &sarama.OffsetFetchResponseBlock{Offset: 5, Err: sarama.ErrNoError})
+	}
+
+	// Every committed offset of the group is fetched
+	fakeClusterAdmin.On("ListConsumerGroupOffsets", "testGroup", mock.MatchedBy(func(topicPartitions map[string][]int32) bool {
+		return topicPartitions == nil
+	})).Return(response, nil).Once()
+	fakeBroker := new(connection.MockBroker)
+	hwmResponse := new(sarama.OffsetResponse)
+	for partition := int32(0); partition < 4; partition++ {
+		hwmResponse.AddTopicPartition("topic", partition, 10)
+	}
+	fakeClient.On("Leader", "topic", mock.Anything).Return(fakeBroker, nil)
+	fakeBroker.On("Connected").Return(true, nil)
+	fakeBroker.On("Close").Return(nil)
+	fakeBroker.On("Open", mock.Anything).Return(nil)
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(hwmResponse, nil).Once()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collectOffsetsForConsumerGroup(kafkaArgs, fakeClient, nil, fakeClusterAdmin, nil, nil, nil, "testGroup", "cooperative-sticky", members, i, &wg)
+	wg.Wait()
+
+	// ListConsumerGroupOffsets is only mocked once, so a request per member would fail
+	// One entity per partition, plus the consumer group
+	assert.Equal(t, 5, len(i.Entities))
+	for _, e := range i.Entities {
+		if e.Metadata.Namespace == "ka-consumerGroup" {
+			assert.Equal(t, float64(1), e.Metrics[0].Metrics["kafka.consumerGroup.hasCommittedOffsets"])
+			assert.Equal(t, "cooperative-sticky", e.Metrics[0].Metrics["assignmentStrategy"])
+			assert.Equal(t, "/10.0.0.1", e.Metrics[0].Metrics["clientHosts"])
+			assert.Equal(t, "client1,client2", e.Metrics[0].Metrics["clientIDs"])
+			continue
+		}
+
+		clientID := e.Metrics[0].Metrics["clientID"]
+		if e.Metadata.Name == "0" || e.Metadata.Name == "1" {
+			assert.Equal(t, "client1", clientID)
+		} else {
+			assert.Equal(t, "client2", clientID)
+		}
+	}
+}
+
+func Test_collectOffsetsForConsumerGroup_SelectedPartitions(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	fakeClient := new(connection.MockClient)
+	fakeClusterAdmin := new(connection.MockClusterAdmin)
+
+	members := map[string]*sarama.GroupMemberDescription{
+		"member1": {ClientId: "client1", MemberAssignment: encodeMemberAssignment(map[string][]int32{"topic": {0, 1, 2, 3}, "other": {0}})},
+	}
+
+	response := new(sarama.OffsetFetchResponse)
+	for partition := int32(0); partition < 4; partition++ {
+		response.AddBlock("topic", partition, &sarama.OffsetFetchResponseBlock{Offset: 5, Err: sarama.ErrNoError})
+	}
+	response.AddBlock("other", 0, &sarama.OffsetFetchResponseBlock{Offset: 5, Err: sarama.ErrNoError})
+	fakeClusterAdmin.On("ListConsumerGroupOffsets", "testGroup", mock.Anything).Return(response, nil)
+
+	fakeBroker := new(connection.MockBroker)
+	hwmResponse := new(sarama.OffsetResponse)
+	hwmResponse.AddTopicPartition("topic", 1, 10)
+	hwmResponse.AddTopicPartition("topic", 3, 10)
+	hwmResponse.AddTopicPartition("other", 0, 10)
+	fakeClient.On("Leader", mock.Anything, mock.Anything).Return(fakeBroker, nil)
+	fakeBroker.On("Connected").Return(true, nil)
+	fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(hwmResponse, nil)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collectOffsetsForConsumerGroup(kafkaArgs, fakeClient, nil, fakeClusterAdmin, nil, TopicPartitions{"topic": {1, 3}}, nil, "testGroup", "range", members, i, &wg)
+	wg.Wait()
+
+	// Only the selected partitions of topic are collected, other isn't listed so all of its partitions are
+	var partitions []string
+	for _, e := range i.Entities {
+		if e.Metadata.Namespace != "ka-consumerGroup" {
+			partitions = append(partitions, e.Metrics[0].Metrics["topic"].(string)+"/"+e.Metadata.Name)
+		}
+	}
+	assert.ElementsMatch(t, []string{"topic/1", "topic/3", "other/0"}, partitions)
+}
+
+func Test_selectedPartitions(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	fakeClient := new(connection.MockClient)
+	assert.Nil(t, selectedPartitions(kafkaArgs, fakeClient))
+
+	// Partitions the topic doesn't have are skipped
+	kafkaArgs.ConsumerGroupPartitions = map[string][]int32{"topic": {1, 7}}
+	fakeClient.On("Partitions", "topic").Return([]int32{0, 1, 2, 3}, nil)
+	assert.Equal(t, TopicPartitions{"topic": {1}}, selectedPartitions(kafkaArgs, fakeClient))
+	assert.Equal(t, []int32{1, 7}, kafkaArgs.ConsumerGroupPartitions["topic"])
+}
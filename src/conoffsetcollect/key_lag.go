@@ -0,0 +1,139 @@
+package conoffsetcollect
+
+import (
+	"strconv"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+)
+
+// partitionLags is the lag of the partitions of a consumer group, by topic and partition
+type partitionLags map[string]map[int32]int64
+
+func (l partitionLags) add(topic string, partition int32, lag int64) {
+	if l[topic] == nil {
+		l[topic] = make(map[int32]int64)
+	}
+	l[topic][partition] = lag
+}
+
+// memberPartitionLags returns the lag of the partitions of a group with a committed offset and a high water mark
+func memberPartitionLags(partitionOffsets []*memberPartitionOffset, hwms groupOffsets) partitionLags {
+	lags := make(partitionLags)
+	for _, p := range partitionOffsets {
+		if hwm, ok := hwms[p.Topic][p.Partition]; ok && p.Block.Offset != -1 {
+			lags.add(p.Topic, p.Partition, hwm-p.Block.Offset)
+		}
+	}
+
+	return lags
+}
+
+// offsetsPartitionLags returns the lag of the partitions of a group collected with consumer_groups
+func offsetsPartitionLags(offsetData []*partitionOffsets) partitionLags {
+	lags := make(partitionLags)
+	for _, offsets := range offsetData {
+		partition, err := strconv.Atoi(offsets.Partition)
+		if err != nil || offsets.ConsumerLag == nil {
+			continue
+		}
+		lags.add(offsets.Topic, int32(partition), *offsets.ConsumerLag)
+	}
+
+	return lags
+}
+
+// murmur2 is the hash the default partitioner of the Kafka Java producer assigns keyed records to partitions
+// with, a port of org.apache.kafka.common.utils.Utils.murmur2
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
+
+// keyPartition returns the partition of the numPartitions of a topic the default partitioner assigns key to
+func keyPartition(key string, numPartitions int) int32 {
+	return (murmur2([]byte(key)) & 0x7fffffff) % int32(numPartitions)
+}
+
+// setKeyLagMetrics reports the lag of the partition each of the lag_keys of the topics of a group is assigned to,
+// as kafka.key.consumerLag with the key as an attribute on the consumer group entity. Keys of partitions the
+// group has no lag for are skipped.
+func setKeyLagMetrics(kafkaArgs *args.KafkaArguments, consumerGroup string, lags partitionLags, client connection.Client, kafkaIntegration *integration.Integration) {
+	for topic, keys := range kafkaArgs.LagKeys {
+		if len(lags[topic]) == 0 {
+			continue
+		}
+
+		// Every partition of the topic, not only those of the group, to assign the keys like the producers do
+		partitions, err := client.Partitions(topic)
+		if err != nil || len(partitions) == 0 {
+			log.Warn("Unable to get the partitions of topic %s for its lag_keys of consumer group '%s': %v", topic, consumerGroup, err)
+			continue
+		}
+
+		groupEntity, err := kafkaIntegration.Entity(consumerGroup, "ka-consumerGroup", kafkaArgs.ClusterIDAttributes()...)
+		if err != nil {
+			collecterrors.Error("Unable to create entity for consumer group '%s': %s", consumerGroup, err.Error())
+			return
+		}
+
+		for _, key := range keys {
+			partition := keyPartition(key, len(partitions))
+			lag, ok := lags[topic][partition]
+			if !ok {
+				log.Debug("No lag for partition %d of topic %s, which key '%s' is assigned to, for consumer group '%s'", partition, topic, key, consumerGroup)
+				continue
+			}
+
+			metricSet := groupEntity.NewMetricSet(kafkaArgs.OffsetSampleName, append([]metric.Attribute{
+				{Key: "displayName", Value: groupEntity.Metadata.Name},
+				{Key: "entityName", Value: "consumerGroup:" + groupEntity.Metadata.Name},
+				{Key: "clusterName", Value: kafkaArgs.ClusterName},
+				{Key: "consumerGroup", Value: consumerGroup},
+				{Key: "topic", Value: topic},
+				{Key: "partition", Value: strconv.Itoa(int(partition))},
+				{Key: "key", Value: key},
+			}, kafkaArgs.TopicAttributes(topic)...)...)
+			if err := metricSet.SetMetric("kafka.key.consumerLag", lag, metric.GAUGE); err != nil {
+				collecterrors.Error("Failed to set metric kafka.key.consumerLag: %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package conoffsetcollect
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_murmur2(t *testing.T) {
+	// The test cases of the Kafka Java client's Utils.murmur2
+	for data, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		assert.Equal(t, expected, murmur2([]byte(data)), data)
+	}
+}
+
+func Test_keyPartition(t *testing.T) {
+	// -790332482 & 0x7fffffff = 1357151166
+	assert.Equal(t, int32(1357151166%6), keyPartition("foobar", 6))
+	assert.Equal(t, int32(0), keyPartition("foobar", 1))
+}
+
+func Test_setKeyLagMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{
+		ClusterName:      "testcluster",
+		OffsetSampleName: "KafkaOffsetSample",
+		LagKeys:          map[string][]string{"topic": {"foobar", "abc"}, "other": {"21"}},
+	}
+	i, _ := integration.New("test", "1.0.0")
+
+	fakeClient := new(connection.MockClient)
+	fakeClient.On("Partitions", "topic").Return([]int32{0, 1, 2, 3, 4, 5}, nil)
+
+	fooPartition := keyPartition("foobar", 6)
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "topic", Partition: fooPartition, Block: &sarama.OffsetFetchResponseBlock{Offset: 10}},
+	}
+	lags := memberPartitionLags(partitionOffsets, groupOffsets{"topic": {fooPartition: 25}})
+	setKeyLagMetrics(kafkaArgs, "testGroup", lags, fakeClient, i)
+
+	// The partition of the other key isn't collected, and the other topic has no lag for the group
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", integration.NewIDAttribute("clusterName", "testcluster"))
+	assert.Len(t, groupEntity.Metrics, 1)
+	metrics := groupEntity.Metrics[0].Metrics
+	assert.Equal(t, "foobar", metrics["key"])
+	assert.Equal(t, "topic", metrics["topic"])
+	assert.Equal(t, float64(15), metrics["kafka.key.consumerLag"])
+	fakeClient.AssertNotCalled(t, "Partitions", "other")
+}
+
+func Test_offsetsPartitionLags(t *testing.T) {
+	lag := int64(7)
+	lags := offsetsPartitionLags([]*partitionOffsets{
+		{Topic: "topic", Partition: "2", ConsumerLag: &lag},
+		{Topic: "topic", Partition: "3"},
+	})
+	assert.Equal(t, partitionLags{"topic": {2: 7}}, lags)
+}
@@ -0,0 +1,81 @@
+package conoffsetcollect
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errShortAssignment = errors.New("member assignment is truncated")
+
+// decodeMemberAssignment decodes the topic partitions of a consumer group member assignment. Every assignor,
+// range, roundrobin, sticky and cooperative-sticky included, assigns in the same consumer protocol format
+// of a version, the topic partitions and the user data of the assignor. Only the topic partitions are decoded,
+// so the user data and fields of newer versions don't fail the decoding the way
+// sarama.GroupMemberDescription.GetMemberAssignment does. An empty assignment, of a member that is still
+// joining the group, has no partitions.
+func decodeMemberAssignment(data []byte) (map[string][]int32, error) {
+	topics := make(map[string][]int32)
+	if len(data) == 0 {
+		return topics, nil
+	}
+
+	decoder := assignmentDecoder{data: data}
+	decoder.int16() // version
+	topicCount := decoder.int32()
+	for i := int32(0); i < topicCount && decoder.err == nil; i++ {
+		topic := decoder.string()
+		partitionCount := decoder.int32()
+		for j := int32(0); j < partitionCount && decoder.err == nil; j++ {
+			partition := decoder.int32()
+			if decoder.err == nil {
+				topics[topic] = append(topics[topic], partition)
+			}
+		}
+	}
+
+	if decoder.err != nil {
+		return nil, decoder.err
+	}
+
+	return topics, nil
+}
+
+// assignmentDecoder reads big endian protocol fields from data, recording the first error
+type assignmentDecoder struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (d *assignmentDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data)-d.off < n {
+		d.err = errShortAssignment
+		return nil
+	}
+
+	field := d.data[d.off : d.off+n]
+	d.off += n
+	return field
+}
+
+func (d *assignmentDecoder) int16() int16 {
+	if field := d.next(2); field != nil {
+		return int16(binary.BigEndian.Uint16(field))
+	}
+	return 0
+}
+
+func (d *assignmentDecoder) int32() int32 {
+	if field := d.next(4); field != nil {
+		return int32(binary.BigEndian.Uint32(field))
+	}
+	return 0
+}
+
+func (d *assignmentDecoder) string() string {
+	length := d.int16()
+	return string(d.next(int(length)))
+}
@@ -0,0 +1,168 @@
+package conoffsetcollect
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// topicAssignment is the partitions of a topic in an encoded assignment, kept in order
+type topicAssignment struct {
+	topic      string
+	partitions []int32
+}
+
+// encodeAssignment encodes a member assignment the way the consumer protocol does, with userData written as
+// nullable bytes and extra appended after it
+func encodeAssignment(version int16, topics []topicAssignment, userData []byte, extra ...byte) []byte {
+	buf := []byte{byte(version >> 8), byte(version)}
+	buf = appendInt32(buf, int32(len(topics)))
+	for _, t := range topics {
+		buf = append(buf, byte(len(t.topic)>>8), byte(len(t.topic)))
+		buf = append(buf, t.topic...)
+		buf = appendInt32(buf, int32(len(t.partitions)))
+		for _, partition := range t.partitions {
+			buf = appendInt32(buf, partition)
+		}
+	}
+
+	if userData == nil {
+		buf = appendInt32(buf, -1)
+	} else {
+		buf = appendInt32(buf, int32(len(userData)))
+		buf = append(buf, userData...)
+	}
+	return append(buf, extra...)
+}
+
+func Test_decodeMemberAssignment(t *testing.T) {
+	testCases := []struct {
+		name       string
+		assignment []byte
+		expected   map[string][]int32
+	}{
+		{
+			// The range assignor assigns contiguous partitions of each topic
+			name:       "range",
+			assignment: encodeAssignment(0, []topicAssignment{{"orders", []int32{0, 1, 2}}, {"payments", []int32{0, 1}}}, nil),
+			expected:   map[string][]int32{"orders": {0, 1, 2}, "payments": {0, 1}},
+		},
+		{
+			// The roundrobin assignor interleaves the partitions of every subscribed topic
+			name:       "roundrobin",
+			assignment: encodeAssignment(0, []topicAssignment{{"orders", []int32{1, 3}}, {"payments", []int32{0}}}, []byte{}),
+			expected:   map[string][]int32{"orders": {1, 3}, "payments": {0}},
+		},
+		{
+			// The sticky assignors can carry user data, and newer clients write version 1 assignments
+			name:       "sticky",
+			assignment: encodeAssignment(1, []topicAssignment{{"orders", []int32{4, 0}}}, []byte{0, 1, 2, 3, 4}),
+			expected:   map[string][]int32{"orders": {4, 0}},
+		},
+		{
+			name:       "trailing fields of a newer version",
+			assignment: encodeAssignment(3, []topicAssignment{{"orders", []int32{2}}}, nil, 0, 0, 0, 7),
+			expected:   map[string][]int32{"orders": {2}},
+		},
+		{
+			name:       "member still joining",
+			assignment: []byte{},
+			expected:   map[string][]int32{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			topics, err := decodeMemberAssignment(tc.assignment)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, topics)
+		})
+	}
+}
+
+func Test_decodeMemberAssignment_WithoutUserData(t *testing.T) {
+	// Some clients leave the user data out altogether
+	assignment := encodeAssignment(0, []topicAssignment{{"orders", []int32{5}}}, nil)
+
+	topics, err := decodeMemberAssignment(assignment[:len(assignment)-4])
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]int32{"orders": {5}}, topics)
+}
+
+func Test_decodeMemberAssignment_Truncated(t *testing.T) {
+	assignment := encodeAssignment(0, []topicAssignment{{"orders", []int32{0, 1}}}, nil)
+
+	_, err := decodeMemberAssignment(assignment[:len(assignment)-4-2])
+	assert.Equal(t, errShortAssignment, err)
+}
+
+func Test_collectOffsetsForConsumerGroup_UnassignedCommittedPartitions(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	fakeClusterAdmin := new(connection.MockClusterAdmin)
+
+	members := map[string]*sarama.GroupMemberDescription{
+		"member1": {ClientId: "client1", MemberAssignment: encodeAssignment(1, []topicAssignment{{"topic", []int32{0, 1}}}, []byte{9})},
+	}
+
+	// Partition 1 is assigned but never committed, partition 2 is committed but no longer assigned
+	response := new(sarama.OffsetFetchResponse)
+	response.AddBlock("topic", 0, &sarama.OffsetFetchResponseBlock{Offset: 5, Err: sarama.ErrNoError})
+	response.AddBlock("topic", 2, &sarama.OffsetFetchResponseBlock{Offset: 7, Err: sarama.ErrNoError})
+	fakeClusterAdmin.On("ListConsumerGroupOffsets", "testGroup", mock.MatchedBy(func(topicPartitions map[string][]int32) bool {
+		return topicPartitions == nil
+	})).Return(response, nil).Once()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collectOffsetsForConsumerGroup(kafkaArgs, new(connection.MockClient), nil, fakeClusterAdmin, nil, nil, nil, "testGroup", "", members, i, &wg)
+	wg.Wait()
+
+	offsets := make(map[string]interface{})
+	clientIDs := make(map[string]interface{})
+	activeMembers := make(map[string]interface{})
+	for _, e := range i.Entities {
+		if e.Metadata.Namespace == "ka-partition-consumer" {
+			offsets[e.Metadata.Name] = e.Metrics[0].Metrics["consumer.offset"]
+			clientIDs[e.Metadata.Name] = e.Metrics[0].Metrics["clientID"]
+			activeMembers[e.Metadata.Name] = e.Metrics[0].Metrics["kafka.partition.hasActiveMember"]
+		}
+	}
+	assert.Equal(t, map[string]interface{}{"0": float64(5), "1": nil, "2": float64(7)}, offsets)
+	assert.Equal(t, map[string]interface{}{"0": "client1", "1": "client1", "2": ""}, clientIDs)
+	// The orphaned partition is still reported, marked as having no active member
+	assert.Equal(t, map[string]interface{}{"0": float64(1), "1": float64(1), "2": float64(0)}, activeMembers)
+}
+
+func Test_collectOffsetsForConsumerGroup_AssignedPartitionsBefore0102(t *testing.T) {
+	version := sarama.V0_10_1_0
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", KafkaVersion: &version}
+	i, _ := integration.New("test", "test")
+	fakeClusterAdmin := new(connection.MockClusterAdmin)
+
+	members := map[string]*sarama.GroupMemberDescription{
+		"member1": {ClientId: "client1", MemberAssignment: encodeAssignment(0, []topicAssignment{{"topic", []int32{0}}}, nil)},
+	}
+
+	response := new(sarama.OffsetFetchResponse)
+	response.AddBlock("topic", 0, &sarama.OffsetFetchResponseBlock{Offset: 5, Err: sarama.ErrNoError})
+	fakeClusterAdmin.On("ListConsumerGroupOffsets", "testGroup", map[string][]int32{"topic": {0}}).Return(response, nil).Once()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collectOffsetsForConsumerGroup(kafkaArgs, new(connection.MockClient), nil, fakeClusterAdmin, nil, nil, nil, "testGroup", "", members, i, &wg)
+	wg.Wait()
+
+	fakeClusterAdmin.AssertExpectations(t)
+
+	// Without members nothing can be requested
+	wg.Add(1)
+	collectOffsetsForConsumerGroup(kafkaArgs, new(connection.MockClient), nil, fakeClusterAdmin, nil, nil, nil, "emptyGroup", "", nil, i, &wg)
+	wg.Wait()
+}
@@ -0,0 +1,77 @@
+package conoffsetcollect
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+)
+
+// maxMemberSummaryValues is the most distinct client hosts or client IDs listed in the clientHosts and clientIDs
+// attributes of a consumer group, to bound the size of the sample of groups with many members
+const maxMemberSummaryValues = 20
+
+// memberSummary is the distinct client hosts and client IDs of the members of a consumer group
+type memberSummary struct {
+	clientHosts []string
+	clientIDs   []string
+}
+
+// summarizeMembers returns the sorted distinct client hosts and client IDs of members
+func summarizeMembers(members map[string]*sarama.GroupMemberDescription) *memberSummary {
+	hosts := make(map[string]bool)
+	clientIDs := make(map[string]bool)
+	for _, member := range members {
+		if member.ClientHost != "" {
+			hosts[member.ClientHost] = true
+		}
+		if member.ClientId != "" {
+			clientIDs[member.ClientId] = true
+		}
+	}
+
+	return &memberSummary{clientHosts: sortedKeys(hosts), clientIDs: sortedKeys(clientIDs)}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// setMetrics reports the number of distinct client hosts and client IDs of the group, and lists them in the
+// comma separated clientHosts and clientIDs attributes. Only the first maxMemberSummaryValues of each are
+// listed, in which case the membersTruncated attribute is true.
+func (m *memberSummary) setMetrics(metricSet *metric.Set) error {
+	if err := metricSet.SetMetric("kafka.consumerGroup.clientHosts", len(m.clientHosts), metric.GAUGE); err != nil {
+		return err
+	}
+	if err := metricSet.SetMetric("kafka.consumerGroup.clientIds", len(m.clientIDs), metric.GAUGE); err != nil {
+		return err
+	}
+
+	truncated := false
+	for name, values := range map[string][]string{"clientHosts": m.clientHosts, "clientIDs": m.clientIDs} {
+		if len(values) == 0 {
+			continue
+		}
+		if len(values) > maxMemberSummaryValues {
+			values = values[:maxMemberSummaryValues]
+			truncated = true
+		}
+		if err := metricSet.SetMetric(name, strings.Join(values, ","), metric.ATTRIBUTE); err != nil {
+			return err
+		}
+	}
+
+	if truncated {
+		return metricSet.SetMetric("membersTruncated", "true", metric.ATTRIBUTE)
+	}
+
+	return nil
+}
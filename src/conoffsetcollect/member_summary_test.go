@@ -0,0 +1,55 @@
+package conoffsetcollect
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_summarizeMembers(t *testing.T) {
+	members := map[string]*sarama.GroupMemberDescription{
+		"member1": {ClientId: "orders", ClientHost: "/10.0.0.2"},
+		"member2": {ClientId: "orders", ClientHost: "/10.0.0.1"},
+		"member3": {ClientId: "billing", ClientHost: "/10.0.0.2"},
+		"member4": {},
+	}
+
+	summary := summarizeMembers(members)
+	assert.Equal(t, []string{"/10.0.0.1", "/10.0.0.2"}, summary.clientHosts)
+	assert.Equal(t, []string{"billing", "orders"}, summary.clientIDs)
+
+	i, _ := integration.New("test", "test")
+	e, _ := i.Entity("testGroup", "ka-consumerGroup")
+	metricSet := e.NewMetricSet("KafkaOffsetSample")
+	assert.NoError(t, summary.setMetrics(metricSet))
+
+	assert.Equal(t, float64(2), metricSet.Metrics["kafka.consumerGroup.clientHosts"])
+	assert.Equal(t, float64(2), metricSet.Metrics["kafka.consumerGroup.clientIds"])
+	assert.Equal(t, "/10.0.0.1,/10.0.0.2", metricSet.Metrics["clientHosts"])
+	assert.Equal(t, "billing,orders", metricSet.Metrics["clientIDs"])
+	assert.NotContains(t, metricSet.Metrics, "membersTruncated")
+}
+
+func Test_memberSummary_Truncated(t *testing.T) {
+	members := make(map[string]*sarama.GroupMemberDescription)
+	for member := 0; member < maxMemberSummaryValues+5; member++ {
+		members[fmt.Sprintf("member%d", member)] = &sarama.GroupMemberDescription{
+			ClientId:   "orders",
+			ClientHost: fmt.Sprintf("/10.0.0.%03d", member),
+		}
+	}
+
+	i, _ := integration.New("test", "test")
+	e, _ := i.Entity("testGroup", "ka-consumerGroup")
+	metricSet := e.NewMetricSet("KafkaOffsetSample")
+	assert.NoError(t, summarizeMembers(members).setMetrics(metricSet))
+
+	// The count has every host, the attribute only the first ones
+	assert.Equal(t, float64(maxMemberSummaryValues+5), metricSet.Metrics["kafka.consumerGroup.clientHosts"])
+	assert.Len(t, metricSet.Metrics["clientHosts"], maxMemberSummaryValues*len("/10.0.0.000,")-1)
+	assert.Equal(t, "orders", metricSet.Metrics["clientIDs"])
+	assert.Equal(t, "true", metricSet.Metrics["membersTruncated"])
+}
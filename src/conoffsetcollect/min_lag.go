@@ -0,0 +1,65 @@
+package conoffsetcollect
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+)
+
+// groupLag sums the lag of the partitions of a group with a committed offset and a high water mark, counting
+// negative lags as 0. hasLag is false if no partition has a lag, such as without high water marks.
+func groupLag(partitionOffsets []*memberPartitionOffset, hwms groupOffsets) (lag int64, hasLag bool) {
+	for _, p := range partitionOffsets {
+		hwm, ok := hwms[p.Topic][p.Partition]
+		if !ok || p.Block.Offset == -1 {
+			continue
+		}
+
+		hasLag = true
+		if hwm > p.Block.Offset {
+			lag += hwm - p.Block.Offset
+		}
+	}
+
+	return lag, hasLag
+}
+
+// offsetsLag sums the lag of the partitions of a group collected with consumer_groups
+func offsetsLag(offsetData []*partitionOffsets) (lag int64, hasLag bool) {
+	for _, offsets := range offsetData {
+		if offsets.ConsumerLag == nil {
+			continue
+		}
+
+		hasLag = true
+		lag += *offsets.ConsumerLag
+	}
+
+	return lag, hasLag
+}
+
+// belowMinLag returns true if min_lag_to_report is set and lag, the total lag of a group, is below it
+func belowMinLag(kafkaArgs *args.KafkaArguments, lag int64) bool {
+	return kafkaArgs.MinLagToReport > 0 && lag < int64(kafkaArgs.MinLagToReport)
+}
+
+// setBelowMinLagMetrics reports only the total lag of a group below min_lag_to_report on the group entity, in
+// place of its partition and group metrics, so the group is still seen every run
+func setBelowMinLagMetrics(kafkaArgs *args.KafkaArguments, consumerGroup string, lag int64, kafkaIntegration *integration.Integration) error {
+	groupEntity, err := kafkaIntegration.Entity(consumerGroup, "ka-consumerGroup", kafkaArgs.ClusterIDAttributes()...)
+	if err != nil {
+		return err
+	}
+
+	metricSet := groupEntity.NewMetricSet(kafkaArgs.OffsetSampleName,
+		metric.Attribute{Key: "displayName", Value: groupEntity.Metadata.Name},
+		metric.Attribute{Key: "entityName", Value: "consumerGroup:" + groupEntity.Metadata.Name},
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+		metric.Attribute{Key: "consumerGroup", Value: consumerGroup},
+	)
+	if err := metricSet.SetMetric("kafka.consumerGroup.totalLag", lag, metric.GAUGE); err != nil {
+		return err
+	}
+
+	return metricSet.SetMetric("kafka.consumerGroup.belowMinLag", 1, metric.GAUGE)
+}
@@ -0,0 +1,97 @@
+package conoffsetcollect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_groupLag(t *testing.T) {
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "topic", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: 10}},
+		{Topic: "topic", Partition: 1, Block: &sarama.OffsetFetchResponseBlock{Offset: 30}},
+		{Topic: "topic", Partition: 2, Block: &sarama.OffsetFetchResponseBlock{Offset: -1}},
+		{Topic: "topic", Partition: 3, Block: &sarama.OffsetFetchResponseBlock{Offset: 5}},
+	}
+	// Partition 1 is ahead of its hwm, partition 2 has no committed offset and partition 3 no hwm
+	hwms := groupOffsets{"topic": {0: 20, 1: 25, 2: 100}}
+
+	lag, hasLag := groupLag(partitionOffsets, hwms)
+	assert.True(t, hasLag)
+	assert.Equal(t, int64(10), lag)
+
+	_, hasLag = groupLag(partitionOffsets, nil)
+	assert.False(t, hasLag)
+}
+
+func Test_offsetsLag(t *testing.T) {
+	lag1, lag2 := int64(3), int64(4)
+	lag, hasLag := offsetsLag([]*partitionOffsets{{ConsumerLag: &lag1}, {ConsumerLag: &lag2}, {}})
+	assert.True(t, hasLag)
+	assert.Equal(t, int64(7), lag)
+
+	_, hasLag = offsetsLag([]*partitionOffsets{{}})
+	assert.False(t, hasLag)
+}
+
+func Test_belowMinLag(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	assert.False(t, belowMinLag(kafkaArgs, 0))
+
+	kafkaArgs.MinLagToReport = 10
+	assert.True(t, belowMinLag(kafkaArgs, 9))
+	assert.False(t, belowMinLag(kafkaArgs, 10))
+}
+
+func Test_collectGroupPartitionOffsets_MinLagToReport(t *testing.T) {
+	testCases := []struct {
+		minLag        int
+		reportsGroup  bool
+		expectedLag   interface{}
+		expectedBelow interface{}
+	}{
+		{minLag: 5, reportsGroup: true},
+		{minLag: 50, reportsGroup: false, expectedLag: float64(10), expectedBelow: float64(1)},
+	}
+
+	for _, tc := range testCases {
+		kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample", CollectHighWaterMarks: true, MinLagToReport: tc.minLag}
+		i, _ := integration.New("test", "test")
+
+		fakeClient := new(connection.MockClient)
+		fakeBroker := new(connection.MockBroker)
+		fakeOffsetResponse := &sarama.OffsetResponse{}
+		fakeOffsetResponse.AddTopicPartition("topic", 0, 20)
+		fakeClient.On("Leader", "topic", int32(0)).Return(fakeBroker, nil)
+		fakeBroker.On("Connected").Return(true, nil)
+		fakeBroker.On("Close").Return(nil)
+		fakeBroker.On("Open", mock.Anything).Return(nil)
+		fakeBroker.On("GetAvailableOffsets", mock.Anything).Return(fakeOffsetResponse, nil)
+
+		partitionOffsets := []*memberPartitionOffset{
+			{Topic: "topic", Partition: 0, Block: &sarama.OffsetFetchResponseBlock{Offset: 10}, Member: &sarama.GroupMemberDescription{}},
+		}
+		collectGroupPartitionOffsets(kafkaArgs, fakeClient, nil, nil, nil, "testGroup", partitionOffsets, nil, "", nil, 0, time.Now(), i)
+
+		clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+		partitionEntity, _ := i.Entity("0", "ka-partition-consumer", clusterIDAttr,
+			integration.NewIDAttribute("consumerGroup", "testGroup"),
+			integration.NewIDAttribute("topic", "topic"),
+			integration.NewIDAttribute("partition", "0"))
+		assert.Equal(t, tc.reportsGroup, len(partitionEntity.Metrics) > 0)
+
+		groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+		assert.Len(t, groupEntity.Metrics, 1)
+		assert.Equal(t, tc.expectedLag, groupEntity.Metrics[0].Metrics["kafka.consumerGroup.totalLag"])
+		assert.Equal(t, tc.expectedBelow, groupEntity.Metrics[0].Metrics["kafka.consumerGroup.belowMinLag"])
+		if tc.reportsGroup {
+			assert.NotNil(t, groupEntity.Metrics[0].Metrics["kafka.consumerGroup.hasCommittedOffsets"])
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package conoffsetcollect
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// offsetsRetentionConfig is the broker config of how long the committed offsets of a group are kept
+// after its last commit, or since Kafka 2.1 after the group became empty
+const offsetsRetentionConfig = "offsets.retention.minutes"
+
+// retentionWarningFraction is the fraction of the offsets retention left below which a group is warned about
+const retentionWarningFraction = 0.1
+
+// defaultOffsetsRetention returns the offsets.retention.minutes default of the Kafka version, which is a day
+// before Kafka 2.0 and a week since
+func defaultOffsetsRetention(kafkaArgs *args.KafkaArguments) time.Duration {
+	if kafkaArgs.KafkaVersion != nil && !kafkaArgs.KafkaVersion.IsAtLeast(sarama.V2_0_0_0) {
+		return 24 * time.Hour
+	}
+
+	return 7 * 24 * time.Hour
+}
+
+// offsetsRetention returns the offsets.retention.minutes of a broker of the cluster, assuming every broker
+// has the same. The default of the Kafka version is returned if the config can't be described.
+func offsetsRetention(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, clusterAdmin sarama.ClusterAdmin) time.Duration {
+	brokerIDs, err := zookeeper.GetBrokerIDs(zkConn)
+	if err != nil || len(brokerIDs) == 0 {
+		log.Debug("Unable to get a broker to describe %s of, using the default: %v", offsetsRetentionConfig, err)
+		return defaultOffsetsRetention(kafkaArgs)
+	}
+
+	entries, err := clusterAdmin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.BrokerResource,
+		Name:        brokerIDs[0],
+		ConfigNames: []string{offsetsRetentionConfig},
+	})
+	if err != nil {
+		log.Debug("Unable to describe %s of broker %s, using the default: %s", offsetsRetentionConfig, brokerIDs[0], err.Error())
+		return defaultOffsetsRetention(kafkaArgs)
+	}
+
+	for _, entry := range entries {
+		if entry.Name != offsetsRetentionConfig {
+			continue
+		}
+
+		minutes, err := strconv.ParseInt(entry.Value, 10, 64)
+		if err != nil || minutes <= 0 {
+			log.Debug("Invalid %s '%s' on broker %s, using the default", offsetsRetentionConfig, entry.Value, brokerIDs[0])
+			break
+		}
+		return time.Duration(minutes) * time.Minute
+	}
+
+	return defaultOffsetsRetention(kafkaArgs)
+}
+
+// retentionRemaining returns the milliseconds from now until the committed offsets of a group expire, from the
+// latest commit timestamp of its partitions. Returns nil if retention isn't known or no partition has a commit
+// timestamp, which is the case with the admin offset collection strategy.
+func retentionRemaining(partitionOffsets []*memberPartitionOffset, retention time.Duration, now time.Time) *int64 {
+	if retention <= 0 {
+		return nil
+	}
+
+	var lastCommit *int64
+	for _, p := range partitionOffsets {
+		if p.CommitTimestamp != nil && (lastCommit == nil || *p.CommitTimestamp > *lastCommit) {
+			lastCommit = p.CommitTimestamp
+		}
+	}
+
+	if lastCommit == nil {
+		return nil
+	}
+
+	expiresMs := *lastCommit + int64(retention/time.Millisecond)
+	remaining := expiresMs - now.UnixNano()/int64(time.Millisecond)
+	return &remaining
+}
+
+// oldestCommit returns the earliest commit timestamp of the partitions of a group and its age in milliseconds at
+// now. A group whose oldest commit is old has partitions it stopped consuming, or was abandoned altogether.
+// Returns nils if no partition has a commit timestamp.
+func oldestCommit(partitionOffsets []*memberPartitionOffset, now time.Time) (timestamp, ageMs *int64) {
+	for _, p := range partitionOffsets {
+		if p.CommitTimestamp != nil && (timestamp == nil || *p.CommitTimestamp < *timestamp) {
+			timestamp = p.CommitTimestamp
+		}
+	}
+
+	if timestamp == nil {
+		return nil, nil
+	}
+
+	age := now.UnixNano()/int64(time.Millisecond) - *timestamp
+	return timestamp, &age
+}
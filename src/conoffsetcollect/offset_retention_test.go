@@ -0,0 +1,80 @@
+package conoffsetcollect
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_offsetsRetention(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	mockZk := &zookeeper.MockConnection{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	mockZk.On("Children", "/brokers/ids").Return([]string{"1", "2"}, new(zk.Stat), nil)
+	mockClusterAdmin.On("DescribeConfig", mock.MatchedBy(func(r sarama.ConfigResource) bool {
+		return r.Type == sarama.BrokerResource && r.Name == "1"
+	})).Return([]sarama.ConfigEntry{{Name: offsetsRetentionConfig, Value: "60"}}, nil).Once()
+
+	assert.Equal(t, time.Hour, offsetsRetention(kafkaArgs, mockZk, mockClusterAdmin))
+}
+
+func Test_offsetsRetention_Default(t *testing.T) {
+	mockZk := &zookeeper.MockConnection{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	mockZk.On("Children", "/brokers/ids").Return([]string{"1"}, new(zk.Stat), nil)
+	mockClusterAdmin.On("DescribeConfig", mock.Anything).Return([]sarama.ConfigEntry{}, errors.New("not authorized"))
+
+	kafkaArgs := &args.KafkaArguments{}
+	assert.Equal(t, 7*24*time.Hour, offsetsRetention(kafkaArgs, mockZk, mockClusterAdmin))
+
+	version := sarama.V1_1_0_0
+	kafkaArgs = &args.KafkaArguments{KafkaVersion: &version}
+	assert.Equal(t, 24*time.Hour, offsetsRetention(kafkaArgs, mockZk, mockClusterAdmin))
+}
+
+func Test_retentionRemaining(t *testing.T) {
+	now := time.Unix(1000, 0)
+	older, newer := now.Add(-2*time.Hour).UnixNano()/int64(time.Millisecond), now.Add(-time.Hour).UnixNano()/int64(time.Millisecond)
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "topic", Partition: 0, CommitTimestamp: &older},
+		{Topic: "topic", Partition: 1, CommitTimestamp: &newer},
+		{Topic: "topic", Partition: 2},
+	}
+
+	// The latest commit counts, an hour ago with a three hour retention
+	remaining := retentionRemaining(partitionOffsets, 3*time.Hour, now)
+	if assert.NotNil(t, remaining) {
+		assert.Equal(t, int64(2*time.Hour/time.Millisecond), *remaining)
+	}
+
+	assert.Nil(t, retentionRemaining(partitionOffsets, 0, now))
+	assert.Nil(t, retentionRemaining([]*memberPartitionOffset{{Topic: "topic"}}, time.Hour, now))
+}
+
+func Test_oldestCommit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	older, newer := now.Add(-2*time.Hour).UnixNano()/int64(time.Millisecond), now.Add(-time.Hour).UnixNano()/int64(time.Millisecond)
+	partitionOffsets := []*memberPartitionOffset{
+		{Topic: "topic", Partition: 0, CommitTimestamp: &newer},
+		{Topic: "topic", Partition: 1, CommitTimestamp: &older},
+		{Topic: "topic", Partition: 2},
+	}
+
+	timestamp, ageMs := oldestCommit(partitionOffsets, now)
+	if assert.NotNil(t, timestamp) && assert.NotNil(t, ageMs) {
+		assert.Equal(t, older, *timestamp)
+		assert.Equal(t, int64(2*time.Hour/time.Millisecond), *ageMs)
+	}
+
+	timestamp, ageMs = oldestCommit([]*memberPartitionOffset{{Topic: "topic"}}, now)
+	assert.Nil(t, timestamp)
+	assert.Nil(t, ageMs)
+}
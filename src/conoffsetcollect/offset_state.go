@@ -0,0 +1,383 @@
+package conoffsetcollect
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/statestore"
+)
+
+const (
+	// offsetStateName is the name of the default offset state file
+	offsetStateName = "com.newrelic.kafka-offsets"
+
+	// offsetStateTTL is how long persisted offsets stay valid. If the state file
+	// is older than this it is ignored and the run is treated as a first run.
+	offsetStateTTL = 1 * time.Hour
+)
+
+// now is the current time, overridable for testing
+var now = time.Now
+
+// offsetState is the state persisted between runs for each consumer group partition
+type offsetState struct {
+	Offset int64
+	// Timestamp is when Offset was collected, in milliseconds since the epoch
+	Timestamp int64
+	// StalledCycles is the number of consecutive runs the offset has not advanced while the partition had lag
+	StalledCycles int
+}
+
+// offsetStatus is the result of comparing a partition's committed offset to the previous run
+type offsetStatus struct {
+	ResetDetected int
+	Stalled       bool
+	// ConsumeRate is the number of messages consumed per second since the previous run.
+	// It is nil if no rate can be calculated for the interval.
+	ConsumeRate *float64
+}
+
+// groupStats accumulates the partition results of a consumer group so they can be reported on the
+// consumer group entity once all of its partitions are collected
+type groupStats struct {
+	lock sync.Mutex
+	// tracked is the number of partitions that had state from a previous run
+	tracked           int
+	stalledPartitions int
+	// skippedPartitions is the number of partitions not collected because of max_partitions_per_group
+	skippedPartitions int
+	// committedPartitions is the number of partitions the group has committed an offset for
+	committedPartitions int
+	// lags holds the lag of every partition with a lag, for the group lag percentiles
+	lags []int64
+	// ratedLag and consumeRate are the sums of the lag and consume rate of the partitions with a consume rate
+	ratedLag    int64
+	consumeRate float64
+	// started is when the collection of the group started, zero if it isn't timed
+	started time.Time
+	// coordinatorID is the broker ID of the group coordinator, nil if it isn't known
+	coordinatorID *int32
+	// generation is the generation ID of the group, nil if it isn't known. rebalances is the number of
+	// generation changes since the previous run, nil without a previous generation to compare to.
+	generation *int32
+	rebalances *int
+	// assignmentStrategy is the partition assignment protocol of the group, such as range or cooperative-sticky.
+	// Empty if it isn't known or the group has no members.
+	assignmentStrategy string
+	// members summarizes the client hosts and client IDs of the members of the group, nil if they aren't known
+	members *memberSummary
+	// retentionRemainingMs is the time until the committed offsets of the group expire, nil if it isn't known
+	retentionRemainingMs *int64
+	// oldestCommitTimestamp is the earliest commit timestamp of the partitions of the group and oldestCommitAgeMs
+	// its age, nil if the commit timestamps aren't known
+	oldestCommitTimestamp *int64
+	oldestCommitAgeMs     *int64
+}
+
+// add records the status and lag of a partition that had state from a previous run. lag is nil if it isn't
+// known, in which case the consume rate of the partition isn't counted towards the drain estimate.
+func (g *groupStats) add(status offsetStatus, lag *int64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.tracked++
+	if status.Stalled {
+		g.stalledPartitions++
+	}
+	if status.ConsumeRate != nil && lag != nil {
+		g.ratedLag += *lag
+		g.consumeRate += *status.ConsumeRate
+	}
+}
+
+// addCommitted records a partition the group has committed an offset for
+func (g *groupStats) addCommitted() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.committedPartitions++
+}
+
+// addLag records the lag of a partition
+func (g *groupStats) addLag(lag int64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.lags = append(g.lags, lag)
+}
+
+// lagPercentile returns the nearest-rank percentile p of lags. lags must be sorted and not empty.
+func lagPercentile(lags []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(lags))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return lags[rank-1]
+}
+
+// openOffsetStore opens the file store holding the committed offsets from the previous run, shared with the
+// clusters collected at the same time that use the same offset_state_path
+func openOffsetStore(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) (persist.Storer, error) {
+	path := kafkaArgs.OffsetStatePath
+	if path == "" {
+		path = persist.DefaultPath(offsetStateName)
+	}
+
+	return statestore.Open(path, kafkaIntegration.Logger(), offsetStateTTL)
+}
+
+// trackOffset records the committed offset of the consumer group partition and compares it and the lag
+// to the state recorded by the previous run. The returned bool is false if there is no previous state.
+func trackOffset(kafkaArgs *args.KafkaArguments, store persist.Storer, consumerGroup, topic, partition string, offset, lag int64) (offsetStatus, bool) {
+	if store == nil {
+		return offsetStatus{}, false
+	}
+
+	key := fmt.Sprintf("%s:%s:%s:%s", kafkaArgs.StateKeyPrefix(), consumerGroup, topic, partition)
+
+	var previous offsetState
+	_, err := store.Get(key, &previous)
+
+	current := offsetState{Offset: offset, Timestamp: now().UnixNano() / int64(time.Millisecond)}
+	if err == nil && offset == previous.Offset && lag > 0 {
+		current.StalledCycles = previous.StalledCycles + 1
+	}
+	store.Set(key, current)
+
+	if err != nil {
+		if err != persist.ErrNotFound {
+			log.Debug("Unable to read previous offset for %s: %s", key, err.Error())
+		}
+		return offsetStatus{}, false
+	}
+
+	var status offsetStatus
+	if offset < previous.Offset {
+		log.Info("Committed offset for consumer group '%s', topic %s, partition %s went backwards from %d to %d", consumerGroup, topic, partition, previous.Offset, offset)
+		status.ResetDetected = 1
+	}
+
+	status.ConsumeRate = consumeRate(previous, current)
+
+	if cycles := kafkaArgs.StallDetectionCycles; cycles > 0 && current.StalledCycles >= cycles {
+		status.Stalled = true
+	}
+
+	return status, true
+}
+
+// trackGeneration records the generation of the consumer group and returns the number of rebalances since the
+// generation recorded by the previous run, or nil if there is none. Each rebalance increments the generation,
+// so a lower generation means the group was deleted and recreated, which counts as a single rebalance.
+func trackGeneration(kafkaArgs *args.KafkaArguments, store persist.Storer, consumerGroup string, generation int32) *int {
+	if store == nil {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s:%s:generation", kafkaArgs.StateKeyPrefix(), consumerGroup)
+
+	var previous int32
+	_, err := store.Get(key, &previous)
+	store.Set(key, generation)
+
+	if err != nil {
+		if err != persist.ErrNotFound {
+			log.Debug("Unable to read previous generation for %s: %s", key, err.Error())
+		}
+		return nil
+	}
+
+	rebalances := int(generation - previous)
+	if generation < previous {
+		rebalances = 1
+	}
+
+	return &rebalances
+}
+
+// consumeRate calculates the messages consumed per second between two states. No rate is
+// returned if the clock went backwards or the offset was reset, since the delta is meaningless.
+func consumeRate(previous, current offsetState) *float64 {
+	elapsed := current.Timestamp - previous.Timestamp
+	if elapsed <= 0 || current.Offset < previous.Offset {
+		return nil
+	}
+
+	rate := float64(current.Offset-previous.Offset) / (float64(elapsed) / 1000)
+	return &rate
+}
+
+// trackOffsets sets the reset detection and consume rate fields on each of the collected partition offsets,
+// including those without a lag, and returns the partition results for the group
+func trackOffsets(kafkaArgs *args.KafkaArguments, store persist.Storer, consumerGroup string, offsetData []*partitionOffsets) *groupStats {
+	stats := &groupStats{}
+	for _, offsets := range offsetData {
+		if offsets.ConsumerOffset != nil {
+			stats.addCommitted()
+		}
+		if offsets.ConsumerOffset == nil {
+			continue
+		}
+
+		// An unknown lag is tracked as 0, which doesn't count as stalled
+		trackedLag := int64(0)
+		if offsets.ConsumerLag != nil {
+			trackedLag = *offsets.ConsumerLag
+			stats.addLag(trackedLag)
+		}
+		if status, ok := trackOffset(kafkaArgs, store, consumerGroup, offsets.Topic, offsets.Partition, *offsets.ConsumerOffset, trackedLag); ok {
+			offsets.ResetDetected = &status.ResetDetected
+			offsets.ConsumeRate = status.ConsumeRate
+			stats.add(status, offsets.ConsumerLag)
+		}
+	}
+
+	return stats
+}
+
+// laggingPartitions returns the number of the sorted partition lags that are above threshold
+func laggingPartitions(sortedLags []int64, threshold int64) int64 {
+	caughtUp := sort.Search(len(sortedLags), func(i int) bool { return sortedLags[i] > threshold })
+	return int64(len(sortedLags) - caughtUp)
+}
+
+// setGroupMetrics reports the accumulated partition results on the consumer group entity.
+// Whether the group has committed any offsets is always reported, so groups that haven't committed
+// yet can be told apart from groups that failed to collect. The lag percentiles and the number of
+// partitions lagging more than partition_lag_threshold are reported if any partition has a lag. The
+// metrics comparing to the previous run are only reported if at least one partition had state from a
+// previous run.
+func setGroupMetrics(kafkaArgs *args.KafkaArguments, consumerGroup string, stats *groupStats, kafkaIntegration *integration.Integration) error {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+	groupEntity, err := kafkaIntegration.Entity(consumerGroup, "ka-consumerGroup", clusterIDAttrs...)
+	if err != nil {
+		return err
+	}
+
+	metricSet := groupEntity.NewMetricSet(kafkaArgs.OffsetSampleName,
+		metric.Attribute{Key: "displayName", Value: groupEntity.Metadata.Name},
+		metric.Attribute{Key: "entityName", Value: "consumerGroup:" + groupEntity.Metadata.Name},
+		metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+		metric.Attribute{Key: "consumerGroup", Value: consumerGroup},
+	)
+
+	// Set last, so the time includes setting the other group metrics
+	if !stats.started.IsZero() {
+		defer func() {
+			collectionTimeMs := float64(time.Since(stats.started)) / float64(time.Millisecond)
+			if err := metricSet.SetMetric("kafka.offsetCollectionTimeMs", collectionTimeMs, metric.GAUGE); err != nil {
+				collecterrors.Error("Failed to set metric kafka.offsetCollectionTimeMs: %s", err.Error())
+			}
+		}()
+	}
+
+	hasCommittedOffsets := 0
+	if stats.committedPartitions > 0 {
+		hasCommittedOffsets = 1
+	}
+	if err := metricSet.SetMetric("kafka.consumerGroup.hasCommittedOffsets", hasCommittedOffsets, metric.GAUGE); err != nil {
+		return err
+	}
+
+	if stats.generation != nil {
+		if err := metricSet.SetMetric("generationId", strconv.Itoa(int(*stats.generation)), metric.ATTRIBUTE); err != nil {
+			return err
+		}
+	}
+	if kafkaArgs.MaxPartitionsPerGroup > 0 {
+		if err := metricSet.SetMetric("skippedPartitions", strconv.Itoa(stats.skippedPartitions), metric.ATTRIBUTE); err != nil {
+			return err
+		}
+	}
+	if stats.rebalances != nil {
+		if err := metricSet.SetMetric("kafka.consumerGroupRebalances", *stats.rebalances, metric.GAUGE); err != nil {
+			return err
+		}
+	}
+
+	if stats.assignmentStrategy != "" {
+		if err := metricSet.SetMetric("assignmentStrategy", stats.assignmentStrategy, metric.ATTRIBUTE); err != nil {
+			return err
+		}
+	}
+
+	if stats.members != nil {
+		if err := stats.members.setMetrics(metricSet); err != nil {
+			return err
+		}
+	}
+
+	if stats.coordinatorID != nil {
+		if err := metricSet.SetMetric("kafka.consumerGroup.coordinatorId", *stats.coordinatorID, metric.GAUGE); err != nil {
+			return err
+		}
+	}
+
+	if stats.retentionRemainingMs != nil {
+		if err := metricSet.SetMetric("kafka.offsetRetentionRemainingMs", *stats.retentionRemainingMs, metric.GAUGE); err != nil {
+			return err
+		}
+	}
+
+	if stats.oldestCommitTimestamp != nil {
+		if err := metricSet.SetMetric("kafka.oldestCommitTimestamp", *stats.oldestCommitTimestamp, metric.GAUGE); err != nil {
+			return err
+		}
+		if err := metricSet.SetMetric("kafka.oldestCommitAgeMs", *stats.oldestCommitAgeMs, metric.GAUGE); err != nil {
+			return err
+		}
+	}
+
+	if len(stats.lags) > 0 {
+		lags := make([]int64, len(stats.lags))
+		copy(lags, stats.lags)
+		sort.Slice(lags, func(i, j int) bool { return lags[i] < lags[j] })
+
+		for name, value := range map[string]int64{
+			"kafka.consumerLagP50":        lagPercentile(lags, 50),
+			"kafka.consumerLagP95":        lagPercentile(lags, 95),
+			"kafka.consumerLagMax":        lags[len(lags)-1],
+			"kafka.laggingPartitionCount": laggingPartitions(lags, int64(kafkaArgs.PartitionLagThreshold)),
+		} {
+			if err := metricSet.SetMetric(name, value, metric.GAUGE); err != nil {
+				return err
+			}
+		}
+	}
+
+	if stats.tracked == 0 {
+		return nil
+	}
+
+	stalled := 0
+	if stats.stalledPartitions > 0 {
+		stalled = 1
+	}
+	if err := metricSet.SetMetric("kafka.consumerGroupStalled", stalled, metric.GAUGE); err != nil {
+		return err
+	}
+
+	// A consumer that isn't consuming would never drain, so report nothing rather than infinity
+	if stats.consumeRate > 0 {
+		drainSeconds := float64(stats.ratedLag) / stats.consumeRate
+		if err := metricSet.SetMetric("kafka.consumerLag.estimatedDrainSeconds", drainSeconds, metric.GAUGE); err != nil {
+			return err
+		}
+	}
+
+	return metricSet.SetMetric("stalledPartitions", stats.stalledPartitions, metric.GAUGE)
+}
@@ -0,0 +1,390 @@
+package conoffsetcollect
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_trackOffset_Reset(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	store := persist.NewInMemoryStore()
+
+	// First run has nothing to compare to
+	_, ok := trackOffset(kafkaArgs, store, "group", "topic", "0", 100, 0)
+	assert.False(t, ok)
+
+	status, ok := trackOffset(kafkaArgs, store, "group", "topic", "0", 150, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 0, status.ResetDetected)
+
+	status, ok = trackOffset(kafkaArgs, store, "group", "topic", "0", 10, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 1, status.ResetDetected)
+
+	// Other partitions are tracked separately
+	_, ok = trackOffset(kafkaArgs, store, "group", "topic", "1", 10, 0)
+	assert.False(t, ok)
+}
+
+func Test_trackOffset_Stalled(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{StallDetectionCycles: 2}
+	store := persist.NewInMemoryStore()
+
+	trackOffset(kafkaArgs, store, "group", "topic", "0", 100, 5)
+
+	status, _ := trackOffset(kafkaArgs, store, "group", "topic", "0", 100, 5)
+	assert.False(t, status.Stalled)
+
+	status, _ = trackOffset(kafkaArgs, store, "group", "topic", "0", 100, 5)
+	assert.True(t, status.Stalled)
+
+	// Advancing clears the stall
+	status, _ = trackOffset(kafkaArgs, store, "group", "topic", "0", 101, 5)
+	assert.False(t, status.Stalled)
+
+	// No lag means the group is idle, not stalled
+	for i := 0; i < 3; i++ {
+		status, _ = trackOffset(kafkaArgs, store, "group", "topic", "0", 101, 0)
+	}
+	assert.False(t, status.Stalled)
+}
+
+func Test_trackOffset_NoStore(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	_, ok := trackOffset(kafkaArgs, nil, "group", "topic", "0", 100, 0)
+	assert.False(t, ok)
+}
+
+func Test_trackOffsets(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{StallDetectionCycles: 1}
+	store := persist.NewInMemoryStore()
+	offset := func(i int64) *int64 { return &i }
+
+	// Partition 2 has no lag, such as without its high water mark
+	first := []*partitionOffsets{
+		{Topic: "topic", Partition: "0", ConsumerOffset: offset(20), ConsumerLag: offset(3)},
+		{Topic: "topic", Partition: "1"},
+		{Topic: "topic", Partition: "2", ConsumerOffset: offset(50)},
+	}
+	stats := trackOffsets(kafkaArgs, store, "group", first)
+	assert.Nil(t, first[0].ResetDetected)
+	assert.Nil(t, first[1].ResetDetected)
+	assert.Nil(t, first[2].ResetDetected)
+	assert.Equal(t, 0, stats.tracked)
+
+	second := []*partitionOffsets{
+		{Topic: "topic", Partition: "0", ConsumerOffset: offset(20), ConsumerLag: offset(3)},
+		{Topic: "topic", Partition: "2", ConsumerOffset: offset(40)},
+	}
+	stats = trackOffsets(kafkaArgs, store, "group", second)
+	assert.Equal(t, 0, *second[0].ResetDetected)
+	assert.Equal(t, 1, *second[1].ResetDetected)
+	assert.Equal(t, 2, stats.tracked)
+	assert.Equal(t, 1, stats.stalledPartitions)
+	assert.Equal(t, []int64{3}, stats.lags)
+}
+
+func Test_setGroupMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+
+	err := setGroupMetrics(kafkaArgs, "testGroup", &groupStats{tracked: 3, stalledPartitions: 2, committedPartitions: 3}, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, err := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), groupEntity.Metrics[0].Metrics["kafka.consumerGroup.hasCommittedOffsets"])
+	assert.Equal(t, float64(1), groupEntity.Metrics[0].Metrics["kafka.consumerGroupStalled"])
+	assert.Equal(t, float64(2), groupEntity.Metrics[0].Metrics["stalledPartitions"])
+}
+
+func Test_setGroupMetrics_OffsetCollectionTime(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+
+	// Without history the group metrics return early, the collection time is still reported
+	err := setGroupMetrics(kafkaArgs, "testGroup", &groupStats{started: time.Now().Add(-20 * time.Millisecond)}, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, err := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Nil(t, err)
+	assert.True(t, groupEntity.Metrics[0].Metrics["kafka.offsetCollectionTimeMs"].(float64) >= 20)
+
+	// Untimed groups don't report it
+	err = setGroupMetrics(kafkaArgs, "otherGroup", &groupStats{}, i)
+	assert.Nil(t, err)
+	groupEntity, _ = i.Entity("otherGroup", "ka-consumerGroup", clusterIDAttr)
+	_, ok := groupEntity.Metrics[0].Metrics["kafka.offsetCollectionTimeMs"]
+	assert.False(t, ok)
+}
+
+func Test_setGroupMetrics_NoCommittedOffsets(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+
+	err := setGroupMetrics(kafkaArgs, "testGroup", &groupStats{}, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, err := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), groupEntity.Metrics[0].Metrics["kafka.consumerGroup.hasCommittedOffsets"])
+	// Without history there is nothing to compare to
+	_, ok := groupEntity.Metrics[0].Metrics["kafka.consumerGroupStalled"]
+	assert.False(t, ok)
+}
+
+func Test_trackOffset_ConsumeRate(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	store := persist.NewInMemoryStore()
+	defer func() { now = time.Now }()
+
+	start := time.Unix(1000, 0)
+	now = func() time.Time { return start }
+	status, _ := trackOffset(kafkaArgs, store, "group", "topic", "0", 100, 0)
+	assert.Nil(t, status.ConsumeRate)
+
+	now = func() time.Time { return start.Add(10 * time.Second) }
+	status, _ = trackOffset(kafkaArgs, store, "group", "topic", "0", 150, 0)
+	assert.Equal(t, float64(5), *status.ConsumeRate)
+
+	// Offset reset
+	now = func() time.Time { return start.Add(20 * time.Second) }
+	status, _ = trackOffset(kafkaArgs, store, "group", "topic", "0", 50, 0)
+	assert.Nil(t, status.ConsumeRate)
+
+	// Clock went backwards
+	now = func() time.Time { return start }
+	status, _ = trackOffset(kafkaArgs, store, "group", "topic", "0", 60, 0)
+	assert.Nil(t, status.ConsumeRate)
+}
+
+func Test_setGroupMetrics_DrainSeconds(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	rate := func(f float64) *float64 { return &f }
+	lag := func(l int64) *int64 { return &l }
+
+	stats := &groupStats{}
+	stats.add(offsetStatus{ConsumeRate: rate(4)}, lag(100))
+	stats.add(offsetStatus{ConsumeRate: rate(6)}, lag(50))
+	// Partitions without a rate or a lag don't count towards the estimate
+	stats.add(offsetStatus{}, lag(1000))
+	stats.add(offsetStatus{ConsumeRate: rate(10)}, nil)
+
+	err := setGroupMetrics(kafkaArgs, "testGroup", stats, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Equal(t, float64(15), groupEntity.Metrics[0].Metrics["kafka.consumerLag.estimatedDrainSeconds"])
+}
+
+func Test_setGroupMetrics_DrainSecondsZeroRate(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+	rate := float64(0)
+	lag := int64(100)
+
+	stats := &groupStats{}
+	stats.add(offsetStatus{ConsumeRate: &rate}, &lag)
+
+	err := setGroupMetrics(kafkaArgs, "testGroup", stats, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	_, ok := groupEntity.Metrics[0].Metrics["kafka.consumerLag.estimatedDrainSeconds"]
+	assert.False(t, ok)
+}
+
+func Test_lagPercentile(t *testing.T) {
+	var lags []int64
+	for i := int64(1); i <= 100; i++ {
+		lags = append(lags, i)
+	}
+	assert.Equal(t, int64(50), lagPercentile(lags, 50))
+	assert.Equal(t, int64(95), lagPercentile(lags, 95))
+
+	assert.Equal(t, int64(7), lagPercentile([]int64{7}, 50))
+	assert.Equal(t, int64(7), lagPercentile([]int64{7}, 95))
+
+	// A single outlier only shows in the top percentiles
+	skewed := []int64{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1000}
+	assert.Equal(t, int64(0), lagPercentile(skewed, 50))
+	assert.Equal(t, int64(0), lagPercentile(skewed, 95))
+	assert.Equal(t, int64(1000), lagPercentile(skewed, 100))
+}
+
+func Test_setGroupMetrics_LagPercentiles(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample"}
+	i, _ := integration.New("test", "test")
+	offset := func(i int64) *int64 { return &i }
+
+	var offsetData []*partitionOffsets
+	for _, lag := range []int64{40, 10, 30, 20, 100} {
+		offsetData = append(offsetData, &partitionOffsets{Topic: "topic", Partition: "0", ConsumerOffset: offset(1), ConsumerLag: offset(lag)})
+	}
+	// Partitions without a lag are left out
+	offsetData = append(offsetData, &partitionOffsets{Topic: "topic", Partition: "1", ConsumerOffset: offset(1)})
+
+	stats := trackOffsets(kafkaArgs, nil, "testGroup", offsetData)
+	err := setGroupMetrics(kafkaArgs, "testGroup", stats, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	metrics := groupEntity.Metrics[0].Metrics
+	assert.Equal(t, float64(30), metrics["kafka.consumerLagP50"])
+	assert.Equal(t, float64(100), metrics["kafka.consumerLagP95"])
+	assert.Equal(t, float64(100), metrics["kafka.consumerLagMax"])
+}
+
+func Test_setGroupMetrics_LaggingPartitionCount(t *testing.T) {
+	offset := func(i int64) *int64 { return &i }
+
+	var offsetData []*partitionOffsets
+	for partition, lag := range []int64{0, 5, 0, 250, 20} {
+		offsetData = append(offsetData, &partitionOffsets{Topic: "topic", Partition: strconv.Itoa(partition), ConsumerOffset: offset(1), ConsumerLag: offset(lag)})
+	}
+	// Partitions without a lag aren't counted
+	offsetData = append(offsetData, &partitionOffsets{Topic: "topic", Partition: "5", ConsumerOffset: offset(1)})
+
+	for threshold, expected := range map[int]float64{0: 3, 10: 2, 250: 0} {
+		kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample", PartitionLagThreshold: threshold}
+		i, _ := integration.New("test", "test")
+
+		err := setGroupMetrics(kafkaArgs, "testGroup", trackOffsets(kafkaArgs, nil, "testGroup", offsetData), i)
+		assert.Nil(t, err)
+
+		clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+		groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+		assert.Equal(t, expected, groupEntity.Metrics[0].Metrics["kafka.laggingPartitionCount"], "threshold %d", threshold)
+	}
+}
+
+func Test_setGroupMetrics_NoLag(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", OffsetSampleName: "KafkaOffsetSample"}
+	i, _ := integration.New("test", "test")
+
+	err := setGroupMetrics(kafkaArgs, "testGroup", &groupStats{committedPartitions: 1}, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	_, ok := groupEntity.Metrics[0].Metrics["kafka.consumerLagMax"]
+	assert.False(t, ok)
+}
+
+func Test_trackGeneration(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	store := persist.NewInMemoryStore()
+
+	// The first run establishes the baseline
+	assert.Nil(t, trackGeneration(kafkaArgs, store, "group", 4))
+
+	rebalances := trackGeneration(kafkaArgs, store, "group", 4)
+	if assert.NotNil(t, rebalances) {
+		assert.Equal(t, 0, *rebalances)
+	}
+
+	rebalances = trackGeneration(kafkaArgs, store, "group", 7)
+	if assert.NotNil(t, rebalances) {
+		assert.Equal(t, 3, *rebalances)
+	}
+
+	// A recreated group starts over
+	rebalances = trackGeneration(kafkaArgs, store, "group", 1)
+	if assert.NotNil(t, rebalances) {
+		assert.Equal(t, 1, *rebalances)
+	}
+
+	assert.Nil(t, trackGeneration(kafkaArgs, nil, "group", 1))
+}
+
+func Test_setGroupMetrics_Rebalances(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+
+	generation, rebalances := int32(12), 2
+	err := setGroupMetrics(kafkaArgs, "testGroup", &groupStats{generation: &generation, rebalances: &rebalances}, i)
+	assert.Nil(t, err)
+	err = setGroupMetrics(kafkaArgs, "newGroup", &groupStats{generation: &generation}, i)
+	assert.Nil(t, err)
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Equal(t, "12", groupEntity.Metrics[0].Metrics["generationId"])
+	assert.Equal(t, float64(2), groupEntity.Metrics[0].Metrics["kafka.consumerGroupRebalances"])
+
+	// Without a baseline only the generation is reported
+	groupEntity, _ = i.Entity("newGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Equal(t, "12", groupEntity.Metrics[0].Metrics["generationId"])
+	_, ok := groupEntity.Metrics[0].Metrics["kafka.consumerGroupRebalances"]
+	assert.False(t, ok)
+}
+
+func Test_setGroupMetrics_OldestCommit(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+
+	timestamp, ageMs := int64(1500000000000), int64(3600000)
+	assert.Nil(t, setGroupMetrics(kafkaArgs, "testGroup", &groupStats{oldestCommitTimestamp: &timestamp, oldestCommitAgeMs: &ageMs}, i))
+	assert.Nil(t, setGroupMetrics(kafkaArgs, "adminGroup", &groupStats{}, i))
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Equal(t, float64(timestamp), groupEntity.Metrics[0].Metrics["kafka.oldestCommitTimestamp"])
+	assert.Equal(t, float64(ageMs), groupEntity.Metrics[0].Metrics["kafka.oldestCommitAgeMs"])
+
+	// Without commit timestamps neither is reported
+	groupEntity, _ = i.Entity("adminGroup", "ka-consumerGroup", clusterIDAttr)
+	_, ok := groupEntity.Metrics[0].Metrics["kafka.oldestCommitTimestamp"]
+	assert.False(t, ok)
+}
+
+func Test_setGroupMetrics_AssignmentStrategy(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ := integration.New("test", "test")
+
+	assert.Nil(t, setGroupMetrics(kafkaArgs, "rangeGroup", &groupStats{assignmentStrategy: "range"}, i))
+	assert.Nil(t, setGroupMetrics(kafkaArgs, "emptyGroup", &groupStats{}, i))
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("rangeGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Equal(t, "range", groupEntity.Metrics[0].Metrics["assignmentStrategy"])
+
+	// Groups without members have no protocol
+	groupEntity, _ = i.Entity("emptyGroup", "ka-consumerGroup", clusterIDAttr)
+	_, ok := groupEntity.Metrics[0].Metrics["assignmentStrategy"]
+	assert.False(t, ok)
+}
+
+func Test_setGroupMetrics_SkippedPartitions(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster", MaxPartitionsPerGroup: 10}
+	i, _ := integration.New("test", "test")
+
+	assert.Nil(t, setGroupMetrics(kafkaArgs, "testGroup", &groupStats{skippedPartitions: 4}, i))
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	groupEntity, _ := i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	assert.Equal(t, "4", groupEntity.Metrics[0].Metrics["skippedPartitions"])
+
+	// Without a limit nothing can be skipped
+	kafkaArgs = &args.KafkaArguments{ClusterName: "testcluster"}
+	i, _ = integration.New("test", "test")
+	assert.Nil(t, setGroupMetrics(kafkaArgs, "testGroup", &groupStats{}, i))
+
+	groupEntity, _ = i.Entity("testGroup", "ka-consumerGroup", clusterIDAttr)
+	_, ok := groupEntity.Metrics[0].Metrics["skippedPartitions"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,352 @@
+package conoffsetcollect
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
+	tc "github.com/newrelic/nri-kafka/src/topiccollect"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// offsetsTopic is the internal topic Kafka stores committed consumer offsets in
+const offsetsTopic = "__consumer_offsets"
+
+// offsetCommit is a decoded offset commit record from the __consumer_offsets topic
+type offsetCommit struct {
+	Group     string
+	Topic     string
+	Partition int32
+}
+
+// committedOffset is the latest offset committed for a partition
+type committedOffset struct {
+	Offset int64
+	// CommitTimestamp is when the offset was committed in milliseconds since the epoch, nil if the record didn't have it
+	CommitTimestamp *int64
+}
+
+// committedOffsets holds the latest committed offset of each group, topic and partition
+type committedOffsets map[string]map[string]map[int32]committedOffset
+
+// groupGenerations holds the latest generation ID of each group, from the group metadata records
+type groupGenerations map[string]int32
+
+// apply updates the generations with a single group metadata record. A nil value is a tombstone,
+// which means the group was deleted.
+func (g groupGenerations) apply(group string, value []byte) error {
+	if value == nil {
+		delete(g, group)
+		return nil
+	}
+
+	generation, err := decodeGroupMetadataValue(value)
+	if err != nil {
+		return err
+	}
+
+	g[group] = generation
+	return nil
+}
+
+// apply updates the committed offsets with a single record from the __consumer_offsets topic.
+// A nil value is a tombstone, which means the offset was deleted (group deleted or offset expired).
+func (c committedOffsets) apply(commit *offsetCommit, value []byte) error {
+	if value == nil {
+		if topics, ok := c[commit.Group]; ok {
+			delete(topics[commit.Topic], commit.Partition)
+			if len(topics[commit.Topic]) == 0 {
+				delete(topics, commit.Topic)
+			}
+			if len(topics) == 0 {
+				delete(c, commit.Group)
+			}
+		}
+		return nil
+	}
+
+	offset, err := decodeOffsetCommitValue(value)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c[commit.Group]; !ok {
+		c[commit.Group] = make(map[string]map[int32]committedOffset)
+	}
+	if _, ok := c[commit.Group][commit.Topic]; !ok {
+		c[commit.Group][commit.Topic] = make(map[int32]committedOffset)
+	}
+	c[commit.Group][commit.Topic][commit.Partition] = offset
+
+	return nil
+}
+
+// collectFromOffsetsTopic collects consumer offsets by reading the __consumer_offsets topic up to its current
+// high water mark rather than requesting the offsets of each group from its coordinator. retention is the
+// offsets.retention.minutes of the brokers, for the time until the offsets of each group expire.
+func collectFromOffsetsTopic(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, client connection.Client, coordinators *coordinatorCache, offsetStore persist.Storer, internalTopics tc.InternalTopics, retention time.Duration, kafkaIntegration *integration.Integration) error {
+	if kafkaArgs.ConsumerGroupRegex == nil {
+		return errors.New("offset_collection_strategy 'topic' requires consumer_group_regex to be set")
+	}
+
+	consumer, err := zkConn.CreateConsumer()
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %s", err)
+	}
+	defer func() {
+		if err := consumer.Close(); err != nil {
+			log.Debug("Error closing consumer connection: %s", err.Error())
+		}
+	}()
+
+	offsets, generations, err := readOffsetsTopic(kafkaArgs, consumer, client)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for consumerGroup, topics := range offsets {
+		if !kafkaArgs.ConsumerGroupRegex.MatchString(consumerGroup) || !coordinators.collects(kafkaArgs, consumerGroup) {
+			continue
+		}
+
+		var generation *int32
+		if g, ok := generations[consumerGroup]; ok {
+			generation = &g
+		}
+
+		wg.Add(1)
+		go collectOffsetsTopicGroup(kafkaArgs, client, coordinators, offsetStore, internalTopics, consumerGroup, topics, generation, retention, kafkaIntegration, &wg)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// collectOffsetsTopicGroup reports the offsets read from the __consumer_offsets topic for a single consumer group,
+// along with its generation if the group metadata was read
+func collectOffsetsTopicGroup(kafkaArgs *args.KafkaArguments, client connection.Client, coordinators *coordinatorCache, offsetStore persist.Storer, internalTopics tc.InternalTopics, consumerGroup string, topics map[string]map[int32]committedOffset, generation *int32, retention time.Duration, kafkaIntegration *integration.Integration, wg *sync.WaitGroup) {
+	defer wg.Done()
+	start := time.Now()
+
+	// The offsets topic carries no member information
+	memberDescription := &sarama.GroupMemberDescription{}
+
+	var partitionOffsets []*memberPartitionOffset
+	for topic, partitions := range topics {
+		for partition, offset := range partitions {
+			partitionOffsets = append(partitionOffsets, &memberPartitionOffset{
+				Member:          memberDescription,
+				Topic:           topic,
+				Partition:       partition,
+				Block:           &sarama.OffsetFetchResponseBlock{Offset: offset.Offset},
+				CommitTimestamp: offset.CommitTimestamp,
+			})
+		}
+	}
+
+	// The assignment strategy isn't decoded from the group metadata records
+	collectGroupPartitionOffsets(kafkaArgs, client, coordinators, offsetStore, internalTopics, consumerGroup, partitionOffsets, generation, "", nil, retention, start, kafkaIntegration)
+}
+
+// readOffsetsTopic reads every partition of the __consumer_offsets topic from the oldest retained offset up to
+// the high water mark at the time of the call. Records are applied in order, so after compaction or a
+// rewrite the latest commit for a partition always wins. The generation of each group is read from the
+// group metadata records along the way.
+func readOffsetsTopic(kafkaArgs *args.KafkaArguments, consumer sarama.Consumer, client connection.Client) (committedOffsets, groupGenerations, error) {
+	partitions, err := consumer.Partitions(offsetsTopic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get partitions of %s: %s", offsetsTopic, err)
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	offsets := make(committedOffsets)
+	generations := make(groupGenerations)
+	for _, partition := range partitions {
+		wg.Add(1)
+		go func(partition int32) {
+			defer wg.Done()
+
+			partitionOffsets, partitionGenerations, err := readOffsetsTopicPartition(kafkaArgs, consumer, client, partition)
+			if err != nil {
+				collecterrors.Error("Failed to read partition %d of %s: %s", partition, offsetsTopic, err)
+				return
+			}
+
+			// Each group is stored in a single partition of the offsets topic, so merging can't conflict
+			lock.Lock()
+			for group, topics := range partitionOffsets {
+				offsets[group] = topics
+			}
+			for group, generation := range partitionGenerations {
+				generations[group] = generation
+			}
+			lock.Unlock()
+		}(partition)
+	}
+	wg.Wait()
+
+	return offsets, generations, nil
+}
+
+func readOffsetsTopicPartition(kafkaArgs *args.KafkaArguments, consumer sarama.Consumer, client connection.Client, partition int32) (committedOffsets, groupGenerations, error) {
+	offsets := make(committedOffsets)
+	generations := make(groupGenerations)
+
+	timeout := time.Duration(kafkaArgs.Timeout) * time.Millisecond
+	err := connection.ReadPartition(consumer, client, offsetsTopic, partition, timeout, func(msg *sarama.ConsumerMessage) {
+		if err := applyOffsetsTopicRecord(offsets, generations, msg.Key, msg.Value); err != nil {
+			log.Debug("Skipping record at offset %d of %s partition %d: %s", msg.Offset, offsetsTopic, partition, err)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return offsets, generations, nil
+}
+
+// applyOffsetsTopicRecord applies a record of the __consumer_offsets topic to the offsets if it's an offset
+// commit, or to the generations if it's group metadata
+func applyOffsetsTopicRecord(offsets committedOffsets, generations groupGenerations, key, value []byte) error {
+	commit, err := decodeOffsetCommitKey(key)
+	if err != nil {
+		return err
+	}
+	if commit != nil {
+		return offsets.apply(commit, value)
+	}
+
+	group, err := decodeGroupMetadataKey(key)
+	if err != nil {
+		return err
+	}
+
+	return generations.apply(group, value)
+}
+
+// decodeOffsetCommitKey decodes the key of a record in the __consumer_offsets topic.
+// Key versions 0 and 1 are offset commits. Version 2 is group metadata, for which nil is returned.
+func decodeOffsetCommitKey(key []byte) (*offsetCommit, error) {
+	if len(key) < 2 {
+		return nil, errors.New("key too short")
+	}
+
+	version := int16(binary.BigEndian.Uint16(key))
+	if version > 1 {
+		return nil, nil
+	}
+
+	rest := key[2:]
+	group, rest, err := readString(rest)
+	if err != nil {
+		return nil, err
+	}
+	topic, rest, err := readString(rest)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < 4 {
+		return nil, errors.New("key missing partition")
+	}
+
+	return &offsetCommit{
+		Group:     group,
+		Topic:     topic,
+		Partition: int32(binary.BigEndian.Uint32(rest)),
+	}, nil
+}
+
+// decodeOffsetCommitValue decodes the committed offset and commit timestamp from the value of an offset commit
+// record. Every value version so far starts with the version followed by the offset. The commit timestamp
+// follows the metadata, which version 3 precedes with the leader epoch. The commit timestamp is left out
+// if the value ends early.
+func decodeOffsetCommitValue(value []byte) (committedOffset, error) {
+	if len(value) < 10 {
+		return committedOffset{}, errors.New("value too short")
+	}
+
+	version := int16(binary.BigEndian.Uint16(value))
+	if version < 0 || version > 3 {
+		return committedOffset{}, fmt.Errorf("unknown offset commit value version %d", version)
+	}
+
+	offset := committedOffset{Offset: int64(binary.BigEndian.Uint64(value[2:]))}
+
+	rest := value[10:]
+	if version == 3 {
+		if len(rest) < 4 {
+			return offset, nil
+		}
+		rest = rest[4:]
+	}
+	_, rest, err := readString(rest)
+	if err != nil || len(rest) < 8 {
+		return offset, nil
+	}
+
+	timestamp := int64(binary.BigEndian.Uint64(rest))
+	offset.CommitTimestamp = &timestamp
+	return offset, nil
+}
+
+// decodeGroupMetadataKey decodes the group of a group metadata record, which has a version 2 key
+func decodeGroupMetadataKey(key []byte) (string, error) {
+	if len(key) < 2 {
+		return "", errors.New("key too short")
+	}
+
+	if version := int16(binary.BigEndian.Uint16(key)); version != 2 {
+		return "", fmt.Errorf("unknown key version %d", version)
+	}
+
+	group, _, err := readString(key[2:])
+	return group, err
+}
+
+// decodeGroupMetadataValue decodes the generation ID from the value of a group metadata record.
+// Every value version so far starts with the version, the protocol type and the generation.
+func decodeGroupMetadataValue(value []byte) (int32, error) {
+	if len(value) < 2 {
+		return 0, errors.New("value too short")
+	}
+
+	if version := int16(binary.BigEndian.Uint16(value)); version < 0 || version > 3 {
+		return 0, fmt.Errorf("unknown group metadata value version %d", version)
+	}
+
+	_, rest, err := readString(value[2:])
+	if err != nil {
+		return 0, err
+	}
+	if len(rest) < 4 {
+		return 0, errors.New("value missing generation")
+	}
+
+	return int32(binary.BigEndian.Uint32(rest)), nil
+}
+
+// readString reads a Kafka protocol string (int16 length followed by the bytes)
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("string missing length")
+	}
+
+	length := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < length {
+		return "", nil, errors.New("string shorter than its length")
+	}
+
+	return string(data[:length]), data[length:], nil
+}
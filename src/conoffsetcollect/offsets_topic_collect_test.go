@@ -0,0 +1,143 @@
+package conoffsetcollect
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeString(s string) []byte {
+	b := make([]byte, 2, 2+len(s))
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func offsetCommitKey(version int16, group, topic string, partition int32) []byte {
+	key := make([]byte, 2)
+	binary.BigEndian.PutUint16(key, uint16(version))
+	key = append(key, encodeString(group)...)
+	key = append(key, encodeString(topic)...)
+	p := make([]byte, 4)
+	binary.BigEndian.PutUint32(p, uint32(partition))
+	return append(key, p...)
+}
+
+// testCommitTimestamp is the commit timestamp of the offset commit values
+const testCommitTimestamp = int64(1530886155628)
+
+func offsetCommitValue(version int16, offset int64) []byte {
+	value := make([]byte, 10)
+	binary.BigEndian.PutUint16(value, uint16(version))
+	binary.BigEndian.PutUint64(value[2:], uint64(offset))
+	if version == 3 {
+		// leader epoch
+		value = append(value, 0, 0, 0, 1)
+	}
+	value = append(value, encodeString("")...)
+	// the expire timestamp of version 1 follows the commit timestamp but isn't decoded
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(testCommitTimestamp))
+	return append(value, timestamp...)
+}
+
+func Test_decodeOffsetCommitKey(t *testing.T) {
+	commit, err := decodeOffsetCommitKey(offsetCommitKey(1, "group", "topic", 3))
+	assert.Nil(t, err)
+	assert.Equal(t, &offsetCommit{Group: "group", Topic: "topic", Partition: 3}, commit)
+
+	// Group metadata records are ignored
+	commit, err = decodeOffsetCommitKey(offsetCommitKey(2, "group", "topic", 3))
+	assert.Nil(t, err)
+	assert.Nil(t, commit)
+
+	_, err = decodeOffsetCommitKey([]byte{0, 1, 0, 10, 'a'})
+	assert.NotNil(t, err)
+}
+
+func Test_decodeOffsetCommitValue(t *testing.T) {
+	for _, version := range []int16{0, 1, 3} {
+		offset, err := decodeOffsetCommitValue(offsetCommitValue(version, 1234))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1234), offset.Offset)
+		if assert.NotNil(t, offset.CommitTimestamp) {
+			assert.Equal(t, testCommitTimestamp, *offset.CommitTimestamp)
+		}
+	}
+
+	// Without the commit timestamp only the offset is decoded
+	offset, err := decodeOffsetCommitValue(offsetCommitValue(1, 1234)[:12])
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1234), offset.Offset)
+	assert.Nil(t, offset.CommitTimestamp)
+
+	_, err = decodeOffsetCommitValue(offsetCommitValue(9, 1234))
+	assert.NotNil(t, err)
+
+	_, err = decodeOffsetCommitValue([]byte{0, 1})
+	assert.NotNil(t, err)
+}
+
+func Test_committedOffsets_apply(t *testing.T) {
+	offsets := make(committedOffsets)
+	commit := &offsetCommit{Group: "group", Topic: "topic", Partition: 0}
+
+	assert.Nil(t, offsets.apply(commit, offsetCommitValue(1, 10)))
+	assert.Nil(t, offsets.apply(commit, offsetCommitValue(1, 20)))
+	assert.Equal(t, int64(20), offsets["group"]["topic"][0].Offset)
+
+	// Tombstones remove the offset, and the group once it has none left
+	assert.Nil(t, offsets.apply(commit, nil))
+	assert.Equal(t, 0, len(offsets))
+
+	// Tombstones for unknown offsets are ignored
+	assert.Nil(t, offsets.apply(&offsetCommit{Group: "other"}, nil))
+}
+
+func groupMetadataKey(group string) []byte {
+	key := make([]byte, 2)
+	binary.BigEndian.PutUint16(key, 2)
+	return append(key, encodeString(group)...)
+}
+
+func groupMetadataValue(version int16, generation int32) []byte {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, uint16(version))
+	value = append(value, encodeString("consumer")...)
+	g := make([]byte, 4)
+	binary.BigEndian.PutUint32(g, uint32(generation))
+	// the protocol, leader and members follow the generation but aren't decoded
+	return append(value, g...)
+}
+
+func Test_decodeGroupMetadataValue(t *testing.T) {
+	for _, version := range []int16{0, 1, 3} {
+		generation, err := decodeGroupMetadataValue(groupMetadataValue(version, 42))
+		assert.Nil(t, err)
+		assert.Equal(t, int32(42), generation)
+	}
+
+	_, err := decodeGroupMetadataValue(groupMetadataValue(9, 42))
+	assert.NotNil(t, err)
+
+	_, err = decodeGroupMetadataValue(append([]byte{0, 1}, encodeString("consumer")...))
+	assert.NotNil(t, err)
+}
+
+func Test_applyOffsetsTopicRecord(t *testing.T) {
+	offsets := make(committedOffsets)
+	generations := make(groupGenerations)
+
+	assert.Nil(t, applyOffsetsTopicRecord(offsets, generations, offsetCommitKey(1, "group", "topic", 0), offsetCommitValue(1, 10)))
+	assert.Nil(t, applyOffsetsTopicRecord(offsets, generations, groupMetadataKey("group"), groupMetadataValue(2, 5)))
+	assert.Nil(t, applyOffsetsTopicRecord(offsets, generations, groupMetadataKey("group"), groupMetadataValue(2, 6)))
+	assert.Equal(t, int64(10), offsets["group"]["topic"][0].Offset)
+	assert.Equal(t, int32(6), generations["group"])
+
+	// Group metadata tombstones remove the generation
+	assert.Nil(t, applyOffsetsTopicRecord(offsets, generations, groupMetadataKey("group"), nil))
+	assert.Equal(t, 0, len(generations))
+	assert.Equal(t, 1, len(offsets))
+
+	assert.NotNil(t, applyOffsetsTopicRecord(offsets, generations, []byte{0, 2, 0, 10, 'a'}, nil))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+)
+
+// setCustomAttributes adds the custom_attributes to every metric set on the entities. A metric set keeps the
+// value of an attribute or metric it already has by the same name.
+func setCustomAttributes(entities []*integration.Entity, attributes []metric.Attribute) {
+	if len(attributes) == 0 {
+		return
+	}
+
+	for _, entity := range entities {
+		for _, set := range entity.Metrics {
+			for _, attribute := range attributes {
+				if _, ok := set.Metrics[attribute.Key]; !ok {
+					set.Metrics[attribute.Key] = attribute.Value
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_setCustomAttributes(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, _ := i.Entity("broker0:9092", "ka-broker")
+	sample := broker.NewMetricSet("KafkaBrokerSample", metric.Attribute{Key: "displayName", Value: "broker0:9092"})
+	sample.SetMetric("broker.logFlushRate", 2, metric.GAUGE)
+	topic, _ := i.Entity("topic1", "ka-topic")
+	topic.NewMetricSet("KafkaTopicSample", metric.Attribute{Key: "team", Value: "orders"})
+
+	setCustomAttributes(i.Entities, []metric.Attribute{{Key: "environment", Value: "prod"}, {Key: "team", Value: "payments"}})
+
+	assert.Equal(t, map[string]interface{}{
+		"event_type":          "KafkaBrokerSample",
+		"displayName":         "broker0:9092",
+		"broker.logFlushRate": float64(2),
+		"environment":         "prod",
+		"team":                "payments",
+	}, broker.Metrics[0].Metrics)
+	// An attribute the sample already has keeps its value
+	assert.Equal(t, "orders", topic.Metrics[0].Metrics["team"])
+	assert.Equal(t, "prod", topic.Metrics[0].Metrics["environment"])
+}
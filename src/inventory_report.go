@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	tc "github.com/newrelic/nri-kafka/src/topiccollect"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// inventoryReport writes the brokers, topics and consumer groups the integration discovers in every cluster to
+// w, marking those it would collect, so topic_mode, topic_regex and consumer_group_regex can be checked before a
+// real run. Nothing is collected or published. Returns true if every cluster was discovered.
+func inventoryReport(w io.Writer, clusterArgLists []args.ArgumentList) bool {
+	passed := true
+	for _, clusterArgList := range clusterArgLists {
+		passed = reportClusterInventory(w, clusterArgList) && passed
+	}
+
+	return passed
+}
+
+// reportClusterInventory writes the inventory of a single cluster
+func reportClusterInventory(w io.Writer, argList args.ArgumentList) bool {
+	kafkaArgs, err := args.ParseArgs(argList)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL cluster '%s': %s\n", argList.ClusterName, err.Error())
+		return false
+	}
+
+	zkConn, err := zookeeper.NewConnection(kafkaArgs)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL cluster '%s': %s\n", argList.ClusterName, err.Error())
+		return false
+	}
+	defer zkConn.Close()
+
+	if err := writeInventory(kafkaArgs, w, zkConn); err != nil {
+		fmt.Fprintf(w, "FAIL cluster '%s': %s\n", kafkaArgs.ClusterName, err.Error())
+		return false
+	}
+
+	return true
+}
+
+// writeInventory writes the brokers of the cluster with their addresses, the topics with their partition counts
+// and the consumer groups. Topics are the ones topic_mode selects, and consumer groups the ones the cluster has.
+func writeInventory(kafkaArgs *args.KafkaArguments, w io.Writer, zkConn zookeeper.Connection) error {
+	fmt.Fprintf(w, "Cluster %s\n", kafkaArgs.ClusterName)
+
+	brokerIDs, err := zookeeper.GetBrokerIDs(zkConn)
+	if err != nil {
+		return err
+	}
+	sort.Slice(brokerIDs, func(i, j int) bool {
+		a, _ := strconv.Atoi(brokerIDs[i])
+		b, _ := strconv.Atoi(brokerIDs[j])
+		return a < b
+	})
+
+	fmt.Fprintf(w, "Brokers (%d):\n", len(brokerIDs))
+	for _, brokerID := range brokerIDs {
+		id, err := strconv.Atoi(brokerID)
+		if err != nil {
+			fmt.Fprintf(w, "  %s: invalid broker ID\n", brokerID)
+			continue
+		}
+
+		brokerConnections, err := zookeeper.GetBrokerConnections(id, zkConn)
+		if err != nil {
+			fmt.Fprintf(w, "  %d: %s\n", id, err.Error())
+			continue
+		}
+		addresses := make([]string, 0, len(brokerConnections))
+		for _, brokerConnection := range brokerConnections {
+			addresses = append(addresses, brokerConnection.Addr())
+		}
+		fmt.Fprintf(w, "  %d: %s\n", id, strings.Join(addresses, ", "))
+	}
+
+	client, err := zkConn.CreateClient()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			log.Debug("Error closing client connection: %s", err.Error())
+		}
+	}()
+
+	topics, err := tc.GetTopics(kafkaArgs, zkConn)
+	if err != nil {
+		return err
+	}
+	sort.Strings(topics)
+
+	fmt.Fprintf(w, "Topics (%d, topic_mode %s):\n", len(topics), kafkaArgs.TopicMode)
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			fmt.Fprintf(w, "  %s: %s\n", topic, err.Error())
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %d partitions\n", topic, len(partitions))
+	}
+
+	clusterAdmin, err := zkConn.CreateClusterAdmin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := clusterAdmin.Close(); err != nil {
+			log.Debug("Error closing clusterAdmin connection: %s", err.Error())
+		}
+	}()
+
+	groupMap, err := clusterAdmin.ListConsumerGroups()
+	if err != nil {
+		return fmt.Errorf("failed to get list of consumer groups: %s", err)
+	}
+	groups := make([]string, 0, len(groupMap))
+	for group := range groupMap {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	collected := 0
+	lines := make([]string, 0, len(groups))
+	for _, group := range groups {
+		line := "  " + group
+		if collectsConsumerGroup(kafkaArgs, group) {
+			line += " (collected)"
+			collected++
+		}
+		lines = append(lines, line)
+	}
+
+	fmt.Fprintf(w, "Consumer groups (%d, %d collected):\n", len(groups), collected)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+
+	return nil
+}
+
+// collectsConsumerGroup returns true if a run in consumer offset mode collects the offsets of group, matching
+// consumer_group_regex or, without it, listed in consumer_groups
+func collectsConsumerGroup(kafkaArgs *args.KafkaArguments, group string) bool {
+	if !kafkaArgs.ConsumerOffset {
+		return false
+	}
+	if kafkaArgs.ConsumerGroupRegex != nil {
+		return kafkaArgs.ConsumerGroupRegex.MatchString(group)
+	}
+
+	_, ok := kafkaArgs.ConsumerGroups[group]
+	return ok
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_writeInventory(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{
+		ClusterName:        "testcluster",
+		TopicMode:          "List",
+		TopicList:          []string{"topic2", "topic1"},
+		ConsumerOffset:     true,
+		ConsumerGroupRegex: regexp.MustCompile("^orders-"),
+	}
+
+	mockZk := &zookeeper.MockConnection{}
+	mockClient := connection.MockClient{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	mockZk.On("Children", "/brokers/ids").Return([]string{"10", "2"}, new(zk.Stat), nil)
+	mockZk.On("Get", "/brokers/ids/2").Return(brokerConnectionBytes, new(zk.Stat), nil)
+	mockZk.On("Get", "/brokers/ids/10").Return([]byte(`{"endpoints":["PLAINTEXT://kafkabroker10:9092"],"jmx_port":9999}`), new(zk.Stat), nil)
+	mockZk.On("CreateClient").Return(&mockClient, nil)
+	mockZk.On("CreateClusterAdmin").Return(&mockClusterAdmin, nil)
+	mockClient.On("Partitions", "topic1").Return([]int32{0, 1, 2}, nil)
+	mockClient.On("Partitions", "topic2").Return([]int32{0}, nil)
+	mockClient.On("Close").Return(nil)
+	mockClusterAdmin.On("ListConsumerGroups").Return(map[string]string{"orders-app": "consumer", "audit": "consumer"}, nil)
+	mockClusterAdmin.On("Close").Return(nil)
+	mockClusterAdmin.On("DescribeTopics", []string(nil)).Return([]*sarama.TopicMetadata{}, nil)
+
+	var output bytes.Buffer
+	assert.Nil(t, writeInventory(kafkaArgs, &output, mockZk))
+
+	assert.Equal(t, `Cluster testcluster
+Brokers (2):
+  2: kafkabroker:9092
+  10: kafkabroker10:9092
+Topics (2, topic_mode List):
+  topic1: 3 partitions
+  topic2: 1 partitions
+Consumer groups (2, 1 collected):
+  audit
+  orders-app (collected)
+`, output.String())
+}
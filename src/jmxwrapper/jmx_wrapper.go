@@ -3,6 +3,8 @@
 package jmxwrapper
 
 import (
+	"net"
+	"strings"
 	"sync"
 
 	"github.com/newrelic/infra-integrations-sdk/jmx"
@@ -19,9 +21,21 @@ var (
 
 	// JMXOpen is a wrapper around infra-integrations-sdk/jmx functions to allow
 	// easier mocking during tests
-	JMXOpen = jmx.Open
+	JMXOpen = func(hostname, port, username, password string, opts ...jmx.Option) error {
+		return jmx.Open(ServiceURLHost(hostname), port, username, password, opts...)
+	}
 
 	// JMXClose is a wrapper around infra-integrations-sdk/jmx functions to allow
 	// easier mocking during tests
 	JMXClose = jmx.Close
 )
+
+// ServiceURLHost returns host as it goes in a JMX service URL, where IPv6 literals must be in brackets.
+// Hosts that are already bracketed and other hosts are returned as they are.
+func ServiceURLHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil && strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
@@ -0,0 +1,14 @@
+package jmxwrapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceURLHost(t *testing.T) {
+	assert.Equal(t, "broker.example.com", ServiceURLHost("broker.example.com"))
+	assert.Equal(t, "10.0.0.1", ServiceURLHost("10.0.0.1"))
+	assert.Equal(t, "[2001:db8::1]", ServiceURLHost("2001:db8::1"))
+	assert.Equal(t, "[2001:db8::1]", ServiceURLHost("[2001:db8::1]"))
+}
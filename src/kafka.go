@@ -1,16 +1,22 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
 	"github.com/newrelic/nri-kafka/src/args"
 	bc "github.com/newrelic/nri-kafka/src/brokercollect"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
 	offc "github.com/newrelic/nri-kafka/src/conoffsetcollect"
 	pcc "github.com/newrelic/nri-kafka/src/prodconcollect"
+	"github.com/newrelic/nri-kafka/src/statsdexport"
 	tc "github.com/newrelic/nri-kafka/src/topiccollect"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 )
@@ -22,70 +28,442 @@ const (
 
 func main() {
 	var argList args.ArgumentList
-	// Create Integration
-	kafkaIntegration, err := integration.New(integrationName, integrationVersion, integration.Args(&argList))
+	// Create Integration. The clusters are collected into integrations of their own, which calculate the
+	// metrics with the store openMetricStore opens, so the store of this one is never used.
+	kafkaIntegration, err := integration.New(integrationName, integrationVersion, integration.Args(&argList), integration.InMemoryStore())
 	ExitOnErr(err)
 
 	// Setup logging with verbose
 	log.SetupLogging(argList.Verbose)
 
-	// Parse args into structs
-	// This has to be after integration creation for defaults to be populated
-	args.GlobalArgs, err = args.ParseArgs(argList)
+	// Merge in the arguments from the config file, if any. Arguments already passed take precedence.
+	if argList.ConfigFile != "" {
+		err = args.LoadConfigFile(argList.ConfigFile)
+		ExitOnErr(err)
+	}
+
+	// The log level and format may come from the config file
+	ExitOnErr(setupLogging(argList.Verbose, argList.LogLevel, argList.LogFormat))
+
+	clusterArgLists, err := argList.ClusterArgumentLists()
 	ExitOnErr(err)
 
-	zkConn, err := zookeeper.NewConnection(args.GlobalArgs)
+	filter, err := validateArgs(argList, clusterArgLists)
 	ExitOnErr(err)
 
-	if !args.GlobalArgs.ConsumerOffset {
-		coreCollection(zkConn, kafkaIntegration)
-	} else {
-		if err := offc.Collect(zkConn, kafkaIntegration); err != nil {
-			log.Error("Failed collecting consumer offset data: %s", err.Error())
-			os.Exit(1)
+	// Only check connectivity and exit without collecting
+	if argList.TestConnection {
+		passed := true
+		for _, clusterArgList := range clusterArgLists {
+			if len(clusterArgLists) > 1 {
+				fmt.Printf("Cluster %s\n", clusterArgList.ClusterName)
+			}
+			passed = testClusterConnection(clusterArgList) && passed
+		}
+		if !passed {
+			exit(1)
+		}
+		exit(0)
+	}
+
+	// Only print what would be collected and exit without collecting
+	if argList.InventoryReport {
+		if !inventoryReport(os.Stdout, clusterArgLists) {
+			exit(1)
+		}
+		exit(0)
+	}
+
+	// Only check the consumer group lag and exit without reporting
+	if argList.CheckLag {
+		if !checkLag(os.Stdout, clusterArgLists, kafkaIntegration) {
+			exit(1)
+		}
+		exit(0)
+	}
+
+	startupDelay := time.Duration(argList.StartupDelayMs) * time.Millisecond
+
+	// Serve the metrics to Prometheus, collecting on an interval rather than once
+	if argList.PrometheusAddr != "" {
+		ExitOnErr(servePrometheus(argList.PrometheusAddr, time.Duration(argList.PrometheusInterval)*time.Second, startupDelay, clusterArgLists, argList.MaxConcurrentClusters, filter, kafkaIntegration))
+	}
+
+	waitStartupDelay(startupDelay)
+
+	// A single cluster fails the integration on the first error as it always has. With several
+	// clusters the others are still collected and published, and the integration fails afterwards.
+	// With fail_on_error, errors the collectors continued past don't stop the clusters from being
+	// published either, but still fail the integration afterwards.
+	metricStore := openMetricStore(kafkaIntegration)
+	collecterrors.Reset()
+	failed := false
+	for i, err := range collectClusters(clusterArgLists, argList.MaxConcurrentClusters, metricStore, kafkaIntegration) {
+		if err != nil {
+			if len(clusterArgLists) == 1 {
+				ExitOnErr(err)
+			}
+			log.Error("Failed collecting cluster '%s': %s", clusterArgLists[i].ClusterName, err.Error())
+			failed = true
 		}
 	}
+	if err := partialCollectionErr(argList.FailOnError); err != nil {
+		log.Error("Failed collecting: %s", err.Error())
+		failed = true
+	}
+
+	filter.apply(kafkaIntegration.Entities)
+
+	// Publish clears the entities, so send them to StatsD first
+	if argList.StatsdAddr != "" {
+		statsdexport.Send(argList.StatsdAddr, kafkaIntegration.Entities)
+	}
 
 	if err := kafkaIntegration.Publish(); err != nil {
 		log.Error("Failed to publish data: %s", err.Error())
-		os.Exit(1)
+		exit(1)
+	}
+	if err := metricStore.Save(); err != nil {
+		log.Error("Failed to save the metric store: %s", err.Error())
+	}
+
+	if failed {
+		exit(1)
+	}
+	flushLogs()
+}
+
+// waitStartupDelay waits startup_delay_ms before the first collection of the process
+func waitStartupDelay(delay time.Duration) {
+	if delay <= 0 {
+		return
 	}
+
+	log.Info("Waiting %s before collecting", delay)
+	time.Sleep(delay)
+}
+
+// validateArgs checks the regex arguments of the run and of every cluster, so an invalid pattern fails the
+// integration before connecting to any cluster, and returns the metric filter of the run
+func validateArgs(argList args.ArgumentList, clusterArgLists []args.ArgumentList) (*metricFilter, error) {
+	regexes, err := args.ValidateArgs(argList)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, clusterArgList := range clusterArgLists {
+		if _, err := args.ValidateArgs(clusterArgList); err != nil {
+			return nil, fmt.Errorf("invalid arguments for cluster '%s': %s", clusterArgList.ClusterName, err)
+		}
+	}
+
+	return newMetricFilter(regexes.MetricAllow, regexes.MetricDeny, argList.MetricPrefix), nil
+}
+
+// openMetricStore opens the store the RATE and DELTA metrics of every cluster are calculated with, persisted
+// between runs like the store of the integration would be. The metrics are calculated from this run only if it
+// can't be opened.
+func openMetricStore(kafkaIntegration *integration.Integration) persist.Storer {
+	store, err := persist.NewFileStore(persist.DefaultPath(integrationName), kafkaIntegration.Logger(), persist.DefaultTTL)
+	if err != nil {
+		log.Warn("Unable to open the metric store, rates are calculated from this run only: %s", err.Error())
+		return persist.NewInMemoryStore()
+	}
+
+	return store
+}
+
+// collectClusters collects the clusters, at most maxConcurrent at once, and adds their entities to
+// kafkaIntegration in the order of the clusters. Each cluster is collected with its own arguments into an
+// integration of its own, sharing metricStore. Returns the error of each cluster, nil if it was collected.
+func collectClusters(clusterArgLists []args.ArgumentList, maxConcurrent int, metricStore persist.Storer, kafkaIntegration *integration.Integration) []error {
+	errs := make([]error, len(clusterArgLists))
+
+	// Created one at a time, since creating an integration parses the command line
+	clusterIntegrations := make([]*integration.Integration, len(clusterArgLists))
+	for i := range clusterArgLists {
+		clusterIntegrations[i], errs[i] = integration.New(integrationName, integrationVersion, integration.Storer(metricStore), integration.Logger(kafkaIntegration.Logger()))
+	}
+
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	slots := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i, clusterArgList := range clusterArgLists {
+		if errs[i] != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, argList args.ArgumentList) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			kafkaArgs, err := args.ParseArgs(argList)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = collectClusterFunc(kafkaArgs, clusterIntegrations[i])
+		}(i, clusterArgList)
+	}
+	wg.Wait()
+
+	for _, clusterIntegration := range clusterIntegrations {
+		if clusterIntegration != nil {
+			addEntities(kafkaIntegration, clusterIntegration.Entities)
+		}
+	}
+
+	return errs
+}
+
+// addEntities adds the metrics, inventory and events of entities to the same entities of kafkaIntegration,
+// creating them there so they get its metadata
+func addEntities(kafkaIntegration *integration.Integration, entities []*integration.Entity) {
+	for _, entity := range entities {
+		var target *integration.Entity
+		if entity.Metadata == nil || entity.Metadata.Name == "" {
+			target = kafkaIntegration.LocalEntity()
+		} else {
+			var err error
+			target, err = kafkaIntegration.Entity(entity.Metadata.Name, entity.Metadata.Namespace, entity.Metadata.IDAttrs...)
+			if err != nil {
+				log.Error("Unable to create entity %s: %s", entity.Metadata.Name, err.Error())
+				continue
+			}
+		}
+
+		target.Metrics = append(target.Metrics, entity.Metrics...)
+		for key, item := range entity.Inventory.Items() {
+			for field, value := range item {
+				if err := target.SetInventoryItem(key, field, value); err != nil {
+					log.Error("Unable to set inventory item %s of entity %s: %s", key, entity.Metadata.Name, err.Error())
+				}
+			}
+		}
+		for _, e := range entity.Events {
+			if err := target.AddEvent(e); err != nil {
+				log.Error("Unable to add event of entity %s: %s", entity.Metadata.Name, err.Error())
+			}
+		}
+	}
+}
+
+// collectClusterFunc is the collectCluster used by collectClusters, replaced in tests
+var collectClusterFunc = collectCluster
+
+// collectCluster collects a single cluster into kafkaIntegration, whose entities are the cluster's
+func collectCluster(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) error {
+	connection.ResetClientMetrics(kafkaArgs.StateKeyPrefix(), kafkaArgs.CollectClientMetrics)
+
+	start := time.Now()
+	phases := newPhaseDurations()
+	defer func() {
+		phases.record(phaseTotal, start)
+		if err := phases.setMetrics(kafkaArgs, kafkaIntegration); err != nil {
+			log.Error("Failed to set collection duration metrics: %s", err.Error())
+		}
+		if err := setClientMetrics(kafkaArgs, kafkaIntegration); err != nil {
+			log.Error("Failed to set client metrics: %s", err.Error())
+		}
+		setCustomAttributes(kafkaIntegration.Entities, kafkaArgs.CustomAttributes)
+	}()
+
+	zkConn, err := zookeeper.NewConnection(kafkaArgs)
+	if err != nil {
+		return err
+	}
+	defer zkConn.Close()
+
+	if err := setKafkaVersion(kafkaArgs, zkConn); err != nil {
+		return err
+	}
+	phases.record(phaseBrokerDiscovery, start)
+
+	if !kafkaArgs.ConsumerOffset {
+		return coreCollection(kafkaArgs, zkConn, kafkaIntegration, phases)
+	}
+
+	offsetStart := time.Now()
+	if err := offc.Collect(kafkaArgs, zkConn, kafkaIntegration); err != nil {
+		return fmt.Errorf("failed collecting consumer offset data: %s", err)
+	}
+	phases.record(phaseConsumerOffsets, offsetStart)
+
+	return nil
+}
+
+// partialCollectionErr returns the errors the collectors logged and continued past since the last
+// collecterrors.Reset if failOnError is set. Whatever was collected is still published.
+func partialCollectionErr(failOnError bool) error {
+	if !failOnError {
+		return nil
+	}
+
+	return collecterrors.Err()
+}
+
+// setKafkaVersion detects the Kafka version when kafka_version is unset and turns off the features it doesn't
+// support. If detection fails the default version is used and every feature is attempted.
+func setKafkaVersion(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection) error {
+	if kafkaArgs.KafkaVersion != nil {
+		return nil
+	}
+
+	version, err := zookeeper.DetectKafkaVersion(kafkaArgs, zkConn)
+	if err != nil {
+		log.Warn("Unable to detect the Kafka version, using %s. Set kafka_version to skip detection: %s", zookeeper.DefaultKafkaVersion, err.Error())
+		version = zookeeper.DefaultKafkaVersion
+	} else {
+		log.Debug("Detected Kafka version %s", version)
+	}
+	kafkaArgs.KafkaVersion = &version
+
+	return kafkaArgs.GateFeatures(version)
+}
+
+// testClusterConnection runs the connection checks for a single cluster
+func testClusterConnection(argList args.ArgumentList) bool {
+	kafkaArgs, err := args.ParseArgs(argList)
+	if err != nil {
+		fmt.Printf("FAIL Arguments: %s\n", err.Error())
+		return false
+	}
+
+	zkConn, err := zookeeper.NewConnection(kafkaArgs)
+	if err == nil {
+		defer zkConn.Close()
+	}
+	return testConnection(kafkaArgs, os.Stdout, zkConn, err)
 }
 
 // coreCollection is the main integration collection. Does not handle consumerOffset collection
-func coreCollection(zkConn zookeeper.Connection, kafkaIntegration *integration.Integration) {
+func coreCollection(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, kafkaIntegration *integration.Integration, phases *phaseDurations) error {
+	topicStart := time.Now()
+
 	// Get topic list
-	collectedTopics, err := tc.GetTopics(zkConn)
-	ExitOnErr(err)
+	collectedTopics, err := tc.GetTopics(kafkaArgs, zkConn)
+	if err != nil {
+		return err
+	}
 	log.Debug("Collecting metrics for the following topics: %s", strings.Join(collectedTopics, ","))
 
 	// Enforce hard limits on Topics
 	collectedTopics = enforceTopicLimit(collectedTopics)
 
-	// Setup wait group
-	var wg sync.WaitGroup
+	// Brokers that keep failing are skipped, across runs, until their circuit breaker cools down
+	bc.OpenBrokerCircuits(kafkaArgs, kafkaIntegration)
+	defer func() {
+		if err := bc.SaveBrokerCircuits(kafkaArgs); err != nil {
+			collecterrors.Error("Error saving broker circuit breaker state: %s", err.Error())
+		}
+	}()
+
+	// Partition counts of the previous run, to report the topics whose partition count changed
+	tc.OpenPartitionCounts(kafkaArgs, kafkaIntegration)
+	defer func() {
+		if err := tc.SavePartitionCounts(kafkaArgs); err != nil {
+			collecterrors.Error("Error saving topic partition counts: %s", err.Error())
+		}
+	}()
+
+	// Setup wait groups. The JMX and topic workers are waited on separately to time them.
+	var jmxWG, topicWG sync.WaitGroup
+
+	// Collects per-broker topic throughput to be summed onto the topic entities
+	var throughput *bc.TopicThroughput
+	if kafkaArgs.CollectTopicThroughput {
+		throughput = bc.NewTopicThroughput()
+	}
+
+	// Read once for every topic worker
+	reassignments := tc.ReadReassignments(kafkaArgs, zkConn, collectedTopics)
 
 	// Start all worker pools
-	brokerChan := bc.StartBrokerPool(3, &wg, zkConn, kafkaIntegration, collectedTopics)
-	topicChan := tc.StartTopicPool(5, &wg, zkConn)
-	consumerChan := pcc.StartWorkerPool(3, &wg, kafkaIntegration, collectedTopics, pcc.ConsumerWorker)
-	producerChan := pcc.StartWorkerPool(3, &wg, kafkaIntegration, collectedTopics, pcc.ProducerWorker)
+	jmxStart := time.Now()
+	brokerChan := bc.StartBrokerPool(kafkaArgs, 3, &jmxWG, zkConn, kafkaIntegration, collectedTopics, throughput)
+	topicChan := tc.StartTopicPool(kafkaArgs, kafkaArgs.TopicWorkerPoolSize, &topicWG, zkConn, reassignments)
+	consumerChan := pcc.StartWorkerPool(kafkaArgs, 3, &jmxWG, kafkaIntegration, collectedTopics, pcc.ConsumerWorker)
+	producerChan := pcc.StartWorkerPool(kafkaArgs, 3, &jmxWG, kafkaIntegration, collectedTopics, pcc.ProducerWorker)
 
 	// After all worker pools are created start feeding them.
 	// It is important to not start feeding any pool until all are created
 	// so that a race condition does not exist between creating all pools and waiting.
 	// Run all of theses in their own Go Routine to maximize concurrency
 	go func() {
-		if err := bc.FeedBrokerPool(zkConn, brokerChan); err != nil {
-			log.Error("Unable to collect Brokers: %s", err.Error())
+		if err := bc.FeedBrokerPool(kafkaArgs, zkConn, brokerChan); err != nil {
+			collecterrors.Error("Unable to collect Brokers: %s", err.Error())
 		}
 	}()
 
-	go tc.FeedTopicPool(topicChan, kafkaIntegration, collectedTopics)
-	go pcc.FeedWorkerPool(consumerChan, args.GlobalArgs.Consumers)
-	go pcc.FeedWorkerPool(producerChan, args.GlobalArgs.Producers)
+	go tc.FeedTopicPool(kafkaArgs, topicChan, kafkaIntegration, collectedTopics)
+
+	// Producers and consumers only report metrics, so there is nothing
+	// to collect from them when running in inventory-only mode
+	consumers, producers := kafkaArgs.Consumers, kafkaArgs.Producers
+	if !kafkaArgs.HasMetrics() {
+		consumers, producers = nil, nil
+	}
+	go pcc.FeedWorkerPool(consumerChan, consumers)
+	go pcc.FeedWorkerPool(producerChan, producers)
 
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		jmxWG.Wait()
+		phases.record(phaseJMX, jmxStart)
+	}()
+	go func() {
+		defer wg.Done()
+		topicWG.Wait()
+		phases.record(phaseTopicCollection, topicStart)
+	}()
 	wg.Wait()
+
+	// Only safe to report once every broker worker is done
+	if throughput != nil {
+		throughput.Populate(kafkaArgs, kafkaIntegration)
+	}
+
+	if kafkaArgs.CollectBrokerTopicData && kafkaArgs.HasMetrics() {
+		if err := bc.CollectClusterMetrics(kafkaArgs, zkConn, kafkaIntegration); err != nil {
+			collecterrors.Error("Failed to collect cluster metrics: %s", err.Error())
+		}
+	}
+
+	if kafkaArgs.CollectTransactions && kafkaArgs.HasMetrics() {
+		if err := bc.CollectTransactionMetrics(kafkaArgs, zkConn, kafkaIntegration); err != nil {
+			collecterrors.Error("Failed to collect transaction metrics: %s", err.Error())
+		}
+	}
+
+	if kafkaArgs.CollectOffsetsTopic && kafkaArgs.HasMetrics() {
+		if err := bc.CollectOffsetsTopicMetrics(kafkaArgs, zkConn, kafkaIntegration); err != nil {
+			collecterrors.Error("Failed to collect offsets topic metrics: %s", err.Error())
+		}
+	}
+
+	if kafkaArgs.CollectZookeeperMetrics && kafkaArgs.HasMetrics() {
+		collectZookeeperMetrics(kafkaArgs, kafkaIntegration)
+	}
+
+	if kafkaArgs.AnyTopicCollectsLogDirSizes() && kafkaArgs.HasMetrics() {
+		if err := bc.CollectLogDirMetrics(kafkaArgs, zkConn, collectedTopics, kafkaIntegration); err != nil {
+			collecterrors.Error("Failed to collect log directory metrics: %s", err.Error())
+		}
+	}
+
+	if kafkaArgs.AnyTopicCollectsInventory() && kafkaArgs.HasInventory() {
+		if err := bc.CollectConfigInventory(kafkaArgs, zkConn, collectedTopics, kafkaIntegration); err != nil {
+			collecterrors.Error("Failed to collect configuration inventory: %s", err.Error())
+		}
+	}
+
+	return nil
 }
 
 // ExitOnErr will exit with a 1 if the error is non-nil
@@ -93,7 +471,7 @@ func coreCollection(zkConn zookeeper.Connection, kafkaIntegration *integration.I
 func ExitOnErr(err error) {
 	if err != nil {
 		log.Error("Integration failed: %s", err)
-		os.Exit(1)
+		exit(1)
 	}
 }
 
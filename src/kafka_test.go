@@ -1,8 +1,19 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_enforceTopicLimit(t *testing.T) {
@@ -36,3 +47,114 @@ func Test_enforceTopicLimit(t *testing.T) {
 		}
 	}
 }
+
+func Test_validateArgs(t *testing.T) {
+	argList := args.ArgumentList{MetricDenyRegex: `^broker\.`}
+	clusterArgLists := []args.ArgumentList{
+		{ClusterName: "cluster1", MetricDenyRegex: `^broker\.`},
+		{ClusterName: "cluster2", MetricDenyRegex: `^broker\.`, ConsumerGroupRegex: "group-("},
+	}
+
+	// An invalid pattern is reported before any cluster is collected
+	filter, err := validateArgs(argList, clusterArgLists)
+	if err == nil {
+		t.Fatal("Expected error for invalid consumer_group_regex")
+	}
+	if filter != nil {
+		t.Errorf("Expected no filter, got %v", filter)
+	}
+	if !strings.Contains(err.Error(), "cluster 'cluster2'") || !strings.Contains(err.Error(), "consumer_group_regex 'group-('") {
+		t.Errorf("Unexpected error message: %s", err.Error())
+	}
+
+	filter, err = validateArgs(argList, clusterArgLists[:1])
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if filter == nil || filter.allowed("broker.IOInPerSecond") {
+		t.Error("Expected filter denying broker metrics")
+	}
+}
+
+func Test_partialCollectionErr(t *testing.T) {
+	collecterrors.Reset()
+	defer collecterrors.Reset()
+	collecterrors.Error("Unable to collect broker %d", 1)
+
+	// By default errors collectors continued past don't fail collection
+	if err := partialCollectionErr(false); err != nil {
+		t.Errorf("Expected no error, got %s", err)
+	}
+
+	err := partialCollectionErr(true)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if !strings.Contains(err.Error(), "Unable to collect broker 1") {
+		t.Errorf("Expected the recorded error, got %s", err)
+	}
+
+	collecterrors.Reset()
+	if err := partialCollectionErr(true); err != nil {
+		t.Errorf("Expected no error without recorded errors, got %s", err)
+	}
+}
+
+func Test_collectClusters(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	collectClusterFunc = func(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) error {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		if kafkaArgs.ClusterName == "cluster3" {
+			return errors.New("collection failed")
+		}
+		clusterEntity, err := kafkaIntegration.Entity(kafkaArgs.ClusterName, "ka-cluster")
+		if err != nil {
+			return err
+		}
+		clusterEntity.NewMetricSet("KafkaIntegrationSample")
+		return nil
+	}
+	defer func() { collectClusterFunc = collectCluster }()
+
+	// The integration fills in the defaults of the arguments
+	var argList args.ArgumentList
+	kafkaIntegration, err := integration.New("test", "1.0.0", integration.Args(&argList), integration.InMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterArgLists := make([]args.ArgumentList, 4)
+	for i := range clusterArgLists {
+		clusterArgLists[i] = argList
+		clusterArgLists[i].ClusterName = fmt.Sprintf("cluster%d", i+1)
+	}
+	clusterArgLists[1].ZookeeperHosts = "not json"
+
+	errs := collectClusters(clusterArgLists, 2, persist.NewInMemoryStore(), kafkaIntegration)
+
+	assert.Equal(t, 2, maxRunning)
+	assert.Equal(t, 4, len(errs))
+	assert.Nil(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.EqualError(t, errs[2], "collection failed")
+	assert.Nil(t, errs[3])
+
+	// The entities of each cluster are added in the order of the clusters
+	if assert.Equal(t, 2, len(kafkaIntegration.Entities)) {
+		assert.Equal(t, "cluster1", kafkaIntegration.Entities[0].Metadata.Name)
+		assert.Equal(t, "cluster4", kafkaIntegration.Entities[1].Metadata.Name)
+		assert.Equal(t, 1, len(kafkaIntegration.Entities[1].Metrics))
+	}
+}
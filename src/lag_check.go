@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+)
+
+// groupLag is the lag of a consumer group compared to lag_threshold by --check_lag
+type groupLag struct {
+	group string
+	total int64
+	max   int64
+}
+
+// checkLag collects the consumer offsets of every cluster and writes a line to w for each consumer group whose
+// lag exceeds lag_threshold. Nothing is published. Returns true if every cluster was collected and no group
+// exceeds the threshold.
+func checkLag(w io.Writer, clusterArgLists []args.ArgumentList, kafkaIntegration *integration.Integration) bool {
+	passed := true
+	for _, clusterArgList := range clusterArgLists {
+		passed = checkClusterLag(w, clusterArgList, kafkaIntegration) && passed
+	}
+
+	return passed
+}
+
+// checkClusterLag checks the lag of the consumer groups of a single cluster
+func checkClusterLag(w io.Writer, argList args.ArgumentList, kafkaIntegration *integration.Integration) bool {
+	// Only the offsets are needed, which is all collectCluster collects in consumer offset mode. The lag
+	// needs the high water marks, and the lag of every partition, including those of groups below
+	// min_lag_to_report.
+	argList.ConsumerOffset = true
+	argList.CollectHighWaterMarks = true
+	argList.MinLagToReport = 0
+	defer kafkaIntegration.Clear()
+
+	kafkaArgs, err := args.ParseArgs(argList)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL cluster '%s': %s\n", argList.ClusterName, err.Error())
+		return false
+	}
+
+	// The clusters are checked one at a time, so the errors recorded are the cluster's
+	collecterrors.Reset()
+	if err := collectCluster(kafkaArgs, kafkaIntegration); err != nil {
+		fmt.Fprintf(w, "FAIL cluster '%s': %s\n", argList.ClusterName, err.Error())
+		return false
+	}
+	if err := partialCollectionErr(kafkaArgs.FailOnError); err != nil {
+		fmt.Fprintf(w, "FAIL cluster '%s': %s\n", argList.ClusterName, err.Error())
+		return false
+	}
+
+	lags := consumerGroupLags(kafkaIntegration.Entities)
+	return writeLagResults(w, kafkaArgs.ClusterName, lags, kafkaArgs.LagThreshold, kafkaArgs.LagThresholdMode)
+}
+
+// writeLagResults writes a FAIL line for each consumer group whose lag in mode exceeds threshold, or a single
+// PASS line for the cluster if none does. Returns true if no group exceeds the threshold.
+func writeLagResults(w io.Writer, clusterName string, lags []groupLag, threshold int64, mode string) bool {
+	passed := true
+	for _, lag := range lags {
+		value := lag.total
+		if mode == args.LagThresholdMax {
+			value = lag.max
+		}
+
+		if value > threshold {
+			fmt.Fprintf(w, "FAIL consumer group '%s' in cluster '%s': %s lag %d exceeds lag_threshold %d\n", lag.group, clusterName, mode, value, threshold)
+			passed = false
+		}
+	}
+
+	if passed {
+		fmt.Fprintf(w, "PASS cluster '%s': %d consumer groups within lag_threshold %d\n", clusterName, len(lags), threshold)
+	}
+
+	return passed
+}
+
+// add counts the lag of a partition, if it has one
+func (l *groupLag) add(partitionLag interface{}) {
+	lag, ok := partitionLag.(float64)
+	if !ok {
+		return
+	}
+
+	l.total += int64(lag)
+	if int64(lag) > l.max {
+		l.max = int64(lag)
+	}
+}
+
+// consumerGroupLags sums the partition lags of every consumer group, ordered by group name. consumer_groups
+// reports them on the consumer group entity as kafka.consumerLag, while consumer_group_regex and the offsets
+// topic strategy report them on the partition consumer entities as consumer.lag. Partitions without a lag
+// aren't counted.
+func consumerGroupLags(entities []*integration.Entity) []groupLag {
+	groups := make(map[string]*groupLag)
+	lagOf := func(group string) *groupLag {
+		if _, ok := groups[group]; !ok {
+			groups[group] = &groupLag{group: group}
+		}
+		return groups[group]
+	}
+
+	for _, entity := range entities {
+		if entity.Metadata == nil {
+			continue
+		}
+
+		switch entity.Metadata.Namespace {
+		case "ka-consumerGroup":
+			lag := lagOf(entity.Metadata.Name)
+			for _, metricSet := range entity.Metrics {
+				lag.add(metricSet.Metrics["kafka.consumerLag"])
+			}
+		case "ka-partition-consumer":
+			for _, metricSet := range entity.Metrics {
+				if group, ok := metricSet.Metrics["consumerGroup"].(string); ok {
+					lagOf(group).add(metricSet.Metrics["consumer.lag"])
+				}
+			}
+		}
+	}
+
+	lags := make([]groupLag, 0, len(groups))
+	for _, lag := range groups {
+		lags = append(lags, *lag)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].group < lags[j].group })
+	return lags
+}
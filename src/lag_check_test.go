@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/conoffsetcollect"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_consumerGroupLags(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusterIDAttr := integration.NewIDAttribute("clusterName", "testcluster")
+	for group, partitionLags := range map[string][]int64{"group-b": {10, 30}, "group-a": {5}} {
+		groupEntity, err := i.Entity(group, "ka-consumerGroup", clusterIDAttr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, lag := range partitionLags {
+			assert.Nil(t, groupEntity.NewMetricSet("KafkaOffsetSample").SetMetric("kafka.consumerLag", lag, metric.GAUGE))
+		}
+		assert.Nil(t, groupEntity.NewMetricSet("KafkaOffsetSample").SetMetric("kafka.consumerLagMax", 30, metric.GAUGE))
+	}
+	topicEntity, _ := i.Entity("topic", "ka-topic", clusterIDAttr)
+	assert.Nil(t, topicEntity.NewMetricSet("KafkaTopicSample").SetMetric("kafka.consumerLag", 100, metric.GAUGE))
+
+	expected := []groupLag{
+		{group: "group-a", total: 5, max: 5},
+		{group: "group-b", total: 40, max: 30},
+	}
+	assert.Equal(t, expected, consumerGroupLags(i.Entities))
+}
+
+func Test_writeLagResults(t *testing.T) {
+	lags := []groupLag{
+		{group: "group-a", total: 5, max: 5},
+		{group: "group-b", total: 40, max: 30},
+	}
+
+	var out bytes.Buffer
+	assert.False(t, writeLagResults(&out, "testcluster", lags, 35, args.LagThresholdTotal))
+	assert.Equal(t, "FAIL consumer group 'group-b' in cluster 'testcluster': total lag 40 exceeds lag_threshold 35\n", out.String())
+
+	out.Reset()
+	assert.True(t, writeLagResults(&out, "testcluster", lags, 35, args.LagThresholdMax))
+	assert.True(t, strings.HasPrefix(out.String(), "PASS cluster 'testcluster': 2 consumer groups"))
+
+	out.Reset()
+	assert.False(t, writeLagResults(&out, "testcluster", lags, 4, args.LagThresholdMax))
+	assert.Equal(t, 2, strings.Count(out.String(), "FAIL"))
+}
+
+func Test_consumerGroupLags_RegexPath(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockZk := &zookeeper.MockConnection{}
+	mockClient := connection.MockClient{}
+	mockClusterAdmin := connection.MockClusterAdmin{}
+	mockBroker := connection.MockBroker{}
+
+	kafkaArgs := &args.KafkaArguments{
+		ClusterName:           "testcluster",
+		OffsetSampleName:      "KafkaOffsetSample",
+		ConsumerGroupRegex:    regexp.MustCompile("^group"),
+		CollectHighWaterMarks: true,
+		CheckLag:              true,
+	}
+
+	offsets := &sarama.OffsetFetchResponse{}
+	offsets.AddBlock("topic", 0, &sarama.OffsetFetchResponseBlock{Offset: 10, Err: sarama.ErrNoError})
+	offsets.AddBlock("topic", 1, &sarama.OffsetFetchResponseBlock{Offset: 20, Err: sarama.ErrNoError})
+	hwms := &sarama.OffsetResponse{}
+	hwms.AddTopicPartition("topic", 0, 25)
+	hwms.AddTopicPartition("topic", 1, 50)
+
+	mockZk.On("CreateClient").Return(&mockClient, nil)
+	mockZk.On("CreateClusterAdmin").Return(&mockClusterAdmin, nil)
+	mockClient.On("Close").Return(nil)
+	mockClient.On("Coordinator", "groupA").Return(&mockBroker, nil)
+	mockClient.On("Leader", "topic", mock.Anything).Return(&mockBroker, nil)
+	mockBroker.On("ID").Return(int32(1))
+	mockBroker.On("Connected").Return(false, nil)
+	mockBroker.On("Open", mock.Anything).Return(nil)
+	mockBroker.On("GetAvailableOffsets", mock.Anything).Return(hwms, nil)
+	mockClusterAdmin.On("Close").Return(nil)
+	mockClusterAdmin.On("DescribeTopics", []string(nil)).Return([]*sarama.TopicMetadata{}, nil)
+	mockClusterAdmin.On("ListConsumerGroups").Return(map[string]string{"groupA": "consumer"}, nil)
+	mockClusterAdmin.On("DescribeConsumerGroups", mock.Anything).Return([]*sarama.GroupDescription{{GroupId: "groupA"}}, nil)
+	mockClusterAdmin.On("ListConsumerGroupOffsets", "groupA", mock.Anything).Return(offsets, nil)
+
+	assert.Nil(t, conoffsetcollect.Collect(kafkaArgs, mockZk, i))
+
+	assert.Equal(t, []groupLag{{group: "groupA", total: 45, max: 30}}, consumerGroupLags(i.Entities))
+}
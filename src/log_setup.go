@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// Log formats of the log_format argument
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevels ranks the levels of the log_level argument, from the least to the most verbose
+var logLevels = map[string]int{"error": 0, "warn": 1, "info": 2, "debug": 3}
+
+// prefixLevels maps the prefixes the SDK logger writes to the log level of the line. Fatal lines are always written.
+var prefixLevels = map[string]string{"[FATAL] ": "fatal", "[ERR] ": "error", "[WARN] ": "warn", "[INFO] ": "info", "[DEBUG] ": "debug"}
+
+// flushLogs waits for the log lines written so far to reach stderr. Replaced by setupLogging when the
+// log lines go through a logWriter.
+var flushLogs = func() {}
+
+// logLine is a log line in the json log_format
+type logLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// logWriter filters the lines written by the SDK logger by level, and rewrites them as JSON for the json log_format
+type logWriter struct {
+	out      io.Writer
+	maxLevel int
+	json     bool
+	now      func() time.Time
+	// level is the level of the last prefixed line, for the lines of a message spanning several lines
+	level string
+}
+
+func (w *logWriter) writeLine(line string) {
+	message := line
+	for prefix, level := range prefixLevels {
+		if strings.HasPrefix(line, prefix) {
+			w.level, message = level, strings.TrimPrefix(line, prefix)
+			break
+		}
+	}
+
+	if rank, ok := logLevels[w.level]; ok && rank > w.maxLevel {
+		return
+	}
+
+	if !w.json {
+		fmt.Fprintln(w.out, line)
+		return
+	}
+
+	encoded, err := json.Marshal(logLine{Time: w.now().UTC().Format(time.RFC3339Nano), Level: w.level, Message: message})
+	if err != nil {
+		fmt.Fprintln(w.out, line)
+		return
+	}
+	fmt.Fprintln(w.out, string(encoded))
+}
+
+// setupLogging configures the SDK logger from the log_level and log_format arguments. The SDK logger only
+// writes human-readable lines to os.Stderr with debug on or off, so for any other level or format os.Stderr
+// is replaced with a pipe whose lines are filtered and formatted by a logWriter before reaching stderr.
+// flushLogs must then be called before exiting so no line is lost.
+func setupLogging(verbose bool, level, format string) error {
+	if verbose {
+		level = "debug"
+	}
+
+	maxLevel, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("invalid log_level '%s', must be one of error, warn, info or debug", level)
+	}
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("invalid log_format '%s', must be one of '%s' or '%s'", format, logFormatText, logFormatJSON)
+	}
+
+	debug := level == "debug"
+	if format == logFormatText && maxLevel >= logLevels["info"] {
+		log.SetupLogging(debug)
+		return nil
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create log pipe: %s", err)
+	}
+
+	stderr := os.Stderr
+	lines := &logWriter{out: stderr, maxLevel: maxLevel, json: format == logFormatJSON, now: time.Now, level: "info"}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			lines.writeLine(scanner.Text())
+		}
+	}()
+
+	// The SDK logger writes to the os.Stderr of the time it is set up
+	os.Stderr = writer
+	log.SetupLogging(debug)
+	os.Stderr = stderr
+
+	flushLogs = func() {
+		writer.Close()
+		<-done
+	}
+
+	return nil
+}
+
+// exit flushes the logs and exits with code
+func exit(code int) {
+	flushLogs()
+	os.Exit(code)
+}
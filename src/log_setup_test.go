@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_logWriter_Level(t *testing.T) {
+	var out bytes.Buffer
+	w := &logWriter{out: &out, maxLevel: logLevels["warn"], now: time.Now, level: "info"}
+
+	for _, line := range []string{"[DEBUG] debug", "[INFO] info", "[WARN] warn", "[ERR] error", "continued error", "[INFO] info", "continued info", "[FATAL] can't continue"} {
+		w.writeLine(line)
+	}
+
+	assert.Equal(t, "[WARN] warn\n[ERR] error\ncontinued error\n[FATAL] can't continue\n", out.String())
+}
+
+func Test_logWriter_JSON(t *testing.T) {
+	var out bytes.Buffer
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	w := &logWriter{out: &out, maxLevel: logLevels["info"], json: true, now: func() time.Time { return now }, level: "info"}
+
+	w.writeLine(`[WARN] Unable to reach broker "broker-1"`)
+	w.writeLine("[DEBUG] skipped")
+
+	assert.Equal(t, `{"time":"2020-01-02T03:04:05Z","level":"warn","message":"Unable to reach broker \"broker-1\""}`+"\n", out.String())
+}
+
+func Test_setupLogging_Invalid(t *testing.T) {
+	assert.NotNil(t, setupLogging(false, "verbose", logFormatText))
+	assert.NotNil(t, setupLogging(false, "info", "xml"))
+	assert.Nil(t, setupLogging(false, "info", logFormatText))
+}
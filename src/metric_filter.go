@@ -0,0 +1,77 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+)
+
+// metricFilter drops metrics by name from the collected samples before they are sent, and prefixes the names
+// of the remaining ones
+type metricFilter struct {
+	allow  *regexp.Regexp
+	deny   *regexp.Regexp
+	prefix string
+}
+
+// newMetricFilter returns a filter on the compiled metric_allow_regex and metric_deny_regex arguments that
+// prefixes the metric names with metric_prefix. Returns nil if none is set.
+func newMetricFilter(allow, deny *regexp.Regexp, prefix string) *metricFilter {
+	if allow == nil && deny == nil && prefix == "" {
+		return nil
+	}
+
+	return &metricFilter{allow: allow, deny: deny, prefix: prefix}
+}
+
+// allowed returns true if the metric called name is reported. A name matching the deny regex is dropped even
+// if it also matches the allow regex.
+func (f *metricFilter) allowed(name string) bool {
+	if f.deny != nil && f.deny.MatchString(name) {
+		return false
+	}
+
+	return f.allow == nil || f.allow.MatchString(name)
+}
+
+// apply removes the metrics that aren't allowed from every metric set on the entities, then prefixes the names of
+// the others. The regexes match the names before they are prefixed. Attributes, such as event_type and the entity
+// and cluster names, are always kept and never prefixed so the remaining metrics can still be queried.
+// A nil filter keeps everything.
+func (f *metricFilter) apply(entities []*integration.Entity) {
+	if f == nil {
+		return
+	}
+
+	for _, entity := range entities {
+		for _, set := range entity.Metrics {
+			for name, value := range set.Metrics {
+				if _, isAttribute := value.(string); isAttribute {
+					continue
+				}
+
+				if !f.allowed(name) {
+					delete(set.Metrics, name)
+				}
+			}
+
+			if f.prefix != "" {
+				prefixMetrics(set, f.prefix)
+			}
+		}
+	}
+}
+
+// prefixMetrics renames the metrics of set, leaving the attributes as they are
+func prefixMetrics(set *metric.Set, prefix string) {
+	// Renamed in a new map, since keys added to a map while ranging over it may be visited again
+	prefixed := make(map[string]interface{}, len(set.Metrics))
+	for name, value := range set.Metrics {
+		if _, isAttribute := value.(string); !isAttribute {
+			name = prefix + name
+		}
+		prefixed[name] = value
+	}
+	set.Metrics = prefixed
+}
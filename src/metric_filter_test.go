@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newMetricFilter(t *testing.T) {
+	assert.Nil(t, newMetricFilter(nil, nil, ""))
+	assert.NotNil(t, newMetricFilter(nil, regexp.MustCompile(`^broker\.`), ""))
+	assert.NotNil(t, newMetricFilter(nil, nil, "myorg."))
+}
+
+func Test_metricFilter_allowed(t *testing.T) {
+	filter := newMetricFilter(regexp.MustCompile(`^broker\.`), regexp.MustCompile(`PerSecond$`), "")
+
+	assert.True(t, filter.allowed("broker.logFlushRate"))
+	assert.False(t, filter.allowed("topic.diskSize"))
+	// Deny wins over allow
+	assert.False(t, filter.allowed("broker.IOInPerSecond"))
+}
+
+func Test_metricFilter_apply(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, _ := i.Entity("broker0:9092", "ka-broker")
+	sample := broker.NewMetricSet("KafkaBrokerSample", metric.Attribute{Key: "displayName", Value: "broker0:9092"})
+	sample.SetMetric("broker.IOInPerSecond", 12.5, metric.GAUGE)
+	sample.SetMetric("broker.logFlushRate", 2, metric.GAUGE)
+
+	group, _ := i.Entity("group1", "ka-consumerGroup")
+	sample = group.NewMetricSet("KafkaOffsetSample", metric.Attribute{Key: "topic", Value: "topic1"})
+	sample.SetMetric("kafka.consumerLag", 7, metric.GAUGE)
+	sample.SetMetric("consumer.hwm", 40, metric.GAUGE)
+
+	filter := newMetricFilter(nil, regexp.MustCompile(`^broker\.IOInPerSecond$|^consumer\.`), "")
+	filter.apply(i.Entities)
+
+	output, err := json.Marshal(i)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(output), "broker.IOInPerSecond")
+	assert.NotContains(t, string(output), "consumer.hwm")
+
+	assert.Equal(t, float64(2), broker.Metrics[0].Metrics["broker.logFlushRate"])
+	assert.Equal(t, "KafkaBrokerSample", broker.Metrics[0].Metrics["event_type"])
+	assert.Equal(t, float64(7), group.Metrics[0].Metrics["kafka.consumerLag"])
+	assert.Equal(t, "topic1", group.Metrics[0].Metrics["topic"])
+}
+
+func Test_metricFilter_apply_Prefix(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, _ := i.Entity("group1", "ka-consumerGroup")
+	sample := group.NewMetricSet("KafkaOffsetSample", metric.Attribute{Key: "topic", Value: "topic1"})
+	sample.SetMetric("kafka.consumerLag", 7, metric.GAUGE)
+	sample.SetMetric("consumer.hwm", 40, metric.GAUGE)
+	sample.SetMetric("assignmentStrategy", "range", metric.ATTRIBUTE)
+
+	// The deny regex matches the name before it's prefixed
+	filter := newMetricFilter(nil, regexp.MustCompile(`^consumer\.`), "myorg.")
+	filter.apply(i.Entities)
+
+	assert.Equal(t, map[string]interface{}{
+		"event_type":              "KafkaOffsetSample",
+		"topic":                   "topic1",
+		"assignmentStrategy":      "range",
+		"myorg.kafka.consumerLag": float64(7),
+	}, group.Metrics[0].Metrics)
+}
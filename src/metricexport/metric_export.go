@@ -0,0 +1,41 @@
+// Package metricexport holds the helpers shared by the exporters that send the collected metrics to other
+// monitoring systems, such as Prometheus and StatsD
+package metricexport
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Sanitize replaces the characters of name matched by invalid, which differ between formats, with underscores
+func Sanitize(name string, invalid *regexp.Regexp) string {
+	return invalid.ReplaceAllString(name, "_")
+}
+
+// Prefix prepends prefix to name unless it already starts with it, so kafka.consumerLag isn't reported as
+// kafka.kafka.consumerLag
+func Prefix(name, prefix string) string {
+	if strings.HasPrefix(name, prefix) {
+		return name
+	}
+
+	return prefix + name
+}
+
+// NumericValue returns the value of a metric as a float64. Returns false for attributes.
+func NumericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
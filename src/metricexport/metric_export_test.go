@@ -0,0 +1,26 @@
+package metricexport
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "broker_IOInPerSecond", Sanitize("broker.IOInPerSecond", regexp.MustCompile(`[^a-zA-Z0-9_]`)))
+}
+
+func TestPrefix(t *testing.T) {
+	assert.Equal(t, "kafka_broker_IOInPerSecond", Prefix("broker_IOInPerSecond", "kafka_"))
+	assert.Equal(t, "kafka_consumerLag", Prefix("kafka_consumerLag", "kafka_"))
+}
+
+func TestNumericValue(t *testing.T) {
+	v, ok := NumericValue(3)
+	assert.True(t, ok)
+	assert.Equal(t, float64(3), v)
+
+	_, ok = NumericValue("broker")
+	assert.False(t, ok)
+}
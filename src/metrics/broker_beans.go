@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/nri-kafka/src/args"
+)
+
+// brokerBeans is the registry of single attribute broker MBeans reported on the broker sample, for broker
+// diagnostics. A bean is added by appending its mapping here, or without a code change with broker_jmx_beans.
+// Beans a broker doesn't have, such as those added after its Kafka version, are skipped.
+var brokerBeans = []*args.JMXBean{
+	// Requests waiting in the purgatories for their conditions to be met, such as acks=all produce requests
+	// waiting for their replicas and fetch requests waiting for fetch.min.bytes
+	{
+		MBean:      "kafka.server:type=DelayedOperationPurgatory,name=PurgatorySize,delayedOperation=Produce",
+		Attribute:  "Value",
+		Metric:     "kafka.broker.purgatorySize.produce",
+		SourceType: metric.GAUGE,
+	},
+	{
+		MBean:      "kafka.server:type=DelayedOperationPurgatory,name=PurgatorySize,delayedOperation=Fetch",
+		Attribute:  "Value",
+		Metric:     "kafka.broker.purgatorySize.fetch",
+		SourceType: metric.GAUGE,
+	},
+	// The largest lag in messages of the follower replicas of the broker behind their leaders. The replica manager
+	// registers it under the replica fetcher manager.
+	{
+		MBean:      "kafka.server:type=ReplicaFetcherManager,name=MaxLag,clientId=Replica",
+		Attribute:  "Value",
+		Metric:     "kafka.broker.replicaMaxLag",
+		SourceType: metric.GAUGE,
+	},
+}
+
+// brokerBeanMetricSets returns the metric sets of the registered broker beans followed by the broker_jmx_beans.
+// A broker_jmx_beans metric named like a registered one or another broker metric is left out, so it can't
+// replace it.
+func brokerBeanMetricSets(kafkaArgs *args.KafkaArguments) []*JMXMetricSet {
+	metricSets := make([]*JMXMetricSet, 0, len(brokerBeans)+len(kafkaArgs.BrokerJMXBeans))
+	registered := make(map[string]bool)
+	for _, metricSet := range brokerMetricDefs {
+		for _, metricDef := range metricSet.MetricDefs {
+			registered[metricDef.Name] = true
+		}
+	}
+	for _, bean := range brokerBeans {
+		registered[bean.Metric] = true
+		metricSets = append(metricSets, beanMetricSet(bean))
+	}
+
+	for _, bean := range kafkaArgs.BrokerJMXBeans {
+		if !registered[bean.Metric] {
+			metricSets = append(metricSets, beanMetricSet(bean))
+		}
+	}
+
+	return metricSets
+}
+
+func beanMetricSet(bean *args.JMXBean) *JMXMetricSet {
+	return &JMXMetricSet{
+		MBean:        bean.MBean,
+		MetricPrefix: bean.MBean + ",",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       bean.Metric,
+				SourceType: bean.SourceType,
+				JMXAttr:    "attr=" + bean.Attribute,
+			},
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/jmxwrapper"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBrokerMetrics_BrokerBeans(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.BrokerJMXBeans = []*args.JMXBean{
+		{MBean: "kafka.server:type=SessionExpireListener,name=ZooKeeperExpiresPerSec", Attribute: "Count", Metric: "kafka.broker.zookeeperExpires", SourceType: metric.GAUGE},
+		// Can't replace a registered metric
+		{MBean: "kafka.server:type=Custom,name=Produce", Attribute: "Value", Metric: "kafka.broker.purgatorySize.produce", SourceType: metric.GAUGE},
+	}
+
+	// The broker has no ReplicaFetcherManager MaxLag bean
+	beans := map[string]map[string]interface{}{
+		"kafka.server:type=DelayedOperationPurgatory,name=PurgatorySize,delayedOperation=Produce": {
+			"kafka.server:type=DelayedOperationPurgatory,name=PurgatorySize,delayedOperation=Produce,attr=Value": float64(12),
+		},
+		"kafka.server:type=DelayedOperationPurgatory,name=PurgatorySize,delayedOperation=Fetch": {
+			"kafka.server:type=DelayedOperationPurgatory,name=PurgatorySize,delayedOperation=Fetch,attr=Value": float64(340),
+		},
+		"kafka.server:type=SessionExpireListener,name=ZooKeeperExpiresPerSec": {
+			"kafka.server:type=SessionExpireListener,name=ZooKeeperExpiresPerSec,attr=Count": float64(3),
+		},
+		"kafka.server:type=Custom,name=Produce": {
+			"kafka.server:type=Custom,name=Produce,attr=Value": float64(99),
+		},
+	}
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		return beans[query], nil
+	}
+
+	i, _ := integration.New("test", "1.0.0")
+	e, _ := i.Entity("testEntity", "testNamespace")
+	m := e.NewMetricSet("testMetrics")
+
+	GetBrokerMetrics(kafkaArgs, m)
+
+	assert.Equal(t, float64(12), m.Metrics["kafka.broker.purgatorySize.produce"])
+	assert.Equal(t, float64(340), m.Metrics["kafka.broker.purgatorySize.fetch"])
+	assert.NotContains(t, m.Metrics, "kafka.broker.replicaMaxLag")
+	assert.Equal(t, float64(3), m.Metrics["kafka.broker.zookeeperExpires"])
+}
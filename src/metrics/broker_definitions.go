@@ -179,6 +179,11 @@ var brokerMetricDefs = []*JMXMetricSet{
 				SourceType: metric.RATE,
 				JMXAttr:    "name=LogFlushRateAndTimeMs,attr=Count",
 			},
+			{
+				Name:       "kafka.broker.logFlushTimeMs.p99",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "name=LogFlushRateAndTimeMs,attr=99thPercentile",
+			},
 		},
 	},
 	// Idle Handler
@@ -227,12 +232,101 @@ var BrokerTopicMetricDefs = []*JMXMetricSet{
 	},
 }
 
+// TopicThroughputMetricDefs metric definitions for the per-broker topic throughput rates
+// that are summed across brokers and reported on the Topic entity
+var TopicThroughputMetricDefs = []*JMXMetricSet{
+	{
+		MBean:        "kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec,topic=" + topicHolder,
+		MetricPrefix: "kafka.server:type=BrokerTopicMetrics,name=MessagesInPerSec,topic=" + topicHolder + ",",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       "kafka.topic.messagesInPerSec",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=OneMinuteRate",
+			},
+		},
+	},
+	{
+		MBean:        "kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec,topic=" + topicHolder,
+		MetricPrefix: "kafka.server:type=BrokerTopicMetrics,name=BytesInPerSec,topic=" + topicHolder + ",",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       "kafka.topic.bytesInPerSec",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=OneMinuteRate",
+			},
+		},
+	},
+	{
+		MBean:        "kafka.server:type=BrokerTopicMetrics,name=BytesOutPerSec,topic=" + topicHolder,
+		MetricPrefix: "kafka.server:type=BrokerTopicMetrics,name=BytesOutPerSec,topic=" + topicHolder + ",",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       "kafka.topic.bytesOutPerSec",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=OneMinuteRate",
+			},
+		},
+	},
+}
+
 // TopicSizeMetricDef metric definition for calculating the roll up for a Topic's
 // on disk size for a given Broker
 var TopicSizeMetricDef = &JMXMetricSet{
 	MBean: "kafka.log:type=Log,name=Size,topic=" + topicHolder + ",partition=*",
 }
 
+// QuotaMetricDefs metric definitions for the client quota MBeans of a Broker. There is an MBean of each type
+// per quota principal, with a user and/or client-id key property depending on the quotas configured, so
+// MBean is a pattern and MetricPrefix is unused.
+var QuotaMetricDefs = []*JMXMetricSet{
+	{
+		MBean: "kafka.server:type=Fetch,*",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       "kafka.quota.fetchThrottleTimeMs",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=throttle-time",
+			},
+			{
+				Name:       "kafka.quota.fetchBytesPerSecond",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=byte-rate",
+			},
+		},
+	},
+	{
+		MBean: "kafka.server:type=Produce,*",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       "kafka.quota.produceThrottleTimeMs",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=throttle-time",
+			},
+			{
+				Name:       "kafka.quota.produceBytesPerSecond",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=byte-rate",
+			},
+		},
+	},
+	{
+		MBean: "kafka.server:type=Request,*",
+		MetricDefs: []*MetricDefinition{
+			{
+				Name:       "kafka.quota.requestThrottleTimeMs",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=throttle-time",
+			},
+			{
+				Name:       "kafka.quota.requestTimePercent",
+				SourceType: metric.GAUGE,
+				JMXAttr:    "attr=request-time",
+			},
+		},
+	},
+}
+
 // ApplyTopicName to modified bean name for Topic
 func ApplyTopicName(topicName string) BeanModifier {
 	return func(beanName string) string {
@@ -10,31 +10,32 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
 	"github.com/newrelic/nri-kafka/src/jmxwrapper"
 )
 
 // GetBrokerMetrics collects all Broker JMX metrics and stores them in sample
-func GetBrokerMetrics(sample *metric.Set) {
-	CollectMetricDefintions(sample, brokerMetricDefs, nil)
+func GetBrokerMetrics(kafkaArgs *args.KafkaArguments, sample *metric.Set) {
+	CollectMetricDefintions(kafkaArgs, sample, append(brokerMetricDefs, brokerBeanMetricSets(kafkaArgs)...), nil)
 }
 
 // GetConsumerMetrics collects all Consumer metrics for the given
 // consumerName and stores them in sample.
-func GetConsumerMetrics(consumerName string, sample *metric.Set) {
-	CollectMetricDefintions(sample, consumerMetricDefs, applyConsumerName(consumerName))
+func GetConsumerMetrics(kafkaArgs *args.KafkaArguments, consumerName string, sample *metric.Set) {
+	CollectMetricDefintions(kafkaArgs, sample, consumerMetricDefs, applyConsumerName(consumerName))
 }
 
 // GetProducerMetrics collects all Producer and Producer metrics for the given
 // producerName and stores them in sample.
-func GetProducerMetrics(producerName string, sample *metric.Set) {
-	CollectMetricDefintions(sample, producerMetricDefs, applyProducerName(producerName))
+func GetProducerMetrics(kafkaArgs *args.KafkaArguments, producerName string, sample *metric.Set) {
+	CollectMetricDefintions(kafkaArgs, sample, producerMetricDefs, applyProducerName(producerName))
 }
 
 // CollectTopicSubMetrics collects Topic metrics that are related to either a Producer or Consumer
 //
 // beanModifier is a function that is used to replace place holder with actual Consumer/Producer
 // and Topic names for a given MBean
-func CollectTopicSubMetrics(entity *integration.Entity, entityType string,
+func CollectTopicSubMetrics(kafkaArgs *args.KafkaArguments, entity *integration.Entity, entityType string,
 	metricSets []*JMXMetricSet, topicList []string,
 	beanModifier func(string, string) BeanModifier) {
 
@@ -42,18 +43,18 @@ func CollectTopicSubMetrics(entity *integration.Entity, entityType string,
 	titleEntityType := strings.Title(strings.TrimPrefix(entity.Metadata.Namespace, "ka-"))
 
 	for _, topicName := range topicList {
-		topicSample := entity.NewMetricSet("Kafka"+titleEntityType+"Sample",
-			metric.Attribute{Key: "displayName", Value: entity.Metadata.Name},
-			metric.Attribute{Key: "entityName", Value: fmt.Sprintf("%s:%s", strings.TrimPrefix(entity.Metadata.Namespace, "ka-"), entity.Metadata.Name)},
-			metric.Attribute{Key: "topic", Value: topicName},
-		)
+		topicSample := entity.NewMetricSet("Kafka"+titleEntityType+"Sample", append([]metric.Attribute{
+			{Key: "displayName", Value: entity.Metadata.Name},
+			{Key: "entityName", Value: fmt.Sprintf("%s:%s", strings.TrimPrefix(entity.Metadata.Namespace, "ka-"), entity.Metadata.Name)},
+			{Key: "topic", Value: topicName},
+		}, kafkaArgs.TopicAttributes(topicName)...)...)
 
-		CollectMetricDefintions(topicSample, metricSets, beanModifier(entity.Metadata.Name, topicName))
+		CollectMetricDefintions(kafkaArgs, topicSample, metricSets, beanModifier(entity.Metadata.Name, topicName))
 	}
 }
 
 // CollectMetricDefintions collects the set of metrics from the current open JMX connection and add them to the sample
-func CollectMetricDefintions(sample *metric.Set, metricSets []*JMXMetricSet, beanModifier BeanModifier) {
+func CollectMetricDefintions(kafkaArgs *args.KafkaArguments, sample *metric.Set, metricSets []*JMXMetricSet, beanModifier BeanModifier) {
 	notFoundMetrics := make([]string, 0)
 
 	for _, metricSet := range metricSets {
@@ -64,10 +65,10 @@ func CollectMetricDefintions(sample *metric.Set, metricSets []*JMXMetricSet, bea
 		}
 
 		// Return all the results under a specific mBean
-		results, err := jmxwrapper.JMXQuery(beanName, args.GlobalArgs.Timeout)
+		results, err := jmxwrapper.JMXQuery(beanName, kafkaArgs.Timeout)
 		// If we fail we don't want a total failure as other metrics can be collected even if a single failure/timout occurs
 		if err != nil {
-			log.Error("Unable to execute JMX query for MBean '%s': %s", beanName, err.Error())
+			collecterrors.Error("Unable to execute JMX query for MBean '%s': %s", beanName, err.Error())
 			continue
 		}
 
@@ -82,7 +83,7 @@ func CollectMetricDefintions(sample *metric.Set, metricSets []*JMXMetricSet, bea
 				notFoundMetrics = append(notFoundMetrics, metricDef.Name)
 			} else {
 				if err := sample.SetMetric(metricDef.Name, value, metricDef.SourceType); err != nil {
-					log.Error("Error setting value: %s", err)
+					collecterrors.Error("Error setting value: %s", err)
 				}
 			}
 		}
@@ -4,16 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
 	"github.com/newrelic/nri-kafka/src/jmxwrapper"
 	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGetBrokerMetrics(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	expected := map[string]interface{}{
 		"request.avgTimeFetch": float64(24),
 		"event_type":           "testMetrics",
@@ -27,8 +31,6 @@ func TestGetBrokerMetrics(t *testing.T) {
 		return result, nil
 	}
 
-	testutils.SetupTestArgs()
-
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
 		t.Errorf("Unexpected error %s", err.Error())
@@ -43,14 +45,52 @@ func TestGetBrokerMetrics(t *testing.T) {
 
 	m := e.NewMetricSet("testMetrics")
 
-	GetBrokerMetrics(m)
+	GetBrokerMetrics(kafkaArgs, m)
 
 	if !reflect.DeepEqual(expected, m.Metrics) {
 		t.Errorf("Expected %+v got %+v", expected, m.Metrics)
 	}
 }
 
+func TestGetBrokerMetrics_LogFlush(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	i, _ := integration.New("test", "1.0.0")
+	e, _ := i.Entity("testEntity", "testNamespace")
+
+	jmxQuery := jmxwrapper.JMXQuery
+	defer func() { jmxwrapper.JMXQuery = jmxQuery }()
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		if query != "kafka.log:type=LogFlushStats,name=*" {
+			return map[string]interface{}{}, nil
+		}
+		return map[string]interface{}{
+			"kafka.log:type=LogFlushStats,name=LogFlushRateAndTimeMs,attr=Count":          100,
+			"kafka.log:type=LogFlushStats,name=LogFlushRateAndTimeMs,attr=99thPercentile": 12.5,
+		}, nil
+	}
+
+	m := e.NewMetricSet("testMetrics")
+	GetBrokerMetrics(kafkaArgs, m)
+
+	assert.Equal(t, float64(12.5), m.Metrics["kafka.broker.logFlushTimeMs.p99"])
+	// Rates need a previous value
+	_, ok := m.Metrics["broker.logFlushPerSecond"]
+	assert.False(t, ok)
+
+	// Brokers without the MBean report nothing for it
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+
+	m = e.NewMetricSet("testMetrics2")
+	GetBrokerMetrics(kafkaArgs, m)
+
+	_, ok = m.Metrics["kafka.broker.logFlushTimeMs.p99"]
+	assert.False(t, ok)
+}
+
 func TestGetConsumerMetrics(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	expected := map[string]interface{}{
 		"consumer.maxLag": float64(24),
 		"event_type":      "testMetrics",
@@ -66,8 +106,6 @@ func TestGetConsumerMetrics(t *testing.T) {
 		return result, nil
 	}
 
-	testutils.SetupTestArgs()
-
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
 		t.Errorf("Unexpected error %s", err.Error())
@@ -82,7 +120,7 @@ func TestGetConsumerMetrics(t *testing.T) {
 
 	m := e.NewMetricSet("testMetrics")
 
-	GetConsumerMetrics(consumerName, m)
+	GetConsumerMetrics(kafkaArgs, consumerName, m)
 
 	if !reflect.DeepEqual(expected, m.Metrics) {
 		t.Errorf("Expected %+v got %+v", expected, m.Metrics)
@@ -90,6 +128,7 @@ func TestGetConsumerMetrics(t *testing.T) {
 }
 
 func TestGetProducerMetrics(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	expected := map[string]interface{}{
 		"producer.ageMetadataUsedInMilliseconds": float64(24),
 		"event_type":                             "testMetrics",
@@ -105,8 +144,6 @@ func TestGetProducerMetrics(t *testing.T) {
 		return result, nil
 	}
 
-	testutils.SetupTestArgs()
-
 	i, err := integration.New("test", "1.0.0")
 	if err != nil {
 		t.Errorf("Unexpected error %s", err.Error())
@@ -121,7 +158,7 @@ func TestGetProducerMetrics(t *testing.T) {
 
 	m := e.NewMetricSet("testMetrics")
 
-	GetProducerMetrics(producerName, m)
+	GetProducerMetrics(kafkaArgs, producerName, m)
 
 	if !reflect.DeepEqual(expected, m.Metrics) {
 		t.Errorf("Expected %+v got %+v", expected, m.Metrics)
@@ -129,7 +166,7 @@ func TestGetProducerMetrics(t *testing.T) {
 }
 
 func TestCollectMetricDefinitions_QueryError(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	errString := "this is an error"
 
 	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
@@ -150,7 +187,7 @@ func TestCollectMetricDefinitions_QueryError(t *testing.T) {
 
 	m := e.NewMetricSet("testMetrics")
 
-	CollectMetricDefintions(m, brokerMetricDefs, nil)
+	CollectMetricDefintions(kafkaArgs, m, brokerMetricDefs, nil)
 
 	if len(m.Metrics) != 1 {
 		t.Error("Metrics where inserted even with a bad query")
@@ -158,7 +195,7 @@ func TestCollectMetricDefinitions_QueryError(t *testing.T) {
 }
 
 func TestCollectMetricDefinitions_MetricError(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	expected := map[string]interface{}{
 		"event_type": "testMetrics",
 	}
@@ -185,7 +222,7 @@ func TestCollectMetricDefinitions_MetricError(t *testing.T) {
 
 	m := e.NewMetricSet("testMetrics")
 
-	CollectMetricDefintions(m, brokerMetricDefs, nil)
+	CollectMetricDefintions(kafkaArgs, m, brokerMetricDefs, nil)
 
 	if !reflect.DeepEqual(expected, m.Metrics) {
 		t.Errorf("Expected %+v got %+v", expected, m.Metrics)
@@ -193,7 +230,7 @@ func TestCollectMetricDefinitions_MetricError(t *testing.T) {
 }
 
 func TestCollectMetricDefinitions_BeanModifier(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	testMetricSet := []*JMXMetricSet{
 		{
 			MBean:        "kafka.network:replace=%REPLACE_ME%",
@@ -247,9 +284,38 @@ func TestCollectMetricDefinitions_BeanModifier(t *testing.T) {
 		}
 	}
 
-	CollectMetricDefintions(m, testMetricSet, renameFunc("Replaced"))
+	CollectMetricDefintions(kafkaArgs, m, testMetricSet, renameFunc("Replaced"))
 
 	if !reflect.DeepEqual(expected, m.Metrics) {
 		t.Errorf("Expected %+v got %+v", expected, m.Metrics)
 	}
 }
+
+func TestCollectTopicSubMetrics_TopicRelabelRules(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.TopicRelabelRules = []*args.TopicRelabelRule{
+		{Regex: regexp.MustCompile(`^([a-z]+)\.`), Attribute: "topicDomain", Replacement: "$1"},
+	}
+
+	jmxwrapper.JMXQuery = func(query string, timeout int) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	e, err := i.Entity("consumer", "ka-consumer")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	CollectTopicSubMetrics(kafkaArgs, e, "consumer", ConsumerTopicMetricDefs, []string{"orders.created", "Legacy"}, ApplyConsumerTopicName)
+
+	assert.Equal(t, 2, len(e.Metrics))
+	assert.Equal(t, "orders.created", e.Metrics[0].Metrics["topic"])
+	assert.Equal(t, "orders", e.Metrics[0].Metrics["topicDomain"])
+	assert.Equal(t, "Legacy", e.Metrics[1].Metrics["topic"])
+	assert.NotContains(t, e.Metrics[1].Metrics, "topicDomain")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+)
+
+// Phases of the collection of a cluster that are timed
+const (
+	phaseBrokerDiscovery = "brokerDiscovery"
+	phaseTopicCollection = "topicCollection"
+	phaseJMX             = "jmx"
+	phaseConsumerOffsets = "consumerOffsets"
+	phaseTotal           = "total"
+)
+
+// phaseDurations records how long each phase of the collection of a cluster took. Phases run
+// concurrently, so recording is safe from several goroutines.
+type phaseDurations struct {
+	lock      sync.Mutex
+	durations map[string]time.Duration
+}
+
+func newPhaseDurations() *phaseDurations {
+	return &phaseDurations{durations: make(map[string]time.Duration)}
+}
+
+// record sets the duration of phase to the time since start
+func (p *phaseDurations) record(phase string, start time.Time) {
+	duration := time.Since(start)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.durations[phase] = duration
+}
+
+// setMetrics reports the duration of each phase in a KafkaIntegrationSample with a phase attribute on the cluster entity
+func (p *phaseDurations) setMetrics(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) error {
+	clusterEntity, err := kafkaIntegration.Entity(kafkaArgs.ClusterName, "ka-cluster", kafkaArgs.NamespaceIDAttributes()...)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	phases := make([]string, 0, len(p.durations))
+	for phase := range p.durations {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+
+	for _, phase := range phases {
+		metricSet := clusterEntity.NewMetricSet("KafkaIntegrationSample",
+			metric.Attribute{Key: "displayName", Value: clusterEntity.Metadata.Name},
+			metric.Attribute{Key: "entityName", Value: "cluster:" + clusterEntity.Metadata.Name},
+			metric.Attribute{Key: "clusterName", Value: kafkaArgs.ClusterName},
+			metric.Attribute{Key: "phase", Value: phase},
+		)
+
+		durationMs := float64(p.durations[phase]) / float64(time.Millisecond)
+		if err := metricSet.SetMetric("kafka.integration.phaseDurationMs", durationMs, metric.GAUGE); err != nil {
+			log.Error("Failed to set duration of phase %s: %s", phase, err.Error())
+		}
+	}
+
+	return nil
+}
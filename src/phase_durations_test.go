@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_phaseDurations_setMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	phases := newPhaseDurations()
+	start := time.Now().Add(-50 * time.Millisecond)
+	phases.record(phaseJMX, start)
+	phases.record(phaseTotal, start)
+
+	assert.Nil(t, phases.setMetrics(kafkaArgs, i))
+
+	clusterEntity, _ := i.Entity("testcluster", "ka-cluster")
+	assert.Equal(t, 2, len(clusterEntity.Metrics))
+	for n, phase := range []string{phaseJMX, phaseTotal} {
+		metrics := clusterEntity.Metrics[n].Metrics
+		assert.Equal(t, "KafkaIntegrationSample", metrics["event_type"])
+		assert.Equal(t, "testcluster", metrics["clusterName"])
+		assert.Equal(t, phase, metrics["phase"])
+		assert.True(t, metrics["kafka.integration.phaseDurationMs"].(float64) >= 50)
+	}
+}
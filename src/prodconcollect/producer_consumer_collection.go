@@ -10,20 +10,21 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/jmx"
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
 	"github.com/newrelic/nri-kafka/src/jmxwrapper"
 	"github.com/newrelic/nri-kafka/src/metrics"
 )
 
 // StartWorkerPool starts a pool of workers to handle collecting data for wither Consumer or producer entities.
 // The channel returned is to be closed by the user (or by feedWorkerPool)
-func StartWorkerPool(poolSize int, wg *sync.WaitGroup, integration *integration.Integration, collectedTopics []string,
-	worker func(<-chan *args.JMXHost, *sync.WaitGroup, *integration.Integration, []string)) chan *args.JMXHost {
+func StartWorkerPool(kafkaArgs *args.KafkaArguments, poolSize int, wg *sync.WaitGroup, integration *integration.Integration, collectedTopics []string,
+	worker func(*args.KafkaArguments, <-chan *args.JMXHost, *sync.WaitGroup, *integration.Integration, []string)) chan *args.JMXHost {
 
 	jmxHostChan := make(chan *args.JMXHost)
 
 	for i := 0; i < poolSize; i++ {
 		wg.Add(1)
-		go worker(jmxHostChan, wg, integration, collectedTopics)
+		go worker(kafkaArgs, jmxHostChan, wg, integration, collectedTopics)
 	}
 
 	return jmxHostChan
@@ -40,7 +41,7 @@ func FeedWorkerPool(jmxHostChan chan<- *args.JMXHost, jmxHosts []*args.JMXHost)
 }
 
 // ConsumerWorker collects information for consumers sent down the consumerChan
-func ConsumerWorker(consumerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *integration.Integration, collectedTopics []string) {
+func ConsumerWorker(kafkaArgs *args.KafkaArguments, consumerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *integration.Integration, collectedTopics []string) {
 	defer wg.Done()
 
 	for {
@@ -50,28 +51,28 @@ func ConsumerWorker(consumerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *in
 		}
 
 		// Create an entity for the consumer
-		clusterIDAttr := integration.NewIDAttribute("clusterName", args.GlobalArgs.ClusterName)
-		consumerEntity, err := i.Entity(jmxInfo.Name, "ka-consumer", clusterIDAttr)
+		clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+		consumerEntity, err := i.Entity(jmxInfo.Name, "ka-consumer", clusterIDAttrs...)
 		if err != nil {
-			log.Error("Unable to create entity for Consumer %s: %s", jmxInfo.Name, err.Error())
+			collecterrors.Error("Unable to create entity for Consumer %s: %s", jmxInfo.Name, err.Error())
 			continue
 		}
 
 		// Gather Metrics for consumer
-		if args.GlobalArgs.All() || args.GlobalArgs.Metrics {
+		if kafkaArgs.HasMetrics() {
 			// Lock since we can only make a single JMX connection at a time.
 			jmxwrapper.JMXLock.Lock()
 			log.Debug("Collecting metrics for consumer %s", consumerEntity.Metadata.Name)
 
 			// Open a JMX connection to the consumer
 			options := make([]jmx.Option, 0)
-			if args.GlobalArgs.KeyStore != "" && args.GlobalArgs.KeyStorePassword != "" && args.GlobalArgs.TrustStore != "" && args.GlobalArgs.TrustStorePassword != "" {
-				ssl := jmx.WithSSL(args.GlobalArgs.KeyStore, args.GlobalArgs.KeyStorePassword, args.GlobalArgs.TrustStore, args.GlobalArgs.TrustStorePassword)
+			if kafkaArgs.KeyStore != "" && kafkaArgs.KeyStorePassword != "" && kafkaArgs.TrustStore != "" && kafkaArgs.TrustStorePassword != "" {
+				ssl := jmx.WithSSL(kafkaArgs.KeyStore, kafkaArgs.KeyStorePassword, kafkaArgs.TrustStore, kafkaArgs.TrustStorePassword)
 				options = append(options, ssl)
 			}
 
 			if err := jmxwrapper.JMXOpen(jmxInfo.Host, strconv.Itoa(jmxInfo.Port), jmxInfo.User, jmxInfo.Password, options...); err != nil {
-				log.Error("Unable to make JMX connection for Consumer '%s': %s", consumerEntity.Metadata.Name, err.Error())
+				collecterrors.Error("Unable to make JMX connection for Consumer '%s': %s", consumerEntity.Metadata.Name, err.Error())
 				jmxwrapper.JMXClose() // Close needs to be called even on a failed open to clear out any set variables
 				jmxwrapper.JMXLock.Unlock()
 				continue
@@ -86,10 +87,10 @@ func ConsumerWorker(consumerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *in
 
 			// Collect the consumer metrics and populate the sample with them
 			log.Debug("Collecting metrics for Consumer '%s'", consumerEntity.Metadata.Name)
-			metrics.GetConsumerMetrics(consumerEntity.Metadata.Name, sample)
+			metrics.GetConsumerMetrics(kafkaArgs, consumerEntity.Metadata.Name, sample)
 
 			// Collect metrics that are topic-specific per Consumer
-			metrics.CollectTopicSubMetrics(consumerEntity, "consumer", metrics.ConsumerTopicMetricDefs,
+			metrics.CollectTopicSubMetrics(kafkaArgs, consumerEntity, "consumer", metrics.ConsumerTopicMetricDefs,
 				collectedTopics, metrics.ApplyConsumerTopicName)
 
 			log.Debug("Collecting metrics for consumer %s", consumerEntity.Metadata.Name)
@@ -102,7 +103,7 @@ func ConsumerWorker(consumerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *in
 }
 
 // ProducerWorker collect information for Producers sent down the producerChan
-func ProducerWorker(producerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *integration.Integration, collectedTopics []string) {
+func ProducerWorker(kafkaArgs *args.KafkaArguments, producerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *integration.Integration, collectedTopics []string) {
 	defer wg.Done()
 	for {
 		jmxInfo, ok := <-producerChan
@@ -113,25 +114,25 @@ func ProducerWorker(producerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *in
 		// Create the producer entity
 		producerEntity, err := i.Entity(jmxInfo.Name, "ka-producer")
 		if err != nil {
-			log.Error("Unable to create entity for Producer %s: %s", jmxInfo.Name, err.Error())
+			collecterrors.Error("Unable to create entity for Producer %s: %s", jmxInfo.Name, err.Error())
 			continue
 		}
 
 		// Gather Metrics for producer
-		if args.GlobalArgs.All() || args.GlobalArgs.Metrics {
+		if kafkaArgs.HasMetrics() {
 			// Lock since we can only make a single JMX connection at a time.
 			jmxwrapper.JMXLock.Lock()
 			log.Debug("Collecting metrics for producer %s", producerEntity.Metadata.Name)
 
 			// Open a JMX connection to the producer
 			options := make([]jmx.Option, 0)
-			if args.GlobalArgs.KeyStore != "" && args.GlobalArgs.KeyStorePassword != "" && args.GlobalArgs.TrustStore != "" && args.GlobalArgs.TrustStorePassword != "" {
-				ssl := jmx.WithSSL(args.GlobalArgs.KeyStore, args.GlobalArgs.KeyStorePassword, args.GlobalArgs.TrustStore, args.GlobalArgs.TrustStorePassword)
+			if kafkaArgs.KeyStore != "" && kafkaArgs.KeyStorePassword != "" && kafkaArgs.TrustStore != "" && kafkaArgs.TrustStorePassword != "" {
+				ssl := jmx.WithSSL(kafkaArgs.KeyStore, kafkaArgs.KeyStorePassword, kafkaArgs.TrustStore, kafkaArgs.TrustStorePassword)
 				options = append(options, ssl)
 			}
 
 			if err := jmxwrapper.JMXOpen(jmxInfo.Host, strconv.Itoa(jmxInfo.Port), jmxInfo.User, jmxInfo.Password, options...); err != nil {
-				log.Error("Unable to make JMX connection for Producer '%s': %s", producerEntity.Metadata.Name, err.Error())
+				collecterrors.Error("Unable to make JMX connection for Producer '%s': %s", producerEntity.Metadata.Name, err.Error())
 				jmxwrapper.JMXClose() // Close needs to be called even on a failed open to clear out any set variables
 				jmxwrapper.JMXLock.Unlock()
 				continue
@@ -146,10 +147,10 @@ func ProducerWorker(producerChan <-chan *args.JMXHost, wg *sync.WaitGroup, i *in
 
 			// Collect producer metrics and populate the metric set with them
 			log.Debug("Collecting metrics for Producer '%s'", producerEntity.Metadata.Name)
-			metrics.GetProducerMetrics(producerEntity.Metadata.Name, sample)
+			metrics.GetProducerMetrics(kafkaArgs, producerEntity.Metadata.Name, sample)
 
 			// Collect metrics that are topic specific per Producer
-			metrics.CollectTopicSubMetrics(producerEntity, "producer", metrics.ProducerTopicMetricDefs,
+			metrics.CollectTopicSubMetrics(kafkaArgs, producerEntity, "producer", metrics.ProducerTopicMetricDefs,
 				collectedTopics, metrics.ApplyProducerTopicName)
 
 			log.Debug("Done Collecting metrics for producer %s", producerEntity.Metadata.Name)
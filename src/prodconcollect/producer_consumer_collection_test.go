@@ -14,6 +14,7 @@ import (
 )
 
 func TestStartWorkerPool(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	var wg sync.WaitGroup
 	collectedTopics := []string{"test1", "test2"}
 	i, err := integration.New("kafka", "1.0.0")
@@ -21,7 +22,7 @@ func TestStartWorkerPool(t *testing.T) {
 		t.Error(err)
 	}
 
-	consumerHosts := StartWorkerPool(3, &wg, i, collectedTopics, ConsumerWorker)
+	consumerHosts := StartWorkerPool(kafkaArgs, 3, &wg, i, collectedTopics, ConsumerWorker)
 
 	c := make(chan int)
 	go func() {
@@ -69,10 +70,10 @@ func TestConsumerWorker(t *testing.T) {
 	}
 	collectedTopics := []string{"test1", "test2"}
 
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	wg.Add(1)
-	go ConsumerWorker(consumerChan, &wg, i, collectedTopics)
+	go ConsumerWorker(kafkaArgs, consumerChan, &wg, i, collectedTopics)
 
 	newJmx := &args.JMXHost{
 		Name: "test",
@@ -97,10 +98,10 @@ func TestConsumerWorker_JmxOpenFuncErr(t *testing.T) {
 	}
 	collectedTopics := []string{"test1", "test2"}
 
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	wg.Add(1)
-	go ConsumerWorker(consumerChan, &wg, i, collectedTopics)
+	go ConsumerWorker(kafkaArgs, consumerChan, &wg, i, collectedTopics)
 
 	newJmx := &args.JMXHost{
 		Name: "test",
@@ -121,10 +122,10 @@ func TestProducerWorker(t *testing.T) {
 	}
 	collectedTopics := []string{"test1", "test2"}
 
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	wg.Add(1)
-	go ProducerWorker(producerChan, &wg, i, collectedTopics)
+	go ProducerWorker(kafkaArgs, producerChan, &wg, i, collectedTopics)
 
 	newJmx := &args.JMXHost{
 		Name: "test",
@@ -149,10 +150,10 @@ func TestProducerWorker_JmxOpenFuncErr(t *testing.T) {
 	}
 	collectedTopics := []string{"test1", "test2"}
 
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 
 	wg.Add(1)
-	go ProducerWorker(producerChan, &wg, i, collectedTopics)
+	go ProducerWorker(kafkaArgs, producerChan, &wg, i, collectedTopics)
 
 	newJmx := &args.JMXHost{
 		Name: "test",
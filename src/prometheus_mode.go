@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/promexport"
+)
+
+// prometheusPath is the path the metrics are served on when prometheus_addr is set
+const prometheusPath = "/metrics"
+
+// servePrometheus serves the metrics of every cluster at /metrics on addr, collecting them every interval after
+// waiting startupDelay. Collection errors are logged and the metrics of the clusters that succeeded are still
+// served. Only returns if the server fails.
+func servePrometheus(addr string, interval, startupDelay time.Duration, clusterArgLists []args.ArgumentList, maxConcurrentClusters int, filter *metricFilter, kafkaIntegration *integration.Integration) error {
+	if interval <= 0 {
+		return errors.New("prometheus_interval must be greater than 0")
+	}
+
+	exporter := &promexport.Exporter{}
+	mux := http.NewServeMux()
+	mux.Handle(prometheusPath, exporter)
+
+	// Listen before collecting so an address in use fails right away
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- http.Serve(listener, mux)
+	}()
+	log.Info("Serving Prometheus metrics on %s%s", addr, prometheusPath)
+
+	// No metrics are served until the first collection
+	waitStartupDelay(startupDelay)
+
+	// Rates are calculated between collections, so the store lives as long as the process
+	metricStore := openMetricStore(kafkaIntegration)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		collectForPrometheus(exporter, clusterArgLists, maxConcurrentClusters, metricStore, filter, kafkaIntegration)
+
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectForPrometheus collects every cluster, at most maxConcurrentClusters at once, and replaces the metrics
+// served by the exporter with the result
+func collectForPrometheus(exporter *promexport.Exporter, clusterArgLists []args.ArgumentList, maxConcurrentClusters int, metricStore persist.Storer, filter *metricFilter, kafkaIntegration *integration.Integration) {
+	// Clear the previous collection, which the exporter no longer references
+	defer kafkaIntegration.Clear()
+
+	collecterrors.Reset()
+	for i, err := range collectClusters(clusterArgLists, maxConcurrentClusters, metricStore, kafkaIntegration) {
+		if err != nil {
+			log.Error("Failed collecting cluster '%s': %s", clusterArgLists[i].ClusterName, err.Error())
+		}
+	}
+
+	filter.apply(kafkaIntegration.Entities)
+
+	if err := exporter.Update(kafkaIntegration.Entities); err != nil {
+		log.Error("Failed to update Prometheus metrics: %s", err.Error())
+	}
+}
@@ -0,0 +1,165 @@
+// Package promexport exposes the metrics collected by the integration in the Prometheus text exposition format
+package promexport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/metricexport"
+)
+
+// metricPrefix is prepended to every metric name that doesn't already start with it
+const metricPrefix = "kafka_"
+
+// invalidNameChars matches the characters not allowed in Prometheus metric and label names
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Exporter holds the metrics of the latest collection and serves them over HTTP
+type Exporter struct {
+	lock      sync.RWMutex
+	collected bool
+	snapshot  []byte
+}
+
+// Update replaces the served metrics with the metrics on the given entities
+func (e *Exporter) Update(entities []*integration.Entity) error {
+	var buf bytes.Buffer
+	if err := Render(&buf, entities); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.collected = true
+	e.snapshot = buf.Bytes()
+
+	return nil
+}
+
+// ServeHTTP writes the metrics of the latest collection. Responds with 503 until the first collection is done.
+// A collection without metrics, such as of an idle cluster, is served as an empty body.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if !e.collected {
+		http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := w.Write(e.snapshot); err != nil {
+		log.Debug("Failed to write Prometheus metrics response: %s", err.Error())
+	}
+}
+
+// sample is a single line of the exposition
+type sample struct {
+	labels string
+	value  float64
+}
+
+// Render writes the numeric metrics of every metric set on the entities as Prometheus gauges. A metric named
+// broker.IOInPerSecond becomes kafka_broker_IOInPerSecond. The event type, entity name and namespace, and the
+// string attributes of the metric set are added as labels. Metrics are sorted so the output is stable.
+func Render(w io.Writer, entities []*integration.Entity) error {
+	families := make(map[string][]sample)
+	for _, entity := range entities {
+		for _, set := range entity.Metrics {
+			labels := make(map[string]string)
+			if entity.Metadata != nil {
+				labels["entity_name"] = entity.Metadata.Name
+				labels["entity_namespace"] = entity.Metadata.Namespace
+			}
+			for key, value := range set.Metrics {
+				if s, ok := value.(string); ok {
+					labels[sanitizeName(key)] = s
+				}
+			}
+			renderedLabels := renderLabels(labels)
+
+			for key, value := range set.Metrics {
+				v, ok := metricexport.NumericValue(value)
+				if !ok {
+					continue
+				}
+
+				name := metricName(key)
+				families[name] = append(families[name], sample{labels: renderedLabels, value: v})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		samples := families[name]
+		sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+
+		if _, err := fmt.Fprintf(w, "# TYPE %s gauge\n", name); err != nil {
+			return err
+		}
+		for _, s := range samples {
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// metricName converts a metric name to a valid Prometheus metric name with the kafka_ prefix
+func metricName(key string) string {
+	return metricexport.Prefix(sanitizeName(key), metricPrefix)
+}
+
+// sanitizeName replaces the characters not allowed in Prometheus names with underscores. Names
+// can't start with a digit, so those are prefixed with an underscore.
+func sanitizeName(name string) string {
+	name = metricexport.Sanitize(name, invalidNameChars)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// renderLabels returns the labels in the {name="value",...} form, sorted by name
+func renderLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(labels[name])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
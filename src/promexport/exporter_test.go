@@ -0,0 +1,77 @@
+package promexport
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntities(t *testing.T) []*integration.Entity {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, _ := i.Entity("broker0:9092", "ka-broker")
+	sample := broker.NewMetricSet("KafkaBrokerSample", metric.Attribute{Key: "displayName", Value: `broker "0"`})
+	sample.SetMetric("broker.IOInPerSecond", 12.5, metric.GAUGE)
+	sample.SetMetric("broker.messagesInPerSecond", 3, metric.GAUGE)
+
+	group, _ := i.Entity("group1", "ka-consumerGroup")
+	for _, partition := range []string{"1", "0"} {
+		sample := group.NewMetricSet("KafkaOffsetSample", metric.Attribute{Key: "partition", Value: partition})
+		sample.SetMetric("kafka.consumerLag", 7, metric.GAUGE)
+	}
+
+	return i.Entities
+}
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	err := Render(&buf, testEntities(t))
+	assert.Nil(t, err)
+
+	expected := `# TYPE kafka_broker_IOInPerSecond gauge
+kafka_broker_IOInPerSecond{displayName="broker \"0\"",entity_name="broker0:9092",entity_namespace="ka-broker",event_type="KafkaBrokerSample"} 12.5
+# TYPE kafka_broker_messagesInPerSecond gauge
+kafka_broker_messagesInPerSecond{displayName="broker \"0\"",entity_name="broker0:9092",entity_namespace="ka-broker",event_type="KafkaBrokerSample"} 3
+# TYPE kafka_consumerLag gauge
+kafka_consumerLag{entity_name="group1",entity_namespace="ka-consumerGroup",event_type="KafkaOffsetSample",partition="0"} 7
+kafka_consumerLag{entity_name="group1",entity_namespace="ka-consumerGroup",event_type="KafkaOffsetSample",partition="1"} 7
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestMetricName(t *testing.T) {
+	assert.Equal(t, "kafka_broker_IOInPerSecond", metricName("broker.IOInPerSecond"))
+	assert.Equal(t, "kafka_consumerOffset_resetDetected", metricName("kafka.consumerOffset.resetDetected"))
+	assert.Equal(t, "kafka__99thPercentile", metricName("99thPercentile"))
+}
+
+func TestExporter_ServeHTTP(t *testing.T) {
+	exporter := &Exporter{}
+
+	recorder := httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	assert.Nil(t, exporter.Update(testEntities(t)))
+
+	recorder = httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "kafka_consumerLag{")
+
+	// A collection without entities is still a successful scrape
+	assert.Nil(t, exporter.Update(nil))
+
+	recorder = httptest.NewRecorder()
+	exporter.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+}
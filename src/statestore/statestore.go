@@ -0,0 +1,36 @@
+// Package statestore opens the file stores holding the state persisted between runs, sharing each one between
+// the clusters collected at once so they don't overwrite each other's state when saving it.
+package statestore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+)
+
+var (
+	lock sync.Mutex
+	// stores are the file stores opened so far, by path
+	stores = make(map[string]persist.Storer)
+)
+
+// Open returns the file store at path, loading it the first time it's opened. Every later call with the same
+// path returns the same store, so the state saved by any caller includes the changes of all of them.
+func Open(path string, logger log.Logger, ttl time.Duration) (persist.Storer, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if store, ok := stores[path]; ok {
+		return store, nil
+	}
+
+	store, err := persist.NewFileStore(path, logger, ttl)
+	if err != nil {
+		return nil, err
+	}
+	stores[path] = store
+
+	return store, nil
+}
@@ -0,0 +1,30 @@
+package statestore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := Open(filepath.Join(dir, "cluster1.json"), log.NewStdErr(false), persist.DefaultTTL)
+	assert.NoError(t, err)
+	store.Set("key", 1)
+
+	// The same path returns the same store with the changes made to it
+	same, err := Open(filepath.Join(dir, "cluster1.json"), log.NewStdErr(false), persist.DefaultTTL)
+	assert.NoError(t, err)
+	assert.True(t, store == same)
+
+	other, err := Open(filepath.Join(dir, "cluster2.json"), log.NewStdErr(false), persist.DefaultTTL)
+	assert.NoError(t, err)
+	assert.False(t, store == other)
+	var value int
+	_, err = other.Get("key", &value)
+	assert.Equal(t, persist.ErrNotFound, err)
+}
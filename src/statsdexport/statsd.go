@@ -0,0 +1,124 @@
+// Package statsdexport sends the metrics collected by the integration to a StatsD server
+package statsdexport
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/metricexport"
+)
+
+// metricPrefix is prepended to every metric name that doesn't already start with it
+const metricPrefix = "kafka."
+
+// maxPacketSize keeps each datagram within the payload of a single packet on a standard Ethernet MTU
+const maxPacketSize = 1432
+
+// reservedChars matches the characters that separate the parts of a StatsD line
+var reservedChars = regexp.MustCompile(`[:|@#,\s]`)
+
+// Send sends the numeric metrics of every metric set on the entities to the StatsD server at addr as gauges
+// over UDP. Delivery is best effort: failures are logged as warnings and the remaining metrics are still sent.
+func Send(addr string, entities []*integration.Entity) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Warn("Unable to send metrics to StatsD at %s: %s", addr, err.Error())
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Debug("Error closing StatsD connection: %s", err.Error())
+		}
+	}()
+
+	failed := 0
+	for _, packet := range packets(gaugeLines(entities), maxPacketSize) {
+		if _, err := conn.Write(packet); err != nil {
+			failed++
+			log.Debug("Failed to send StatsD packet: %s", err.Error())
+		}
+	}
+
+	if failed > 0 {
+		log.Warn("Failed to send %d packets of metrics to StatsD at %s", failed, addr)
+	}
+}
+
+// gaugeLines returns a StatsD gauge line for each numeric metric on the entities. Metric names keep their
+// New Relic name, with the kafka. prefix added if they don't have it, so broker.IOInPerSecond becomes
+// kafka.broker.IOInPerSecond. The entity name and namespace and the string attributes of the sample
+// are sent as tags using the DogStatsD extension. Lines are sorted so the output is stable.
+func gaugeLines(entities []*integration.Entity) []string {
+	var lines []string
+	for _, entity := range entities {
+		for _, set := range entity.Metrics {
+			var tags []string
+			if entity.Metadata != nil {
+				tags = append(tags, "entity_name:"+sanitize(entity.Metadata.Name), "entity_namespace:"+sanitize(entity.Metadata.Namespace))
+			}
+			for key, value := range set.Metrics {
+				if s, ok := value.(string); ok {
+					tags = append(tags, sanitize(key)+":"+sanitize(s))
+				}
+			}
+			sort.Strings(tags)
+
+			suffix := "|g"
+			if len(tags) > 0 {
+				suffix += "|#" + strings.Join(tags, ",")
+			}
+
+			for key, value := range set.Metrics {
+				v, ok := metricexport.NumericValue(value)
+				if !ok {
+					continue
+				}
+
+				lines = append(lines, fmt.Sprintf("%s:%s%s", metricName(key), strconv.FormatFloat(v, 'f', -1, 64), suffix))
+			}
+		}
+	}
+
+	sort.Strings(lines)
+	return lines
+}
+
+// metricName converts a metric name to a valid StatsD metric name with the kafka. prefix
+func metricName(key string) string {
+	return metricexport.Prefix(sanitize(key), metricPrefix)
+}
+
+// sanitize replaces the characters with a meaning in the StatsD line format with underscores
+func sanitize(s string) string {
+	return metricexport.Sanitize(s, reservedChars)
+}
+
+// packets joins the lines into newline separated packets of at most size bytes. A line longer than
+// size is sent in a packet of its own.
+func packets(lines []string, size int) [][]byte {
+	var result [][]byte
+	var current []byte
+	for _, line := range lines {
+		if len(current) > 0 && len(current)+1+len(line) > size {
+			result = append(result, current)
+			current = nil
+		}
+
+		if len(current) > 0 {
+			current = append(current, '\n')
+		}
+		current = append(current, line...)
+	}
+
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+
+	return result
+}
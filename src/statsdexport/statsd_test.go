@@ -0,0 +1,67 @@
+package statsdexport
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntities(t *testing.T) []*integration.Entity {
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, _ := i.Entity("broker0:9092", "ka-broker")
+	sample := broker.NewMetricSet("KafkaBrokerSample")
+	sample.SetMetric("broker.IOInPerSecond", 12.5, metric.GAUGE)
+
+	group, _ := i.Entity("group1", "ka-consumerGroup")
+	sample = group.NewMetricSet("KafkaOffsetSample", metric.Attribute{Key: "topic", Value: "my topic"})
+	sample.SetMetric("kafka.consumerLag", 7, metric.GAUGE)
+
+	return i.Entities
+}
+
+func TestGaugeLines(t *testing.T) {
+	expected := []string{
+		"kafka.broker.IOInPerSecond:12.5|g|#entity_name:broker0_9092,entity_namespace:ka-broker,event_type:KafkaBrokerSample",
+		"kafka.consumerLag:7|g|#entity_name:group1,entity_namespace:ka-consumerGroup,event_type:KafkaOffsetSample,topic:my_topic",
+	}
+	assert.Equal(t, expected, gaugeLines(testEntities(t)))
+}
+
+func TestPackets(t *testing.T) {
+	lines := []string{"aaaa", "bbbb", "cccc", "dddddddddddd"}
+
+	result := packets(lines, 10)
+	assert.Equal(t, [][]byte{[]byte("aaaa\nbbbb"), []byte("cccc"), []byte("dddddddddddd")}, result)
+
+	assert.Empty(t, packets(nil, 10))
+}
+
+func TestSend(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	Send(listener.LocalAddr().String(), testEntities(t))
+
+	buf := make([]byte, maxPacketSize)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := listener.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(strings.Split(string(buf[:n]), "\n")))
+}
+
+func TestSend_InvalidAddress(t *testing.T) {
+	// Failures are only logged
+	Send("not an address", testEntities(t))
+}
@@ -7,10 +7,16 @@ import (
 	"github.com/newrelic/nri-kafka/src/jmxwrapper"
 )
 
-// SetupTestArgs sets up a basic value for KafkaArgs with CollectBrokerTopicData
-// set to true
-func SetupTestArgs() {
-	args.GlobalArgs = &args.KafkaArguments{CollectBrokerTopicData: true, ZookeeperPath: ""}
+// SetupTestArgs returns a basic value for KafkaArgs with CollectBrokerTopicData
+// set to true and the default sample names
+func SetupTestArgs() *args.KafkaArguments {
+	return &args.KafkaArguments{
+		CollectBrokerTopicData: true,
+		ZookeeperPath:          "",
+		BrokerSampleName:       args.DefaultBrokerSampleName,
+		TopicSampleName:        args.DefaultTopicSampleName,
+		OffsetSampleName:       args.DefaultOffsetSampleName,
+	}
 }
 
 // SetupJmxTesting sets all JMX wrapper variables to basic shells
@@ -0,0 +1,51 @@
+package topiccollect
+
+import (
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+)
+
+// internalTopicPrefix is the prefix of the topics Kafka uses internally, such as __consumer_offsets
+// and __transaction_state, used when the topic metadata doesn't tell
+const internalTopicPrefix = "__"
+
+// InternalTopics is whether each topic of the cluster is flagged as internal in the topic metadata
+type InternalTopics map[string]bool
+
+// DescribeInternalTopics requests the metadata of every topic to tell the internal ones apart. The metadata the
+// cluster admin requests only has the flag since Kafka 0.11, so nil is returned for older versions, or if the
+// request fails, which makes IsInternal fall back to the topic name. The version is assumed to be recent if it
+// isn't known.
+func DescribeInternalTopics(kafkaArgs *args.KafkaArguments, clusterAdmin sarama.ClusterAdmin) InternalTopics {
+	if version := kafkaArgs.KafkaVersion; version != nil && !version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil
+	}
+
+	metadata, err := clusterAdmin.DescribeTopics(nil)
+	if err != nil {
+		log.Warn("Unable to request the topic metadata, telling internal topics apart by name: %s", err.Error())
+		return nil
+	}
+
+	internalTopics := make(InternalTopics, len(metadata))
+	for _, topic := range metadata {
+		if topic.Err == sarama.ErrNoError {
+			internalTopics[topic.Name] = topic.IsInternal
+		}
+	}
+
+	return internalTopics
+}
+
+// IsInternal returns true if topic is a Kafka internal topic. Topics without metadata are internal if their
+// name starts with __, which every topic Kafka flags as internal does.
+func (i InternalTopics) IsInternal(topic string) bool {
+	if internal, ok := i[topic]; ok {
+		return internal
+	}
+
+	return strings.HasPrefix(topic, internalTopicPrefix)
+}
@@ -0,0 +1,42 @@
+package topiccollect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeInternalTopics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	clusterAdmin := internalTopicsAdmin(
+		&sarama.TopicMetadata{Name: "__consumer_offsets", IsInternal: true},
+		&sarama.TopicMetadata{Name: "__user_topic"},
+		&sarama.TopicMetadata{Name: "orders", Err: sarama.ErrUnknownTopicOrPartition},
+	)
+
+	internalTopics := DescribeInternalTopics(kafkaArgs, clusterAdmin)
+	assert.Equal(t, InternalTopics{"__consumer_offsets": true, "__user_topic": false}, internalTopics)
+	assert.True(t, internalTopics.IsInternal("__consumer_offsets"))
+	assert.False(t, internalTopics.IsInternal("__user_topic"))
+	// Topics without metadata are told apart by name
+	assert.True(t, internalTopics.IsInternal("__transaction_state"))
+	assert.False(t, internalTopics.IsInternal("orders"))
+}
+
+func TestDescribeInternalTopics_Fallback(t *testing.T) {
+	// The metadata has no internal flag before Kafka 0.11
+	version := sarama.V0_10_2_0
+	kafkaArgs := &args.KafkaArguments{KafkaVersion: &version}
+	assert.Nil(t, DescribeInternalTopics(kafkaArgs, &connection.MockClusterAdmin{}))
+
+	kafkaArgs = &args.KafkaArguments{}
+	clusterAdmin := &connection.MockClusterAdmin{}
+	clusterAdmin.On("DescribeTopics", []string(nil)).Return([]*sarama.TopicMetadata{}, errors.New("metadata failed"))
+	internalTopics := DescribeInternalTopics(kafkaArgs, clusterAdmin)
+	assert.Nil(t, internalTopics)
+	assert.True(t, internalTopics.IsInternal("__consumer_offsets"))
+}
@@ -5,7 +5,7 @@ import (
 	"strconv"
 	"sync"
 
-	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 )
 
@@ -46,9 +46,9 @@ func feedPartitionPool(partitionInChan chan<- *partitionSender, topicName string
 	defer close(partitionInChan)
 
 	// Collect the partition replication info from the topic configuration in Zookeeper
-	partitionInfo, _, err := zkConn.Get(zookeeper.Path("/brokers/topics/" + topicName))
+	partitionInfo, _, err := zkConn.Get("/brokers/topics/" + topicName)
 	if err != nil {
-		log.Error("Unable to collect partition info: %s", err)
+		collecterrors.Error("Unable to collect partition info: %s", err)
 		return
 	}
 
@@ -58,7 +58,7 @@ func feedPartitionPool(partitionInChan chan<- *partitionSender, topicName string
 	}
 	var decodedPartitionInfo partitionInfoDecoder
 	if err := json.Unmarshal([]byte(partitionInfo), &decodedPartitionInfo); err != nil {
-		log.Error("Unable to collect topic partition configuration: %s", err)
+		collecterrors.Error("Unable to collect topic partition configuration: %s", err)
 		return
 	}
 
@@ -66,7 +66,7 @@ func feedPartitionPool(partitionInChan chan<- *partitionSender, topicName string
 	for partitionID := range decodedPartitionInfo.Partitions {
 		intID, err := strconv.Atoi(partitionID)
 		if err != nil {
-			log.Error("Unable to parse id %s to int", partitionID)
+			collecterrors.Error("Unable to parse id %s to int", partitionID)
 			continue
 		}
 		newSender := &partitionSender{
@@ -110,7 +110,7 @@ func collectPartitions(partitionOutChans []chan interface{}) []*partition {
 				// If it's an error log it, if it's a partition, push it onto partitionCollectChan
 				switch p.(type) {
 				case error:
-					log.Error("Unable to create partition: %s", p.(error))
+					collecterrors.Error("Unable to create partition: %s", p.(error))
 				case *partition:
 					partitionCollectChan <- p.(*partition)
 				}
@@ -151,9 +151,10 @@ func partitionWorker(partitionInChan chan *partitionSender, partitionOutChan cha
 		}
 
 		// Collect partition information from Zookeeper
-		partitionJSON, _, err := c.Get(zookeeper.Path("/brokers/topics/" + in.TopicName + "/partitions/" + strconv.Itoa(in.ID) + "/state"))
+		partitionJSON, _, err := c.Get("/brokers/topics/" + in.TopicName + "/partitions/" + strconv.Itoa(in.ID) + "/state")
 		if err != nil {
 			partitionOutChan <- err
+			continue
 		}
 
 		// Parse JSON returned from Zookeeper
@@ -164,6 +165,7 @@ func partitionWorker(partitionInChan chan *partitionSender, partitionOutChan cha
 		var decodedPartition partitionJSONDecoder
 		if err = json.Unmarshal([]byte(partitionJSON), &decodedPartition); err != nil {
 			partitionOutChan <- err // If parsing fails, send an error down the partition channel
+			continue
 		}
 
 		newPartition := &partition{
@@ -6,7 +6,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/newrelic/nri-kafka/src/testutils"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 	"github.com/samuel/go-zookeeper/zk"
 )
@@ -56,11 +55,10 @@ func TestCollectPartitions(t *testing.T) {
 }
 
 func TestPartitionWorker(t *testing.T) {
-	testutils.SetupTestArgs()
 	partitionInChan := make(chan *partitionSender, 10)
 	partitionOutChan := make(chan interface{}, 10)
 	var wg sync.WaitGroup
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 	zkConn.On("Get", "/brokers/topics/test/partitions/0/state").Return(partitionState, new(zk.Stat), nil)
 
 	expectedPartition := &partition{
@@ -73,7 +71,7 @@ func TestPartitionWorker(t *testing.T) {
 	topicReplication := map[string][]int{"0": {0, 1, 2}}
 
 	wg.Add(1)
-	go partitionWorker(partitionInChan, partitionOutChan, &wg, &zkConn)
+	go partitionWorker(partitionInChan, partitionOutChan, &wg, zkConn)
 
 	partitionInChan <- &partitionSender{
 		ID:               0,
@@ -93,7 +91,6 @@ func TestPartitionWorker(t *testing.T) {
 }
 
 func TestFeedPartitionPool(t *testing.T) {
-
 	topicReplication := map[string][]int{"0": {1, 2, 0}}
 
 	expectedPartitionSenders := []*partitionSender{
@@ -104,12 +101,12 @@ func TestFeedPartitionPool(t *testing.T) {
 		},
 	}
 
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 	zkConn.On("Get", "/brokers/topics/test1").Return(topicState, new(zk.Stat), nil)
 	zkConn.On("Get", "/brokers/topics/test/partitions/0/state").Return(partitionState, new(zk.Stat), nil)
 
 	partitionInChan := make(chan *partitionSender)
-	go feedPartitionPool(partitionInChan, "test1", &zkConn)
+	go feedPartitionPool(partitionInChan, "test1", zkConn)
 
 	var partitionSenders []*partitionSender
 	for {
@@ -129,9 +126,9 @@ func TestFeedPartitionPool(t *testing.T) {
 
 func TestStartPartitionPool(t *testing.T) {
 	var wg sync.WaitGroup
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 
-	partitionInChan, partitionOutChan := startPartitionPool(3, &wg, &zkConn)
+	partitionInChan, partitionOutChan := startPartitionPool(3, &wg, zkConn)
 	if len(partitionOutChan) != 3 {
 		t.Errorf("Expected 3 channels, got %d", len(partitionOutChan))
 	}
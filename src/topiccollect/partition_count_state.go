@@ -0,0 +1,90 @@
+package topiccollect
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/statestore"
+)
+
+const (
+	// partitionCountStateName is the name of the file the partition counts of the topics are persisted in between runs
+	partitionCountStateName = "com.newrelic.kafka-partition-counts"
+
+	// partitionCountStateTTL is how long the persisted partition counts stay valid. Every run saves them, so a
+	// topic's count is only dropped when it hasn't been collected for this long.
+	partitionCountStateTTL = 24 * time.Hour
+)
+
+var (
+	partitionCountsLock sync.Mutex
+	// partitionCounts are the partition count stores of the clusters being collected, by state key prefix
+	partitionCounts = make(map[string]persist.Storer)
+)
+
+// OpenPartitionCounts loads the partition counts of the topics recorded by the previous runs, to report the
+// changes in kafka.topic.partitionCountChanged. Changes aren't reported if they can't be loaded. Called before
+// collecting each cluster.
+func OpenPartitionCounts(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) {
+	partitionCountsLock.Lock()
+	defer partitionCountsLock.Unlock()
+
+	store, err := statestore.Open(persist.DefaultPath(partitionCountStateName), kafkaIntegration.Logger(), partitionCountStateTTL)
+	if err != nil {
+		log.Warn("Unable to open the topic partition count state, partition count changes won't be reported: %s", err.Error())
+		delete(partitionCounts, kafkaArgs.StateKeyPrefix())
+		return
+	}
+
+	partitionCounts[kafkaArgs.StateKeyPrefix()] = store
+}
+
+// SavePartitionCounts persists the partition counts of the topics of the cluster for the next run
+func SavePartitionCounts(kafkaArgs *args.KafkaArguments) error {
+	partitionCountsLock.Lock()
+	defer partitionCountsLock.Unlock()
+
+	store, ok := partitionCounts[kafkaArgs.StateKeyPrefix()]
+	if !ok {
+		return nil
+	}
+
+	return store.Save()
+}
+
+// setPartitionCountChange records the partition count of the topic and reports how much it changed since the
+// previous run as kafka.topic.partitionCountChanged, 0 if it didn't. Nothing is reported the first time a topic
+// is collected.
+func setPartitionCountChange(kafkaArgs *args.KafkaArguments, t *Topic, sample *metric.Set) error {
+	partitionCountsLock.Lock()
+	defer partitionCountsLock.Unlock()
+
+	store, ok := partitionCounts[kafkaArgs.StateKeyPrefix()]
+	if !ok {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s:%s", kafkaArgs.StateKeyPrefix(), t.Name)
+	var previous int
+	_, err := store.Get(key, &previous)
+	store.Set(key, t.PartitionCount)
+	if err == persist.ErrNotFound {
+		return nil
+	} else if err != nil {
+		log.Debug("Unable to read the previous partition count of topic %s: %s", t.Name, err.Error())
+		return nil
+	}
+
+	change := t.PartitionCount - previous
+	if change != 0 {
+		log.Warn("The partition count of topic %s changed from %d to %d since the previous run", t.Name, previous, t.PartitionCount)
+	}
+
+	return sample.SetMetric("kafka.topic.partitionCountChanged", change, metric.GAUGE)
+}
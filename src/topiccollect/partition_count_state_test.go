@@ -0,0 +1,47 @@
+package topiccollect
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/persist"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetPartitionCountChange(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	// Shared between the runs like the persisted state
+	partitionCounts[kafkaArgs.StateKeyPrefix()] = persist.NewInMemoryStore()
+	defer delete(partitionCounts, kafkaArgs.StateKeyPrefix())
+
+	// collect records a run of the topic with partitionCount partitions, returning its sample
+	collect := func(partitionCount int) map[string]interface{} {
+		i, _ := integration.New("kafka", "1.0.0")
+		entity, _ := i.Entity("topic", "ka-topic")
+		sample := entity.NewMetricSet("KafkaTopicSample")
+		assert.NoError(t, setPartitionCountChange(kafkaArgs, &Topic{Name: "topic", PartitionCount: partitionCount}, sample))
+		return sample.Metrics
+	}
+
+	// The first run only records the count
+	_, ok := collect(6)["kafka.topic.partitionCountChanged"]
+	assert.False(t, ok)
+
+	assert.Equal(t, float64(0), collect(6)["kafka.topic.partitionCountChanged"])
+	assert.Equal(t, float64(6), collect(12)["kafka.topic.partitionCountChanged"])
+	assert.Equal(t, float64(0), collect(12)["kafka.topic.partitionCountChanged"])
+}
+
+func TestSetPartitionCountChange_NoState(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	delete(partitionCounts, kafkaArgs.StateKeyPrefix())
+
+	i, _ := integration.New("kafka", "1.0.0")
+	entity, _ := i.Entity("topic", "ka-topic")
+	sample := entity.NewMetricSet("KafkaTopicSample")
+	assert.NoError(t, setPartitionCountChange(kafkaArgs, &Topic{Name: "topic", PartitionCount: 6}, sample))
+	assert.NoError(t, SavePartitionCounts(kafkaArgs))
+	_, ok := sample.Metrics["kafka.topic.partitionCountChanged"]
+	assert.False(t, ok)
+}
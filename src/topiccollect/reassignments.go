@@ -0,0 +1,113 @@
+package topiccollect
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// reassignmentPath is the Zookeeper node a partition reassignment is stored in until it completes
+const reassignmentPath = "/admin/reassign_partitions"
+
+// Reassignments is whether each topic has a partition reassignment in progress. Topics that aren't in it have
+// none. A nil Reassignments means they couldn't be read.
+type Reassignments map[string]bool
+
+// ReadReassignments reads the partition reassignments in progress for the collected topics, once per collection.
+// Kafka 2.4 and newer answer ListPartitionReassignments, which also lists the reassignments started with the
+// admin API. Older versions, and unknown ones, read the Zookeeper node kafka-reassign-partitions writes, which
+// bootstrap connections don't have. Returns nil if the reassignments can't be read or the topic metrics aren't
+// collected.
+func ReadReassignments(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection, topics []string) Reassignments {
+	if !kafkaArgs.CollectBrokerTopicData || !kafkaArgs.HasMetrics() || zkConn == nil || len(topics) == 0 {
+		return nil
+	}
+
+	var reassignments Reassignments
+	var err error
+	if version := kafkaArgs.KafkaVersion; version != nil && version.IsAtLeast(sarama.V2_4_0_0) {
+		reassignments, err = listReassignments(zkConn, topics)
+	} else if zookeeper.IsBootstrapConnection(zkConn) {
+		log.Debug("Partition reassignments are only read from Zookeeper before Kafka 2.4, not reporting kafka.topic.reassignmentInProgress")
+		return nil
+	} else {
+		reassignments, err = zookeeperReassignments(zkConn)
+	}
+	if err != nil {
+		log.Debug("Unable to read partition reassignments, not reporting kafka.topic.reassignmentInProgress: %s", err)
+		return nil
+	}
+
+	return reassignments
+}
+
+// listReassignments requests the reassignments of every partition of topics from the controller
+func listReassignments(zkConn zookeeper.Connection, topics []string) (Reassignments, error) {
+	clusterAdmin, err := zkConn.CreateClusterAdmin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := clusterAdmin.Close(); err != nil {
+			log.Debug("Error closing clusterAdmin connection: %s", err.Error())
+		}
+	}()
+
+	metadata, err := clusterAdmin.DescribeTopics(topics)
+	if err != nil {
+		return nil, err
+	}
+
+	reassignments := make(Reassignments)
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError || len(topic.Partitions) == 0 {
+			continue
+		}
+
+		partitions := make([]int32, 0, len(topic.Partitions))
+		for _, p := range topic.Partitions {
+			partitions = append(partitions, p.ID)
+		}
+
+		status, err := clusterAdmin.ListPartitionReassignments(topic.Name, partitions)
+		if err != nil {
+			return nil, err
+		}
+		if len(status[topic.Name]) > 0 {
+			reassignments[topic.Name] = true
+		}
+	}
+
+	return reassignments, nil
+}
+
+// zookeeperReassignments reads the topics of the reassignment stored in Zookeeper. The node only exists while a
+// reassignment started with kafka-reassign-partitions is in progress.
+func zookeeperReassignments(zkConn zookeeper.Connection) (Reassignments, error) {
+	data, _, err := zkConn.Get(reassignmentPath)
+	if err == zk.ErrNoNode {
+		return Reassignments{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var reassignment struct {
+		Partitions []struct {
+			Topic string `json:"topic"`
+		} `json:"partitions"`
+	}
+	if err := json.Unmarshal(data, &reassignment); err != nil {
+		return nil, err
+	}
+
+	reassignments := make(Reassignments)
+	for _, p := range reassignment.Partitions {
+		reassignments[p.Topic] = true
+	}
+
+	return reassignments, nil
+}
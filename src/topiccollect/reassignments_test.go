@@ -0,0 +1,94 @@
+package topiccollect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadReassignments_Zookeeper(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("Get", "/admin/reassign_partitions").Return([]byte(`{"version":1,"partitions":[{"topic":"test","partition":0,"replicas":[1,2]}]}`), new(zk.Stat), nil)
+
+	reassignments := ReadReassignments(kafkaArgs, zkConn, []string{"test", "other"})
+	assert.Equal(t, Reassignments{"test": true}, reassignments)
+
+	// The node only exists during a reassignment
+	zkConn = &zookeeper.MockConnection{}
+	zkConn.On("Get", "/admin/reassign_partitions").Return([]byte{}, new(zk.Stat), zk.ErrNoNode)
+	assert.Equal(t, Reassignments{}, ReadReassignments(kafkaArgs, zkConn, []string{"test"}))
+
+	zkConn = &zookeeper.MockConnection{}
+	zkConn.On("Get", "/admin/reassign_partitions").Return([]byte{}, new(zk.Stat), errors.New("not authorized"))
+	assert.Nil(t, ReadReassignments(kafkaArgs, zkConn, []string{"test"}))
+}
+
+func TestReadReassignments_ClusterAdmin(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	version := sarama.V2_4_0_0
+	kafkaArgs.KafkaVersion = &version
+
+	clusterAdmin := &connection.MockClusterAdmin{}
+	clusterAdmin.On("DescribeTopics", []string{"test", "other", "missing"}).Return([]*sarama.TopicMetadata{
+		{Name: "test", Partitions: []*sarama.PartitionMetadata{{ID: 0}, {ID: 1}}},
+		{Name: "other", Partitions: []*sarama.PartitionMetadata{{ID: 0}}},
+		{Name: "missing", Err: sarama.ErrUnknownTopicOrPartition},
+	}, nil)
+	clusterAdmin.On("ListPartitionReassignments", "test", []int32{0, 1}).Return(map[string]map[int32]*sarama.PartitionReplicaReassignmentsStatus{
+		"test": {1: {Replicas: []int32{1, 2}, AddingReplicas: []int32{2}}},
+	}, nil)
+	clusterAdmin.On("ListPartitionReassignments", "other", []int32{0}).Return(map[string]map[int32]*sarama.PartitionReplicaReassignmentsStatus{}, nil)
+	clusterAdmin.On("Close").Return(nil)
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("CreateClusterAdmin").Return(clusterAdmin, nil)
+
+	// Zookeeper isn't read
+	reassignments := ReadReassignments(kafkaArgs, zkConn, []string{"test", "other", "missing"})
+	assert.Equal(t, Reassignments{"test": true}, reassignments)
+	clusterAdmin.AssertExpectations(t)
+
+	clusterAdmin = &connection.MockClusterAdmin{}
+	clusterAdmin.On("DescribeTopics", []string{"test"}).Return([]*sarama.TopicMetadata{
+		{Name: "test", Partitions: []*sarama.PartitionMetadata{{ID: 0}}},
+	}, nil)
+	clusterAdmin.On("ListPartitionReassignments", "test", []int32{0}).Return(map[string]map[int32]*sarama.PartitionReplicaReassignmentsStatus(nil), errors.New("not controller"))
+	clusterAdmin.On("Close").Return(nil)
+	zkConn = &zookeeper.MockConnection{}
+	zkConn.On("CreateClusterAdmin").Return(clusterAdmin, nil)
+	assert.Nil(t, ReadReassignments(kafkaArgs, zkConn, []string{"test"}))
+}
+
+func TestReadReassignments_NoTopicMetrics(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.CollectBrokerTopicData = false
+
+	// Nothing is read
+	assert.Nil(t, ReadReassignments(kafkaArgs, &zookeeper.MockConnection{}, []string{"test"}))
+}
+
+func Test_calculateReassignmentInProgress(t *testing.T) {
+	i, _ := integration.New("kafka", "1.0.0")
+	e, _ := i.Entity("test", "ka-topic")
+
+	sample := e.NewMetricSet("KafkaTopicSample")
+	assert.Nil(t, calculateReassignmentInProgress("test", Reassignments{"test": true}, sample))
+	assert.Equal(t, float64(1), sample.Metrics["kafka.topic.reassignmentInProgress"])
+
+	sample = e.NewMetricSet("KafkaTopicSample")
+	assert.Nil(t, calculateReassignmentInProgress("other", Reassignments{"test": true}, sample))
+	assert.Equal(t, float64(0), sample.Metrics["kafka.topic.reassignmentInProgress"])
+
+	// Left out if the reassignments couldn't be read
+	sample = e.NewMetricSet("KafkaTopicSample")
+	assert.Nil(t, calculateReassignmentInProgress("test", nil, sample))
+	_, ok := sample.Metrics["kafka.topic.reassignmentInProgress"]
+	assert.False(t, ok)
+}
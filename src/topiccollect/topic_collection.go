@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -14,6 +14,8 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/infra-integrations-sdk/log"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/connection"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 )
 
@@ -28,45 +30,85 @@ type Topic struct {
 }
 
 // StartTopicPool Starts a pool of topicWorkers to handle collecting data for Topic entities.
-// The channel returned is to be closed by the user.
-func StartTopicPool(poolSize int, wg *sync.WaitGroup, zkConn zookeeper.Connection) chan *Topic {
+// At most poolSize topics are collected at once. The workers share nothing but the Zookeeper
+// connection, which is safe for concurrent use, and the reassignments read for the collection. Each topic is
+// only handled by one worker. The channel returned is to be closed by the user.
+func StartTopicPool(kafkaArgs *args.KafkaArguments, poolSize int, wg *sync.WaitGroup, zkConn zookeeper.Connection, reassignments Reassignments) chan *Topic {
 	topicChan := make(chan *Topic)
 
-	if args.GlobalArgs.CollectBrokerTopicData && zkConn != nil {
+	if kafkaArgs.CollectBrokerTopicData && zkConn != nil {
 		for i := 0; i < poolSize; i++ {
 			wg.Add(1)
-			go topicWorker(topicChan, wg, zkConn)
+			go topicWorker(kafkaArgs, topicChan, wg, zkConn, reassignments)
 		}
 	}
 
 	return topicChan
 }
 
-// GetTopics retrieves the list of topics to collect based on the user-provided configuration
-func GetTopics(zkConn zookeeper.Connection) ([]string, error) {
-	switch strings.ToLower(args.GlobalArgs.TopicMode) {
+// GetTopics retrieves the list of topics to collect based on the user-provided configuration.
+// Internal topics are left out unless include_internal_topics is set.
+func GetTopics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection) ([]string, error) {
+	topics, err := getConfiguredTopics(kafkaArgs, zkConn)
+	if err != nil || kafkaArgs.IncludeInternalTopics || len(topics) == 0 {
+		return topics, err
+	}
+
+	internalTopics := describeInternalTopics(kafkaArgs, zkConn)
+	filteredTopics := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if internalTopics.IsInternal(topic) {
+			log.Debug("Skipping internal topic %s, set include_internal_topics to collect it", topic)
+			continue
+		}
+		filteredTopics = append(filteredTopics, topic)
+	}
+
+	return filteredTopics, nil
+}
+
+// describeInternalTopics returns which topics are internal from the topic metadata, or nil to tell them apart by
+// name if there is no connection to the cluster, such as when only producers and consumers of listed topics are
+// collected
+func describeInternalTopics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection) InternalTopics {
+	if zkConn == nil {
+		return nil
+	}
+
+	clusterAdmin, err := zkConn.CreateClusterAdmin()
+	if err != nil {
+		log.Warn("Unable to create cluster admin, telling internal topics apart by name: %s", err.Error())
+		return nil
+	}
+	defer func() {
+		if err := clusterAdmin.Close(); err != nil {
+			log.Debug("Error closing clusterAdmin connection: %s", err.Error())
+		}
+	}()
+
+	return DescribeInternalTopics(kafkaArgs, clusterAdmin)
+}
+
+func getConfiguredTopics(kafkaArgs *args.KafkaArguments, zkConn zookeeper.Connection) ([]string, error) {
+	switch strings.ToLower(kafkaArgs.TopicMode) {
 	case "none":
 		return []string{}, nil
 	case "list":
-		return args.GlobalArgs.TopicList, nil
+		return kafkaArgs.TopicList, nil
 	case "regex":
 		if zkConn == nil {
 			return nil, errors.New("zookeeper connection must not be nil for 'All' mode")
 		}
 
-		if args.GlobalArgs.TopicRegex == "" {
+		pattern := kafkaArgs.TopicRegex
+		if pattern == nil {
 			return nil, errors.New("regex topic mode requires the topic_regex argument to be set")
 		}
 
-		pattern, err := regexp.Compile(args.GlobalArgs.TopicRegex)
-		if err != nil {
-			return nil, fmt.Errorf("failed to compile topic regex: %s", err)
-		}
-
 		// If they want all topics, ask Zookeeper for the list of topics
-		collectedTopics, _, err := zkConn.Children(zookeeper.Path("/brokers/topics"))
+		collectedTopics, _, err := zkConn.Children("/brokers/topics")
 		if err != nil {
-			log.Error("Unable to get list of topics from Zookeeper with error: %s", err)
+			collecterrors.Error("Unable to get list of topics from Zookeeper with error: %s", err)
 			return nil, err
 		}
 
@@ -84,29 +126,29 @@ func GetTopics(zkConn zookeeper.Connection) ([]string, error) {
 		}
 
 		// If they want all topics, ask Zookeeper for the list of topics
-		collectedTopics, _, err := zkConn.Children(zookeeper.Path("/brokers/topics"))
+		collectedTopics, _, err := zkConn.Children("/brokers/topics")
 		if err != nil {
-			log.Error("Unable to get list of topics from Zookeeper with error: %s", err)
+			collecterrors.Error("Unable to get list of topics from Zookeeper with error: %s", err)
 			return nil, err
 		}
 		return collectedTopics, nil
 	default:
-		log.Error("Invalid topic mode %s", args.GlobalArgs.TopicMode)
-		return nil, fmt.Errorf("invalid topic_mode '%s'", args.GlobalArgs.TopicMode)
+		collecterrors.Error("Invalid topic mode %s", kafkaArgs.TopicMode)
+		return nil, fmt.Errorf("invalid topic_mode '%s'", kafkaArgs.TopicMode)
 	}
 }
 
 // FeedTopicPool sends Topic structs down the topicChan for workers to collect and build Topic structs
-func FeedTopicPool(topicChan chan<- *Topic, i *integration.Integration, collectedTopics []string) {
+func FeedTopicPool(kafkaArgs *args.KafkaArguments, topicChan chan<- *Topic, i *integration.Integration, collectedTopics []string) {
 	defer close(topicChan)
 
-	if args.GlobalArgs.CollectBrokerTopicData {
+	if kafkaArgs.CollectBrokerTopicData {
 		for _, topicName := range collectedTopics {
 			// create topic entity
-			clusterIDAttr := integration.NewIDAttribute("clusterName", args.GlobalArgs.ClusterName)
-			topicEntity, err := i.Entity(topicName, "ka-topic", clusterIDAttr)
+			clusterIDAttrs := kafkaArgs.ClusterIDAttributes()
+			topicEntity, err := i.Entity(topicName, "ka-topic", clusterIDAttrs...)
 			if err != nil {
-				log.Error("Unable to create an entity for topic %s", topicName)
+				collecterrors.Error("Unable to create an entity for topic %s", topicName)
 			}
 
 			topicChan <- &Topic{
@@ -118,7 +160,7 @@ func FeedTopicPool(topicChan chan<- *Topic, i *integration.Integration, collecte
 }
 
 // Collect inventory and metrics for topics sent down topicChan
-func topicWorker(topicChan <-chan *Topic, wg *sync.WaitGroup, zkConn zookeeper.Connection) {
+func topicWorker(kafkaArgs *args.KafkaArguments, topicChan <-chan *Topic, wg *sync.WaitGroup, zkConn zookeeper.Connection, reassignments Reassignments) {
 	defer wg.Done()
 
 	for {
@@ -129,32 +171,36 @@ func topicWorker(topicChan <-chan *Topic, wg *sync.WaitGroup, zkConn zookeeper.C
 
 		// Finish populating topic struct
 		if err := setTopicInfo(topic, zkConn); err != nil {
-			log.Error("Unable to set topic data for topic %s with error: %s", topic.Name, err)
+			collecterrors.Error("Unable to set topic data for topic %s with error: %s", topic.Name, err)
 			continue
 		}
 
 		// Collect and populate inventory with topic configuration
-		if args.GlobalArgs.All() || args.GlobalArgs.Inventory {
+		if kafkaArgs.HasInventory() {
 			log.Debug("Collecting inventory for topic %q", topic.Name)
 			errors := populateTopicInventory(topic)
 			if len(errors) != 0 {
-				log.Error("Failed to populate inventory with %d errors", len(errors))
+				collecterrors.Error("Failed to populate inventory with %d errors", len(errors))
 			}
 			log.Debug("Done collecting inventory for topic %q", topic.Name)
 		}
 
 		// Collect topic metrics
-		if args.GlobalArgs.All() || args.GlobalArgs.Metrics {
+		if kafkaArgs.HasMetrics() {
 			log.Debug("Collecting metrics for topic %s", topic.Name)
 			// Create metric set for topic
-			sample := topic.Entity.NewMetricSet("KafkaTopicSample",
-				metric.Attribute{Key: "displayName", Value: topic.Name},
-				metric.Attribute{Key: "entityName", Value: "topic:" + topic.Name},
-			)
+			sample := topic.Entity.NewMetricSet(kafkaArgs.TopicSampleName, append([]metric.Attribute{
+				{Key: "displayName", Value: topic.Name},
+				{Key: "entityName", Value: "topic:" + topic.Name},
+			}, kafkaArgs.TopicAttributes(topic.Name)...)...)
 
 			// Collect metrics and populate metric set with them
-			if err := populateTopicMetrics(topic, sample, zkConn); err != nil {
-				log.Error("Error collecting metrics from Topic %q: %s", topic.Name, err.Error())
+			if err := populateTopicMetrics(kafkaArgs, topic, sample, zkConn, reassignments); err != nil {
+				collecterrors.Error("Error collecting metrics from Topic %q: %s", topic.Name, err.Error())
+			}
+			setPartitionLeaderMetrics(kafkaArgs, topic)
+			if err := setPartitionCountChange(kafkaArgs, topic, sample); err != nil {
+				collecterrors.Error("Error setting partition count change of Topic %q: %s", topic.Name, err.Error())
 			}
 
 			log.Debug("Done collecting metrics for topic %q", topic.Name)
@@ -163,7 +209,7 @@ func topicWorker(topicChan <-chan *Topic, wg *sync.WaitGroup, zkConn zookeeper.C
 }
 
 // Calculate topic metrics and populate metric set with them
-func populateTopicMetrics(t *Topic, sample *metric.Set, zkConn zookeeper.Connection) error {
+func populateTopicMetrics(kafkaArgs *args.KafkaArguments, t *Topic, sample *metric.Set, zkConn zookeeper.Connection, reassignments Reassignments) error {
 
 	if err := calculateTopicRetention(t.Configs, sample); err != nil {
 		return err
@@ -177,7 +223,11 @@ func populateTopicMetrics(t *Topic, sample *metric.Set, zkConn zookeeper.Connect
 		return err
 	}
 
-	responds := topicRespondsToMetadata(t, zkConn)
+	if err := calculateReassignmentInProgress(t.Name, reassignments, sample); err != nil {
+		return err
+	}
+
+	responds := topicRespondsToMetadata(kafkaArgs, t, zkConn)
 	return sample.SetMetric("topic.respondsToMetadataRequests", responds, metric.GAUGE)
 }
 
@@ -203,6 +253,26 @@ func calculateNonPreferredLeader(partitions []*partition, sample *metric.Set) er
 	return sample.SetMetric("topic.partitionsWithNonPreferredLeader", numberNonPreferredLeader, metric.GAUGE)
 }
 
+// noLeader is the leader of a partition in its Zookeeper state when none of its replicas is the leader
+const noLeader = -1
+
+// setPartitionLeaderMetrics reports whether each partition of the topic has a leader, in a topic sample with a
+// partition attribute. A partition without a leader can't be produced to or consumed from.
+func setPartitionLeaderMetrics(kafkaArgs *args.KafkaArguments, t *Topic) {
+	for _, p := range t.Partitions {
+		sample := t.Entity.NewMetricSet(kafkaArgs.TopicSampleName, append([]metric.Attribute{
+			{Key: "displayName", Value: t.Name},
+			{Key: "entityName", Value: "topic:" + t.Name},
+			{Key: "partition", Value: strconv.Itoa(p.ID)},
+		}, kafkaArgs.TopicAttributes(t.Name)...)...)
+
+		hasLeader := p.Leader != noLeader
+		if err := sample.SetMetric("kafka.partition.hasLeader", hasLeader, metric.GAUGE); err != nil {
+			collecterrors.Error("Unable to set leader metric for partition %d of Topic %s: %s", p.ID, t.Name, err.Error())
+		}
+	}
+}
+
 func calculateUnderReplicatedCount(partitions []*partition, sample *metric.Set) error {
 	numberUnderReplicated := 0
 	for _, p := range partitions {
@@ -214,8 +284,23 @@ func calculateUnderReplicatedCount(partitions []*partition, sample *metric.Set)
 	return sample.SetMetric("topic.underReplicatedPartitions", numberUnderReplicated, metric.GAUGE)
 }
 
+// Sets whether a partition of the topic is being reassigned. The metric is left out if the
+// reassignments couldn't be read.
+func calculateReassignmentInProgress(topic string, reassignments Reassignments, sample *metric.Set) error {
+	if reassignments == nil {
+		return nil
+	}
+
+	inProgress := 0
+	if reassignments[topic] {
+		inProgress = 1
+	}
+
+	return sample.SetMetric("kafka.topic.reassignmentInProgress", inProgress, metric.GAUGE)
+}
+
 // Makes a metadata request to determine whether a topic is able to respond
-func topicRespondsToMetadata(t *Topic, zkConn zookeeper.Connection) int {
+func topicRespondsToMetadata(kafkaArgs *args.KafkaArguments, t *Topic, zkConn zookeeper.Connection) int {
 
 	// Get connection information for a broker
 	connections, err := zookeeper.GetBrokerConnections(0, zkConn)
@@ -226,9 +311,9 @@ func topicRespondsToMetadata(t *Topic, zkConn zookeeper.Connection) int {
 	var broker *sarama.Broker
 
 	// Create a broker connection object and open the connection
-	for _, connection := range connections {
-		broker = sarama.NewBroker(fmt.Sprintf("%s:%d", connection.BrokerHost, connection.BrokerPort))
-		config := sarama.NewConfig()
+	config := connection.NewConfig(kafkaArgs.StateKeyPrefix())
+	for _, brokerConnection := range connections {
+		broker = sarama.NewBroker(brokerConnection.Addr())
 		err = broker.Open(config)
 		if err != nil {
 			return 0
@@ -254,7 +339,7 @@ func topicRespondsToMetadata(t *Topic, zkConn zookeeper.Connection) int {
 func setTopicInfo(t *Topic, zkConn zookeeper.Connection) error {
 
 	// Collect topic configuration from Zookeeper
-	config, _, err := zkConn.Get(zookeeper.Path("/config/topics/" + t.Name))
+	config, _, err := zkConn.Get("/config/topics/" + t.Name)
 	if err != nil {
 		return err
 	}
@@ -8,21 +8,32 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/kr/pretty"
 	"github.com/newrelic/infra-integrations-sdk/data/inventory"
 	"github.com/newrelic/infra-integrations-sdk/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/integration"
 	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
 	"github.com/newrelic/nri-kafka/src/testutils"
 	"github.com/newrelic/nri-kafka/src/zookeeper"
 	"github.com/samuel/go-zookeeper/zk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 var (
 	brokerConnectionBytes = []byte(`{"listener_security_protocol_map":{"PLAINTEXT":"PLAINTEXT"},"endpoints":["PLAINTEXT://kafkabroker:9092"],"jmx_port":9999,"host":"kafkabroker","timestamp":"1530886155628","port":9092,"version":4}`)
 )
 
+// internalTopicsAdmin returns a cluster admin whose topic metadata is metadata
+func internalTopicsAdmin(metadata ...*sarama.TopicMetadata) *connection.MockClusterAdmin {
+	clusterAdmin := &connection.MockClusterAdmin{}
+	clusterAdmin.On("DescribeTopics", []string(nil)).Return(metadata, nil)
+	clusterAdmin.On("Close").Return(nil)
+	return clusterAdmin
+}
+
 func TestGetTopics(t *testing.T) {
 	testCases := []struct {
 		topicMode     string
@@ -39,12 +50,13 @@ func TestGetTopics(t *testing.T) {
 	for _, tc := range testCases {
 		zkConn := &zookeeper.MockConnection{}
 		zkConn.On("Children", "/brokers/topics").Return([]string{"test1", "test2", "test3"}, new(zk.Stat), nil)
-		args.GlobalArgs = &args.KafkaArguments{
+		zkConn.On("CreateClusterAdmin").Return(internalTopicsAdmin(), nil)
+		kafkaArgs := &args.KafkaArguments{
 			TopicMode: tc.topicMode,
 			TopicList: tc.topicNames,
 		}
 
-		topicNames, err := GetTopics(zkConn)
+		topicNames, err := GetTopics(kafkaArgs, zkConn)
 		if (err != nil) != tc.expectedErr {
 			t.Error("Incorrect error state returned.")
 		}
@@ -70,33 +82,64 @@ func TestGetTopics_zkErr(t *testing.T) {
 	for _, tc := range testCases {
 		zkConn := &zookeeper.MockConnection{}
 		zkConn.On("Children", "/brokers/topics").Return([]string{"test1", "test2", "test3"}, new(zk.Stat), nil)
-		args.GlobalArgs = &args.KafkaArguments{
+		zkConn.On("CreateClusterAdmin").Return(internalTopicsAdmin(), nil)
+		kafkaArgs := &args.KafkaArguments{
 			TopicMode: tc.topicMode,
 			TopicList: tc.topicNames,
 		}
 
-		topicNames, err := GetTopics(zkConn)
+		topicNames, err := GetTopics(kafkaArgs, zkConn)
 		assert.Equal(t, tc.expectedErr, err)
 		assert.Equal(t, tc.expectedNames, topicNames)
 	}
 }
 
+func TestGetTopics_InternalTopics(t *testing.T) {
+	testCases := []struct {
+		includeInternal bool
+		expectedNames   []string
+	}{
+		{false, []string{"test1", "__user_topic", "test2"}},
+		{true, []string{"__consumer_offsets", "test1", "__transaction_state", "__user_topic", "test2"}},
+	}
+
+	for _, tc := range testCases {
+		zkConn := &zookeeper.MockConnection{}
+		zkConn.On("Children", "/brokers/topics").Return([]string{"__consumer_offsets", "test1", "__transaction_state", "__user_topic", "test2"}, new(zk.Stat), nil)
+		// __transaction_state has no metadata, so it's told apart by name
+		zkConn.On("CreateClusterAdmin").Return(internalTopicsAdmin(
+			&sarama.TopicMetadata{Name: "__consumer_offsets", IsInternal: true},
+			&sarama.TopicMetadata{Name: "test1"},
+			&sarama.TopicMetadata{Name: "__user_topic"},
+			&sarama.TopicMetadata{Name: "test2"},
+		), nil)
+		kafkaArgs := &args.KafkaArguments{
+			TopicMode:             "All",
+			IncludeInternalTopics: tc.includeInternal,
+		}
+
+		topicNames, err := GetTopics(kafkaArgs, zkConn)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expectedNames, topicNames)
+	}
+}
+
 func TestGetTopics_zkNil(t *testing.T) {
-	args.GlobalArgs = &args.KafkaArguments{
+	kafkaArgs := &args.KafkaArguments{
 		TopicMode: "All",
 	}
 
-	if _, err := GetTopics(nil); err == nil {
+	if _, err := GetTopics(kafkaArgs, nil); err == nil {
 		t.Error("Did not get expected error")
 	}
 }
 
 func TestStartTopicPool(t *testing.T) {
-	testutils.SetupTestArgs()
+	kafkaArgs := testutils.SetupTestArgs()
 	var wg sync.WaitGroup
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 
-	topicChan := StartTopicPool(3, &wg, &zkConn)
+	topicChan := StartTopicPool(kafkaArgs, 3, &wg, zkConn, nil)
 	close(topicChan)
 
 	c := make(chan int)
@@ -112,26 +155,65 @@ func TestStartTopicPool(t *testing.T) {
 	}
 }
 
+func TestStartTopicPool_ConcurrencyBound(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	// Every topic fails on its config, which is the first read, once it has been in flight for a while
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("Get", mock.Anything).Return([]byte{}, new(zk.Stat), errors.New("no node")).Run(func(mock.Arguments) {
+		lock.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+	})
+
+	topicChan := StartTopicPool(kafkaArgs, 3, &wg, zkConn, nil)
+	for n := 0; n < 20; n++ {
+		topicChan <- &Topic{Name: fmt.Sprintf("topic%d", n)}
+	}
+	close(topicChan)
+	wg.Wait()
+
+	if maxInFlight > 3 {
+		t.Errorf("Expected at most 3 topics collected at once, got %d", maxInFlight)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("Expected topics to be collected concurrently, got %d at once", maxInFlight)
+	}
+}
+
 func TestFeedTopicPool(t *testing.T) {
-	testutils.SetupTestArgs()
-	args.GlobalArgs.TopicMode = "All"
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.TopicMode = "All"
 
 	i, err := integration.New("kafka", "1.0.0")
 	if err != nil {
 		t.Error("Failed to create integration")
 	}
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 	zkConn.On("Children", "/brokers/topics").Return([]string{"test1", "test2", "test3"}, new(zk.Stat), nil)
+	zkConn.On("CreateClusterAdmin").Return(internalTopicsAdmin(), nil)
 
 	topicChan := make(chan *Topic, 10)
 
-	collectedTopics, err := GetTopics(zkConn)
+	collectedTopics, err := GetTopics(kafkaArgs, zkConn)
 	if err != nil {
 		t.Errorf("Unexpected error: %s", err.Error())
 		t.FailNow()
 	}
 
-	FeedTopicPool(topicChan, i, collectedTopics)
+	FeedTopicPool(kafkaArgs, topicChan, i, collectedTopics)
 
 	var topics []*Topic
 	for {
@@ -154,7 +236,7 @@ func TestFeedTopicPool(t *testing.T) {
 func TestTopicWorker(t *testing.T) {
 	topicChan := make(chan *Topic)
 	var wg sync.WaitGroup
-	zkConn := zookeeper.MockConnection{}
+	zkConn := &zookeeper.MockConnection{}
 	zkConn.On("Get", "/config/topics/test").Return([]byte(`{"version":1,"config":{"flush.messages":"12345"}}`), new(zk.Stat), nil)
 	zkConn.On("Get", "/brokers/topics/test").Return([]byte(`{"version":1,"partitions":{"2":[1,2,0],"1":[0,1,2],"0":[2,0,1]}}`), new(zk.Stat), nil)
 	zkConn.On("Get", "/brokers/topics/test/partitions/0/state").Return(partitionState, new(zk.Stat), nil)
@@ -162,8 +244,8 @@ func TestTopicWorker(t *testing.T) {
 	zkConn.On("Get", "/brokers/topics/test/partitions/2/state").Return(partitionState, new(zk.Stat), nil)
 	zkConn.On("Get", "/brokers/ids/0").Return(brokerConnectionBytes, new(zk.Stat), nil)
 
-	testutils.SetupTestArgs()
-	args.GlobalArgs.Metrics = false
+	kafkaArgs := testutils.SetupTestArgs()
+	kafkaArgs.Metrics = false
 
 	i, err := integration.New("kafka", "1.0.0")
 	if err != nil {
@@ -175,7 +257,7 @@ func TestTopicWorker(t *testing.T) {
 	}
 
 	wg.Add(1)
-	go topicWorker(topicChan, &wg, &zkConn)
+	go topicWorker(kafkaArgs, topicChan, &wg, zkConn, nil)
 
 	myTopic := &Topic{
 		Name:   "test",
@@ -205,8 +287,6 @@ func TestTopicWorker(t *testing.T) {
 }
 
 func TestPopulateTopicInventory(t *testing.T) {
-	testutils.SetupTestArgs()
-
 	i, err := integration.New("kafka", "1.0.0")
 	if err != nil {
 		t.Error(err)
@@ -253,6 +333,7 @@ func TestPopulateTopicInventory(t *testing.T) {
 }
 
 func TestPopulateTopicMetrics(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
 	zkConn := &zookeeper.MockConnection{}
 	zkConn.On("Get", "/config/topics/test").Return([]byte(`{"version":1,"config":{"flush.messages":"12345"}}`), new(zk.Stat), nil)
 	zkConn.On("Get", "/brokers/topics/test").Return([]byte(`{"version":1,"partitions":{"2":[1,2,0],"1":[0,1,2],"0":[2,0,1]}}`), new(zk.Stat), nil)
@@ -279,7 +360,7 @@ func TestPopulateTopicMetrics(t *testing.T) {
 		metric.Attribute{Key: "name", Value: testTopic.Name},
 	)
 
-	populateTopicMetrics(testTopic, sample, zkConn)
+	populateTopicMetrics(kafkaArgs, testTopic, sample, zkConn, Reassignments{})
 
 	expectedMetrics := map[string]interface{}{
 		"event_type": "KafkaTopicSample",
@@ -288,6 +369,7 @@ func TestPopulateTopicMetrics(t *testing.T) {
 		"topic.retentionBytesOrTime":             0.0,
 		"topic.partitionsWithNonPreferredLeader": 0.0,
 		"topic.underReplicatedPartitions":        0.0,
+		"kafka.topic.reassignmentInProgress":     0.0,
 		"topic.respondsToMetadataRequests":       0.0,
 	}
 
@@ -298,3 +380,26 @@ func TestPopulateTopicMetrics(t *testing.T) {
 
 	return
 }
+
+func TestSetPartitionLeaderMetrics(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	zkConn := &zookeeper.MockConnection{}
+	zkConn.On("Get", "/config/topics/test").Return([]byte(`{"version":1,"config":{}}`), new(zk.Stat), nil)
+	zkConn.On("Get", "/brokers/topics/test").Return([]byte(`{"version":1,"partitions":{"1":[0,1,2],"0":[2,0,1]}}`), new(zk.Stat), nil)
+	zkConn.On("Get", "/brokers/topics/test/partitions/0/state").Return(partitionState, new(zk.Stat), nil)
+	// The leader is -1 while none of the replicas of the partition is available
+	zkConn.On("Get", "/brokers/topics/test/partitions/1/state").Return([]byte(`{"controller_epoch":9,"leader":-1,"version":1,"leader_epoch":3,"isr":[]}`), new(zk.Stat), nil)
+	i, _ := integration.New("kafka", "1.0.0")
+	e, _ := i.Entity("test", "ka-topic")
+	topic := &Topic{Name: "test", Entity: e}
+	assert.Nil(t, setTopicInfo(topic, zkConn))
+
+	setPartitionLeaderMetrics(kafkaArgs, topic)
+
+	hasLeader := make(map[interface{}]interface{})
+	for _, sample := range e.Metrics {
+		assert.Equal(t, args.DefaultTopicSampleName, sample.Metrics["event_type"])
+		hasLeader[sample.Metrics["partition"]] = sample.Metrics["kafka.partition.hasLeader"]
+	}
+	assert.Equal(t, map[interface{}]interface{}{"0": float64(1), "1": float64(0)}, hasLeader)
+}
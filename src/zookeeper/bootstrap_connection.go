@@ -0,0 +1,308 @@
+package zookeeper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/connection"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// bootstrapConnection is the Connection used for KRaft clusters, configured with bootstrap_brokers instead of
+// zookeeper_hosts, and when Zookeeper can't be reached. It answers the reads of the Zookeeper nodes the
+// integration uses from the metadata and configs of the brokers in bootstrap_brokers and bootstrap_brokers_file,
+// so broker, topic and consumer offset collection still work. Nodes without an equivalent, such as the
+// in-progress partition reassignment, don't exist.
+type bootstrapConnection struct {
+	kafkaArgs *args.KafkaArguments
+
+	lock   sync.Mutex
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// newBootstrapConnection returns a bootstrapConnection to the bootstrap_brokers and the brokers in the
+// bootstrap_brokers_file of kafkaArgs, either of which can be empty. No connection is made until the first read.
+func newBootstrapConnection(kafkaArgs *args.KafkaArguments) *bootstrapConnection {
+	return &bootstrapConnection{kafkaArgs: kafkaArgs}
+}
+
+// addresses returns the bootstrap_brokers followed by the brokers in the bootstrap_brokers_file, which is read
+// again every time. A file that can't be read is only an error if there are no bootstrap_brokers.
+func (b *bootstrapConnection) addresses() ([]string, error) {
+	addresses := append([]string(nil), b.kafkaArgs.BootstrapBrokers...)
+	if brokersFile := b.kafkaArgs.BootstrapBrokersFile; brokersFile != "" {
+		fileBrokers, err := readBrokersFile(brokersFile)
+		if err != nil && len(addresses) == 0 {
+			return nil, fmt.Errorf("unable to read bootstrap_brokers_file %s: %s", brokersFile, err)
+		} else if err != nil {
+			log.Warn("Unable to read bootstrap_brokers_file %s, using bootstrap_brokers only: %s", brokersFile, err.Error())
+		}
+		addresses = mergeAddresses(addresses, fileBrokers)
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("no brokers in bootstrap_brokers or bootstrap_brokers_file")
+	}
+
+	return addresses, nil
+}
+
+// IsBootstrapConnection returns true if conn reads the cluster from bootstrap brokers instead of Zookeeper
+func IsBootstrapConnection(conn Connection) bool {
+	_, ok := conn.(*bootstrapConnection)
+	return ok
+}
+
+// metadata returns the client the nodes are read from, creating it on the first read
+func (b *bootstrapConnection) metadata() (sarama.Client, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	err := connectWithRetries(b.kafkaArgs, "Kafka client", func() error {
+		addresses, err := b.addresses()
+		if err != nil {
+			return err
+		}
+
+		b.client, err = sarama.NewClient(addresses, createConfig(b.kafkaArgs, false))
+		return err
+	})
+
+	return b.client, err
+}
+
+// configs returns the cluster admin the config nodes are read from, creating it on the first read
+func (b *bootstrapConnection) configs() (sarama.ClusterAdmin, error) {
+	client, err := b.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.admin == nil {
+		b.admin, err = sarama.NewClusterAdminFromClient(client)
+	}
+
+	return b.admin, err
+}
+
+func (b *bootstrapConnection) Children(s string) ([]string, *zk.Stat, error) {
+	client, err := b.metadata()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch s {
+	case "/brokers/ids":
+		brokers := client.Brokers()
+		brokerIDs := make([]string, 0, len(brokers))
+		for _, broker := range brokers {
+			brokerIDs = append(brokerIDs, strconv.Itoa(int(broker.ID())))
+		}
+		sort.Strings(brokerIDs)
+		return brokerIDs, &zk.Stat{}, nil
+	case "/brokers/topics":
+		topics, err := client.Topics()
+		if err != nil {
+			return nil, nil, err
+		}
+		return topics, &zk.Stat{}, nil
+	}
+
+	return nil, nil, zk.ErrNoNode
+}
+
+func (b *bootstrapConnection) Get(s string) ([]byte, *zk.Stat, error) {
+	client, err := b.metadata()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var node interface{}
+	switch nodePath := strings.Split(s, "/"); {
+	case len(nodePath) == 4 && nodePath[1] == "brokers" && nodePath[2] == "ids":
+		node, err = brokerNode(client, nodePath[3], b.kafkaArgs.DefaultJMXPort)
+	case len(nodePath) == 4 && nodePath[1] == "brokers" && nodePath[2] == "topics":
+		node, err = topicNode(client, nodePath[3])
+	case len(nodePath) == 7 && nodePath[1] == "brokers" && nodePath[2] == "topics" && nodePath[4] == "partitions" && nodePath[6] == "state":
+		node, err = partitionStateNode(client, nodePath[3], nodePath[5])
+	case len(nodePath) == 4 && nodePath[1] == "config" && nodePath[2] == "topics":
+		node, err = b.configNode(sarama.TopicResource, nodePath[3])
+	case len(nodePath) == 4 && nodePath[1] == "config" && nodePath[2] == "brokers":
+		node, err = b.configNode(sarama.BrokerResource, nodePath[3])
+	default:
+		return nil, nil, zk.ErrNoNode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, &zk.Stat{}, nil
+}
+
+// brokerNode returns the registration of the broker with brokerID, with its metadata address as the plaintext
+// endpoint. Brokers don't advertise their JMX port, so it's the default_jmx_port, jmxPort, unless
+// broker_jmx_ports overrides it.
+func brokerNode(client sarama.Client, brokerID string, jmxPort int) (interface{}, error) {
+	for _, broker := range client.Brokers() {
+		if strconv.Itoa(int(broker.ID())) != brokerID {
+			continue
+		}
+
+		return map[string]interface{}{
+			"endpoints": []string{"PLAINTEXT://" + broker.Addr()},
+			"jmx_port":  jmxPort,
+		}, nil
+	}
+
+	return nil, zk.ErrNoNode
+}
+
+// topicNode returns the replicas of each partition of topic
+func topicNode(client sarama.Client, topic string) (interface{}, error) {
+	partitions, err := client.Partitions(topic)
+	if err == sarama.ErrUnknownTopicOrPartition {
+		return nil, zk.ErrNoNode
+	} else if err != nil {
+		return nil, err
+	}
+
+	replicas := make(map[string][]int32, len(partitions))
+	for _, partition := range partitions {
+		partitionReplicas, err := client.Replicas(topic, partition)
+		if err != nil {
+			return nil, err
+		}
+		replicas[strconv.Itoa(int(partition))] = partitionReplicas
+	}
+
+	return map[string]interface{}{"partitions": replicas}, nil
+}
+
+// partitionStateNode returns the leader and in sync replicas of the partition
+func partitionStateNode(client sarama.Client, topic, partition string) (interface{}, error) {
+	partitionID, err := strconv.Atoi(partition)
+	if err != nil {
+		return nil, zk.ErrNoNode
+	}
+
+	isr, err := client.InSyncReplicas(topic, int32(partitionID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Zookeeper has a leader of -1 for a partition without one
+	leader, err := client.Leader(topic, int32(partitionID))
+	if err == sarama.ErrLeaderNotAvailable {
+		return map[string]interface{}{"leader": -1, "isr": isr}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"leader": leader.ID(), "isr": isr}, nil
+}
+
+// configNode returns the configs of the topic or broker called name that aren't left to their defaults, as
+// Zookeeper only stores those. Sensitive configs have no value and are left out.
+func (b *bootstrapConnection) configNode(resourceType sarama.ConfigResourceType, name string) (interface{}, error) {
+	admin, err := b.configs()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{Type: resourceType, Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	config := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.Default && !entry.Sensitive {
+			config[entry.Name] = entry.Value
+		}
+	}
+
+	return map[string]interface{}{"config": config}, nil
+}
+
+func (b *bootstrapConnection) CreateClient() (connection.Client, error) {
+	var client sarama.Client
+	err := connectWithRetries(b.kafkaArgs, "Kafka client", func() error {
+		addresses, err := b.addresses()
+		if err != nil {
+			return err
+		}
+
+		client, err = sarama.NewClient(addresses, createConfig(b.kafkaArgs, false))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return connection.SaramaClient{Client: client}, nil
+}
+
+func (b *bootstrapConnection) CreateClusterAdmin() (sarama.ClusterAdmin, error) {
+	var admin sarama.ClusterAdmin
+	err := connectWithRetries(b.kafkaArgs, "Kafka cluster admin", func() error {
+		addresses, err := b.addresses()
+		if err != nil {
+			return err
+		}
+
+		admin, err = sarama.NewClusterAdmin(addresses, createConfig(b.kafkaArgs, false))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return admin, nil
+}
+
+func (b *bootstrapConnection) CreateConsumer() (sarama.Consumer, error) {
+	addresses, err := b.addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	return sarama.NewConsumer(addresses, createConfig(b.kafkaArgs, false))
+}
+
+func (b *bootstrapConnection) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Closing the admin closes the client it was created from
+	var err error
+	if b.admin != nil {
+		err = b.admin.Close()
+	} else if b.client != nil {
+		err = b.client.Close()
+	}
+	if err != nil {
+		log.Debug("Error closing bootstrap broker connection: %s", err.Error())
+	}
+	b.admin, b.client = nil, nil
+}
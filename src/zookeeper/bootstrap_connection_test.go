@@ -0,0 +1,159 @@
+package zookeeper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// writeBrokersFile writes a bootstrap_brokers_file with addresses in dir
+func writeBrokersFile(t *testing.T, dir string, addresses ...string) string {
+	path := filepath.Join(dir, "brokers.txt")
+	contents := ""
+	for _, addr := range addresses {
+		contents += addr + "\n"
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func Test_bootstrapConnection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brokers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	version := sarama.V1_0_0_0
+	kafkaArgs := &args.KafkaArguments{DefaultJMXPort: 9999, KafkaVersion: &version}
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(1).
+			SetBroker(broker.Addr(), 1).
+			SetLeader("orders", 0, 1).
+			SetLeader("orders", 1, 1),
+		"DescribeConfigsRequest": sarama.NewMockDescribeConfigsResponse(t),
+	})
+
+	kafkaArgs.BootstrapBrokersFile = writeBrokersFile(t, dir, broker.Addr())
+	conn := newBootstrapConnection(kafkaArgs)
+	defer conn.Close()
+
+	brokerIDs, err := GetBrokerIDs(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !reflect.DeepEqual(brokerIDs, []string{"1"}) {
+		t.Errorf("Expected broker IDs [1] got %v", brokerIDs)
+	}
+
+	brokerConnections, err := GetBrokerConnections(1, conn)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	expectedConnections := []BrokerConnection{{Scheme: "http", BrokerHost: "127.0.0.1", JmxPort: 9999, BrokerPort: int(broker.Port())}}
+	if !reflect.DeepEqual(brokerConnections, expectedConnections) {
+		t.Errorf("Expected %v got %v", expectedConnections, brokerConnections)
+	}
+
+	topics, _, err := conn.Children("/brokers/topics")
+	if err != nil || !reflect.DeepEqual(topics, []string{"orders"}) {
+		t.Errorf("Expected topics [orders] got %v, %v", topics, err)
+	}
+
+	expectedNodes := map[string]string{
+		"/brokers/topics/orders":                    `{"partitions":{"0":[1],"1":[1]}}`,
+		"/brokers/topics/orders/partitions/1/state": `{"isr":[1],"leader":1}`,
+		"/config/topics/orders":                     `{"config":{"retention.ms":"5000"}}`,
+	}
+	for path, expected := range expectedNodes {
+		data, _, err := conn.Get(path)
+		if err != nil {
+			t.Errorf("Unexpected error reading %s: %s", path, err.Error())
+		} else if string(data) != expected {
+			t.Errorf("Expected %s for %s got %s", expected, path, string(data))
+		}
+	}
+
+	for _, path := range []string{"/brokers/ids/2", "/admin/reassign_partitions"} {
+		if _, _, err := conn.Get(path); err != zk.ErrNoNode {
+			t.Errorf("Expected %s not to exist, got %v", path, err)
+		}
+	}
+}
+
+func Test_NewConnection_BootstrapFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brokers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kafkaArgs := &args.KafkaArguments{BootstrapBrokersFile: writeBrokersFile(t, dir, "broker-1:9092")}
+
+	conn, err := NewConnection(kafkaArgs)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if _, ok := conn.(*bootstrapConnection); !ok {
+		t.Errorf("Expected a bootstrap connection without Zookeeper hosts, got %T", conn)
+	}
+
+	kafkaArgs.RequireZookeeper = true
+	if _, err := NewConnection(kafkaArgs); err == nil {
+		t.Error("Expected error without Zookeeper hosts when require_zookeeper is set")
+	}
+
+	kafkaArgs = &args.KafkaArguments{BootstrapBrokers: []string{"broker-2:9092"}}
+	conn, err = NewConnection(kafkaArgs)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if !IsBootstrapConnection(conn) {
+		t.Errorf("Expected a bootstrap connection with bootstrap_brokers, got %T", conn)
+	}
+
+	kafkaArgs = &args.KafkaArguments{}
+	if _, err := NewConnection(kafkaArgs); err == nil {
+		t.Error("Expected error without Zookeeper hosts or bootstrap_brokers_file")
+	}
+}
+
+func Test_bootstrapConnection_addresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brokers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	brokersFile := writeBrokersFile(t, dir, "broker-2:9092", "broker-3:9092")
+	addresses, err := newBootstrapConnection(&args.KafkaArguments{BootstrapBrokers: []string{"broker-1:9092", "broker-2:9092"}, BootstrapBrokersFile: brokersFile}).addresses()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if expected := []string{"broker-1:9092", "broker-2:9092", "broker-3:9092"}; !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Expected %v got %v", expected, addresses)
+	}
+
+	missingFile := filepath.Join(dir, "missing.txt")
+	addresses, err = newBootstrapConnection(&args.KafkaArguments{BootstrapBrokers: []string{"broker-1:9092"}, BootstrapBrokersFile: missingFile}).addresses()
+	if err != nil || !reflect.DeepEqual(addresses, []string{"broker-1:9092"}) {
+		t.Errorf("Expected bootstrap_brokers when the file can't be read, got %v, %v", addresses, err)
+	}
+
+	if _, err := newBootstrapConnection(&args.KafkaArguments{BootstrapBrokersFile: missingFile}).addresses(); err == nil {
+		t.Error("Expected error when the file can't be read without bootstrap_brokers")
+	}
+}
@@ -0,0 +1,80 @@
+package zookeeper
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/log"
+)
+
+// plaintextScheme is the connection scheme of the brokers read from bootstrap_brokers_file
+const plaintextScheme = "http"
+
+// readBrokersFile reads the host:port broker addresses in the file at path, one per line. Empty lines and
+// lines starting with # are ignored. Malformed lines are logged and skipped. The file is read on every call
+// so brokers replaced while the integration runs are picked up on the next collection.
+func readBrokersFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Debug("Error closing brokers file: %s", err.Error())
+		}
+	}()
+
+	var brokers []string
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := validateBrokerAddress(line); err != nil {
+			log.Warn("Skipping line %d of bootstrap_brokers_file %s: %s", lineNumber, path, err)
+			continue
+		}
+		brokers = append(brokers, line)
+	}
+
+	return brokers, scanner.Err()
+}
+
+// validateBrokerAddress checks that addr is of the form host:port
+func validateBrokerAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("'%s' is not of the form host:port", addr)
+	}
+	if host == "" {
+		return fmt.Errorf("'%s' has no host", addr)
+	}
+	if portNumber, err := strconv.Atoi(port); err != nil || portNumber < 1 || portNumber > 65535 {
+		return fmt.Errorf("'%s' has an invalid port", addr)
+	}
+
+	return nil
+}
+
+// mergeAddresses appends the addresses in extra that aren't already in addresses
+func mergeAddresses(addresses, extra []string) []string {
+	seen := make(map[string]bool, len(addresses))
+	for _, addr := range addresses {
+		seen[addr] = true
+	}
+
+	for _, addr := range extra {
+		if !seen[addr] {
+			seen[addr] = true
+			addresses = append(addresses, addr)
+		}
+	}
+
+	return addresses
+}
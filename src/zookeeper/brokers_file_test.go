@@ -0,0 +1,50 @@
+package zookeeper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_readBrokersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brokers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "brokers.txt")
+	contents := "# bootstrap brokers\nbroker-1:9092\n\n  broker-2:9092  \nbroker-3\n:9092\nbroker-4:port\nbroker-5:70000\n[::1]:9092\n[2001:db8::1]:9092\n2001:db8::2:9092\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	brokers, err := readBrokersFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	expected := []string{"broker-1:9092", "broker-2:9092", "[::1]:9092", "[2001:db8::1]:9092"}
+	if !reflect.DeepEqual(brokers, expected) {
+		t.Errorf("Expected %v got %v", expected, brokers)
+	}
+
+	if _, err := readBrokersFile(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func Test_mergeAddresses(t *testing.T) {
+	merged := mergeAddresses([]string{"broker-1:9092", "broker-2:9092"}, []string{"broker-2:9092", "broker-3:9092", "broker-3:9092"})
+
+	expected := []string{"broker-1:9092", "broker-2:9092", "broker-3:9092"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %v got %v", expected, merged)
+	}
+
+	if merged := mergeAddresses(nil, []string{"broker-1:9092"}); !reflect.DeepEqual(merged, []string{"broker-1:9092"}) {
+		t.Errorf("Expected only the file brokers, got %v", merged)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"strconv"
 	"strings"
@@ -24,6 +25,8 @@ type Connection interface {
 	Children(string) ([]string, *zk.Stat, error)
 	CreateClient() (connection.Client, error)
 	CreateClusterAdmin() (sarama.ClusterAdmin, error)
+	CreateConsumer() (sarama.Consumer, error)
+	Close()
 }
 
 // BrokerConnection struct to allow for multiple connection setups.
@@ -34,49 +37,200 @@ type BrokerConnection struct {
 	BrokerPort int
 }
 
+// Addr returns the host:port address of the broker, with IPv6 hosts in brackets
+func (b BrokerConnection) Addr() string {
+	return net.JoinHostPort(b.BrokerHost, strconv.Itoa(b.BrokerPort))
+}
+
+// observerSessionTimeout is how long NewConnection waits for a session with the zookeeper_observer_hosts
+// before only reading from zookeeper_hosts
+const observerSessionTimeout = 5 * time.Second
+
+// zkReader is the part of *zk.Conn the integration uses, which only reads from Zookeeper
+type zkReader interface {
+	Get(string) ([]byte, *zk.Stat, error)
+	Children(string) ([]string, *zk.Stat, error)
+	Close()
+}
+
+// noServerError is the error of a read that couldn't reach any of the Zookeeper hosts at addresses
+type noServerError struct {
+	addresses []string
+}
+
+func (e noServerError) Error() string {
+	return fmt.Sprintf("%s, tried %s", zk.ErrNoServer.Error(), strings.Join(e.addresses, ", "))
+}
+
+// hostsReader reads from a session with any of the Zookeeper hosts at addresses, which go-zookeeper tries in
+// turn until one of them can be reached. Reads failing after every host was tried name them in their error.
+type hostsReader struct {
+	zkReader
+	addresses []string
+}
+
+func (r hostsReader) Children(s string) ([]string, *zk.Stat, error) {
+	children, stat, err := r.zkReader.Children(s)
+	return children, stat, r.namingHosts(err)
+}
+
+func (r hostsReader) Get(s string) ([]byte, *zk.Stat, error) {
+	data, stat, err := r.zkReader.Get(s)
+	return data, stat, r.namingHosts(err)
+}
+
+func (r hostsReader) namingHosts(err error) error {
+	if err == zk.ErrNoServer {
+		return noServerError{addresses: r.addresses}
+	}
+
+	return err
+}
+
+// zookeeperConnection reads from the observers when zookeeper_observer_hosts is set, falling back to the
+// ensemble of zookeeper_hosts if the observers can't be reached. observers is nil otherwise. The paths read
+// are relative to the zookeeper_path of the cluster, chroot.
 type zookeeperConnection struct {
-	inner *zk.Conn
+	inner     zkReader
+	observers zkReader
+	chroot    string
+	kafkaArgs *args.KafkaArguments
 }
 
 func (z zookeeperConnection) Children(s string) ([]string, *zk.Stat, error) {
+	s = z.chroot + s
+	if z.observers != nil {
+		children, stat, err := z.observers.Children(s)
+		if !isConnectionError(err) {
+			return children, stat, err
+		}
+		log.Debug("Unable to read %s from the Zookeeper observers, reading from zookeeper_hosts: %s", s, err.Error())
+	}
+
 	return z.inner.Children(s)
 }
 
 func (z zookeeperConnection) Get(s string) ([]byte, *zk.Stat, error) {
+	s = z.chroot + s
+	if z.observers != nil {
+		data, stat, err := z.observers.Get(s)
+		if !isConnectionError(err) {
+			return data, stat, err
+		}
+		log.Debug("Unable to read %s from the Zookeeper observers, reading from zookeeper_hosts: %s", s, err.Error())
+	}
+
 	return z.inner.Get(s)
 }
 
+func (z zookeeperConnection) Close() {
+	if z.observers != nil {
+		z.observers.Close()
+	}
+	z.inner.Close()
+}
+
+// isConnectionError returns true if err means the Zookeeper servers couldn't be reached, rather than the
+// request failing on a server
+func isConnectionError(err error) bool {
+	if _, ok := err.(noServerError); ok {
+		return true
+	}
+
+	switch err {
+	case zk.ErrNoServer, zk.ErrConnectionClosed, zk.ErrSessionExpired, zk.ErrClosing:
+		return true
+	}
+
+	return false
+}
+
+// isRetryableConnectError returns true if err means Zookeeper or the brokers couldn't be reached. Authentication
+// failures, such as sarama.ErrSASLAuthenticationFailed, aren't.
+func isRetryableConnectError(err error) bool {
+	if isConnectionError(err) || err == sarama.ErrOutOfBrokers {
+		return true
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// connectWithRetries calls connect until it succeeds, retrying connection errors up to zookeeper_connect_retries
+// times. The first retry waits zookeeper_connect_backoff_ms, doubling after each retry, so a Zookeeper or broker
+// that is briefly unavailable during a rolling restart doesn't fail the collection.
+func connectWithRetries(kafkaArgs *args.KafkaArguments, name string, connect func() error) error {
+	backoff := time.Duration(kafkaArgs.ZookeeperConnectBackoffMs) * time.Millisecond
+	for retry := 0; ; retry++ {
+		err := connect()
+		if err == nil || retry >= kafkaArgs.ZookeeperConnectRetries || !isRetryableConnectError(err) {
+			return err
+		}
+
+		log.Warn("Failed to create the %s, retrying in %s: %s", name, backoff, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 func (z zookeeperConnection) CreateClient() (connection.Client, error) {
-	brokerIDs, _, err := z.Children(Path("/brokers/ids"))
+	var client sarama.Client
+	err := connectWithRetries(z.kafkaArgs, "Kafka client", func() error {
+		connections, err := z.brokerAddresses()
+		if err != nil {
+			return err
+		}
+
+		for scheme, connection := range connections {
+			client, err = sarama.NewClient(connection, createConfig(z.kafkaArgs, scheme == "https"))
+			if err != nil {
+				continue
+			} else { // make sure that we break when we have a working connection.
+				break
+			}
+		}
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	return connection.SaramaClient{client}, nil
+}
 
-	connections := make(map[string][]string, 0)
-	for _, brokerID := range brokerIDs {
-		// convert to int id
-		intID, err := strconv.Atoi(brokerID)
+func (z zookeeperConnection) CreateClusterAdmin() (sarama.ClusterAdmin, error) {
+	var client sarama.ClusterAdmin
+	err := connectWithRetries(z.kafkaArgs, "Kafka cluster admin", func() error {
+		connections, err := z.brokerAddresses()
 		if err != nil {
-			log.Warn("Unable to parse integer broker ID from %s", brokerID)
-			continue
+			return err
 		}
 
-		// get broker connection info
-		brokerConnections, err := GetBrokerConnections(intID, z)
-		if err != nil {
-			log.Warn("Unable to get connection information for broker with ID '%d'. Will not collect offset data for consumer groups on this broker: %s", intID, err)
-			continue
+		for scheme, connection := range connections {
+			client, err = sarama.NewClusterAdmin(connection, createConfig(z.kafkaArgs, scheme == "https"))
+			if err != nil {
+				continue
+			} else { // make sure that we break when we have a working connection.
+				break
+			}
 		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		for _, brokerConnection := range brokerConnections {
-			connections[brokerConnection.Scheme] = append(connections[brokerConnection.Scheme],
-				fmt.Sprintf("%s:%d", brokerConnection.BrokerHost, brokerConnection.BrokerPort))
-		}
+	return client, nil
+}
+
+func (z zookeeperConnection) CreateConsumer() (sarama.Consumer, error) {
+	connections, err := z.brokerAddresses()
+	if err != nil {
+		return nil, err
 	}
 
-	var client sarama.Client
+	var consumer sarama.Consumer
 	for scheme, connection := range connections {
-		client, err = sarama.NewClient(connection, createConfig(scheme == "https"))
+		consumer, err = sarama.NewConsumer(connection, createConfig(z.kafkaArgs, scheme == "https"))
 		if err != nil {
 			continue
 		} else { // make sure that we break when we have a working connection.
@@ -86,11 +240,43 @@ func (z zookeeperConnection) CreateClient() (connection.Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return connection.SaramaClient{client}, nil
+
+	return consumer, nil
 }
 
-func (z zookeeperConnection) CreateClusterAdmin() (sarama.ClusterAdmin, error) {
-	brokerIDs, _, err := z.Children(Path("/brokers/ids"))
+// brokerAddresses collects the host:port address of every broker registered in Zookeeper and in the
+// bootstrap_brokers_file, grouped by connection scheme. The brokers in the file are still used if the
+// brokers can't be read from Zookeeper.
+func (z zookeeperConnection) brokerAddresses() (map[string][]string, error) {
+	connections, err := z.registeredBrokerAddresses()
+	if z.kafkaArgs.BootstrapBrokersFile == "" {
+		return connections, err
+	}
+
+	fileBrokers, fileErr := readBrokersFile(z.kafkaArgs.BootstrapBrokersFile)
+	if fileErr != nil {
+		log.Warn("Unable to read bootstrap_brokers_file %s: %s", z.kafkaArgs.BootstrapBrokersFile, fileErr)
+	}
+
+	if err != nil {
+		if len(fileBrokers) == 0 {
+			return nil, err
+		}
+		log.Warn("Unable to get brokers from Zookeeper, only using the brokers in bootstrap_brokers_file: %s", err)
+		connections = make(map[string][]string)
+	}
+
+	if len(fileBrokers) > 0 {
+		connections[plaintextScheme] = mergeAddresses(connections[plaintextScheme], fileBrokers)
+	}
+
+	return connections, nil
+}
+
+// registeredBrokerAddresses collects the host:port address of every broker registered in Zookeeper,
+// grouped by connection scheme
+func (z zookeeperConnection) registeredBrokerAddresses() (map[string][]string, error) {
+	brokerIDs, _, err := z.Children("/brokers/ids")
 	if err != nil {
 		return nil, err
 	}
@@ -112,61 +298,81 @@ func (z zookeeperConnection) CreateClusterAdmin() (sarama.ClusterAdmin, error) {
 		}
 
 		for _, brokerConnection := range brokerConnections {
-			connections[brokerConnection.Scheme] = append(connections[brokerConnection.Scheme],
-				fmt.Sprintf("%s:%d", brokerConnection.BrokerHost, brokerConnection.BrokerPort))
-		}
-	}
-
-	var client sarama.ClusterAdmin
-	for scheme, connection := range connections {
-		client, err = sarama.NewClusterAdmin(connection, createConfig(scheme == "https"))
-		if err != nil {
-			continue
-		} else { // make sure that we break when we have a working connection.
-			break
+			connections[brokerConnection.Scheme] = append(connections[brokerConnection.Scheme], brokerConnection.Addr())
 		}
 	}
-	if err != nil {
-		return nil, err
-	}
 
-	return client, nil
+	return connections, nil
 }
 
-func createConfig(isTLS bool) *sarama.Config {
-	config := sarama.NewConfig()
+func createConfig(kafkaArgs *args.KafkaArguments, isTLS bool) *sarama.Config {
+	config := connection.NewConfig(kafkaArgs.StateKeyPrefix())
 	if isTLS {
 		config.Net.TLS.Enable = true
 		config.Net.TLS.Config = &tls.Config{
 			InsecureSkipVerify: true,
+			// Empty unless tls_server_name is set, in which case it's sent with SNI instead of the broker host
+			ServerName: kafkaArgs.TLSServerName,
 		}
 	}
 
-	config.Version = sarama.V2_0_0_0
+	if kafkaArgs.ProxyURL != nil {
+		setProxy(config, kafkaArgs.ProxyURL)
+	}
+
+	config.Version = kafkaVersion(kafkaArgs)
+	if kafkaArgs.MetadataRefreshIntervalMs > 0 {
+		config.Metadata.RefreshFrequency = time.Duration(kafkaArgs.MetadataRefreshIntervalMs) * time.Millisecond
+	}
 
 	return config
 }
 
-// NewConnection creates a new Connection with the given arguments.
+// NewConnection creates a new Connection with the given arguments, which it keeps using, so the Kafka version
+// detected afterwards is used by the clients it creates.
+// Without Zookeeper hosts, as with KRaft clusters, the Connection reads from the brokers in bootstrap_brokers
+// and bootstrap_brokers_file. It also falls back to those if Zookeeper can't be reached. If require_zookeeper
+// is set or there are no bootstrap brokers, a nil Connection and error will be returned instead.
+func NewConnection(kafkaArgs *args.KafkaArguments) (Connection, error) {
+	hasBootstrapBrokers := len(kafkaArgs.BootstrapBrokers) > 0 || kafkaArgs.BootstrapBrokersFile != ""
+	if len(kafkaArgs.ZookeeperHosts) == 0 && hasBootstrapBrokers && !kafkaArgs.RequireZookeeper {
+		log.Debug("No Zookeeper hosts, reading the brokers, topics and configs from the bootstrap brokers")
+		return newBootstrapConnection(kafkaArgs), nil
+	}
+
+	conn, err := newZookeeperConnection(kafkaArgs)
+	if kafkaArgs.RequireZookeeper || !hasBootstrapBrokers {
+		return conn, err
+	}
+
+	if err == nil {
+		// zk.Connect doesn't wait for a session, so a Zookeeper that can't be reached only fails the first read
+		_, _, err = conn.Children("/brokers/ids")
+		if !isConnectionError(err) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+
+	log.Warn("Unable to use Zookeeper, reading the brokers, topics and configs from the brokers in bootstrap_brokers_file instead. Set require_zookeeper to fail instead: %s", err.Error())
+	return newBootstrapConnection(kafkaArgs), nil
+}
+
+// newZookeeperConnection creates a new Connection to Zookeeper with the given arguments.
 // If not hosts are specified then a nil Connection and error will be returned
 //
 // Waiting on issue https://github.com/samuel/go-zookeeper/issues/108 so we can change this function
 // and allow us to mock out the zk.Connect function
-func NewConnection(kafkaArgs *args.KafkaArguments) (Connection, error) {
+func newZookeeperConnection(kafkaArgs *args.KafkaArguments) (Connection, error) {
 	// No Zookeeper hosts so can't make a connection
 	if len(kafkaArgs.ZookeeperHosts) == 0 {
 		return nil, errors.New("no Zookeeper hosts specified")
 	}
 
-	// Create array of host:port strings for connecting
-	zkHosts := make([]string, 0, len(kafkaArgs.ZookeeperHosts))
-	for _, zkHost := range kafkaArgs.ZookeeperHosts {
-		zkHosts = append(zkHosts, fmt.Sprintf("%s:%d", zkHost.Host, zkHost.Port))
-	}
-
-	// Create array of host:port strings for connecting
-	// Create connection and add authentication if provided
-	zkConn, _, err := zk.Connect(zkHosts, time.Second)
+	// Create connection and add authentication if provided. Every host is passed, so if one can't be reached the
+	// session is established with the next.
+	addresses := zookeeperAddresses(kafkaArgs.ZookeeperHosts)
+	zkConn, _, err := zk.Connect(addresses, time.Second, zk.WithDialer(zookeeperDialer(kafkaArgs.ProxyURL)))
 	if err != nil {
 		log.Error("Failed to connect to Zookeeper: %s", err.Error())
 		return nil, err
@@ -179,14 +385,74 @@ func NewConnection(kafkaArgs *args.KafkaArguments) (Connection, error) {
 		}
 	}
 
-	return zookeeperConnection{zkConn}, nil
+	conn := zookeeperConnection{inner: hostsReader{zkReader: zkConn, addresses: addresses}, chroot: kafkaArgs.ZookeeperPath, kafkaArgs: kafkaArgs}
+	if len(kafkaArgs.ZookeeperObserverHosts) > 0 {
+		if observers := connectObservers(kafkaArgs); observers != nil {
+			conn.observers = hostsReader{zkReader: observers, addresses: zookeeperAddresses(kafkaArgs.ZookeeperObserverHosts)}
+		}
+	}
+
+	return conn, nil
+}
+
+// connectObservers connects to the zookeeper_observer_hosts, returning nil if no session can be
+// established with any of them within observerSessionTimeout
+func connectObservers(kafkaArgs *args.KafkaArguments) *zk.Conn {
+	observerConn, events, err := zk.Connect(zookeeperAddresses(kafkaArgs.ZookeeperObserverHosts), time.Second, zk.WithDialer(zookeeperDialer(kafkaArgs.ProxyURL)))
+	if err != nil {
+		log.Warn("Failed to connect to the Zookeeper observers, reading from zookeeper_hosts: %s", err.Error())
+		return nil
+	}
+
+	if !waitForSession(events, observerSessionTimeout) {
+		log.Warn("Unable to reach the Zookeeper observers within %s, reading from zookeeper_hosts", observerSessionTimeout)
+		observerConn.Close()
+		return nil
+	}
+
+	if kafkaArgs.ZookeeperAuthScheme != "" {
+		if err = observerConn.AddAuth(kafkaArgs.ZookeeperAuthScheme, []byte(kafkaArgs.ZookeeperAuthSecret)); err != nil {
+			log.Warn("Failed to Authenticate to the Zookeeper observers, reading from zookeeper_hosts: %s", err.Error())
+			observerConn.Close()
+			return nil
+		}
+	}
+
+	return observerConn
+}
+
+// waitForSession waits until events reports a Zookeeper session, returning false if there is none after timeout
+func waitForSession(events <-chan zk.Event, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if event.State == zk.StateHasSession {
+				return true
+			}
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// zookeeperAddresses creates the host:port strings of hosts for connecting
+func zookeeperAddresses(hosts []*args.ZookeeperHost) []string {
+	addresses := make([]string, 0, len(hosts))
+	for _, zkHost := range hosts {
+		addresses = append(addresses, net.JoinHostPort(zkHost.Host, strconv.Itoa(zkHost.Port)))
+	}
+
+	return addresses
 }
 
 // GetBrokerIDs retrieves the broker ids from Zookeeper
 func GetBrokerIDs(zkConn Connection) ([]string, error) {
-	brokerIDs, _, err := zkConn.Children(Path("/brokers/ids"))
+	brokerIDs, _, err := zkConn.Children("/brokers/ids")
 	if err != nil {
-		log.Info(Path("/brokers/ids"))
 		return nil, fmt.Errorf("unable to get broker ID from Zookeeper: %s", err.Error())
 	}
 
@@ -231,7 +497,7 @@ func getURLStringAndSchemeFromEndpoint(urlString string, protocolMap map[string]
 func GetBrokerConnections(brokerID int, zkConn Connection) (brokerConnections []BrokerConnection, err error) {
 
 	// Query Zookeeper for broker information
-	path := Path("/brokers/ids/" + strconv.Itoa(brokerID))
+	path := "/brokers/ids/" + strconv.Itoa(brokerID)
 	rawBrokerJSON, _, err := zkConn.Get(path)
 	if err != nil {
 		return
@@ -1,18 +1,25 @@
 package zookeeper
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
 	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/nri-kafka/src/args"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
 func Test_GetBrokerConnectionInfo_WithHost(t *testing.T) {
-	testutils.SetupTestArgs()
-
 	brokerID := 0
-	zkConn := MockConnection{}
+	zkConn := &MockConnection{}
 	zkConn.On("Get", "/brokers/ids/0").Return([]byte(`{"listener_security_protocol_map":{"SASL_SSL":"SASL_SSL","SSL":"SSL", "PLAINTEXT":"PLAINTEXT"},"endpoints":["SASL_SSL://my-broker.host:9193","SSL://my-broker.host:9093","PLAINTEXT://my-broker.host:9092"],"rack":"us-east-1d","jmx_port":9999,"host":null,"timestamp":"1542127633364","port":-1,"version":4}`), new(zk.Stat), nil)
 
 	expectedValues := []BrokerConnection{
@@ -20,7 +27,7 @@ func Test_GetBrokerConnectionInfo_WithHost(t *testing.T) {
 		{Scheme: "http", BrokerHost: "my-broker.host", JmxPort: 9999, BrokerPort: 9092},
 	}
 
-	brokerConnections, err := GetBrokerConnections(brokerID, &zkConn)
+	brokerConnections, err := GetBrokerConnections(brokerID, zkConn)
 	if err != nil {
 		t.Fatalf("Unexpected error %s", err.Error())
 	}
@@ -46,17 +53,15 @@ func Test_GetBrokerConnectionInfo_WithHost(t *testing.T) {
 }
 
 func Test_GetBrokerConnectionInfo_WithProtocolMap(t *testing.T) {
-	testutils.SetupTestArgs()
-
 	brokerID := 0
-	zkConn := MockConnection{}
+	zkConn := &MockConnection{}
 	zkConn.On("Get", "/brokers/ids/0").Return([]byte(`{"listener_security_protocol_map":{"EXTERNAL":"SASL_SSL","INTERNAL":"PLAINTEXT"},"endpoints":["EXTERNAL://my-broker.host:9193", "INTERNAL://my-broker.host:9093"],"rack":"us-east-1d","jmx_port":9999,"host":null,"timestamp":"1542127633364","port":-1,"version":4}`), new(zk.Stat), nil)
 
 	expectedValues := []BrokerConnection{
 		{Scheme: "http", BrokerHost: "my-broker.host", JmxPort: 9999, BrokerPort: 9093},
 	}
 
-	brokerConnections, err := GetBrokerConnections(brokerID, &zkConn)
+	brokerConnections, err := GetBrokerConnections(brokerID, zkConn)
 
 	if err != nil {
 		t.Fatalf("Unexpected error %s", err.Error())
@@ -83,8 +88,6 @@ func Test_GetBrokerConnectionInfo_WithProtocolMap(t *testing.T) {
 }
 
 func Test_getUrlStringAndSchemeFromEndpoints_WithVanilla(t *testing.T) {
-	testutils.SetupTestArgs()
-
 	endpoints := []string{"SASL_SSL://my-broker.host:9193", "SSL://my-broker.host:9093", "PLAINTEXT://my-broker.host:9092"}
 	protocolMap := map[string]string{
 		"SASL_SSL":  "SASL_SSL",
@@ -118,8 +121,6 @@ func Test_getUrlStringAndSchemeFromEndpoints_WithVanilla(t *testing.T) {
 }
 
 func Test_getUrlStringAndSchemeFromEndpoints_WithProtocolMap(t *testing.T) {
-	testutils.SetupTestArgs()
-
 	endpoints := []string{"EXTERNAL://my-broker.host:9193", "INTERNAL://my-broker.host:9093"}
 	protocolMap := map[string]string{
 		"EXTERNAL": "SASL_SSL",
@@ -147,3 +148,334 @@ func Test_getUrlStringAndSchemeFromEndpoints_WithProtocolMap(t *testing.T) {
 	}
 
 }
+
+func Test_zookeeperConnection_ReadsFromObservers(t *testing.T) {
+	observers := &MockConnection{}
+	observers.On("Get", "/brokers/ids/0").Return([]byte("observer"), new(zk.Stat), nil)
+	observers.On("Children", "/brokers/ids").Return([]string{}, new(zk.Stat), zk.ErrNoNode)
+	conn := zookeeperConnection{inner: &MockConnection{}, observers: observers}
+
+	data, _, err := conn.Get("/brokers/ids/0")
+	if err != nil || string(data) != "observer" {
+		t.Errorf("Expected the observer data, got '%s' %v", data, err)
+	}
+
+	// Errors of a reachable observer aren't retried on the ensemble
+	if _, _, err := conn.Children("/brokers/ids"); err != zk.ErrNoNode {
+		t.Errorf("Expected %v got %v", zk.ErrNoNode, err)
+	}
+}
+
+func Test_zookeeperConnection_FallsBackToEnsemble(t *testing.T) {
+	observers := &MockConnection{}
+	observers.On("Get", "/brokers/ids/0").Return([]byte{}, new(zk.Stat), zk.ErrNoServer)
+	observers.On("Children", "/brokers/ids").Return([]string{}, new(zk.Stat), zk.ErrConnectionClosed)
+	ensemble := &MockConnection{}
+	ensemble.On("Get", "/brokers/ids/0").Return([]byte("ensemble"), new(zk.Stat), nil)
+	ensemble.On("Children", "/brokers/ids").Return([]string{"0"}, new(zk.Stat), nil)
+	conn := zookeeperConnection{inner: ensemble, observers: observers}
+
+	data, _, err := conn.Get("/brokers/ids/0")
+	if err != nil || string(data) != "ensemble" {
+		t.Errorf("Expected the ensemble data, got '%s' %v", data, err)
+	}
+
+	children, _, err := conn.Children("/brokers/ids")
+	if err != nil || len(children) != 1 {
+		t.Errorf("Expected the ensemble children, got %v %v", children, err)
+	}
+}
+
+func Test_zookeeperConnection_Chroot(t *testing.T) {
+	testCases := []struct {
+		chroot string
+		output string
+	}{
+		{"", "/brokers/ids"},
+		{"/root", "/root/brokers/ids"},
+		{"/root/root2", "/root/root2/brokers/ids"},
+	}
+
+	for _, tc := range testCases {
+		ensemble := &MockConnection{}
+		ensemble.On("Get", tc.output+"/0").Return([]byte("broker"), new(zk.Stat), nil)
+		ensemble.On("Children", tc.output).Return([]string{"0"}, new(zk.Stat), nil)
+		conn := zookeeperConnection{inner: ensemble, chroot: tc.chroot}
+
+		if data, _, err := conn.Get("/brokers/ids/0"); err != nil || string(data) != "broker" {
+			t.Errorf("Expected %s/0 to be read, got '%s' %v", tc.output, data, err)
+		}
+		if children, _, err := conn.Children("/brokers/ids"); err != nil || len(children) != 1 {
+			t.Errorf("Expected the children of %s, got %v %v", tc.output, children, err)
+		}
+	}
+}
+
+func Test_waitForSession(t *testing.T) {
+	events := make(chan zk.Event, 2)
+	events <- zk.Event{State: zk.StateConnecting}
+	events <- zk.Event{State: zk.StateHasSession}
+	if !waitForSession(events, time.Second) {
+		t.Error("Expected a session")
+	}
+
+	if waitForSession(make(chan zk.Event), 10*time.Millisecond) {
+		t.Error("Expected no session before the timeout")
+	}
+}
+
+func Test_GetBrokerConnectionInfo_IPv6(t *testing.T) {
+	zkConn := &MockConnection{}
+	zkConn.On("Get", "/brokers/ids/0").Return([]byte(`{"listener_security_protocol_map":{"PLAINTEXT":"PLAINTEXT"},"endpoints":["PLAINTEXT://[2001:db8::1]:9092"],"jmx_port":9999,"host":null,"port":-1,"version":4}`), new(zk.Stat), nil)
+
+	brokerConnections, err := GetBrokerConnections(0, zkConn)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	if len(brokerConnections) != 1 {
+		t.Fatalf("Expected 1 connection got %d", len(brokerConnections))
+	}
+	if host := brokerConnections[0].BrokerHost; host != "2001:db8::1" {
+		t.Errorf("Expected host 2001:db8::1 got %s", host)
+	}
+	if addr := brokerConnections[0].Addr(); addr != "[2001:db8::1]:9092" {
+		t.Errorf("Expected address [2001:db8::1]:9092 got %s", addr)
+	}
+}
+
+func Test_zookeeperAddresses(t *testing.T) {
+	addresses := zookeeperAddresses([]*args.ZookeeperHost{{Host: "zookeeper", Port: 2181}, {Host: "2001:db8::1", Port: 2181}})
+
+	expected := []string{"zookeeper:2181", "[2001:db8::1]:2181"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("Expected %v got %v", expected, addresses)
+	}
+}
+
+func Test_zookeeperConnection_CreateClusterAdmin_Retries(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ZookeeperConnectRetries: 2, ZookeeperConnectBackoffMs: 1}
+
+	// Zookeeper is unreachable on the first attempt only. With no registered brokers no connection is made.
+	ensemble := &MockConnection{}
+	ensemble.On("Children", "/brokers/ids").Return([]string{}, new(zk.Stat), zk.ErrNoServer).Once()
+	ensemble.On("Children", "/brokers/ids").Return([]string{}, new(zk.Stat), nil)
+	conn := zookeeperConnection{inner: ensemble, kafkaArgs: kafkaArgs}
+
+	if _, err := conn.CreateClusterAdmin(); err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+}
+
+func Test_connectWithRetries(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ZookeeperConnectRetries: 2, ZookeeperConnectBackoffMs: 1}
+
+	testCases := []struct {
+		name          string
+		err           error
+		expectedCalls int
+	}{
+		{"connection errors are retried", sarama.ErrOutOfBrokers, 3},
+		{"auth failures aren't retried", sarama.ErrSASLAuthenticationFailed, 1},
+		{"Zookeeper auth failures aren't retried", zk.ErrNoAuth, 1},
+	}
+
+	for _, tc := range testCases {
+		calls := 0
+		err := connectWithRetries(kafkaArgs, "test", func() error {
+			calls++
+			return tc.err
+		})
+
+		if err != tc.err {
+			t.Errorf("%s: expected %v got %v", tc.name, tc.err, err)
+		}
+		if calls != tc.expectedCalls {
+			t.Errorf("%s: expected %d attempts got %d", tc.name, tc.expectedCalls, calls)
+		}
+	}
+}
+
+func Test_createConfig_MetadataRefreshInterval(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{}
+	if frequency := createConfig(kafkaArgs, false).Metadata.RefreshFrequency; frequency != 10*time.Minute {
+		t.Errorf("Expected the default refresh frequency got %s", frequency)
+	}
+
+	kafkaArgs.MetadataRefreshIntervalMs = 30000
+	if frequency := createConfig(kafkaArgs, false).Metadata.RefreshFrequency; frequency != 30*time.Second {
+		t.Errorf("Expected a refresh frequency of 30s got %s", frequency)
+	}
+}
+
+func Test_createConfig_TLSServerName(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{TLSServerName: "kafka.example.com"}
+
+	config := createConfig(kafkaArgs, true)
+	if !config.Net.TLS.Enable || config.Net.TLS.Config == nil {
+		t.Fatal("Expected TLS to be enabled")
+	}
+	if serverName := config.Net.TLS.Config.ServerName; serverName != "kafka.example.com" {
+		t.Errorf("Expected server name kafka.example.com got '%s'", serverName)
+	}
+
+	// Without tls_server_name the broker host is sent
+	kafkaArgs.TLSServerName = ""
+	if serverName := createConfig(kafkaArgs, true).Net.TLS.Config.ServerName; serverName != "" {
+		t.Errorf("Expected no server name got '%s'", serverName)
+	}
+
+	if createConfig(kafkaArgs, false).Net.TLS.Enable {
+		t.Error("Expected TLS to be disabled for plaintext brokers")
+	}
+}
+
+func Test_GetBrokerConnectionInfo_IPv6TLS(t *testing.T) {
+	zkConn := &MockConnection{}
+	zkConn.On("Get", "/brokers/ids/0").Return([]byte(`{"listener_security_protocol_map":{"SSL":"SSL"},"endpoints":["SSL://[::1]:9093"],"jmx_port":9999,"host":null,"port":-1,"version":4}`), new(zk.Stat), nil)
+
+	brokerConnections, err := GetBrokerConnections(0, zkConn)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	expected := []BrokerConnection{{Scheme: "https", BrokerHost: "::1", JmxPort: 9999, BrokerPort: 9093}}
+	if !reflect.DeepEqual(brokerConnections, expected) {
+		t.Errorf("Expected %v got %v", expected, brokerConnections)
+	}
+}
+
+// serveZookeeper answers the session and getChildren2 requests of the Zookeeper clients connecting to a local
+// port with children, returning its address
+func serveZookeeper(t *testing.T, children []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveZookeeperSession(conn, children)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveZookeeperSession(conn net.Conn, children []string) {
+	defer conn.Close()
+
+	readPacket := func() ([]byte, error) {
+		var length int32
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		packet := make([]byte, length)
+		_, err := io.ReadFull(conn, packet)
+		return packet, err
+	}
+	writePacket := func(fields ...interface{}) {
+		var packet bytes.Buffer
+		for _, field := range fields {
+			binary.Write(&packet, binary.BigEndian, field)
+		}
+		binary.Write(conn, binary.BigEndian, int32(packet.Len()))
+		conn.Write(packet.Bytes())
+	}
+
+	// The connect request, answered with protocol version 0, a timeout of 4s, session 1 and an empty password
+	if _, err := readPacket(); err != nil {
+		return
+	}
+	writePacket(int32(0), int32(4000), int64(1), int32(0))
+
+	for {
+		request, err := readPacket()
+		if err != nil || len(request) < 8 {
+			return
+		}
+		xid, opcode := int32(binary.BigEndian.Uint32(request)), int32(binary.BigEndian.Uint32(request[4:]))
+
+		// Response header of the xid with zxid 1 and no error
+		response := []interface{}{xid, int64(1), int32(0)}
+		if opcode == 12 {
+			response = append(response, int32(len(children)))
+			for _, child := range children {
+				response = append(response, int32(len(child)), []byte(child))
+			}
+			response = append(response, make([]byte, 68)) // An empty zk.Stat
+		}
+		writePacket(response...)
+	}
+}
+
+// unreachableAddress returns a local address nothing listens on
+func unreachableAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func zookeeperHosts(t *testing.T, addresses ...string) []*args.ZookeeperHost {
+	hosts := make([]*args.ZookeeperHost, 0, len(addresses))
+	for _, address := range addresses {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		portNumber, _ := strconv.Atoi(port)
+		hosts = append(hosts, &args.ZookeeperHost{Host: host, Port: portNumber})
+	}
+
+	return hosts
+}
+
+func Test_newZookeeperConnection_UnreachableHost(t *testing.T) {
+	// go-zookeeper shuffles the hosts, so the session is either established on the first attempt or after the
+	// unreachable host fails
+	kafkaArgs := &args.KafkaArguments{ZookeeperHosts: zookeeperHosts(t, unreachableAddress(t), serveZookeeper(t, []string{"1", "2"}))}
+
+	conn, err := newZookeeperConnection(kafkaArgs)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	defer conn.Close()
+
+	children, _, err := conn.Children("/brokers/ids")
+	if err != nil {
+		t.Fatalf("Expected the children from the reachable host, got error %s", err.Error())
+	}
+	if !reflect.DeepEqual(children, []string{"1", "2"}) {
+		t.Errorf("Expected children [1 2] got %v", children)
+	}
+}
+
+func Test_newZookeeperConnection_NoReachableHost(t *testing.T) {
+	addresses := []string{unreachableAddress(t), unreachableAddress(t)}
+	kafkaArgs := &args.KafkaArguments{ZookeeperHosts: zookeeperHosts(t, addresses...)}
+
+	conn, err := newZookeeperConnection(kafkaArgs)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	defer conn.Close()
+
+	_, _, err = conn.Children("/brokers/ids")
+	if !isConnectionError(err) {
+		t.Fatalf("Expected a connection error, got %v", err)
+	}
+	for _, address := range addresses {
+		if !strings.Contains(err.Error(), address) {
+			t.Errorf("Expected the error to name %s, got '%s'", address, err.Error())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package zookeeper
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+)
+
+// DefaultKafkaVersion is used for the protocol requests when kafka_version is unset and detection fails
+var DefaultKafkaVersion = sarama.V2_0_0_0
+
+// fetchAPIKey is the key of the Fetch request in the ApiVersions response
+const fetchAPIKey = 1
+
+// fetchVersions maps the highest Fetch request version a broker supports to the Kafka release that introduced it.
+// Every release since 0.10.0, the first to answer ApiVersions, up to the newest release sarama knows bumped it.
+var fetchVersions = []struct {
+	maxVersion   int16
+	kafkaVersion sarama.KafkaVersion
+}{
+	{11, sarama.V2_3_0_0},
+	{10, sarama.V2_1_0_0},
+	{8, sarama.V2_0_0_0},
+	{7, sarama.V1_1_0_0},
+	{6, sarama.V1_0_0_0},
+	{4, sarama.V0_11_0_0},
+	{3, sarama.V0_10_1_0},
+	{2, sarama.V0_10_0_0},
+}
+
+// DetectKafkaVersion returns the Kafka version of the first broker that answers an ApiVersions request
+func DetectKafkaVersion(kafkaArgs *args.KafkaArguments, zkConn Connection) (sarama.KafkaVersion, error) {
+	brokerIDs, err := GetBrokerIDs(zkConn)
+	if err != nil {
+		return sarama.KafkaVersion{}, err
+	}
+
+	for _, id := range brokerIDs {
+		brokerID, err := strconv.Atoi(id)
+		if err != nil {
+			log.Warn("Unable to parse integer broker ID from %s", id)
+			continue
+		}
+
+		brokerConnections, err := GetBrokerConnections(brokerID, zkConn)
+		if err != nil {
+			log.Debug("Unable to get connection information for broker with ID '%d': %s", brokerID, err)
+			continue
+		}
+
+		for _, brokerConnection := range brokerConnections {
+			addr := brokerConnection.Addr()
+			apiVersions, err := requestAPIVersions(kafkaArgs, addr, brokerConnection.Scheme == "https")
+			if err != nil {
+				log.Debug("ApiVersions request to broker %s failed: %s", addr, err)
+				continue
+			}
+
+			return versionFromAPIVersions(apiVersions), nil
+		}
+	}
+
+	return sarama.KafkaVersion{}, errors.New("no broker responded to the ApiVersions request")
+}
+
+// requestAPIVersions sends an ApiVersions request to the broker at addr
+func requestAPIVersions(kafkaArgs *args.KafkaArguments, addr string, isTLS bool) ([]*sarama.ApiVersionsResponseBlock, error) {
+	config := createConfig(kafkaArgs, isTLS)
+	// The first version that answers ApiVersions
+	config.Version = sarama.V0_10_0_0
+
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(config); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := broker.Close(); err != nil {
+			log.Debug("Error closing broker connection: %s", err.Error())
+		}
+	}()
+
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if response.Err != sarama.ErrNoError {
+		return nil, response.Err
+	}
+
+	return response.ApiVersions, nil
+}
+
+// versionFromAPIVersions returns the oldest Kafka release that supports the highest Fetch request version
+// in the ApiVersions response
+func versionFromAPIVersions(apiVersions []*sarama.ApiVersionsResponseBlock) sarama.KafkaVersion {
+	for _, block := range apiVersions {
+		if block.ApiKey != fetchAPIKey {
+			continue
+		}
+
+		for _, fetch := range fetchVersions {
+			if block.MaxVersion >= fetch.maxVersion {
+				return fetch.kafkaVersion
+			}
+		}
+	}
+
+	return sarama.V0_10_0_0
+}
+
+// kafkaVersion is the version used for the protocol requests of the connections
+func kafkaVersion(kafkaArgs *args.KafkaArguments) sarama.KafkaVersion {
+	if kafkaArgs.KafkaVersion != nil {
+		return *kafkaArgs.KafkaVersion
+	}
+
+	return DefaultKafkaVersion
+}
@@ -0,0 +1,68 @@
+package zookeeper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/newrelic/nri-kafka/src/testutils"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func Test_versionFromAPIVersions(t *testing.T) {
+	testCases := []struct {
+		fetchMaxVersion int16
+		expected        sarama.KafkaVersion
+	}{
+		{2, sarama.V0_10_0_0},
+		{3, sarama.V0_10_1_0},
+		{5, sarama.V0_11_0_0},
+		{8, sarama.V2_0_0_0},
+		{9, sarama.V2_0_0_0},
+		{11, sarama.V2_3_0_0},
+		{12, sarama.V2_3_0_0},
+	}
+
+	for _, tc := range testCases {
+		apiVersions := []*sarama.ApiVersionsResponseBlock{
+			{ApiKey: 0, MinVersion: 0, MaxVersion: 7},
+			{ApiKey: fetchAPIKey, MinVersion: 0, MaxVersion: tc.fetchMaxVersion},
+		}
+		if version := versionFromAPIVersions(apiVersions); version != tc.expected {
+			t.Errorf("Expected %s for Fetch version %d, got %s", tc.expected, tc.fetchMaxVersion, version)
+		}
+	}
+
+	if version := versionFromAPIVersions(nil); version != sarama.V0_10_0_0 {
+		t.Errorf("Expected %s without Fetch, got %s", sarama.V0_10_0_0, version)
+	}
+}
+
+func TestDetectKafkaVersion_NoBrokers(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+
+	zkConn := &MockConnection{}
+	zkConn.On("Children", "/brokers/ids").Return([]string{}, new(zk.Stat), nil)
+	if _, err := DetectKafkaVersion(kafkaArgs, zkConn); err == nil {
+		t.Error("Expected error without brokers")
+	}
+
+	zkConn = &MockConnection{}
+	zkConn.On("Children", "/brokers/ids").Return([]string{}, new(zk.Stat), errors.New("no node"))
+	if _, err := DetectKafkaVersion(kafkaArgs, zkConn); err == nil {
+		t.Error("Expected error when broker IDs can't be read")
+	}
+}
+
+func Test_kafkaVersion(t *testing.T) {
+	kafkaArgs := testutils.SetupTestArgs()
+	if version := kafkaVersion(kafkaArgs); version != DefaultKafkaVersion {
+		t.Errorf("Expected default version %s, got %s", DefaultKafkaVersion, version)
+	}
+
+	configured := sarama.V1_1_0_0
+	kafkaArgs.KafkaVersion = &configured
+	if version := kafkaVersion(kafkaArgs); version != sarama.V1_1_0_0 {
+		t.Errorf("Expected configured version %s, got %s", sarama.V1_1_0_0, version)
+	}
+}
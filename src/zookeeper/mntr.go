@@ -0,0 +1,63 @@
+package zookeeper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrMntrDisabled is returned by Mntr when the Zookeeper host doesn't have mntr in its 4lw.commands.whitelist
+var ErrMntrDisabled = errors.New("mntr is not in the 4lw.commands.whitelist of the host")
+
+// Mntr sends the mntr four letter word command to the Zookeeper host at address and returns its stats by key,
+// such as zk_outstanding_requests. Zookeeper closes the connection once it has written the response. The
+// connection goes through the proxy_url, proxyURL, like the Zookeeper sessions.
+func Mntr(proxyURL *url.URL, address string, timeout time.Duration) (map[string]string, error) {
+	conn, err := dial(proxyURL, "tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte("mntr")); err != nil {
+		return nil, err
+	}
+
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMntr(response)
+}
+
+// parseMntr parses the tab separated key and value lines of a mntr response
+func parseMntr(response []byte) (map[string]string, error) {
+	// Hosts that don't allow the command answer with a single line such as
+	// "mntr is not executed because it is not in the whitelist."
+	if bytes.Contains(response, []byte("not executed")) {
+		return nil, ErrMntrDisabled
+	}
+
+	stats := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(response))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		stats[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
+	}
+	if len(stats) == 0 {
+		return nil, errors.New("empty mntr response")
+	}
+
+	return stats, nil
+}
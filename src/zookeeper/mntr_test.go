@@ -0,0 +1,70 @@
+package zookeeper
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testMntrResponse = "zk_version\t3.5.9-83df9301aa5c2a5d284a9940177808c01bc35cef, built on 01/06/2021 20:03 GMT\n" +
+	"zk_avg_latency\t0\n" +
+	"zk_outstanding_requests\t2\n" +
+	"zk_server_state\tleader\n" +
+	"zk_followers\t2\n"
+
+// serveMntr answers a single mntr request on a local port with response, returning its address
+func serveMntr(t *testing.T, response string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		command := make([]byte, 4)
+		if _, err := conn.Read(command); err != nil || string(command) != "mntr" {
+			return
+		}
+		conn.Write([]byte(response))
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestMntr(t *testing.T) {
+	stats, err := Mntr(nil, serveMntr(t, testMntrResponse), time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		"zk_version":              "3.5.9-83df9301aa5c2a5d284a9940177808c01bc35cef, built on 01/06/2021 20:03 GMT",
+		"zk_avg_latency":          "0",
+		"zk_outstanding_requests": "2",
+		"zk_server_state":         "leader",
+		"zk_followers":            "2",
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Expected %v, got %v", expected, stats)
+	}
+}
+
+func TestMntr_Disabled(t *testing.T) {
+	_, err := Mntr(nil, serveMntr(t, "mntr is not executed because it is not in the whitelist.\n"), time.Second)
+	if err != ErrMntrDisabled {
+		t.Errorf("Expected ErrMntrDisabled, got %v", err)
+	}
+}
+
+func TestMntr_Empty(t *testing.T) {
+	if _, err := Mntr(nil, serveMntr(t, ""), time.Second); err == nil {
+		t.Error("Expected an error for an empty response")
+	}
+}
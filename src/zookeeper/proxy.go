@@ -0,0 +1,99 @@
+package zookeeper
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"golang.org/x/net/proxy"
+)
+
+// dial connects to address, through the SOCKS5 proxy at proxyURL unless it's nil. It has the signature of
+// zk.Dialer, so it dials the Zookeeper sessions too.
+func dial(proxyURL *url.URL, network, address string, timeout time.Duration) (net.Conn, error) {
+	if proxyURL == nil {
+		return net.DialTimeout(network, address, timeout)
+	}
+
+	dialer, err := proxy.FromURL(proxyURL, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("the proxy dialer doesn't support timeouts")
+	}
+
+	// The timeout covers the SOCKS handshake as well as the connection to the proxy
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return contextDialer.DialContext(ctx, network, address)
+}
+
+// zookeeperDialer returns the zk.Dialer of the Zookeeper sessions, going through the SOCKS5 proxy at proxyURL
+func zookeeperDialer(proxyURL *url.URL) func(network, address string, timeout time.Duration) (net.Conn, error) {
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		return dial(proxyURL, network, address, timeout)
+	}
+}
+
+// brokerDialer is the sarama proxy dialer of the broker connections going through the SOCKS5 proxy. sarama
+// ignores the proxy of TLS connections, so the TLS handshake is made over the proxied connection here instead.
+type brokerDialer struct {
+	proxyURL  *url.URL
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// Dial connects to the broker at address through the proxy, starting TLS on the connection if tlsConfig is set
+func (d brokerDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := dial(d.proxyURL, network, address, d.timeout)
+	if err != nil || d.tlsConfig == nil {
+		return conn, err
+	}
+
+	// Like tls.Dial, the broker host is the server name unless one is configured
+	config := d.tlsConfig.Clone()
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// setProxy routes the broker connections of config through the SOCKS5 proxy at proxyURL
+func setProxy(config *sarama.Config, proxyURL *url.URL) {
+	dialer := brokerDialer{proxyURL: proxyURL, timeout: config.Net.DialTimeout}
+	if config.Net.TLS.Enable {
+		dialer.tlsConfig = config.Net.TLS.Config
+		if dialer.tlsConfig == nil {
+			dialer.tlsConfig = &tls.Config{}
+		}
+		config.Net.TLS.Enable = false
+		config.Net.TLS.Config = nil
+	}
+
+	config.Net.Proxy.Enable = true
+	config.Net.Proxy.Dialer = dialer
+}
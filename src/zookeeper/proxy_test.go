@@ -0,0 +1,156 @@
+package zookeeper
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// serveSOCKS5 runs a SOCKS5 proxy on a local port for a single CONNECT, requiring the user and password if
+// user isn't empty. It records the address the client connected to in target.
+func serveSOCKS5(t *testing.T, user, password string, target chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Greeting: version, number of methods, methods
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		methods := make([]byte, header[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+
+		if user == "" {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+			// Username and password: version, user length, user, password length, password
+			auth := make([]byte, 2)
+			if _, err := io.ReadFull(conn, auth); err != nil {
+				return
+			}
+			gotUser := make([]byte, auth[1])
+			io.ReadFull(conn, gotUser)
+			passwordLength := make([]byte, 1)
+			io.ReadFull(conn, passwordLength)
+			gotPassword := make([]byte, passwordLength[0])
+			io.ReadFull(conn, gotPassword)
+			if string(gotUser) != user || string(gotPassword) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		// Request: version, command, reserved, address type, address, port
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 3:
+			length := make([]byte, 1)
+			io.ReadFull(conn, length)
+			name := make([]byte, length[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		default:
+			return
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+		target <- address
+
+		upstream, err := net.Dial("tcp", address)
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestMntr_Proxy(t *testing.T) {
+	target := make(chan string, 1)
+	proxyAddress := serveSOCKS5(t, "user", "secret", target)
+	proxyURL, _ := url.Parse("socks5://user:secret@" + proxyAddress)
+
+	zkAddress := serveMntr(t, testMntrResponse)
+	stats, err := Mntr(proxyURL, zkAddress, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if stats["zk_server_state"] != "leader" {
+		t.Errorf("Expected the stats through the proxy, got %v", stats)
+	}
+	if got := <-target; got != zkAddress {
+		t.Errorf("Expected the proxy to connect to %s, got %s", zkAddress, got)
+	}
+}
+
+func TestDial_ProxyAuthFailure(t *testing.T) {
+	proxyAddress := serveSOCKS5(t, "user", "secret", make(chan string, 1))
+	proxyURL, _ := url.Parse("socks5://user:wrong@" + proxyAddress)
+
+	if _, err := dial(proxyURL, "tcp", "127.0.0.1:2181", time.Second); err == nil {
+		t.Error("Expected an error with the wrong proxy password")
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	proxyURL, _ := url.Parse("socks5://proxy:1080")
+
+	config := sarama.NewConfig()
+	setProxy(config, proxyURL)
+	dialer := config.Net.Proxy.Dialer.(brokerDialer)
+	if !config.Net.Proxy.Enable || dialer.proxyURL != proxyURL || dialer.tlsConfig != nil {
+		t.Errorf("Unexpected proxy dialer %+v", dialer)
+	}
+
+	// sarama doesn't proxy TLS connections, so the dialer makes the TLS handshake instead
+	config = sarama.NewConfig()
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = &tls.Config{InsecureSkipVerify: true, ServerName: "kafka"}
+	setProxy(config, proxyURL)
+	dialer = config.Net.Proxy.Dialer.(brokerDialer)
+	if config.Net.TLS.Enable || config.Net.TLS.Config != nil {
+		t.Error("Expected TLS to be left to the proxy dialer")
+	}
+	if dialer.tlsConfig == nil || dialer.tlsConfig.ServerName != "kafka" {
+		t.Errorf("Expected the TLS config on the proxy dialer, got %+v", dialer.tlsConfig)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Unexpected invalid config: %s", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/newrelic/infra-integrations-sdk/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/infra-integrations-sdk/log"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/newrelic/nri-kafka/src/collecterrors"
+	"github.com/newrelic/nri-kafka/src/zookeeper"
+)
+
+// zookeeperSampleName is the event type of the samples of the ka-zookeeper entities
+const zookeeperSampleName = "KafkaZookeeperSample"
+
+// zookeeperMetrics maps the numeric mntr stats to the metrics they're reported as. The follower stats are only
+// returned by the leader of the ensemble.
+var zookeeperMetrics = map[string]string{
+	"zk_avg_latency":                "kafka.zookeeper.avgLatencyMs",
+	"zk_min_latency":                "kafka.zookeeper.minLatencyMs",
+	"zk_max_latency":                "kafka.zookeeper.maxLatencyMs",
+	"zk_outstanding_requests":       "kafka.zookeeper.outstandingRequests",
+	"zk_num_alive_connections":      "kafka.zookeeper.aliveConnections",
+	"zk_znode_count":                "kafka.zookeeper.znodeCount",
+	"zk_watch_count":                "kafka.zookeeper.watchCount",
+	"zk_ephemerals_count":           "kafka.zookeeper.ephemeralsCount",
+	"zk_approximate_data_size":      "kafka.zookeeper.approximateDataSizeBytes",
+	"zk_open_file_descriptor_count": "kafka.zookeeper.openFileDescriptors",
+	"zk_followers":                  "kafka.zookeeper.followers",
+	"zk_synced_followers":           "kafka.zookeeper.syncedFollowers",
+	"zk_pending_syncs":              "kafka.zookeeper.pendingSyncs",
+}
+
+// collectZookeeperMetrics reports the mntr stats of each of the zookeeper_hosts and zookeeper_observer_hosts on a
+// ka-zookeeper entity named after its address. Hosts that don't allow mntr are skipped with a warning.
+func collectZookeeperMetrics(kafkaArgs *args.KafkaArguments, kafkaIntegration *integration.Integration) {
+	timeout := time.Duration(kafkaArgs.Timeout) * time.Millisecond
+	hosts := append(append([]*args.ZookeeperHost{}, kafkaArgs.ZookeeperHosts...), kafkaArgs.ZookeeperObserverHosts...)
+	for _, zkHost := range hosts {
+		address := net.JoinHostPort(zkHost.Host, strconv.Itoa(zkHost.Port))
+		stats, err := zookeeper.Mntr(kafkaArgs.ProxyURL, address, timeout)
+		if err == zookeeper.ErrMntrDisabled {
+			log.Warn("Skipping Zookeeper metrics of %s: %s", address, err.Error())
+			continue
+		} else if err != nil {
+			collecterrors.Error("Unable to collect Zookeeper metrics of %s: %s", address, err.Error())
+			continue
+		}
+
+		setZookeeperMetrics(kafkaArgs, address, stats, kafkaIntegration)
+	}
+}
+
+// setZookeeperMetrics reports the stats of the Zookeeper host at address, with its server state and version as
+// attributes. Stats that aren't numbers are skipped.
+func setZookeeperMetrics(kafkaArgs *args.KafkaArguments, address string, stats map[string]string, kafkaIntegration *integration.Integration) {
+	zkEntity, err := kafkaIntegration.Entity(address, "ka-zookeeper", kafkaArgs.ClusterIDAttributes()...)
+	if err != nil {
+		collecterrors.Error("Unable to create entity for Zookeeper host %s: %s", address, err.Error())
+		return
+	}
+
+	attributes := []metric.Attribute{
+		{Key: "displayName", Value: address},
+		{Key: "entityName", Value: "zookeeper:" + address},
+	}
+	if state, ok := stats["zk_server_state"]; ok {
+		attributes = append(attributes, metric.Attribute{Key: "serverState", Value: state})
+	}
+	if version, ok := stats["zk_version"]; ok {
+		attributes = append(attributes, metric.Attribute{Key: "version", Value: version})
+	}
+	sample := zkEntity.NewMetricSet(zookeeperSampleName, attributes...)
+
+	for key, metricName := range zookeeperMetrics {
+		value, ok := stats[key]
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Debug("Skipping Zookeeper stat %s of %s: %s", key, address, err.Error())
+			continue
+		}
+		if err := sample.SetMetric(metricName, number, metric.GAUGE); err != nil {
+			collecterrors.Error("Unable to set metric %s for Zookeeper host %s: %s", metricName, address, err.Error())
+		}
+	}
+}
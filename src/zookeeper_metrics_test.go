@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/integration"
+	"github.com/newrelic/nri-kafka/src/args"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_setZookeeperMetrics(t *testing.T) {
+	kafkaArgs := &args.KafkaArguments{ClusterName: "testcluster"}
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := map[string]string{
+		"zk_version":              "3.5.9",
+		"zk_server_state":         "leader",
+		"zk_avg_latency":          "1.5",
+		"zk_outstanding_requests": "2",
+		"zk_followers":            "2",
+		"zk_unknown":              "7",
+		"zk_znode_count":          "unknown",
+	}
+	setZookeeperMetrics(kafkaArgs, "zk1:2181", stats, i)
+
+	zkEntity, _ := i.Entity("zk1:2181", "ka-zookeeper", integration.NewIDAttribute("clusterName", "testcluster"))
+	assert.Len(t, zkEntity.Metrics, 1)
+	assert.Equal(t, map[string]interface{}{
+		"event_type":                          "KafkaZookeeperSample",
+		"displayName":                         "zk1:2181",
+		"entityName":                          "zookeeper:zk1:2181",
+		"serverState":                         "leader",
+		"version":                             "3.5.9",
+		"kafka.zookeeper.avgLatencyMs":        1.5,
+		"kafka.zookeeper.outstandingRequests": float64(2),
+		"kafka.zookeeper.followers":           float64(2),
+	}, zkEntity.Metrics[0].Metrics)
+}